@@ -17,6 +17,9 @@ go generate -x "${PKG}/pkg/migrations/"
 go generate -x "${PKG}/pkg/lua/"
 go generate -x "${PKG}/pkg/smartcitizen/"
 
+# generate protobuf and twirp stubs for our API
+go generate -x "${PKG}/pkg/encoder/"
+
 # compile our binary using install, the mounted volume ensures we can see it
 # outside the build container
 go install \
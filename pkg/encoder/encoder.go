@@ -0,0 +1,3 @@
+package encoder
+
+//go:generate retool do protoc --go_out=. --twirp_out=. encoder.proto
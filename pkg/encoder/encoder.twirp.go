@@ -943,40 +943,75 @@ func callError(ctx context.Context, h *twirp.ServerHooks, err twirp.Error) conte
 }
 
 var twirpFileDescriptor0 = []byte{
-	// 549 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0xc1, 0x6e, 0xd3, 0x40,
-	0x10, 0xc5, 0x76, 0xeb, 0xd8, 0x93, 0xb6, 0xb2, 0xb6, 0x11, 0xb2, 0x02, 0x48, 0x21, 0x17, 0x7c,
-	0xb2, 0x4a, 0xb9, 0xc0, 0xb1, 0xa5, 0x51, 0x49, 0x13, 0xec, 0xb2, 0x6d, 0x8a, 0xc4, 0xc5, 0x72,
-	0xec, 0x11, 0x5a, 0xd5, 0xf1, 0x1a, 0x7b, 0x5d, 0x91, 0xbf, 0xe0, 0xcf, 0xf8, 0x0a, 0xfe, 0x03,
-	0x79, 0xed, 0x98, 0x04, 0x22, 0x11, 0xb8, 0x79, 0xde, 0x9b, 0x79, 0xfb, 0x66, 0xc6, 0xbb, 0x70,
-	0x88, 0x69, 0xc4, 0x63, 0xcc, 0xdd, 0x2c, 0xe7, 0x82, 0x13, 0x12, 0x63, 0x15, 0xba, 0x8c, 0x0b,
-	0xb7, 0x61, 0x86, 0xdf, 0x74, 0x38, 0x7e, 0x9b, 0x63, 0x28, 0xf0, 0x46, 0xe4, 0x18, 0x2e, 0x28,
-	0x7e, 0x29, 0xb1, 0x10, 0xe4, 0x39, 0x1c, 0xc4, 0xf8, 0xc0, 0x22, 0x0c, 0x04, 0xbf, 0xc7, 0xd4,
-	0x56, 0x06, 0x8a, 0x63, 0xd2, 0x6e, 0x8d, 0xdd, 0x56, 0xd0, 0x5a, 0x4a, 0x12, 0xce, 0x31, 0xb1,
-	0xcd, 0xf5, 0x94, 0x69, 0x05, 0x55, 0x29, 0x11, 0x5f, 0x2c, 0xca, 0x94, 0x89, 0x65, 0xc0, 0x62,
-	0xdb, 0xa8, 0x53, 0x5a, 0x6c, 0x1c, 0x93, 0x13, 0xe8, 0xe5, 0x18, 0xb1, 0x8c, 0x61, 0x2a, 0x82,
-	0xac, 0x9c, 0x27, 0x2c, 0x0a, 0xee, 0x71, 0x69, 0x6b, 0x32, 0x95, 0xb4, 0xdc, 0xb5, 0xa4, 0x26,
-	0xb8, 0x24, 0x53, 0x30, 0x12, 0x1e, 0x85, 0x82, 0xf1, 0xd4, 0xde, 0x1f, 0x28, 0x4e, 0xf7, 0xf4,
-	0xc4, 0xfd, 0xb3, 0x33, 0x77, 0x4b, 0x57, 0xee, 0xb4, 0xa9, 0xa3, 0xad, 0x42, 0xa5, 0x86, 0x5f,
-	0x33, 0x5e, 0x94, 0x39, 0xda, 0xfa, 0x40, 0x71, 0x8e, 0x76, 0x57, 0x1b, 0x35, 0x75, 0xb4, 0x55,
-	0x20, 0x1f, 0x00, 0x78, 0x86, 0xb9, 0x94, 0x2e, 0xec, 0xce, 0x40, 0x73, 0xba, 0xa7, 0x2f, 0x77,
-	0xd5, 0xf3, 0x57, 0x95, 0x74, 0x4d, 0xa4, 0x7f, 0x01, 0xc6, 0xca, 0x36, 0x79, 0x0a, 0x66, 0xc2,
-	0xd3, 0xcf, 0x4c, 0x94, 0x31, 0xca, 0x95, 0x28, 0xf4, 0x17, 0x40, 0xfa, 0x60, 0x24, 0xa1, 0xa8,
-	0x49, 0x55, 0x92, 0x6d, 0xdc, 0xff, 0xa1, 0x80, 0xd9, 0xea, 0x93, 0x27, 0x60, 0x16, 0x98, 0x16,
-	0x3c, 0xaf, 0x96, 0x52, 0xe9, 0x1c, 0x52, 0xa3, 0x06, 0xc6, 0x31, 0xb9, 0x06, 0x3d, 0x8c, 0xe4,
-	0x74, 0x55, 0x39, 0x8f, 0xd7, 0xff, 0xec, 0xdf, 0x3d, 0x93, 0xf5, 0xb4, 0xd1, 0x21, 0x04, 0xf6,
-	0xe6, 0x2c, 0x2d, 0x6c, 0x6d, 0xa0, 0x39, 0x0a, 0x95, 0xdf, 0x95, 0x59, 0x96, 0x0a, 0xcc, 0x1f,
-	0xc2, 0xc4, 0xde, 0xab, 0x1d, 0xac, 0xe2, 0xe1, 0x1b, 0xd0, 0x6b, 0x05, 0xd2, 0x85, 0xce, 0xcc,
-	0x9b, 0x78, 0xfe, 0x47, 0xcf, 0x7a, 0x44, 0x4c, 0xd8, 0xbf, 0x79, 0x77, 0x46, 0x47, 0x96, 0x42,
-	0x3a, 0xa0, 0x9d, 0x8f, 0x3d, 0x4b, 0x25, 0x47, 0x00, 0xef, 0xfd, 0xbb, 0xb1, 0x77, 0x19, 0x9c,
-	0xdd, 0x5d, 0x5a, 0xda, 0xf0, 0x04, 0x8c, 0xd5, 0x5a, 0x36, 0x8b, 0x01, 0xf4, 0xb1, 0x77, 0xe1,
-	0xfb, 0xd4, 0x52, 0x2a, 0xc2, 0x9f, 0xdd, 0xca, 0x40, 0xbd, 0xda, 0x33, 0x54, 0x4b, 0xa3, 0x66,
-	0xc6, 0x13, 0x16, 0x55, 0x3f, 0xe9, 0x70, 0x02, 0xbd, 0xcd, 0xee, 0x8a, 0x8c, 0xa7, 0x05, 0x92,
-	0x67, 0x00, 0x85, 0x44, 0x82, 0xb2, 0x99, 0x9a, 0x49, 0xcd, 0x1a, 0x99, 0xb1, 0x98, 0xf4, 0x60,
-	0xbf, 0xbe, 0x2a, 0xaa, 0x64, 0xea, 0x60, 0x78, 0x05, 0xc7, 0x17, 0x98, 0xe0, 0xef, 0xd7, 0xeb,
-	0xbf, 0xb4, 0x1e, 0x43, 0x6f, 0x53, 0xab, 0x36, 0x76, 0xfa, 0x5d, 0x81, 0xce, 0xa8, 0xde, 0x0b,
-	0x09, 0xe1, 0x60, 0xdd, 0x3c, 0x79, 0xb1, 0xe3, 0xf2, 0xfa, 0xce, 0xdf, 0x13, 0x9b, 0x39, 0x84,
-	0x70, 0xb0, 0x6e, 0x63, 0xfb, 0x11, 0x5b, 0x9a, 0xde, 0x7e, 0xc4, 0xb6, 0x8e, 0xce, 0xcd, 0x4f,
-	0x9d, 0x86, 0x9f, 0xeb, 0xf2, 0xed, 0x7a, 0xf5, 0x33, 0x00, 0x00, 0xff, 0xff, 0xe6, 0x00, 0x8c,
-	0xdf, 0xcc, 0x04, 0x00, 0x00,
+	// 1117 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x56, 0x6d, 0x8f, 0xdb, 0xc4,
+	0x13, 0x3f, 0x27, 0x77, 0x89, 0x3d, 0xb9, 0xcb, 0xb9, 0xdb, 0xfb, 0x57, 0xd6, 0xfd, 0x01, 0x85,
+	0x48, 0xd0, 0x20, 0x50, 0x54, 0x82, 0x04, 0x48, 0xf4, 0x8d, 0x9b, 0x58, 0x39, 0xb7, 0x79, 0xea,
+	0x26, 0x69, 0x0b, 0x6f, 0x2c, 0xc7, 0x9e, 0xbb, 0xac, 0xea, 0xd8, 0xc6, 0xeb, 0x1c, 0x4d, 0xdf,
+	0xf3, 0x41, 0xf8, 0x22, 0x7c, 0x05, 0xbe, 0x12, 0xda, 0xf5, 0x43, 0x13, 0xa8, 0xd4, 0x1e, 0xef,
+	0x76, 0x7e, 0x33, 0xf3, 0xdb, 0x99, 0xd9, 0x99, 0xb1, 0xdb, 0x7f, 0x9e, 0xc3, 0xfd, 0x7e, 0x82,
+	0x6e, 0x8a, 0xf3, 0x34, 0x41, 0x77, 0x43, 0xf1, 0xd7, 0x2d, 0xf2, 0x94, 0x7c, 0x0e, 0xa7, 0x3e,
+	0xde, 0x32, 0x0f, 0x9d, 0x34, 0x7a, 0x8d, 0xa1, 0xa1, 0xb4, 0x94, 0x8e, 0x46, 0x1b, 0x19, 0xb6,
+	0x10, 0xd0, 0x9e, 0x49, 0xe0, 0xae, 0x30, 0x30, 0xb4, 0x7d, 0x93, 0x91, 0x80, 0x84, 0x89, 0x17,
+	0x6d, 0x36, 0xdb, 0x90, 0xa5, 0x3b, 0x87, 0xf9, 0x86, 0x9a, 0x99, 0x94, 0x98, 0xed, 0x93, 0x47,
+	0x70, 0x91, 0xa0, 0xc7, 0x62, 0x86, 0x61, 0xea, 0xc4, 0xdb, 0x55, 0xc0, 0x3c, 0xe7, 0x35, 0xee,
+	0x8c, 0xaa, 0x34, 0x25, 0xa5, 0x6e, 0x26, 0x55, 0xcf, 0x70, 0x47, 0x46, 0xa0, 0x06, 0x91, 0xe7,
+	0xa6, 0x2c, 0x0a, 0x8d, 0x93, 0x96, 0xd2, 0x69, 0xf4, 0x1e, 0x75, 0x7d, 0xf4, 0x22, 0x1f, 0xbb,
+	0x2c, 0x4a, 0xbb, 0x18, 0x8a, 0x63, 0xd2, 0x7d, 0x4f, 0x56, 0xdd, 0x51, 0xee, 0x47, 0x4b, 0x06,
+	0xc1, 0x86, 0x6f, 0xe2, 0x88, 0x6f, 0x13, 0x34, 0x6a, 0x2d, 0xa5, 0xd3, 0xfc, 0x78, 0x36, 0x2b,
+	0xf7, 0xa3, 0x25, 0x03, 0x79, 0x0e, 0x10, 0xc5, 0x98, 0x48, 0x6a, 0x6e, 0xd4, 0x5b, 0xd5, 0x4e,
+	0xa3, 0xf7, 0xed, 0xc7, 0xf2, 0x4d, 0x0b, 0x4f, 0xba, 0x47, 0x42, 0xbe, 0x80, 0x66, 0x9c, 0xb0,
+	0x5b, 0xd7, 0xdb, 0x39, 0xab, 0xad, 0x7f, 0x83, 0xa9, 0x01, 0x2d, 0xa5, 0xa3, 0xd0, 0xb3, 0x1c,
+	0x7d, 0x22, 0x41, 0xd2, 0x83, 0xff, 0x1d, 0x9a, 0x39, 0x31, 0x26, 0x2c, 0xf2, 0x8d, 0x46, 0x4b,
+	0xe9, 0x9c, 0xd1, 0xfb, 0x07, 0xd6, 0x33, 0xa9, 0x22, 0x0e, 0x9c, 0x17, 0x75, 0x70, 0xe2, 0x28,
+	0x60, 0xde, 0xce, 0x38, 0x95, 0x25, 0xf8, 0xfe, 0xae, 0x05, 0x9d, 0x49, 0x6f, 0xda, 0x0c, 0x0e,
+	0x64, 0xf2, 0x0d, 0x90, 0xf2, 0x82, 0x9b, 0x84, 0xf9, 0x0e, 0x67, 0x6f, 0xd1, 0x38, 0x93, 0x11,
+	0xe9, 0x85, 0x66, 0x98, 0x30, 0x7f, 0xce, 0xde, 0x22, 0x79, 0x0c, 0x97, 0xef, 0xac, 0x31, 0x5a,
+	0xbb, 0x7c, 0xed, 0xc4, 0xa2, 0x01, 0xb8, 0x78, 0xea, 0xa6, 0xf4, 0x32, 0x4a, 0xaf, 0xcc, 0x60,
+	0x56, 0xe8, 0xc9, 0x43, 0x38, 0x4f, 0xd9, 0x06, 0x9d, 0x04, 0x79, 0x14, 0x6c, 0x65, 0x77, 0x9c,
+	0x4b, 0x97, 0xa6, 0x80, 0x69, 0x89, 0x92, 0xaf, 0xe1, 0x1e, 0x6e, 0x18, 0x17, 0x4e, 0x0e, 0x0b,
+	0x53, 0x4c, 0x6e, 0xdd, 0xc0, 0xd0, 0xb3, 0x98, 0x0a, 0x85, 0x9d, 0xe3, 0xa2, 0xfa, 0x2b, 0x37,
+	0xf5, 0xd6, 0x4e, 0x82, 0xae, 0xcf, 0xc2, 0x1b, 0x6e, 0xdc, 0x6b, 0x29, 0x1d, 0x95, 0x9e, 0x49,
+	0x94, 0xe6, 0x20, 0xf9, 0x0a, 0xf4, 0x34, 0x71, 0x43, 0x7e, 0x1d, 0x25, 0x1b, 0x87, 0x7b, 0x09,
+	0x8b, 0x53, 0x83, 0xc8, 0x0e, 0x3e, 0x2f, 0xf1, 0xb9, 0x84, 0x2f, 0x07, 0xa0, 0x16, 0x55, 0x23,
+	0x9f, 0x80, 0x16, 0x44, 0xe1, 0x0d, 0x4b, 0xb7, 0x3e, 0xca, 0x11, 0x53, 0xe8, 0x3b, 0x80, 0x5c,
+	0x82, 0x1a, 0xb8, 0x69, 0xa6, 0xac, 0x48, 0x65, 0x29, 0x5f, 0xfe, 0x5e, 0x07, 0xad, 0xec, 0x17,
+	0xf2, 0x7f, 0xd0, 0x38, 0x86, 0x3c, 0x4a, 0xc4, 0x90, 0x29, 0x32, 0x15, 0x35, 0x03, 0x6c, 0x9f,
+	0xcc, 0xa0, 0xe6, 0x7a, 0xb2, 0x1e, 0x15, 0xf9, 0xb8, 0x3f, 0xde, 0xb9, 0x1f, 0xbb, 0xa6, 0xf4,
+	0xa7, 0x39, 0x0f, 0x21, 0x70, 0xbc, 0x62, 0x21, 0x37, 0xaa, 0xad, 0x6a, 0x47, 0xa1, 0xf2, 0x2c,
+	0x82, 0x2d, 0x8b, 0x79, 0x9c, 0x45, 0x50, 0xc8, 0xe4, 0x33, 0x80, 0x18, 0x13, 0x0f, 0xc3, 0x94,
+	0x05, 0x28, 0x67, 0x56, 0xa1, 0x7b, 0x08, 0x31, 0xa0, 0x8e, 0x31, 0x67, 0x41, 0x14, 0xca, 0x11,
+	0x54, 0x68, 0x21, 0x92, 0x16, 0x34, 0x44, 0x1e, 0x2c, 0x65, 0xb7, 0x2c, 0xdd, 0x19, 0x75, 0xa9,
+	0xdd, 0x87, 0xc8, 0x05, 0x9c, 0xf8, 0x18, 0xa4, 0xae, 0xdc, 0x2d, 0x0a, 0xcd, 0x04, 0xf2, 0x33,
+	0x68, 0x1b, 0xf4, 0xd6, 0x6e, 0xc8, 0xf8, 0x46, 0x2e, 0xa6, 0x66, 0xef, 0xa7, 0xbb, 0xa7, 0x3d,
+	0x2e, 0x28, 0xe8, 0x3b, 0x36, 0x71, 0xe1, 0x36, 0x8e, 0x31, 0xc9, 0xc7, 0x30, 0x13, 0x04, 0x1a,
+	0x44, 0xbf, 0x61, 0x22, 0xc7, 0x4d, 0xa1, 0x99, 0x20, 0x12, 0x5f, 0xef, 0x78, 0x8a, 0x09, 0x72,
+	0xc6, 0xe5, 0x6c, 0x29, 0x74, 0x0f, 0x11, 0x85, 0x14, 0x7b, 0x50, 0x4e, 0x84, 0x46, 0xe5, 0x99,
+	0x8c, 0xe1, 0x84, 0x85, 0x3e, 0xbe, 0x91, 0x0d, 0xdf, 0xec, 0xfd, 0x70, 0xf7, 0xb0, 0x6d, 0xe1,
+	0x4e, 0x33, 0x16, 0xd1, 0x1a, 0x6b, 0x37, 0xb8, 0x76, 0x02, 0x76, 0x8d, 0xf9, 0x40, 0xa8, 0x02,
+	0x18, 0xb1, 0x6b, 0x59, 0x78, 0xee, 0x6e, 0xe2, 0x00, 0x79, 0x3e, 0x00, 0x85, 0x48, 0x1e, 0x40,
+	0x4d, 0x6e, 0x75, 0xd1, 0xef, 0xd5, 0x8e, 0x46, 0x73, 0x49, 0x3c, 0xc8, 0x06, 0x5d, 0xb1, 0xeb,
+	0x36, 0x18, 0x16, 0x3d, 0xbe, 0x0f, 0xb5, 0xff, 0x50, 0xa0, 0x96, 0xf5, 0x0b, 0x69, 0x40, 0x7d,
+	0x39, 0x79, 0x36, 0x99, 0xbe, 0x9c, 0xe8, 0x47, 0x44, 0x83, 0x93, 0xf9, 0x95, 0x49, 0x2d, 0x5d,
+	0x21, 0x75, 0xa8, 0x3e, 0xb1, 0x27, 0x7a, 0x85, 0x34, 0x01, 0xc6, 0xd3, 0x17, 0xf6, 0x64, 0xe8,
+	0x98, 0x2f, 0x86, 0x7a, 0x55, 0x28, 0xc6, 0xf6, 0x44, 0x3f, 0x96, 0x07, 0xf3, 0x95, 0x7e, 0x42,
+	0x00, 0x6a, 0x63, 0x6b, 0x60, 0x9b, 0x13, 0xbd, 0x26, 0xac, 0x67, 0x16, 0xed, 0x5b, 0x93, 0x85,
+	0x3d, 0xb2, 0xf4, 0xba, 0x60, 0x9c, 0x4c, 0xed, 0xb9, 0xa5, 0xab, 0xe4, 0x0c, 0xb4, 0xc5, 0x15,
+	0xb5, 0xe6, 0x57, 0xd3, 0xd1, 0x40, 0xd7, 0xc4, 0xc5, 0xfd, 0xe9, 0xe4, 0x85, 0x45, 0x17, 0x3a,
+	0x08, 0x2e, 0xf3, 0xb9, 0xad, 0x37, 0x88, 0x0a, 0xc7, 0xd6, 0xcb, 0xb1, 0xa9, 0x9f, 0xb6, 0xbf,
+	0x04, 0xad, 0x7c, 0x5b, 0x61, 0x3c, 0x32, 0x67, 0x23, 0xb3, 0x6f, 0xe9, 0x47, 0xe4, 0x14, 0xd4,
+	0xa1, 0xb9, 0x9c, 0xcf, 0xc5, 0x8d, 0x4a, 0xbb, 0x05, 0x27, 0xb2, 0x98, 0x22, 0x8c, 0xe5, 0xdc,
+	0xb1, 0x66, 0xa6, 0x7e, 0x24, 0xec, 0xad, 0xa5, 0xd3, 0x17, 0x9c, 0x4a, 0xfb, 0x11, 0xa8, 0xc5,
+	0x67, 0xe0, 0x30, 0x5d, 0x80, 0x9a, 0x3d, 0x19, 0x4c, 0xa7, 0x54, 0x57, 0x84, 0x62, 0xba, 0x5c,
+	0x48, 0xa1, 0xd2, 0x7e, 0x0c, 0xcd, 0xc3, 0xad, 0x29, 0xf2, 0xb0, 0x5e, 0x99, 0xfd, 0x85, 0x7e,
+	0x24, 0x42, 0x1c, 0x52, 0x7b, 0x90, 0xf9, 0x0c, 0xad, 0xe9, 0x95, 0x39, 0xbf, 0xd2, 0x2b, 0x02,
+	0x9e, 0x8e, 0xed, 0x85, 0x5e, 0x7d, 0x7a, 0xac, 0x56, 0xf4, 0x2a, 0xd5, 0xb2, 0x6d, 0xed, 0x30,
+	0xbf, 0xfd, 0x0c, 0x2e, 0x0e, 0xbb, 0x81, 0xc7, 0x51, 0xc8, 0x91, 0x7c, 0x0a, 0xc0, 0x25, 0xe2,
+	0x6c, 0xf3, 0x9d, 0xa0, 0x51, 0x2d, 0x43, 0x96, 0xcc, 0x17, 0xfd, 0x9a, 0x7d, 0xd8, 0x2b, 0x52,
+	0x93, 0x09, 0xed, 0xa7, 0x70, 0x7f, 0x80, 0x01, 0xfe, 0xf3, 0x67, 0xe0, 0x3f, 0x71, 0x3d, 0x80,
+	0x8b, 0x43, 0xae, 0x2c, 0x30, 0x38, 0x2b, 0x9a, 0x38, 0x4e, 0xa2, 0x34, 0x22, 0xe4, 0xdf, 0xed,
+	0xdd, 0xfb, 0x4b, 0x81, 0xba, 0x95, 0x9d, 0x89, 0x0b, 0xa7, 0xfb, 0xf9, 0x91, 0x87, 0x1f, 0x39,
+	0x0f, 0x97, 0x9d, 0x0f, 0x1b, 0xe6, 0xa5, 0x72, 0xe1, 0x74, 0x3f, 0xd2, 0xf7, 0x5f, 0xf1, 0x9e,
+	0xba, 0x5c, 0x76, 0x3e, 0x6c, 0x98, 0x5d, 0xf1, 0x44, 0xfb, 0xa5, 0x9e, 0xeb, 0x57, 0x35, 0x99,
+	0xf7, 0x77, 0x7f, 0x0f, 0x00, 0xce, 0x43, 0x91, 0x03, 0x7a, 0x09, 0x00, 0x00,
 }
@@ -18,6 +18,32 @@
 // sql/20190315225536_change_stream_unique_index.up.sql (163B)
 // sql/20190512204433_add_device_label.down.sql (47B)
 // sql/20190512204433_add_device_label.up.sql (71B)
+// sql/20261016103212_add_privacy_budget_to_streams.down.sql (86B)
+// sql/20261016103212_add_privacy_budget_to_streams.up.sql (147B)
+// sql/20261016104530_add_location_policy_to_streams.down.sql (126B)
+// sql/20261016104530_add_location_policy_to_streams.up.sql (207B)
+// sql/20261016105817_add_time_resolution_to_streams.down.sql (50B)
+// sql/20261016105817_add_time_resolution_to_streams.up.sql (76B)
+// sql/20261016111204_add_emission_interval_to_streams.down.sql (52B)
+// sql/20261016111204_add_emission_interval_to_streams.up.sql (78B)
+// sql/20261016112439_create_moving_average_entries.down.sql (44B)
+// sql/20261016112439_create_moving_average_entries.up.sql (420B)
+// sql/20261016113527_add_window_samples_to_moving_average_entries.down.sql (267B)
+// sql/20261016113527_add_window_samples_to_moving_average_entries.up.sql (309B)
+// sql/20261016114342_add_batch_readings_to_streams.down.sql (49B)
+// sql/20261016114342_add_batch_readings_to_streams.up.sql (79B)
+// sql/20261016120715_add_transform_script_to_streams.down.sql (51B)
+// sql/20261016120715_add_transform_script_to_streams.up.sql (75B)
+// sql/20261016123048_create_outbox_events.down.sql (35B)
+// sql/20261016123048_create_outbox_events.up.sql (414B)
+// sql/20261016124519_create_dead_letters.down.sql (34B)
+// sql/20261016124519_create_dead_letters.up.sql (296B)
+// sql/20261016131207_create_seen_readings.down.sql (35B)
+// sql/20261016131207_create_seen_readings.up.sql (303B)
+// sql/20261016141523_create_privacy_budget_spends.down.sql (43B)
+// sql/20261016141523_create_privacy_budget_spends.up.sql (371B)
+// sql/20261016153412_add_stream_id_to_moving_average_entries.down.sql (278B)
+// sql/20261016153412_add_stream_id_to_moving_average_entries.up.sql (313B)
 
 package migrations
 
@@ -446,6 +472,526 @@ func _20190512204433_add_device_labelUpSql() (*asset, error) {
 	return a, nil
 }
 
+var __20261016103212_add_privacy_budget_to_streamsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x56\x00\xa9\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x70\x72\x69\x76\x61\x63\x79\x5f\x62\x75\x64\x67\x65\x74\x2c\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x70\x72\x69\x76\x61\x63\x79\x5f\x62\x75\x64\x67\x65\x74\x5f\x70\x65\x72\x69\x6f\x64\x3b\x03\x00\xc3\x62\x4b\x32\x56\x00\x00\x00")
+
+func _20261016103212_add_privacy_budget_to_streamsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016103212_add_privacy_budget_to_streamsDownSql,
+		"20261016103212_add_privacy_budget_to_streams.down.sql",
+	)
+}
+
+func _20261016103212_add_privacy_budget_to_streamsDownSql() (*asset, error) {
+	bytes, err := _20261016103212_add_privacy_budget_to_streamsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016103212_add_privacy_budget_to_streams.down.sql", size: 86, mode: os.FileMode(420), modTime: time.Unix(1792145802, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf, 0x8, 0x58, 0xdd, 0x7d, 0x5e, 0xd0, 0x3e, 0x98, 0xd8, 0xbe, 0x9f, 0x3a, 0x4c, 0x98, 0xb7, 0xa0, 0x9e, 0xf9, 0xe4, 0x46, 0x43, 0x7b, 0x89, 0xb1, 0x37, 0x71, 0xc4, 0x60, 0x35, 0xa0, 0xf9}}
+	return a, nil
+}
+
+var __20261016103212_add_privacy_budget_to_streamsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x28\x2e\x29\x4a\x4d\xcc\x2d\xe6\x52\x50\x70\x74\x71\x51\x70\xf6\xf7\x09\xf5\xf5\x53\x28\x28\xca\x2c\x4b\x4c\xae\x8c\x4f\x2a\x4d\x49\x4f\x2d\x51\x70\xf1\x0f\x05\x29\x0d\x08\x72\x75\xf6\x0c\xf6\xf4\xf7\x53\xf0\xf3\x0f\x51\xf0\x0b\xf5\xf1\x51\x70\x71\x75\x73\x0c\xf5\x09\x51\x30\xd0\xc1\x67\x40\x7c\x41\x6a\x51\x66\x7e\x8a\x82\xa7\x5f\x88\xab\xbb\x6b\x10\x16\xed\xd6\x80\x01\x00\xed\xc5\xa4\x99\x93\x00\x00\x00")
+
+func _20261016103212_add_privacy_budget_to_streamsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016103212_add_privacy_budget_to_streamsUpSql,
+		"20261016103212_add_privacy_budget_to_streams.up.sql",
+	)
+}
+
+func _20261016103212_add_privacy_budget_to_streamsUpSql() (*asset, error) {
+	bytes, err := _20261016103212_add_privacy_budget_to_streamsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016103212_add_privacy_budget_to_streams.up.sql", size: 147, mode: os.FileMode(420), modTime: time.Unix(1792145802, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x4e, 0x2c, 0x11, 0x8, 0x55, 0xeb, 0x30, 0xef, 0xce, 0xc8, 0x78, 0x5e, 0x92, 0x8c, 0xfb, 0xcf, 0x3e, 0xf5, 0x1c, 0x25, 0x36, 0x5d, 0x4a, 0x90, 0xb5, 0xfb, 0x62, 0x2c, 0x9b, 0x37, 0x9b, 0x30}}
+	return a, nil
+}
+
+var __20261016104530_add_location_policy_to_streamsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x7e\x00\x81\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x6c\x6f\x63\x61\x74\x69\x6f\x6e\x5f\x70\x6f\x6c\x69\x63\x79\x2c\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x6c\x6f\x63\x61\x74\x69\x6f\x6e\x5f\x67\x72\x69\x64\x5f\x73\x69\x7a\x65\x2c\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x6c\x6f\x63\x61\x74\x69\x6f\x6e\x5f\x67\x65\x6f\x68\x61\x73\x68\x5f\x70\x72\x65\x63\x69\x73\x69\x6f\x6e\x3b\x03\x00\x9b\xcb\xb1\x72\x7e\x00\x00\x00")
+
+func _20261016104530_add_location_policy_to_streamsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016104530_add_location_policy_to_streamsDownSql,
+		"20261016104530_add_location_policy_to_streams.down.sql",
+	)
+}
+
+func _20261016104530_add_location_policy_to_streamsDownSql() (*asset, error) {
+	bytes, err := _20261016104530_add_location_policy_to_streamsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016104530_add_location_policy_to_streams.down.sql", size: 126, mode: os.FileMode(420), modTime: time.Unix(1792146140, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf3, 0x65, 0x6d, 0x0, 0x1c, 0x70, 0xb7, 0x37, 0x36, 0x68, 0x7c, 0x94, 0x27, 0x69, 0xb5, 0x98, 0xfb, 0x16, 0x77, 0x8f, 0xc7, 0x90, 0xd3, 0x6a, 0xc4, 0x75, 0x28, 0x42, 0xf3, 0xe9, 0xd9, 0x9c}}
+	return a, nil
+}
+
+var __20261016104530_add_location_policy_to_streamsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\xcc\xb1\xaa\xc2\x30\x14\x06\xe0\xfd\x3e\xc5\xbf\x75\xb9\x83\xbb\x53\x6c\x8f\x22\x1c\x53\x28\x27\xd0\x2d\x84\x18\xec\x81\xda\x94\xa4\x8b\x3e\xbd\x0f\x20\xf5\x01\xbe\xcf\xb0\xd0\x00\x31\x27\x26\xd4\xad\xa4\xf0\xac\x7f\x80\xe9\x3a\xb4\x3d\xbb\x9b\xc5\x9c\x63\xd8\x34\x2f\x7e\xcd\xb3\xc6\x17\x84\x46\x81\xed\x05\xd6\x31\xa3\xa3\xb3\x71\x2c\x68\x68\x34\xad\x34\xff\x3b\xf6\x51\xf4\xee\xab\xbe\x13\xae\x56\xe8\x42\xc3\xf7\x70\xd8\xb5\x29\x4f\xa1\x4e\x7e\x2d\x29\x6a\xd5\xbc\xfc\x38\x8e\x9f\x01\x00\x32\xf0\xa6\xec\xcf\x00\x00\x00")
+
+func _20261016104530_add_location_policy_to_streamsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016104530_add_location_policy_to_streamsUpSql,
+		"20261016104530_add_location_policy_to_streams.up.sql",
+	)
+}
+
+func _20261016104530_add_location_policy_to_streamsUpSql() (*asset, error) {
+	bytes, err := _20261016104530_add_location_policy_to_streamsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016104530_add_location_policy_to_streams.up.sql", size: 207, mode: os.FileMode(420), modTime: time.Unix(1792146140, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa3, 0x96, 0x4d, 0x77, 0xcd, 0x5a, 0x18, 0x9a, 0xc5, 0x89, 0x87, 0x5b, 0x24, 0x8e, 0xe5, 0xeb, 0x3f, 0xdc, 0x3a, 0x1f, 0xda, 0x6, 0x8c, 0xbc, 0xe0, 0x82, 0xc2, 0xea, 0x60, 0xb2, 0x69, 0xf3}}
+	return a, nil
+}
+
+var __20261016105817_add_time_resolution_to_streamsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x32\x00\xcd\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x74\x69\x6d\x65\x5f\x72\x65\x73\x6f\x6c\x75\x74\x69\x6f\x6e\x3b\x03\x00\xd4\xb8\x1c\x44\x32\x00\x00\x00")
+
+func _20261016105817_add_time_resolution_to_streamsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016105817_add_time_resolution_to_streamsDownSql,
+		"20261016105817_add_time_resolution_to_streams.down.sql",
+	)
+}
+
+func _20261016105817_add_time_resolution_to_streamsDownSql() (*asset, error) {
+	bytes, err := _20261016105817_add_time_resolution_to_streamsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016105817_add_time_resolution_to_streams.down.sql", size: 50, mode: os.FileMode(420), modTime: time.Unix(1792146415, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf, 0xb2, 0xa8, 0x47, 0x6, 0xd8, 0x8c, 0x57, 0xc4, 0xb4, 0x8, 0xc1, 0x60, 0xb4, 0xbd, 0xdc, 0x9d, 0x95, 0x3b, 0x75, 0x1d, 0x1c, 0x9c, 0xab, 0xf, 0xf3, 0x16, 0xd9, 0xc6, 0x6a, 0x89, 0xfc}}
+	return a, nil
+}
+
+var __20261016105817_add_time_resolution_to_streamsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4c\x00\xb3\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x74\x69\x6d\x65\x5f\x72\x65\x73\x6f\x6c\x75\x74\x69\x6f\x6e\x20\x49\x4e\x54\x45\x47\x45\x52\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x30\x3b\x03\x00\xe2\x5c\xb8\x76\x4c\x00\x00\x00")
+
+func _20261016105817_add_time_resolution_to_streamsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016105817_add_time_resolution_to_streamsUpSql,
+		"20261016105817_add_time_resolution_to_streams.up.sql",
+	)
+}
+
+func _20261016105817_add_time_resolution_to_streamsUpSql() (*asset, error) {
+	bytes, err := _20261016105817_add_time_resolution_to_streamsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016105817_add_time_resolution_to_streams.up.sql", size: 76, mode: os.FileMode(420), modTime: time.Unix(1792146415, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xce, 0xd8, 0x2f, 0x3c, 0x61, 0xc9, 0xa2, 0xe9, 0x36, 0x95, 0x9a, 0x75, 0x8f, 0xbe, 0xa7, 0x71, 0xed, 0x57, 0x57, 0xe1, 0x32, 0x35, 0x5f, 0xb7, 0xe1, 0x2a, 0xfe, 0xdd, 0x5e, 0x32, 0x4a, 0xcd}}
+	return a, nil
+}
+
+var __20261016111204_add_emission_interval_to_streamsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x34\x00\xcb\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x65\x6d\x69\x73\x73\x69\x6f\x6e\x5f\x69\x6e\x74\x65\x72\x76\x61\x6c\x3b\x03\x00\xb0\x56\xaf\x3a\x34\x00\x00\x00")
+
+func _20261016111204_add_emission_interval_to_streamsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016111204_add_emission_interval_to_streamsDownSql,
+		"20261016111204_add_emission_interval_to_streams.down.sql",
+	)
+}
+
+func _20261016111204_add_emission_interval_to_streamsDownSql() (*asset, error) {
+	bytes, err := _20261016111204_add_emission_interval_to_streamsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016111204_add_emission_interval_to_streams.down.sql", size: 52, mode: os.FileMode(420), modTime: time.Unix(1792146771, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x40, 0x22, 0x57, 0x91, 0x89, 0x3c, 0x1c, 0x4e, 0x96, 0x5f, 0xa, 0x2, 0x90, 0xbe, 0x5a, 0xaf, 0xf0, 0xd7, 0xec, 0x20, 0xd2, 0x35, 0xe, 0xab, 0x8c, 0xac, 0x5b, 0x33, 0x2f, 0x54, 0xef, 0x8a}}
+	return a, nil
+}
+
+var __20261016111204_add_emission_interval_to_streamsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4e\x00\xb1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x65\x6d\x69\x73\x73\x69\x6f\x6e\x5f\x69\x6e\x74\x65\x72\x76\x61\x6c\x20\x49\x4e\x54\x45\x47\x45\x52\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x30\x3b\x03\x00\xb9\xaa\xb0\x3d\x4e\x00\x00\x00")
+
+func _20261016111204_add_emission_interval_to_streamsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016111204_add_emission_interval_to_streamsUpSql,
+		"20261016111204_add_emission_interval_to_streams.up.sql",
+	)
+}
+
+func _20261016111204_add_emission_interval_to_streamsUpSql() (*asset, error) {
+	bytes, err := _20261016111204_add_emission_interval_to_streamsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016111204_add_emission_interval_to_streams.up.sql", size: 78, mode: os.FileMode(420), modTime: time.Unix(1792146771, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x84, 0xcb, 0x75, 0x51, 0x6c, 0xe1, 0x26, 0xc4, 0x8b, 0x8b, 0x2e, 0x6a, 0xa8, 0x88, 0xaf, 0xcc, 0xb9, 0x30, 0x1b, 0xf8, 0x0, 0xee, 0x32, 0x76, 0xf4, 0xe7, 0x81, 0x90, 0x4e, 0xb2, 0xc3, 0x62}}
+	return a, nil
+}
+
+var __20261016112439_create_moving_average_entriesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2c\x00\xd3\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x6d\x6f\x76\x69\x6e\x67\x5f\x61\x76\x65\x72\x61\x67\x65\x5f\x65\x6e\x74\x72\x69\x65\x73\x3b\x03\x00\xdf\xb5\x90\xe7\x2c\x00\x00\x00")
+
+func _20261016112439_create_moving_average_entriesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016112439_create_moving_average_entriesDownSql,
+		"20261016112439_create_moving_average_entries.down.sql",
+	)
+}
+
+func _20261016112439_create_moving_average_entriesDownSql() (*asset, error) {
+	bytes, err := _20261016112439_create_moving_average_entriesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016112439_create_moving_average_entries.down.sql", size: 44, mode: os.FileMode(420), modTime: time.Unix(1792147422, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xbd, 0x1c, 0x77, 0x92, 0x63, 0x89, 0x92, 0xca, 0x2b, 0x64, 0xbe, 0xb6, 0x48, 0x26, 0x8d, 0x2a, 0xa4, 0x8f, 0x9f, 0xca, 0x13, 0xa6, 0x13, 0x4d, 0x1c, 0x66, 0x19, 0x9c, 0x7f, 0xc0, 0x21, 0x4}}
+	return a, nil
+}
+
+var __20261016112439_create_moving_average_entriesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x90\xc1\x6a\xc3\x30\x0c\x86\xef\x7e\x0a\x1d\x5b\xc8\x1b\xf4\xe4\x36\x6a\x27\x96\x38\xc1\x71\x68\xba\x8b\x09\xb1\x28\x66\x9b\x0d\x71\x96\xee\xf1\x47\xca\xe8\xba\xb1\xc1\x8e\xe6\xf7\x2f\xe9\xfb\x76\x1a\xa5\x41\x30\x72\x5b\x20\xd0\x1e\x54\x65\x00\x3b\x6a\x4c\x03\xaf\x71\xf6\xe1\x6c\xfb\x99\xc7\xfe\xcc\x96\xc3\x34\x7a\x4e\xb0\x12\x00\xde\xc1\x96\x0e\x0d\x6a\x92\x05\xd4\x9a\x4a\xa9\x4f\xf0\x88\xa7\x4c\x00\x38\x9e\xfd\xc0\x76\x8a\xcf\x1c\xc0\x60\x67\xae\x33\x55\x5b\x14\x4b\x9a\x38\xa4\x38\x5a\xef\x80\x94\xc1\x03\xea\x6f\xe9\xc5\x07\x17\x2f\x36\xf1\x10\x83\x4b\xbf\x7e\x19\x79\x88\xa3\x63\x67\xfb\x09\x0c\x95\xd8\x18\x59\xd6\x70\x24\xf3\x70\x7d\xc2\x53\xa5\xf0\x56\x80\x1c\xf7\xb2\x2d\x96\x13\x8e\xab\xf5\xb2\x7f\xee\x5f\xde\x18\xf2\xaa\x5d\x78\x6b\x8d\x3b\x6a\xa8\x52\xb7\x82\x58\x6f\x84\xf8\x74\x42\x2a\xc7\xee\x5f\x4e\x6c\xe2\x45\x8d\xf5\xee\x5d\x00\x54\xea\x4f\x75\xf7\x6e\xb2\x2f\x17\xd9\x0f\xf0\xec\x9e\x72\xbd\xf9\x18\x00\x54\x64\x20\x57\xa4\x01\x00\x00")
+
+func _20261016112439_create_moving_average_entriesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016112439_create_moving_average_entriesUpSql,
+		"20261016112439_create_moving_average_entries.up.sql",
+	)
+}
+
+func _20261016112439_create_moving_average_entriesUpSql() (*asset, error) {
+	bytes, err := _20261016112439_create_moving_average_entriesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016112439_create_moving_average_entries.up.sql", size: 420, mode: os.FileMode(420), modTime: time.Unix(1792147422, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x62, 0x4d, 0x1, 0xc0, 0x5c, 0x5e, 0xe4, 0xbc, 0x4b, 0x50, 0x74, 0x84, 0xa6, 0x94, 0x94, 0x93, 0x40, 0x3b, 0xd0, 0x8, 0xdb, 0x84, 0xe0, 0xec, 0xf5, 0xf0, 0x30, 0x70, 0xb1, 0xb8, 0x5b, 0xb7}}
+	return a, nil
+}
+
+var __20261016113527_add_window_samples_to_moving_average_entriesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8e\xc1\x8a\x83\x30\x10\x86\xef\x79\x8a\x39\xee\x82\x6f\xe0\xc9\xd5\x2c\x08\x6e\xb2\x68\x0a\xde\x86\xe0\x0c\x12\x5a\x93\x92\x11\xed\xe3\x17\x3c\xb4\x27\xa1\xa7\xff\xf2\xf1\x7f\x5f\xd3\xdb\x7f\x68\x4d\xa3\x47\x68\x7f\x41\x8f\xed\xe0\x06\x58\xd2\x16\xe2\x8c\x7e\xe3\xec\x67\x46\x8e\x6b\x0e\x2c\x28\x7c\x4c\xa0\x47\xa9\x54\xd5\x39\xdd\x83\xab\x7e\x3a\x7d\xc2\x2b\x80\xe3\xbd\xb6\xdd\xe5\xcf\xc0\x1e\x22\xa5\x1d\xc5\x2f\xf7\x1b\x4b\xa9\x54\xdd\xeb\xca\xe9\xb7\xdc\x58\xf7\x71\x80\x02\xb0\xe6\x04\x83\x2f\xe2\x2d\x4c\x8c\x6b\xba\x72\x2c\x40\x38\x4a\xca\x18\xa8\x78\x35\xf0\x94\x22\x49\x01\x99\xa7\x94\x89\x09\xfd\xfa\x5d\x3e\x07\x00\x98\xe3\x22\xaf\x0b\x01\x00\x00")
+
+func _20261016113527_add_window_samples_to_moving_average_entriesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016113527_add_window_samples_to_moving_average_entriesDownSql,
+		"20261016113527_add_window_samples_to_moving_average_entries.down.sql",
+	)
+}
+
+func _20261016113527_add_window_samples_to_moving_average_entriesDownSql() (*asset, error) {
+	bytes, err := _20261016113527_add_window_samples_to_moving_average_entriesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016113527_add_window_samples_to_moving_average_entries.down.sql", size: 267, mode: os.FileMode(420), modTime: time.Unix(1792148061, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xa4, 0xf3, 0x95, 0x0, 0x3a, 0x93, 0x8, 0x82, 0xe4, 0xbe, 0x56, 0x12, 0x30, 0x36, 0x8, 0xad, 0xd0, 0x12, 0xcd, 0xbb, 0x31, 0x2b, 0xa7, 0x6, 0xda, 0x39, 0x80, 0x8b, 0xd0, 0xfe, 0x6f, 0xc0}}
+	return a, nil
+}
+
+var __20261016113527_add_window_samples_to_moving_average_entriesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8f\x31\x6b\xc3\x30\x10\x46\x77\xfd\x8a\x6f\x6c\xc1\x43\x77\x4f\x6a\x74\x29\x06\x55\x2e\x8e\x0c\xd9\x0e\x61\x1d\x41\xb4\x91\x8a\x64\x9c\xfe\xfc\x12\x0a\xcd\x14\xc8\x78\x70\xef\x7b\x3c\x6d\x3d\x4d\xf0\xfa\xd5\x12\xce\x65\x4b\xf9\xc4\x61\x93\x1a\x4e\xc2\x92\xd7\x9a\xa4\x29\x40\x1b\x83\xdd\x68\xe7\x77\x87\x4b\xca\xb1\x5c\xb8\x85\xf3\xf7\x97\x34\x0c\xce\xd3\x1b\x4d\x70\xa3\x87\x9b\xad\x85\xa1\xbd\x9e\xad\xc7\x4b\xaf\x94\x99\xc6\x0f\x0c\xce\xd0\x11\xc3\x1e\x74\x1c\x0e\xfe\x70\x47\xc2\x4d\xae\x2e\x4e\xf1\xa7\x57\x6a\x37\x91\xf6\x74\x43\xaf\xeb\x8f\xe2\x0a\x18\xdd\x9d\x37\x3c\x45\xd9\xd2\x22\xbc\x96\x4f\xc9\x1d\x9a\xe4\x56\x2a\xa7\xd8\xfd\x77\xc9\x52\x72\x6c\xb7\xfb\xaf\xb3\x43\x95\xa5\xd4\x28\x91\xc3\xfa\xdc\xff\x0e\x00\xad\xb7\x92\x2e\x35\x01\x00\x00")
+
+func _20261016113527_add_window_samples_to_moving_average_entriesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016113527_add_window_samples_to_moving_average_entriesUpSql,
+		"20261016113527_add_window_samples_to_moving_average_entries.up.sql",
+	)
+}
+
+func _20261016113527_add_window_samples_to_moving_average_entriesUpSql() (*asset, error) {
+	bytes, err := _20261016113527_add_window_samples_to_moving_average_entriesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016113527_add_window_samples_to_moving_average_entries.up.sql", size: 309, mode: os.FileMode(420), modTime: time.Unix(1792148061, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xd6, 0x4a, 0xb4, 0xe4, 0x4d, 0x36, 0x99, 0xbe, 0x96, 0x5, 0x59, 0x61, 0xc0, 0x11, 0xdb, 0xa9, 0x32, 0xdd, 0xbd, 0x6d, 0xb7, 0x55, 0x26, 0x95, 0x22, 0x7, 0xb4, 0x1a, 0xba, 0x1e, 0x4, 0x93}}
+	return a, nil
+}
+
+var __20261016114342_add_batch_readings_to_streamsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x31\x00\xce\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x62\x61\x74\x63\x68\x5f\x72\x65\x61\x64\x69\x6e\x67\x73\x3b\x03\x00\xa7\xab\x26\x86\x31\x00\x00\x00")
+
+func _20261016114342_add_batch_readings_to_streamsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016114342_add_batch_readings_to_streamsDownSql,
+		"20261016114342_add_batch_readings_to_streams.down.sql",
+	)
+}
+
+func _20261016114342_add_batch_readings_to_streamsDownSql() (*asset, error) {
+	bytes, err := _20261016114342_add_batch_readings_to_streamsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016114342_add_batch_readings_to_streams.down.sql", size: 49, mode: os.FileMode(420), modTime: time.Unix(1792148383, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x73, 0x91, 0x3d, 0x33, 0xd4, 0x2f, 0x27, 0x5d, 0x7, 0x3d, 0x96, 0xee, 0xfa, 0x57, 0xb1, 0xfd, 0x33, 0x9b, 0xcb, 0x70, 0x0, 0xf9, 0xfb, 0xb2, 0x1c, 0x23, 0x12, 0x2b, 0x3, 0x0, 0x7a, 0x1}}
+	return a, nil
+}
+
+var __20261016114342_add_batch_readings_to_streamsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4f\x00\xb0\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x62\x61\x74\x63\x68\x5f\x72\x65\x61\x64\x69\x6e\x67\x73\x20\x42\x4f\x4f\x4c\x45\x41\x4e\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x46\x41\x4c\x53\x45\x3b\x03\x00\xbf\x01\x70\x8c\x4f\x00\x00\x00")
+
+func _20261016114342_add_batch_readings_to_streamsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016114342_add_batch_readings_to_streamsUpSql,
+		"20261016114342_add_batch_readings_to_streams.up.sql",
+	)
+}
+
+func _20261016114342_add_batch_readings_to_streamsUpSql() (*asset, error) {
+	bytes, err := _20261016114342_add_batch_readings_to_streamsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016114342_add_batch_readings_to_streams.up.sql", size: 79, mode: os.FileMode(420), modTime: time.Unix(1792148383, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe, 0xfd, 0x1, 0xf8, 0x5, 0xfd, 0xa8, 0x44, 0xb0, 0x35, 0xd, 0x4e, 0x1, 0xbf, 0xf1, 0x74, 0xab, 0x2a, 0xe1, 0xb4, 0xeb, 0x8b, 0x21, 0x9f, 0xee, 0x68, 0x26, 0x92, 0x5b, 0x89, 0xb1, 0xdc}}
+	return a, nil
+}
+
+var __20261016120715_add_transform_script_to_streamsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x33\x00\xcc\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x74\x72\x61\x6e\x73\x66\x6f\x72\x6d\x5f\x73\x63\x72\x69\x70\x74\x3b\x03\x00\x3f\xc3\xc0\x74\x33\x00\x00\x00")
+
+func _20261016120715_add_transform_script_to_streamsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016120715_add_transform_script_to_streamsDownSql,
+		"20261016120715_add_transform_script_to_streams.down.sql",
+	)
+}
+
+func _20261016120715_add_transform_script_to_streamsDownSql() (*asset, error) {
+	bytes, err := _20261016120715_add_transform_script_to_streamsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016120715_add_transform_script_to_streams.down.sql", size: 51, mode: os.FileMode(420), modTime: time.Unix(1792149162, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe5, 0x7b, 0x92, 0x1a, 0xb1, 0xc7, 0xe9, 0xd7, 0xa7, 0x57, 0x6, 0xc2, 0x54, 0x87, 0xeb, 0x43, 0x36, 0x3c, 0xc7, 0x40, 0x2b, 0x8a, 0x38, 0x9b, 0x88, 0x8, 0x65, 0xbd, 0xe4, 0xcf, 0x5e, 0x61}}
+	return a, nil
+}
+
+var __20261016120715_add_transform_script_to_streamsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x4b\x00\xb4\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x73\x74\x72\x65\x61\x6d\x73\x0a\x20\x20\x41\x44\x44\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x74\x72\x61\x6e\x73\x66\x6f\x72\x6d\x5f\x73\x63\x72\x69\x70\x74\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x44\x45\x46\x41\x55\x4c\x54\x20\x27\x27\x3b\x03\x00\x4c\x1f\xb2\xfc\x4b\x00\x00\x00")
+
+func _20261016120715_add_transform_script_to_streamsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016120715_add_transform_script_to_streamsUpSql,
+		"20261016120715_add_transform_script_to_streams.up.sql",
+	)
+}
+
+func _20261016120715_add_transform_script_to_streamsUpSql() (*asset, error) {
+	bytes, err := _20261016120715_add_transform_script_to_streamsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016120715_add_transform_script_to_streams.up.sql", size: 75, mode: os.FileMode(420), modTime: time.Unix(1792149162, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc7, 0x2e, 0x45, 0x50, 0xc1, 0xdd, 0xfe, 0xc, 0xd8, 0xef, 0xf5, 0x45, 0x4c, 0x25, 0xd4, 0x7d, 0x71, 0x38, 0x5b, 0xdd, 0xb6, 0x4c, 0xd7, 0x3c, 0xfb, 0x49, 0x69, 0x8, 0xc5, 0xaf, 0x40, 0x66}}
+	return a, nil
+}
+
+var __20261016123048_create_outbox_eventsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x23\x00\xdc\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x6f\x75\x74\x62\x6f\x78\x5f\x65\x76\x65\x6e\x74\x73\x3b\x03\x00\x6c\x79\xeb\xd3\x23\x00\x00\x00")
+
+func _20261016123048_create_outbox_eventsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016123048_create_outbox_eventsDownSql,
+		"20261016123048_create_outbox_events.down.sql",
+	)
+}
+
+func _20261016123048_create_outbox_eventsDownSql() (*asset, error) {
+	bytes, err := _20261016123048_create_outbox_eventsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016123048_create_outbox_events.down.sql", size: 35, mode: os.FileMode(420), modTime: time.Unix(1792149746, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf4, 0x42, 0x6c, 0x95, 0x9e, 0xec, 0xb1, 0xf2, 0x66, 0xec, 0x49, 0xdf, 0xaf, 0xc2, 0x5a, 0x1b, 0x42, 0xe5, 0xa4, 0x11, 0x96, 0xdb, 0x8e, 0xf8, 0x30, 0xc0, 0xb2, 0x8d, 0xff, 0xf1, 0x1c, 0x33}}
+	return a, nil
+}
+
+var __20261016123048_create_outbox_eventsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x9c\x90\x3f\x6f\xb3\x30\x10\x87\x77\x7f\x8a\xdf\x18\xa4\x77\x78\xf7\x4c\xa6\xb9\xa4\x56\xc1\x44\x70\x51\xa0\x8b\xe5\x80\x07\xab\x01\xaa\xe6\x82\xd2\x6f\x5f\x11\x55\x55\xfa\x47\x1d\x3a\xda\xcf\x3d\x77\xd2\x73\x57\x92\x66\x02\xeb\x34\x23\x98\x35\x6c\xc1\xa0\xda\x54\x5c\x61\x3c\xcb\x61\xbc\xb8\x30\x85\x41\x4e\x58\x28\x20\x76\x48\xcd\xa6\xa2\xd2\xe8\x0c\xdb\xd2\xe4\xba\x6c\xf0\x40\xcd\x3f\x05\xb4\x63\xdf\x9f\x87\x28\xaf\x2e\x76\x60\xaa\xf9\xba\xca\xee\xb2\x6c\xa6\x5d\x98\x62\x1b\x9c\x8c\x4f\x61\xf8\x81\x7a\xf1\x48\x1b\x26\xfd\xe9\xdb\x8b\x84\xfe\x59\x4e\x30\x96\x69\x43\xe5\x07\xc4\x8a\xd6\x7a\x97\x31\xfe\xcf\x76\xfb\x12\xbc\x84\xce\x79\x01\x9b\x9c\x2a\xd6\xf9\x16\x7b\xc3\xf7\xd7\x27\x1e\x0b\x4b\xdf\x4d\x5b\xec\x17\xc9\x6c\xfb\xc9\xc7\xa3\x3f\x1c\xc3\x1f\x7c\x95\x2c\x95\x7a\x2f\x68\xec\x8a\xea\xdf\x0a\xba\xdb\x4b\x2e\x76\x17\x05\x14\xf6\x6b\xe5\xdb\xa1\x64\xf9\x36\x00\x37\x21\x24\x6c\x9e\x01\x00\x00")
+
+func _20261016123048_create_outbox_eventsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016123048_create_outbox_eventsUpSql,
+		"20261016123048_create_outbox_events.up.sql",
+	)
+}
+
+func _20261016123048_create_outbox_eventsUpSql() (*asset, error) {
+	bytes, err := _20261016123048_create_outbox_eventsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016123048_create_outbox_events.up.sql", size: 414, mode: os.FileMode(420), modTime: time.Unix(1792149746, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe4, 0x58, 0xdb, 0x19, 0x55, 0x36, 0x35, 0x7, 0xb5, 0xd8, 0x7e, 0xf, 0x8d, 0x75, 0xd0, 0xfc, 0xa4, 0xc6, 0x48, 0xa6, 0xca, 0x25, 0x7b, 0x1f, 0x53, 0x4d, 0xbd, 0xae, 0x5c, 0x1d, 0xea, 0x1}}
+	return a, nil
+}
+
+var __20261016124519_create_dead_lettersDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x22\x00\xdd\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x61\x64\x5f\x6c\x65\x74\x74\x65\x72\x73\x3b\x03\x00\xdb\x2f\x6a\x41\x22\x00\x00\x00")
+
+func _20261016124519_create_dead_lettersDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016124519_create_dead_lettersDownSql,
+		"20261016124519_create_dead_letters.down.sql",
+	)
+}
+
+func _20261016124519_create_dead_lettersDownSql() (*asset, error) {
+	bytes, err := _20261016124519_create_dead_lettersDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016124519_create_dead_letters.down.sql", size: 34, mode: os.FileMode(420), modTime: time.Unix(1792149953, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x36, 0xae, 0xf0, 0xad, 0x68, 0xd1, 0xc5, 0xde, 0x4d, 0xfa, 0xee, 0xf9, 0x53, 0x97, 0xbf, 0xc7, 0x5e, 0xd5, 0xf9, 0xaa, 0xb1, 0x8c, 0x0, 0x82, 0xed, 0xa1, 0xc6, 0x48, 0xe7, 0x8d, 0x17, 0x93}}
+	return a, nil
+}
+
+var __20261016124519_create_dead_lettersUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x64\x8e\xc1\x4a\x03\x31\x10\x86\xef\xfb\x14\xff\xad\x2d\xf8\x06\x9e\xb2\x3a\xd5\x60\x76\xb7\x24\x53\xda\xf5\x12\xc2\x66\x90\x60\x35\x25\x0d\x82\x6f\x2f\xed\xcd\xe6\x38\xdf\x37\xf3\x31\x4f\x96\x14\x13\x58\xf5\x86\xa0\xb7\x18\x27\x06\x1d\xb5\x63\x87\x28\x21\xfa\x93\xd4\x2a\xe5\x82\x75\x07\xa4\x88\x5e\xbf\x38\xb2\x5a\x19\xec\xac\x1e\x94\x9d\xf1\x46\xf3\x43\x07\xd4\x7c\x4e\x0b\x98\x8e\x7c\x4b\x8c\x7b\x63\xae\x38\xca\x4f\x5a\xc4\xd7\xfc\x29\xdf\xad\xbd\xd4\x22\xe1\xcb\xa7\xf8\x5f\xe1\x99\xb6\x6a\x6f\x18\xab\xd5\x75\xeb\x1c\x7e\x4f\x39\x44\xf4\x33\x93\xba\xbb\x0f\x1f\xd2\x66\xa5\x94\x5c\x5a\xbc\x14\x09\x55\xa2\x0f\x15\xac\x07\x72\xac\x86\x1d\x0e\x9a\x5f\x6f\x23\xde\xa7\x91\xda\x17\xc6\xe9\xb0\xde\x74\x9b\xc7\xbf\x01\x00\x3c\xa1\x77\x7b\x28\x01\x00\x00")
+
+func _20261016124519_create_dead_lettersUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016124519_create_dead_lettersUpSql,
+		"20261016124519_create_dead_letters.up.sql",
+	)
+}
+
+func _20261016124519_create_dead_lettersUpSql() (*asset, error) {
+	bytes, err := _20261016124519_create_dead_lettersUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016124519_create_dead_letters.up.sql", size: 296, mode: os.FileMode(420), modTime: time.Unix(1792149953, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xdd, 0x11, 0xbc, 0x9f, 0x39, 0x8e, 0x9a, 0xe3, 0x1b, 0xb1, 0x70, 0xa1, 0x65, 0xda, 0x5e, 0xf6, 0x38, 0xa7, 0xe5, 0x36, 0x92, 0x84, 0x4d, 0xda, 0x14, 0x35, 0x7d, 0x21, 0xae, 0x3f, 0x1a, 0x17}}
+	return a, nil
+}
+
+var __20261016131207_create_seen_readingsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x23\x00\xdc\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x73\x65\x65\x6e\x5f\x72\x65\x61\x64\x69\x6e\x67\x73\x3b\x03\x00\x1b\x71\x1d\x3e\x23\x00\x00\x00")
+
+func _20261016131207_create_seen_readingsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016131207_create_seen_readingsDownSql,
+		"20261016131207_create_seen_readings.down.sql",
+	)
+}
+
+func _20261016131207_create_seen_readingsDownSql() (*asset, error) {
+	bytes, err := _20261016131207_create_seen_readingsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016131207_create_seen_readings.down.sql", size: 35, mode: os.FileMode(420), modTime: time.Unix(1792150568, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x27, 0xa, 0x13, 0xcf, 0x2b, 0x8b, 0x31, 0x81, 0x47, 0x46, 0xe1, 0xd3, 0x1c, 0x2a, 0x4e, 0x6b, 0xca, 0xce, 0xdb, 0xf0, 0x1c, 0x4d, 0x27, 0xd1, 0xbb, 0xeb, 0xe7, 0xf4, 0xe0, 0x13, 0x94, 0x93}}
+	return a, nil
+}
+
+var __20261016131207_create_seen_readingsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x8f\xc1\x4a\x86\x40\x14\x85\xf7\xf3\x14\x67\xe9\xc0\xff\x06\xae\xa6\xbc\xd2\xd0\x38\x8a\x5e\x51\xdb\x0c\x92\x97\x10\x61\x82\x94\xa8\xb7\x0f\x45\xc2\x0a\x5a\x0e\xf3\x9d\x7b\xce\x77\x5f\x93\x61\x02\x9b\x3b\x47\xb0\x39\x7c\xc9\xa0\xde\x36\xdc\x60\x15\x89\xe1\x4d\xc6\x69\x8e\x2f\x2b\x12\x05\x4c\xf2\x3e\x3f\x4b\xd8\x5e\x17\x89\x60\xea\xf9\xc0\x7d\xeb\xdc\x4d\x01\x27\x1a\x16\xf9\xfc\xfb\x79\x1c\x1b\x37\xb0\x2d\xa8\x61\x53\x54\xe8\x2c\x3f\x1c\x4f\x3c\x95\x9e\xbe\x61\x64\x94\x9b\xd6\xed\xe9\x2e\xd1\x7b\xb4\xaa\x6d\x61\xea\x01\x8f\x34\x20\xb9\x4e\xb8\x5d\x2b\xb5\xd2\xa9\x52\xa7\x8e\xf5\x19\xf5\xff\xe9\x84\x73\x4f\x98\xa7\x0f\x05\x94\xfe\xb7\xed\xcf\x9e\x55\x24\x86\x71\xd3\xe9\xd7\x00\xa9\x52\x2d\x65\x2f\x01\x00\x00")
+
+func _20261016131207_create_seen_readingsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016131207_create_seen_readingsUpSql,
+		"20261016131207_create_seen_readings.up.sql",
+	)
+}
+
+func _20261016131207_create_seen_readingsUpSql() (*asset, error) {
+	bytes, err := _20261016131207_create_seen_readingsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016131207_create_seen_readings.up.sql", size: 303, mode: os.FileMode(420), modTime: time.Unix(1792150568, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x13, 0x83, 0x6e, 0x38, 0x62, 0x4, 0xee, 0x94, 0xcd, 0xf5, 0xb9, 0x52, 0x14, 0xd2, 0x46, 0x75, 0x17, 0x65, 0xa6, 0xe9, 0x32, 0x2b, 0xa0, 0xbe, 0xfc, 0x64, 0xb2, 0x25, 0x65, 0x6c, 0xb9, 0xe6}}
+	return a, nil
+}
+
+var __20261016141523_create_privacy_budget_spendsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2b\x00\xd4\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x70\x72\x69\x76\x61\x63\x79\x5f\x62\x75\x64\x67\x65\x74\x5f\x73\x70\x65\x6e\x64\x73\x3b\x03\x00\xa6\x93\x6e\xda\x2b\x00\x00\x00")
+
+func _20261016141523_create_privacy_budget_spendsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016141523_create_privacy_budget_spendsDownSql,
+		"20261016141523_create_privacy_budget_spends.down.sql",
+	)
+}
+
+func _20261016141523_create_privacy_budget_spendsDownSql() (*asset, error) {
+	bytes, err := _20261016141523_create_privacy_budget_spendsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016141523_create_privacy_budget_spends.down.sql", size: 43, mode: os.FileMode(420), modTime: time.Unix(1792151608, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xc8, 0xae, 0xdd, 0xd9, 0x41, 0xc9, 0xe2, 0x29, 0xc9, 0x71, 0xbe, 0x8d, 0x2b, 0x7d, 0xe3, 0x94, 0x2, 0x20, 0xe8, 0x9c, 0xc8, 0xf0, 0x3f, 0x2e, 0xf2, 0x57, 0x95, 0xb, 0x1b, 0xac, 0xc9, 0xd8}}
+	return a, nil
+}
+
+var __20261016141523_create_privacy_budget_spendsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x90\xd1\x4a\xc3\x30\x18\x85\xef\xf3\x14\xe7\x72\x85\xbd\xc1\xae\xb2\xf6\xdf\xfc\x31\x4b\x4a\x92\xb2\xcd\x9b\x50\x4d\x91\x80\xd6\xb2\x44\xd1\xb7\x97\x8e\x59\xaf\x04\x2f\x03\x27\xdf\x39\xdf\x5f\x5b\x92\x9e\xe0\xe5\x56\x11\x78\x07\x6d\x3c\xe8\xc4\xce\x3b\x4c\x97\xf4\xd1\x3f\x7d\x85\xc7\xf7\xf8\x3c\x94\x90\xa7\x61\x8c\x19\x2b\x01\xa4\x88\x2d\xef\x1d\x59\x96\x0a\xad\xe5\x83\xb4\x67\xdc\xd3\x79\x2d\x80\x5c\x2e\x43\xff\x1a\x52\x04\x6b\x4f\x7b\xb2\x57\xa4\xee\x94\x82\xa5\x1d\x59\xd2\x35\xb9\x5b\x2a\xaf\x52\xac\x60\x34\x1a\x52\xe4\x09\xb5\x74\xb5\x6c\x68\xc6\x0c\x53\x4e\x2f\x6f\x23\x1a\xd3\xcd\xcb\x5a\x4b\x35\x3b\x36\x7a\xa1\xcd\xa1\x79\x52\x09\x7d\x81\xe7\x03\x39\x2f\x0f\x2d\x8e\xec\xef\xae\x4f\x3c\x18\x4d\xbf\xdd\x0d\xed\x64\xa7\x3c\xb4\x39\xae\x2a\x51\x6d\x84\xb8\x99\xb3\x6e\xe8\xf4\x1f\xf3\xb0\x98\x85\x9f\xde\x90\xe2\xa7\xc0\x2c\xf0\xc7\xad\x96\x2f\x6b\xe4\x69\x18\x4b\xe8\x4b\xb5\xf9\x1e\x00\x46\xd8\xb9\xd6\x73\x01\x00\x00")
+
+func _20261016141523_create_privacy_budget_spendsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016141523_create_privacy_budget_spendsUpSql,
+		"20261016141523_create_privacy_budget_spends.up.sql",
+	)
+}
+
+func _20261016141523_create_privacy_budget_spendsUpSql() (*asset, error) {
+	bytes, err := _20261016141523_create_privacy_budget_spendsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016141523_create_privacy_budget_spends.up.sql", size: 371, mode: os.FileMode(420), modTime: time.Unix(1792151608, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xb9, 0x5b, 0xb, 0xb9, 0x2a, 0xd8, 0x9, 0x2b, 0x5f, 0x7f, 0x7a, 0x7c, 0x78, 0x14, 0xea, 0x7c, 0xad, 0x88, 0x55, 0x2, 0x12, 0xe8, 0xc9, 0x9e, 0x21, 0xad, 0x9e, 0xaa, 0x85, 0x1c, 0xa, 0xb2}}
+	return a, nil
+}
+
+var __20261016153412_add_stream_id_to_moving_average_entriesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8d\xc1\x6a\x85\x30\x10\x45\xf7\xf9\x8a\x59\xb6\xe0\x1f\xb8\xb2\x9a\x82\x60\x93\xa2\x29\xb8\x1b\x82\x33\x48\x68\x4d\x4a\x26\xe8\xfb\xfc\x07\x6e\x5c\x09\x6f\x75\xb9\x70\x38\xa7\x1b\xed\x37\xf4\xa6\xd3\x33\xf4\x9f\xa0\xe7\x7e\x72\x13\x6c\x69\x0f\x71\x45\xbf\x73\xf6\x2b\x23\xc7\x92\x03\x0b\x0a\x9f\x13\xe8\x51\x2b\xd5\x0c\x4e\x8f\xe0\x9a\x8f\x41\xdf\xf0\x0a\xe0\xb4\xb7\x76\xf8\xf9\x32\x20\x25\xb3\xdf\x30\x50\xad\x54\x3b\xea\xc6\xe9\xab\x6b\xac\x7b\xb9\xad\x00\xac\xb9\xc1\xe0\x8d\x78\x0f\x0b\x63\x49\xbf\x1c\x2b\x10\x8e\x92\x32\x06\xaa\xe0\x08\x91\xd2\x81\xc2\x4b\x8a\x24\xd7\xf7\xdb\xff\x1f\x4b\x05\x99\x97\x94\x89\x09\x7d\x79\xaf\x9f\x03\x00\x73\x3e\x02\xbc\x16\x01\x00\x00")
+
+func _20261016153412_add_stream_id_to_moving_average_entriesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016153412_add_stream_id_to_moving_average_entriesDownSql,
+		"20261016153412_add_stream_id_to_moving_average_entries.down.sql",
+	)
+}
+
+func _20261016153412_add_stream_id_to_moving_average_entriesDownSql() (*asset, error) {
+	bytes, err := _20261016153412_add_stream_id_to_moving_average_entriesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016153412_add_stream_id_to_moving_average_entries.down.sql", size: 278, mode: os.FileMode(420), modTime: time.Unix(1792154286, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x7a, 0xc9, 0x7f, 0x82, 0x11, 0x63, 0x7, 0x84, 0x9c, 0xca, 0x48, 0x9d, 0xb, 0x72, 0x59, 0x20, 0x31, 0xfe, 0xd0, 0x0, 0x8d, 0xd7, 0x27, 0x65, 0x11, 0x38, 0x5f, 0x66, 0xe9, 0x34, 0xb9, 0xa1}}
+	return a, nil
+}
+
+var __20261016153412_add_stream_id_to_moving_average_entriesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8e\xc1\x6a\xc3\x30\x10\x44\xef\xfa\x8a\xb9\xa5\x05\xff\x81\x4f\x6a\xa4\x80\x41\x95\x8b\x23\x83\x6f\x42\x58\x4b\x10\xad\xa5\xa2\x15\x4e\x3f\xbf\xa4\x87\xe6\x14\xc8\x71\xd9\x79\x6f\x46\x1a\xa7\x27\x38\xf9\x66\x34\xb6\xb2\xa7\x7c\xf1\x61\xa7\x1a\x2e\xe4\x29\xb7\x9a\x88\x05\x20\x95\xc2\x71\x34\xf3\xbb\x05\xb7\x4a\x61\xf3\x29\xc2\xe9\xc5\xc1\x8e\x0e\x76\x36\x06\x4a\x9f\xe4\x6c\x1c\x0e\x87\x5e\x08\x35\x8d\x1f\x18\xac\xd2\x0b\x86\x13\xf4\x32\x9c\xdd\xf9\x81\xdd\x33\xdd\x4a\x7c\x8a\x3f\xbd\x10\xc7\x49\x4b\xa7\xef\xe8\x4d\xff\x2c\x2e\x80\xd1\x3e\x88\xe1\xe5\x7f\x77\x87\x48\x7b\x5a\xc9\xb7\xf2\x49\xb9\x03\x53\xe6\x52\xff\x1e\xd7\x94\x63\xb9\x7a\xa6\xb5\xe4\xc8\xf7\x3b\x6c\xdf\x5f\xc4\x1d\x2a\xad\xa5\x46\x8a\x3e\xb4\xd7\xfe\x77\x00\x97\xb2\x5b\x0c\x39\x01\x00\x00")
+
+func _20261016153412_add_stream_id_to_moving_average_entriesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261016153412_add_stream_id_to_moving_average_entriesUpSql,
+		"20261016153412_add_stream_id_to_moving_average_entries.up.sql",
+	)
+}
+
+func _20261016153412_add_stream_id_to_moving_average_entriesUpSql() (*asset, error) {
+	bytes, err := _20261016153412_add_stream_id_to_moving_average_entriesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261016153412_add_stream_id_to_moving_average_entries.up.sql", size: 313, mode: os.FileMode(420), modTime: time.Unix(1792154286, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xb8, 0x5b, 0xa0, 0xd6, 0x99, 0x23, 0x1, 0xfc, 0x2f, 0x8a, 0x8e, 0xf3, 0xfb, 0x1b, 0xfa, 0x56, 0xa6, 0x23, 0xe4, 0xef, 0x59, 0x4b, 0xc0, 0x50, 0x21, 0x8e, 0xf4, 0xdc, 0xf7, 0x2, 0x10, 0xdd}}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -572,6 +1118,58 @@ var _bindata = map[string]func() (*asset, error){
 	"20190512204433_add_device_label.down.sql": _20190512204433_add_device_labelDownSql,
 
 	"20190512204433_add_device_label.up.sql": _20190512204433_add_device_labelUpSql,
+
+	"20261016103212_add_privacy_budget_to_streams.down.sql": _20261016103212_add_privacy_budget_to_streamsDownSql,
+
+	"20261016103212_add_privacy_budget_to_streams.up.sql": _20261016103212_add_privacy_budget_to_streamsUpSql,
+
+	"20261016104530_add_location_policy_to_streams.down.sql": _20261016104530_add_location_policy_to_streamsDownSql,
+
+	"20261016104530_add_location_policy_to_streams.up.sql": _20261016104530_add_location_policy_to_streamsUpSql,
+
+	"20261016105817_add_time_resolution_to_streams.down.sql": _20261016105817_add_time_resolution_to_streamsDownSql,
+
+	"20261016105817_add_time_resolution_to_streams.up.sql": _20261016105817_add_time_resolution_to_streamsUpSql,
+
+	"20261016111204_add_emission_interval_to_streams.down.sql": _20261016111204_add_emission_interval_to_streamsDownSql,
+
+	"20261016111204_add_emission_interval_to_streams.up.sql": _20261016111204_add_emission_interval_to_streamsUpSql,
+
+	"20261016112439_create_moving_average_entries.down.sql": _20261016112439_create_moving_average_entriesDownSql,
+
+	"20261016112439_create_moving_average_entries.up.sql": _20261016112439_create_moving_average_entriesUpSql,
+
+	"20261016113527_add_window_samples_to_moving_average_entries.down.sql": _20261016113527_add_window_samples_to_moving_average_entriesDownSql,
+
+	"20261016113527_add_window_samples_to_moving_average_entries.up.sql": _20261016113527_add_window_samples_to_moving_average_entriesUpSql,
+
+	"20261016114342_add_batch_readings_to_streams.down.sql": _20261016114342_add_batch_readings_to_streamsDownSql,
+
+	"20261016114342_add_batch_readings_to_streams.up.sql": _20261016114342_add_batch_readings_to_streamsUpSql,
+
+	"20261016120715_add_transform_script_to_streams.down.sql": _20261016120715_add_transform_script_to_streamsDownSql,
+
+	"20261016120715_add_transform_script_to_streams.up.sql": _20261016120715_add_transform_script_to_streamsUpSql,
+
+	"20261016123048_create_outbox_events.down.sql": _20261016123048_create_outbox_eventsDownSql,
+
+	"20261016123048_create_outbox_events.up.sql": _20261016123048_create_outbox_eventsUpSql,
+
+	"20261016124519_create_dead_letters.down.sql": _20261016124519_create_dead_lettersDownSql,
+
+	"20261016124519_create_dead_letters.up.sql": _20261016124519_create_dead_lettersUpSql,
+
+	"20261016131207_create_seen_readings.down.sql": _20261016131207_create_seen_readingsDownSql,
+
+	"20261016131207_create_seen_readings.up.sql": _20261016131207_create_seen_readingsUpSql,
+
+	"20261016141523_create_privacy_budget_spends.down.sql": _20261016141523_create_privacy_budget_spendsDownSql,
+
+	"20261016141523_create_privacy_budget_spends.up.sql": _20261016141523_create_privacy_budget_spendsUpSql,
+
+	"20261016153412_add_stream_id_to_moving_average_entries.down.sql": _20261016153412_add_stream_id_to_moving_average_entriesDownSql,
+
+	"20261016153412_add_stream_id_to_moving_average_entries.up.sql": _20261016153412_add_stream_id_to_moving_average_entriesUpSql,
 }
 
 // AssetDir returns the file names below a certain
@@ -615,24 +1213,50 @@ type bintree struct {
 }
 
 var _bintree = &bintree{nil, map[string]*bintree{
-	"20180525115614_create_device_table.down.sql":        &bintree{_20180525115614_create_device_tableDownSql, map[string]*bintree{}},
-	"20180525115614_create_device_table.up.sql":          &bintree{_20180525115614_create_device_tableUpSql, map[string]*bintree{}},
-	"20180526232618_add_streams_table.down.sql":          &bintree{_20180526232618_add_streams_tableDownSql, map[string]*bintree{}},
-	"20180526232618_add_streams_table.up.sql":            &bintree{_20180526232618_add_streams_tableUpSql, map[string]*bintree{}},
-	"20181202133704_add_operations.down.sql":             &bintree{_20181202133704_add_operationsDownSql, map[string]*bintree{}},
-	"20181202133704_add_operations.up.sql":               &bintree{_20181202133704_add_operationsUpSql, map[string]*bintree{}},
-	"20190306164350_remove_broker_col.down.sql":          &bintree{_20190306164350_remove_broker_colDownSql, map[string]*bintree{}},
-	"20190306164350_remove_broker_col.up.sql":            &bintree{_20190306164350_remove_broker_colUpSql, map[string]*bintree{}},
-	"20190306170548_add_certificate_table.down.sql":      &bintree{_20190306170548_add_certificate_tableDownSql, map[string]*bintree{}},
-	"20190306170548_add_certificate_table.up.sql":        &bintree{_20190306170548_add_certificate_tableUpSql, map[string]*bintree{}},
-	"20190308144957_rename_policy_id.down.sql":           &bintree{_20190308144957_rename_policy_idDownSql, map[string]*bintree{}},
-	"20190308144957_rename_policy_id.up.sql":             &bintree{_20190308144957_rename_policy_idUpSql, map[string]*bintree{}},
-	"20190315170620_add_uuid_column_to_stream.down.sql":  &bintree{_20190315170620_add_uuid_column_to_streamDownSql, map[string]*bintree{}},
-	"20190315170620_add_uuid_column_to_stream.up.sql":    &bintree{_20190315170620_add_uuid_column_to_streamUpSql, map[string]*bintree{}},
-	"20190315225536_change_stream_unique_index.down.sql": &bintree{_20190315225536_change_stream_unique_indexDownSql, map[string]*bintree{}},
-	"20190315225536_change_stream_unique_index.up.sql":   &bintree{_20190315225536_change_stream_unique_indexUpSql, map[string]*bintree{}},
-	"20190512204433_add_device_label.down.sql":           &bintree{_20190512204433_add_device_labelDownSql, map[string]*bintree{}},
-	"20190512204433_add_device_label.up.sql":             &bintree{_20190512204433_add_device_labelUpSql, map[string]*bintree{}},
+	"20180525115614_create_device_table.down.sql":                          &bintree{_20180525115614_create_device_tableDownSql, map[string]*bintree{}},
+	"20180525115614_create_device_table.up.sql":                            &bintree{_20180525115614_create_device_tableUpSql, map[string]*bintree{}},
+	"20180526232618_add_streams_table.down.sql":                            &bintree{_20180526232618_add_streams_tableDownSql, map[string]*bintree{}},
+	"20180526232618_add_streams_table.up.sql":                              &bintree{_20180526232618_add_streams_tableUpSql, map[string]*bintree{}},
+	"20181202133704_add_operations.down.sql":                               &bintree{_20181202133704_add_operationsDownSql, map[string]*bintree{}},
+	"20181202133704_add_operations.up.sql":                                 &bintree{_20181202133704_add_operationsUpSql, map[string]*bintree{}},
+	"20190306164350_remove_broker_col.down.sql":                            &bintree{_20190306164350_remove_broker_colDownSql, map[string]*bintree{}},
+	"20190306164350_remove_broker_col.up.sql":                              &bintree{_20190306164350_remove_broker_colUpSql, map[string]*bintree{}},
+	"20190306170548_add_certificate_table.down.sql":                        &bintree{_20190306170548_add_certificate_tableDownSql, map[string]*bintree{}},
+	"20190306170548_add_certificate_table.up.sql":                          &bintree{_20190306170548_add_certificate_tableUpSql, map[string]*bintree{}},
+	"20190308144957_rename_policy_id.down.sql":                             &bintree{_20190308144957_rename_policy_idDownSql, map[string]*bintree{}},
+	"20190308144957_rename_policy_id.up.sql":                               &bintree{_20190308144957_rename_policy_idUpSql, map[string]*bintree{}},
+	"20190315170620_add_uuid_column_to_stream.down.sql":                    &bintree{_20190315170620_add_uuid_column_to_streamDownSql, map[string]*bintree{}},
+	"20190315170620_add_uuid_column_to_stream.up.sql":                      &bintree{_20190315170620_add_uuid_column_to_streamUpSql, map[string]*bintree{}},
+	"20190315225536_change_stream_unique_index.down.sql":                   &bintree{_20190315225536_change_stream_unique_indexDownSql, map[string]*bintree{}},
+	"20190315225536_change_stream_unique_index.up.sql":                     &bintree{_20190315225536_change_stream_unique_indexUpSql, map[string]*bintree{}},
+	"20190512204433_add_device_label.down.sql":                             &bintree{_20190512204433_add_device_labelDownSql, map[string]*bintree{}},
+	"20190512204433_add_device_label.up.sql":                               &bintree{_20190512204433_add_device_labelUpSql, map[string]*bintree{}},
+	"20261016103212_add_privacy_budget_to_streams.down.sql":                &bintree{_20261016103212_add_privacy_budget_to_streamsDownSql, map[string]*bintree{}},
+	"20261016103212_add_privacy_budget_to_streams.up.sql":                  &bintree{_20261016103212_add_privacy_budget_to_streamsUpSql, map[string]*bintree{}},
+	"20261016104530_add_location_policy_to_streams.down.sql":               &bintree{_20261016104530_add_location_policy_to_streamsDownSql, map[string]*bintree{}},
+	"20261016104530_add_location_policy_to_streams.up.sql":                 &bintree{_20261016104530_add_location_policy_to_streamsUpSql, map[string]*bintree{}},
+	"20261016105817_add_time_resolution_to_streams.down.sql":               &bintree{_20261016105817_add_time_resolution_to_streamsDownSql, map[string]*bintree{}},
+	"20261016105817_add_time_resolution_to_streams.up.sql":                 &bintree{_20261016105817_add_time_resolution_to_streamsUpSql, map[string]*bintree{}},
+	"20261016111204_add_emission_interval_to_streams.down.sql":             &bintree{_20261016111204_add_emission_interval_to_streamsDownSql, map[string]*bintree{}},
+	"20261016111204_add_emission_interval_to_streams.up.sql":               &bintree{_20261016111204_add_emission_interval_to_streamsUpSql, map[string]*bintree{}},
+	"20261016112439_create_moving_average_entries.down.sql":                &bintree{_20261016112439_create_moving_average_entriesDownSql, map[string]*bintree{}},
+	"20261016112439_create_moving_average_entries.up.sql":                  &bintree{_20261016112439_create_moving_average_entriesUpSql, map[string]*bintree{}},
+	"20261016113527_add_window_samples_to_moving_average_entries.down.sql": &bintree{_20261016113527_add_window_samples_to_moving_average_entriesDownSql, map[string]*bintree{}},
+	"20261016113527_add_window_samples_to_moving_average_entries.up.sql":   &bintree{_20261016113527_add_window_samples_to_moving_average_entriesUpSql, map[string]*bintree{}},
+	"20261016114342_add_batch_readings_to_streams.down.sql":                &bintree{_20261016114342_add_batch_readings_to_streamsDownSql, map[string]*bintree{}},
+	"20261016114342_add_batch_readings_to_streams.up.sql":                  &bintree{_20261016114342_add_batch_readings_to_streamsUpSql, map[string]*bintree{}},
+	"20261016120715_add_transform_script_to_streams.down.sql":              &bintree{_20261016120715_add_transform_script_to_streamsDownSql, map[string]*bintree{}},
+	"20261016120715_add_transform_script_to_streams.up.sql":                &bintree{_20261016120715_add_transform_script_to_streamsUpSql, map[string]*bintree{}},
+	"20261016123048_create_outbox_events.down.sql":                         &bintree{_20261016123048_create_outbox_eventsDownSql, map[string]*bintree{}},
+	"20261016123048_create_outbox_events.up.sql":                           &bintree{_20261016123048_create_outbox_eventsUpSql, map[string]*bintree{}},
+	"20261016124519_create_dead_letters.down.sql":                          &bintree{_20261016124519_create_dead_lettersDownSql, map[string]*bintree{}},
+	"20261016124519_create_dead_letters.up.sql":                            &bintree{_20261016124519_create_dead_lettersUpSql, map[string]*bintree{}},
+	"20261016131207_create_seen_readings.down.sql":                         &bintree{_20261016131207_create_seen_readingsDownSql, map[string]*bintree{}},
+	"20261016131207_create_seen_readings.up.sql":                           &bintree{_20261016131207_create_seen_readingsUpSql, map[string]*bintree{}},
+	"20261016141523_create_privacy_budget_spends.down.sql":                 &bintree{_20261016141523_create_privacy_budget_spendsDownSql, map[string]*bintree{}},
+	"20261016141523_create_privacy_budget_spends.up.sql":                   &bintree{_20261016141523_create_privacy_budget_spendsUpSql, map[string]*bintree{}},
+	"20261016153412_add_stream_id_to_moving_average_entries.down.sql":      &bintree{_20261016153412_add_stream_id_to_moving_average_entriesDownSql, map[string]*bintree{}},
+	"20261016153412_add_stream_id_to_moving_average_entries.up.sql":        &bintree{_20261016153412_add_stream_id_to_moving_average_entriesUpSql, map[string]*bintree{}},
 }}
 
 // RestoreAsset restores an asset under the given directory.
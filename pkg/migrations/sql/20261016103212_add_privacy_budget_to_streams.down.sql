@@ -0,0 +1,3 @@
+ALTER TABLE streams
+  DROP COLUMN privacy_budget,
+  DROP COLUMN privacy_budget_period;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE streams
+  ADD COLUMN privacy_budget DOUBLE PRECISION NOT NULL DEFAULT 0,
+  ADD COLUMN privacy_budget_period INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE streams
+  DROP COLUMN location_policy,
+  DROP COLUMN location_grid_size,
+  DROP COLUMN location_geohash_precision;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE streams
+  ADD COLUMN location_policy TEXT NOT NULL DEFAULT 'EXACT',
+  ADD COLUMN location_grid_size INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN location_geohash_precision INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  DROP COLUMN time_resolution;
\ No newline at end of file
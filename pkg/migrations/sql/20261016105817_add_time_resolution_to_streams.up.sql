@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  ADD COLUMN time_resolution INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  DROP COLUMN emission_interval;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  ADD COLUMN emission_interval INTEGER NOT NULL DEFAULT 0;
\ No newline at end of file
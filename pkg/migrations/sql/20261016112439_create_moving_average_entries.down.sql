@@ -0,0 +1 @@
+DROP TABLE IF EXISTS moving_average_entries;
\ No newline at end of file
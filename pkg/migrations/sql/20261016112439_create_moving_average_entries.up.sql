@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS moving_average_entries (
+  id BIGSERIAL PRIMARY KEY,
+  device_token TEXT NOT NULL,
+  sensor_id INTEGER NOT NULL,
+  window_seconds INTEGER NOT NULL,
+  recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  value DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS moving_average_entries_series_idx
+  ON moving_average_entries (device_token, sensor_id, window_seconds, recorded_at);
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE moving_average_entries
+  ADD COLUMN window_samples INTEGER NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS moving_average_entries_series_idx;
+
+CREATE INDEX IF NOT EXISTS moving_average_entries_series_idx
+  ON moving_average_entries (device_token, sensor_id, window_seconds, window_samples, recorded_at);
\ No newline at end of file
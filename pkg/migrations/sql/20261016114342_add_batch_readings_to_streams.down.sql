@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  DROP COLUMN batch_readings;
\ No newline at end of file
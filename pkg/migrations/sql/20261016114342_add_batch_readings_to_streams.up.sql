@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  ADD COLUMN batch_readings BOOLEAN NOT NULL DEFAULT FALSE;
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  DROP COLUMN transform_script;
\ No newline at end of file
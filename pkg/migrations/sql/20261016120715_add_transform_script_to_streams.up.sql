@@ -0,0 +1,2 @@
+ALTER TABLE streams
+  ADD COLUMN transform_script TEXT NOT NULL DEFAULT '';
\ No newline at end of file
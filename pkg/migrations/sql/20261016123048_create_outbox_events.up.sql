@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+  id BIGSERIAL PRIMARY KEY,
+  community_id TEXT NOT NULL,
+  device_token TEXT NOT NULL,
+  data BYTEA NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  available_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_available_at_idx
+  ON outbox_events (available_at);
\ No newline at end of file
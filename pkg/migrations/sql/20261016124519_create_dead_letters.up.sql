@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS dead_letters (
+  id BIGSERIAL PRIMARY KEY,
+  topic TEXT NOT NULL,
+  device_token TEXT NOT NULL,
+  stream_id TEXT NOT NULL DEFAULT '',
+  payload BYTEA NOT NULL,
+  stage TEXT NOT NULL,
+  error TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS seen_readings;
\ No newline at end of file
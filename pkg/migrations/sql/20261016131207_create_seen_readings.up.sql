@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS seen_readings (
+  device_token TEXT NOT NULL,
+  reading_key TEXT NOT NULL,
+  seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (device_token, reading_key)
+);
+
+CREATE INDEX IF NOT EXISTS seen_readings_seen_at_idx
+  ON seen_readings (device_token, seen_at);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS privacy_budget_spends;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS privacy_budget_spends (
+  id BIGSERIAL PRIMARY KEY,
+  stream_id INTEGER NOT NULL REFERENCES streams(id) ON DELETE CASCADE,
+  epsilon DOUBLE PRECISION NOT NULL,
+  spent_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS privacy_budget_spends_stream_id_spent_at_idx
+  ON privacy_budget_spends (stream_id, spent_at);
\ No newline at end of file
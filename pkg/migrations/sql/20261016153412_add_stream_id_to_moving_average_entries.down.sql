@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS moving_average_entries_series_idx;
+
+ALTER TABLE moving_average_entries
+  DROP COLUMN stream_id;
+
+CREATE INDEX IF NOT EXISTS moving_average_entries_series_idx
+  ON moving_average_entries (device_token, sensor_id, window_seconds, window_samples, recorded_at);
\ No newline at end of file
@@ -0,0 +1,22 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type Deduplicator struct {
+	mock.Mock
+}
+
+func (d *Deduplicator) Seen(ctx context.Context, deviceToken, key string, horizon time.Duration) (bool, error) {
+	args := d.Called(ctx, deviceToken, key, horizon)
+	return args.Bool(0), args.Error(1)
+}
+
+func (d *Deduplicator) Forget(ctx context.Context, deviceToken, key string) error {
+	args := d.Called(ctx, deviceToken, key)
+	return args.Error(0)
+}
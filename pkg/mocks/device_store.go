@@ -0,0 +1,16 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+type DeviceStore struct {
+	mock.Mock
+}
+
+func (m *DeviceStore) GetDevice(deviceToken string) (*postgres.Device, error) {
+	args := m.Called(deviceToken)
+	return args.Get(0).(*postgres.Device), args.Error(1)
+}
@@ -0,0 +1,23 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+type Emitter struct {
+	mock.Mock
+}
+
+func (m *Emitter) Emit(device *postgres.Device, stream *postgres.Stream, reading *smartcitizen.Device) (*smartcitizen.Device, bool, error) {
+	args := m.Called(device, stream, reading)
+	return args.Get(0).(*smartcitizen.Device), args.Bool(1), args.Error(2)
+}
+
+func (m *Emitter) Flush(all bool) []*pipeline.Emission {
+	args := m.Called(all)
+	return args.Get(0).([]*pipeline.Emission)
+}
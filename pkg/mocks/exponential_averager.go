@@ -0,0 +1,16 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type ExponentialAverager struct {
+	mock.Mock
+}
+
+func (m *ExponentialAverager) ExponentialAverage(value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, halfLife uint32) (float64, error) {
+	args := m.Called(value, recordedAt, streamID, deviceToken, sensorID, halfLife)
+	return args.Get(0).(float64), args.Error(1)
+}
@@ -1,6 +1,9 @@
 package mocks
 
 import (
+	"context"
+	"time"
+
 	"github.com/stretchr/testify/mock"
 )
 
@@ -8,7 +11,12 @@ type MovingAverager struct {
 	mock.Mock
 }
 
-func (m *MovingAverager) MovingAverage(value float64, deviceToken string, sensorID int, interval uint32) (float64, error) {
-	args := m.Called(value, deviceToken, sensorID, interval)
-	return args.Get(0).(float64), args.Error(1)
+func (m *MovingAverager) MovingAverage(ctx context.Context, value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) (float64, int, error) {
+	args := m.Called(ctx, value, recordedAt, streamID, deviceToken, sensorID, interval, samples, lateness)
+	return args.Get(0).(float64), args.Int(1), args.Error(2)
+}
+
+func (m *MovingAverager) ExpireMovingAverages(lateness, idleTimeout time.Duration) (int, error) {
+	args := m.Called(lateness, idleTimeout)
+	return args.Int(0), args.Error(1)
 }
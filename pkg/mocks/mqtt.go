@@ -15,6 +15,7 @@ type MQTTClient struct {
 
 	sync.RWMutex
 	Subscriptions map[string]map[string]bool
+	Callbacks     map[string]mqtt.Callback
 }
 
 // NewMQTTClient returns a new mock client with the internal map correctly
@@ -23,12 +24,13 @@ func NewMQTTClient(err error) *MQTTClient {
 	return &MQTTClient{
 		err:           err,
 		Subscriptions: make(map[string]map[string]bool),
+		Callbacks:     make(map[string]mqtt.Callback),
 	}
 }
 
 // Subscribe is the public interface method. In the mock we add the given broker
 // and topic to an internal data structure where it can be retrieved for test
-// verification.
+// verification, and keep the callback so tests can deliver messages.
 func (m *MQTTClient) Subscribe(broker, username, deviceToken string, cb mqtt.Callback) error {
 	if m.err != nil {
 		return m.err
@@ -44,6 +46,7 @@ func (m *MQTTClient) Subscribe(broker, username, deviceToken string, cb mqtt.Cal
 	}
 
 	m.Subscriptions[key][deviceToken] = true
+	m.Callbacks[deviceToken] = cb
 
 	return nil
 }
@@ -61,6 +64,7 @@ func (m *MQTTClient) Unsubscribe(broker, username, deviceToken string) error {
 	if _, ok := m.Subscriptions[key]; ok {
 		if _, ok := m.Subscriptions[key][deviceToken]; ok {
 			delete(m.Subscriptions[key], deviceToken)
+			delete(m.Callbacks, deviceToken)
 			if len(m.Subscriptions[key]) == 0 {
 				delete(m.Subscriptions, key)
 			}
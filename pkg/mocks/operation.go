@@ -0,0 +1,30 @@
+package mocks
+
+import (
+	"context"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+type Operation struct {
+	mock.Mock
+}
+
+func (m *Operation) Validate(operation *postgres.Operation) error {
+	args := m.Called(operation)
+	return args.Error(0)
+}
+
+func (m *Operation) Apply(ctx context.Context, reading *pipeline.Reading) (*pipeline.Result, error) {
+	args := m.Called(ctx, reading)
+	return args.Get(0).(*pipeline.Result), args.Error(1)
+}
+
+func (m *Operation) Render(reading *pipeline.Reading, result *pipeline.Result) *smartcitizen.Sensor {
+	args := m.Called(reading, result)
+	return args.Get(0).(*smartcitizen.Sensor)
+}
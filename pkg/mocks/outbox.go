@@ -0,0 +1,38 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+type Outbox struct {
+	mock.Mock
+}
+
+func (o *Outbox) EnqueueEvent(event *postgres.OutboxEvent, lease time.Duration) (*postgres.OutboxEvent, error) {
+	args := o.Called(event, lease)
+	return args.Get(0).(*postgres.OutboxEvent), args.Error(1)
+}
+
+func (o *Outbox) ClaimEvents(limit int, lease time.Duration) ([]*postgres.OutboxEvent, error) {
+	args := o.Called(limit, lease)
+	return args.Get(0).([]*postgres.OutboxEvent), args.Error(1)
+}
+
+func (o *Outbox) AcknowledgeEvent(id int64) error {
+	args := o.Called(id)
+	return args.Error(0)
+}
+
+func (o *Outbox) ExpireEvents(maxAge time.Duration) (int, error) {
+	args := o.Called(maxAge)
+	return args.Int(0), args.Error(1)
+}
+
+func (o *Outbox) OutboxStats() (int, time.Duration, error) {
+	args := o.Called()
+	return args.Int(0), args.Get(1).(time.Duration), args.Error(2)
+}
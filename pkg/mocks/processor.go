@@ -1,15 +1,46 @@
 package mocks
 
 import (
+	"context"
+	"sync"
+
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
 )
 
-type Processor struct{}
+// Processor is a mock processor which returns the error it was created with,
+// recording the devices it was asked to process. When replaying it reports
+// that it wrote Written events.
+type Processor struct {
+	err error
+
+	sync.Mutex
+	Devices []*postgres.Device
+	Written int
+}
 
 func NewProcessor() *Processor {
 	return &Processor{}
 }
 
-func (p *Processor) Process(device *postgres.Device, payload []byte) error {
-	return nil
+// NewFailingProcessor returns a mock processor which fails with the given
+// error.
+func NewFailingProcessor(err error) *Processor {
+	return &Processor{
+		err: err,
+	}
+}
+
+func (p *Processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	p.Lock()
+	defer p.Unlock()
+
+	p.Devices = append(p.Devices, device)
+
+	return p.err
+}
+
+func (p *Processor) Replay(ctx context.Context, device *postgres.Device, payload []byte) (int, error) {
+	err := p.Process(ctx, device, payload)
+
+	return p.Written, err
 }
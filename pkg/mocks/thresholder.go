@@ -0,0 +1,14 @@
+package mocks
+
+import (
+	"github.com/stretchr/testify/mock"
+)
+
+type Thresholder struct {
+	mock.Mock
+}
+
+func (m *Thresholder) Threshold(value float64, streamID, deviceToken string, sensorID int, lower, upper, hysteresis float64) (string, bool, error) {
+	args := m.Called(value, streamID, deviceToken, sensorID, lower, upper, hysteresis)
+	return args.String(0), args.Bool(1), args.Error(2)
+}
@@ -0,0 +1,16 @@
+package mocks
+
+import (
+	"time"
+
+	"github.com/stretchr/testify/mock"
+)
+
+type Windower struct {
+	mock.Mock
+}
+
+func (m *Windower) Window(value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) ([]float64, error) {
+	args := m.Called(value, recordedAt, streamID, deviceToken, sensorID, interval, samples, lateness)
+	return args.Get(0).([]float64), args.Error(1)
+}
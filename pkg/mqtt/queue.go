@@ -0,0 +1,272 @@
+package mqtt
+
+import (
+	"hash/fnv"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OverflowPolicy is the type used to say what the queue does with a message
+// received when it is full.
+type OverflowPolicy string
+
+const (
+	// DropOldest discards the oldest queued message to make room for the new
+	// one, so that we keep up with the most recent readings
+	DropOldest = OverflowPolicy("drop-oldest")
+
+	// DropNewest discards the new message, keeping those already queued
+	DropNewest = OverflowPolicy("drop-newest")
+
+	// Block waits for room in the queue, which stalls receipt of messages from
+	// the broker in the same way as processing them inline
+	Block = OverflowPolicy("block")
+
+	// DefaultQueueSize is the number of messages held in the queue if no other
+	// size is configured
+	DefaultQueueSize = 1000
+
+	// DefaultQueueWorkers is the number of workers processing queued messages if
+	// no other number is configured
+	DefaultQueueWorkers = 4
+
+	// DefaultOverflowPolicy is the policy used if no other is configured
+	DefaultOverflowPolicy = DropOldest
+)
+
+var (
+	// QueueDepthGauge is a prometheus gauge recording the number of received
+	// messages waiting to be processed
+	QueueDepthGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "ingestion_queue_depth",
+			Help:      "Count of received messages waiting to be processed",
+		},
+	)
+
+	// QueueDroppedCounter is a prometheus counter vec recording the number of
+	// received messages dropped without being processed, labelled by whether
+	// they were dropped because the queue was full or because it was stopped
+	QueueDroppedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "ingestion_queue_dropped",
+			Help:      "Count of received messages dropped without being processed",
+		},
+		[]string{"reason"},
+	)
+)
+
+// ParseOverflowPolicy returns the overflow policy with the given name, or an
+// error if there is no such policy.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	switch policy := OverflowPolicy(name); policy {
+	case DropOldest, DropNewest, Block:
+		return policy, nil
+	default:
+		return "", errors.Errorf("unknown overflow policy: %s", name)
+	}
+}
+
+// message is a message received from the broker waiting to be processed.
+type message struct {
+	topic   string
+	payload []byte
+}
+
+// QueueConfig is used to pass in configuration when creating the queue. Zero
+// values are replaced by defaults.
+type QueueConfig struct {
+	Size    int
+	Workers int
+	Policy  OverflowPolicy
+	Verbose bool
+}
+
+// Queue is a bounded in-memory queue sitting between the handlers receiving
+// messages from the broker and the callback processing them, so that a slow
+// callback doesn't hold up the client's receipt of messages. Messages are
+// shared between the workers by topic, so messages from a single device are
+// processed in the order they were received, one at a time.
+type Queue struct {
+	shards  []chan *message
+	policy  OverflowPolicy
+	verbose bool
+	logger  kitlog.Logger
+	quit    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+
+	sync.RWMutex
+	started bool
+	stopped bool
+}
+
+// NewQueue returns a new queue configured from the given config, which is
+// ready to be started. The size of the queue is shared between its workers.
+func NewQueue(config *QueueConfig, logger kitlog.Logger) *Queue {
+	logger = kitlog.With(logger, "module", "queue")
+
+	size := config.Size
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = DefaultQueueWorkers
+	}
+
+	policy := config.Policy
+	if policy == "" {
+		policy = DefaultOverflowPolicy
+	}
+
+	capacity := size / workers
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	shards := make([]chan *message, workers)
+	for i := range shards {
+		shards[i] = make(chan *message, capacity)
+	}
+
+	return &Queue{
+		shards:  shards,
+		policy:  policy,
+		verbose: config.Verbose,
+		logger:  logger,
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start starts the workers which pass queued messages to the given callback.
+func (q *Queue) Start(cb Callback) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.started {
+		return errors.New("queue already started")
+	}
+
+	q.logger.Log("msg", "starting queue", "workers", len(q.shards), "policy", q.policy)
+
+	q.started = true
+
+	for _, shard := range q.shards {
+		q.wg.Add(1)
+
+		go func(shard chan *message) {
+			defer q.wg.Done()
+
+			for m := range shard {
+				QueueDepthGauge.Dec()
+				cb(m.topic, m.payload)
+			}
+		}(shard)
+	}
+
+	return nil
+}
+
+// Stop stops the queue accepting messages, and then waits for the workers to
+// process the messages already queued.
+func (q *Queue) Stop() error {
+	q.logger.Log("msg", "stopping queue, draining messages")
+
+	// release any handlers blocked waiting for room in the queue before we take
+	// the lock they hold
+	q.once.Do(func() {
+		close(q.quit)
+	})
+
+	q.Lock()
+	if !q.stopped {
+		q.stopped = true
+
+		for _, shard := range q.shards {
+			close(shard)
+		}
+	}
+	q.Unlock()
+
+	q.wg.Wait()
+
+	return nil
+}
+
+// Enqueue adds a message to the queue to be processed, applying the overflow
+// policy if the queue is full. Messages received once the queue has been
+// stopped are dropped.
+func (q *Queue) Enqueue(topic string, payload []byte) {
+	m := &message{topic: topic, payload: payload}
+
+	q.RLock()
+	defer q.RUnlock()
+
+	if q.stopped {
+		q.drop(m, "stopped")
+		return
+	}
+
+	shard := q.shards[q.shardIndex(topic)]
+
+	switch q.policy {
+	case Block:
+		select {
+		case shard <- m:
+			QueueDepthGauge.Inc()
+		case <-q.quit:
+			q.drop(m, "stopped")
+		}
+	case DropNewest:
+		select {
+		case shard <- m:
+			QueueDepthGauge.Inc()
+		default:
+			q.drop(m, "overflow")
+		}
+	default:
+		for {
+			select {
+			case shard <- m:
+				QueueDepthGauge.Inc()
+				return
+			default:
+			}
+
+			// the queue is full, so make room by discarding its oldest message
+			select {
+			case old := <-shard:
+				QueueDepthGauge.Dec()
+				q.drop(old, "overflow")
+			default:
+			}
+		}
+	}
+}
+
+// shardIndex returns the index of the worker which processes messages for the
+// given topic.
+func (q *Queue) shardIndex(topic string) int {
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+
+	return int(h.Sum32() % uint32(len(q.shards)))
+}
+
+// drop records that a message was dropped without being processed.
+func (q *Queue) drop(m *message, reason string) {
+	QueueDroppedCounter.With(prometheus.Labels{"reason": reason}).Inc()
+
+	if q.verbose {
+		q.logger.Log("topic", m.topic, "reason", reason, "msg", "dropped message")
+	}
+}
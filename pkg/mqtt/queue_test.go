@@ -0,0 +1,200 @@
+package mqtt_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+)
+
+// recorder is a callback which records the payloads it receives, optionally
+// blocking on each until released.
+type recorder struct {
+	received chan string
+	release  chan struct{}
+
+	sync.Mutex
+	payloads []string
+}
+
+func newRecorder(blocking bool) *recorder {
+	r := &recorder{
+		received: make(chan string, 100),
+	}
+
+	if blocking {
+		r.release = make(chan struct{})
+	}
+
+	return r
+}
+
+func (r *recorder) callback(topic string, payload []byte) {
+	r.received <- string(payload)
+
+	if r.release != nil {
+		<-r.release
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.payloads = append(r.payloads, string(payload))
+}
+
+func (r *recorder) waitFor(t *testing.T, payload string) {
+	t.Helper()
+
+	select {
+	case received := <-r.received:
+		assert.Equal(t, payload, received)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s was not received", payload)
+	}
+}
+
+func TestQueuePreservesOrder(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	queue := mqtt.NewQueue(&mqtt.QueueConfig{
+		Size:    100,
+		Workers: 4,
+		Policy:  mqtt.Block,
+	}, logger)
+
+	r := newRecorder(false)
+
+	err := queue.Start(r.callback)
+	assert.Nil(t, err)
+
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		payload := fmt.Sprintf("%v", i)
+		expected = append(expected, payload)
+		queue.Enqueue("device/sck/abc123/readings", []byte(payload))
+	}
+
+	// stopping drains the queue
+	err = queue.Stop()
+	assert.Nil(t, err)
+
+	assert.Equal(t, expected, r.payloads)
+}
+
+func TestQueueOverflow(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	testcases := []struct {
+		label    string
+		policy   mqtt.OverflowPolicy
+		expected []string
+	}{
+		{
+			label:    "drop oldest",
+			policy:   mqtt.DropOldest,
+			expected: []string{"a", "c", "d"},
+		},
+		{
+			label:    "drop newest",
+			policy:   mqtt.DropNewest,
+			expected: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			queue := mqtt.NewQueue(&mqtt.QueueConfig{
+				Size:    2,
+				Workers: 1,
+				Policy:  tc.policy,
+			}, logger)
+
+			r := newRecorder(true)
+
+			err := queue.Start(r.callback)
+			assert.Nil(t, err)
+
+			// the worker is held processing the first message while the rest fill
+			// and then overflow the queue
+			queue.Enqueue("device/sck/abc123/readings", []byte("a"))
+			r.waitFor(t, "a")
+
+			for _, payload := range []string{"b", "c", "d"} {
+				queue.Enqueue("device/sck/abc123/readings", []byte(payload))
+			}
+
+			close(r.release)
+
+			err = queue.Stop()
+			assert.Nil(t, err)
+
+			assert.Equal(t, tc.expected, r.payloads)
+		})
+	}
+}
+
+func TestQueueStopReleasesBlockedHandlers(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	queue := mqtt.NewQueue(&mqtt.QueueConfig{
+		Size:    1,
+		Workers: 1,
+		Policy:  mqtt.Block,
+	}, logger)
+
+	r := newRecorder(true)
+
+	err := queue.Start(r.callback)
+	assert.Nil(t, err)
+
+	queue.Enqueue("device/sck/abc123/readings", []byte("a"))
+	r.waitFor(t, "a")
+
+	queue.Enqueue("device/sck/abc123/readings", []byte("b"))
+
+	// the queue is full so this handler blocks until we stop
+	enqueued := make(chan struct{})
+	go func() {
+		queue.Enqueue("device/sck/abc123/readings", []byte("c"))
+		close(enqueued)
+	}()
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- queue.Stop()
+	}()
+
+	select {
+	case <-enqueued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked handler was not released")
+	}
+
+	close(r.release)
+
+	select {
+	case err = <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("queue did not stop")
+	}
+
+	// messages queued before stopping are processed, and those received after
+	// are dropped
+	queue.Enqueue("device/sck/abc123/readings", []byte("d"))
+	assert.Equal(t, []string{"a", "b"}, r.payloads)
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	policy, err := mqtt.ParseOverflowPolicy("drop-newest")
+	assert.Nil(t, err)
+	assert.Equal(t, mqtt.DropNewest, policy)
+
+	_, err = mqtt.ParseOverflowPolicy("drop-everything")
+	assert.NotNil(t, err)
+}
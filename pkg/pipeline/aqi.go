@@ -0,0 +1,384 @@
+package pipeline
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+const (
+	// USEPAAQISensorID is the id of the virtual sensor used to share the US EPA
+	// Air Quality Index. Virtual sensors have negative ids so they can never
+	// clash with SmartCitizen's sensor ids.
+	USEPAAQISensorID = -1
+
+	// EUCAQISensorID is the id of the virtual sensor used to share the European
+	// Common Air Quality Index.
+	EUCAQISensorID = -2
+
+	// secondsPerHour and secondsPerDay are the averaging periods used by the
+	// indices
+	secondsPerHour = 3600
+	secondsPerDay  = 86400
+
+	// PM25Measurement and PM10Measurement are the measurements from which the
+	// indices are computed, resolved to whichever of a device's sensors report
+	// them as for any other operation targeting a measurement
+	PM25Measurement = "PM 2.5"
+	PM10Measurement = "PM 10"
+
+	// particulateUnit is the unit of the concentrations used by the indices
+	particulateUnit = "µg/m³"
+)
+
+// breakpoint is one band of an index, mapping a range of concentrations onto a
+// range of index values.
+type breakpoint struct {
+	cLow, cHigh float64
+	iLow, iHigh float64
+}
+
+// band describes a category of an index, which applies to index values up to
+// and including the upper value.
+type band struct {
+	upper    float64
+	category string
+}
+
+var (
+	// usEPAPM25 are the US EPA breakpoints for 24 hour PM2.5 (as revised in
+	// 2024) in µg/m³
+	usEPAPM25 = []breakpoint{
+		{0, 9.0, 0, 50},
+		{9.1, 35.4, 51, 100},
+		{35.5, 55.4, 101, 150},
+		{55.5, 125.4, 151, 200},
+		{125.5, 225.4, 201, 300},
+		{225.5, 325.4, 301, 500},
+	}
+
+	// usEPAPM10 are the US EPA breakpoints for 24 hour PM10 in µg/m³
+	usEPAPM10 = []breakpoint{
+		{0, 54, 0, 50},
+		{55, 154, 51, 100},
+		{155, 254, 101, 150},
+		{255, 354, 151, 200},
+		{355, 424, 201, 300},
+		{425, 604, 301, 500},
+	}
+
+	// usEPABands are the US EPA AQI categories
+	usEPABands = []band{
+		{50, "Good"},
+		{100, "Moderate"},
+		{150, "Unhealthy for Sensitive Groups"},
+		{200, "Unhealthy"},
+		{300, "Very Unhealthy"},
+		{math.Inf(1), "Hazardous"},
+	}
+
+	// euCAQIHourlyPM25 and euCAQIHourlyPM10 are the CAQI grids for hourly
+	// concentrations in µg/m³
+	euCAQIHourlyPM25 = []breakpoint{
+		{0, 15, 0, 25},
+		{15, 30, 25, 50},
+		{30, 55, 50, 75},
+		{55, 110, 75, 100},
+	}
+
+	euCAQIHourlyPM10 = []breakpoint{
+		{0, 25, 0, 25},
+		{25, 50, 25, 50},
+		{50, 90, 50, 75},
+		{90, 180, 75, 100},
+	}
+
+	// euCAQIDailyPM25 and euCAQIDailyPM10 are the CAQI grids for daily
+	// concentrations in µg/m³
+	euCAQIDailyPM25 = []breakpoint{
+		{0, 10, 0, 25},
+		{10, 20, 25, 50},
+		{20, 30, 50, 75},
+		{30, 60, 75, 100},
+	}
+
+	euCAQIDailyPM10 = []breakpoint{
+		{0, 15, 0, 25},
+		{15, 30, 25, 50},
+		{30, 50, 50, 75},
+		{50, 100, 75, 100},
+	}
+
+	// euCAQIBands are the CAQI categories
+	euCAQIBands = []band{
+		{25, "Very Low"},
+		{50, "Low"},
+		{75, "Medium"},
+		{100, "High"},
+		{math.Inf(1), "Very High"},
+	}
+)
+
+// DefaultIndexInterval returns the averaging period in seconds required by
+// the given index.
+func DefaultIndexInterval(index postgres.Index) uint32 {
+	if index == postgres.EUCAQI {
+		return secondsPerHour
+	}
+
+	return secondsPerDay
+}
+
+// USEPAAQI returns the US EPA Air Quality Index for the given PM2.5 and PM10
+// concentrations in µg/m³, along with its category. Either concentration may
+// be NaN if it is not available, in which case the index is computed from the
+// other. The index is the highest of the pollutant sub-indices.
+func USEPAAQI(pm25, pm10 float64) (float64, string) {
+	aqi := math.NaN()
+
+	if !math.IsNaN(pm25) {
+		// concentrations are truncated to the precision of the breakpoints
+		aqi = maxIndex(aqi, math.Round(interpolate(math.Floor(pm25*10)/10, usEPAPM25, true)))
+	}
+
+	if !math.IsNaN(pm10) {
+		aqi = maxIndex(aqi, math.Round(interpolate(math.Floor(pm10), usEPAPM10, true)))
+	}
+
+	return aqi, categorise(aqi, usEPABands)
+}
+
+// EUCAQI returns the European Common Air Quality Index for the given PM2.5 and
+// PM10 concentrations in µg/m³, along with its category. The interval is the
+// averaging period of the concentrations in seconds, which selects the hourly
+// or daily grid. Either concentration may be NaN if not available.
+func EUCAQI(pm25, pm10 float64, interval uint32) (float64, string) {
+	pm25Grid, pm10Grid := euCAQIHourlyPM25, euCAQIHourlyPM10
+	if interval >= secondsPerDay {
+		pm25Grid, pm10Grid = euCAQIDailyPM25, euCAQIDailyPM10
+	}
+
+	caqi := math.NaN()
+
+	if !math.IsNaN(pm25) {
+		caqi = maxIndex(caqi, interpolate(pm25, pm25Grid, false))
+	}
+
+	if !math.IsNaN(pm10) {
+		caqi = maxIndex(caqi, interpolate(pm10, pm10Grid, false))
+	}
+
+	return caqi, categorise(caqi, euCAQIBands)
+}
+
+// interpolate returns the index value for the concentration by linear
+// interpolation within the matching breakpoint. Concentrations above the
+// highest breakpoint are either capped or extrapolated from the last band.
+func interpolate(c float64, breakpoints []breakpoint, capped bool) float64 {
+	if c < 0 {
+		c = 0
+	}
+
+	for _, bp := range breakpoints {
+		if c <= bp.cHigh {
+			return (bp.iHigh-bp.iLow)/(bp.cHigh-bp.cLow)*(c-bp.cLow) + bp.iLow
+		}
+	}
+
+	last := breakpoints[len(breakpoints)-1]
+
+	if capped {
+		return last.iHigh
+	}
+
+	return (last.iHigh-last.iLow)/(last.cHigh-last.cLow)*(c-last.cLow) + last.iLow
+}
+
+// maxIndex returns the larger of two index values, ignoring NaN.
+func maxIndex(a, b float64) float64 {
+	if math.IsNaN(a) {
+		return b
+	}
+
+	return math.Max(a, b)
+}
+
+// categorise returns the category of the given index value.
+func categorise(value float64, bands []band) string {
+	if math.IsNaN(value) {
+		return ""
+	}
+
+	for _, b := range bands {
+		if value <= b.upper {
+			return b.category
+		}
+	}
+
+	return ""
+}
+
+// aqiOperation is our implementation of the AQI action, which shares an air
+// quality index derived from the particulate sensors of a device rather than
+// the value of a single sensor, so is configured without a sensor.
+type aqiOperation struct {
+	sensors  *smartcitizen.Smartcitizen
+	windower Windower
+	lateness time.Duration
+}
+
+// Validate is our implementation of the Operation interface method. The US EPA
+// index is computed unless another index is configured. An interval may only
+// select one of the averaging periods the index defines, as the breakpoints of
+// an index don't apply to concentrations averaged over any other period.
+func (a *aqiOperation) Validate(operation *postgres.Operation) error {
+	if operation.Index == "" {
+		operation.Index = postgres.USEPA
+	}
+
+	switch operation.Index {
+	case postgres.USEPA:
+		if operation.Interval != 0 && operation.Interval != secondsPerDay {
+			return errors.Errorf("US EPA AQI requires an interval of %v seconds", secondsPerDay)
+		}
+	case postgres.EUCAQI:
+		if operation.Interval != 0 && operation.Interval != secondsPerHour && operation.Interval != secondsPerDay {
+			return errors.Errorf("EU CAQI requires an interval of %v or %v seconds", secondsPerHour, secondsPerDay)
+		}
+	default:
+		return errors.Errorf("unknown air quality index %s", operation.Index)
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method. We compute
+// the index requested by the operation, averaging each pollutant over the
+// period required by the index up to when the reading was recorded. There is
+// no output if the device has no particulate sensors, or if their values
+// arrived too late to be included. The result is labelled with the category of
+// the index.
+func (a *aqiOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	interval := indexInterval(reading.Operation)
+
+	pm25, pm25Count, err := a.averagePollutant(reading, PM25Measurement, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	pm10, pm10Count, err := a.averagePollutant(reading, PM10Measurement, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	if math.IsNaN(pm25) && math.IsNaN(pm10) {
+		return nil, nil
+	}
+
+	result := &Result{Count: pm25Count}
+
+	if pm10Count > result.Count {
+		result.Count = pm10Count
+	}
+
+	switch reading.Operation.Index {
+	case postgres.EUCAQI:
+		result.Value, result.Label = EUCAQI(pm25, pm10, interval)
+	default:
+		result.Value, result.Label = USEPAAQI(pm25, pm10)
+	}
+
+	return result, nil
+}
+
+// Render is our implementation of the Operation interface method. Each index
+// is shared as a virtual sensor.
+func (a *aqiOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	processedSensor := &smartcitizen.Sensor{
+		Action:   reading.Operation.Action,
+		Category: result.Label,
+	}
+
+	switch reading.Operation.Index {
+	case postgres.EUCAQI:
+		processedSensor.ID = EUCAQISensorID
+		processedSensor.Name = "EU CAQI"
+		processedSensor.Description = "European Common Air Quality Index computed from particulate matter concentrations"
+	default:
+		processedSensor.ID = USEPAAQISensorID
+		processedSensor.Name = "US EPA AQI"
+		processedSensor.Description = "US EPA Air Quality Index computed from particulate matter concentrations"
+	}
+
+	interval := null.IntFrom(int64(indexInterval(reading.Operation)))
+	value := null.FloatFrom(result.Value)
+
+	processedSensor.Interval = &interval
+	processedSensor.Value = &value
+
+	return processedSensor
+}
+
+// averagePollutant returns the mean concentration over the interval of the
+// device's sensor reporting the given measurement along with the number of
+// values averaged, or NaN if the device has no such sensor, it reported no
+// value, or its value arrived too late to be included.
+func (a *aqiOperation) averagePollutant(reading *Reading, measurement string, interval uint32) (float64, int, error) {
+	sensor, err := a.findPollutant(reading.Device, measurement)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if sensor == nil {
+		return math.NaN(), 0, nil
+	}
+
+	values, err := reading.window(a.windower, a.lateness, sensor, interval, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if len(values) == 0 {
+		LateReadingsCounter.Inc()
+		return math.NaN(), 0, nil
+	}
+
+	return MeanValue(values), len(values), nil
+}
+
+// findPollutant returns the device's sensor reporting the given measurement
+// with its value converted into µg/m³, or nil if the device has no such sensor
+// or it reported no value.
+func (a *aqiOperation) findPollutant(device *smartcitizen.Device, measurement string) (*smartcitizen.Sensor, error) {
+	sensor, err := a.sensors.FindMeasurement(device, measurement, particulateUnit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve pollutant")
+	}
+
+	if sensor == nil || !validValue(sensor) {
+		return nil, nil
+	}
+
+	converted, err := a.sensors.ConvertSensor(sensor, particulateUnit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert pollutant")
+	}
+
+	return converted, nil
+}
+
+// indexInterval returns the averaging period in seconds configured for the
+// operation, or that required by its index if none is configured.
+func indexInterval(operation *postgres.Operation) uint32 {
+	if operation.Interval == 0 {
+		return DefaultIndexInterval(operation.Index)
+	}
+
+	return operation.Interval
+}
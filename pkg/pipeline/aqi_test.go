@@ -0,0 +1,101 @@
+package pipeline_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestUSEPAAQI(t *testing.T) {
+	testcases := []struct {
+		label            string
+		pm25             float64
+		pm10             float64
+		expected         float64
+		expectedCategory string
+	}{
+		{
+			label:            "good",
+			pm25:             5,
+			pm10:             20,
+			expected:         28,
+			expectedCategory: "Good",
+		},
+		{
+			label:            "moderate from pm25",
+			pm25:             12.05,
+			pm10:             60,
+			expected:         56,
+			expectedCategory: "Moderate",
+		},
+		{
+			label:            "pm10 only",
+			pm25:             math.NaN(),
+			pm10:             200,
+			expected:         123,
+			expectedCategory: "Unhealthy for Sensitive Groups",
+		},
+		{
+			label:            "capped",
+			pm25:             400,
+			pm10:             math.NaN(),
+			expected:         500,
+			expectedCategory: "Hazardous",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			aqi, category := pipeline.USEPAAQI(tc.pm25, tc.pm10)
+			assert.Equal(t, tc.expected, aqi)
+			assert.Equal(t, tc.expectedCategory, category)
+		})
+	}
+}
+
+func TestEUCAQI(t *testing.T) {
+	testcases := []struct {
+		label            string
+		pm25             float64
+		pm10             float64
+		interval         uint32
+		expected         float64
+		expectedCategory string
+	}{
+		{
+			label:            "hourly",
+			pm25:             20,
+			pm10:             60,
+			interval:         3600,
+			expected:         56.25,
+			expectedCategory: "Medium",
+		},
+		{
+			label:            "daily",
+			pm25:             15,
+			pm10:             10,
+			interval:         86400,
+			expected:         37.5,
+			expectedCategory: "Low",
+		},
+		{
+			label:            "above the grid",
+			pm25:             math.NaN(),
+			pm10:             270,
+			interval:         3600,
+			expected:         125,
+			expectedCategory: "Very High",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			caqi, category := pipeline.EUCAQI(tc.pm25, tc.pm10, tc.interval)
+			assert.InDelta(t, tc.expected, caqi, 1e-9)
+			assert.Equal(t, tc.expectedCategory, category)
+		})
+	}
+}
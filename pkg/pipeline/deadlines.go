@@ -0,0 +1,29 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// DefaultTransformTimeout is the default deadline for applying a stream's
+	// operations to the readings of a message, which may involve reading and
+	// updating state held in the database
+	DefaultTransformTimeout = 5 * time.Second
+
+	// DefaultWriteTimeout is the default deadline for each write of an event to
+	// the datastore
+	DefaultWriteTimeout = 10 * time.Second
+)
+
+// stageContext returns a context derived from the given context with the
+// deadline configured for the stage, or one that is only cancelled along with
+// its parent if the stage has no deadline.
+func (p *Processor) stageContext(ctx context.Context, stage Stage) (context.Context, context.CancelFunc) {
+	timeout := p.deadlines[stage]
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
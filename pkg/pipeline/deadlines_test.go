@@ -0,0 +1,106 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestProcessWithStageDeadlines(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	mv := mocks.MovingAverager{}
+	mv.On("MovingAverage", mock.Anything, 12.58, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(12.58, 1, nil)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:        datastore.Datastore(&ds),
+		MovingAverager:   &mv,
+		TransformTimeout: time.Minute,
+		WriteTimeout:     time.Hour,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.MovingAverage,
+						Interval: 900,
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+	start := time.Now()
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	// each stage sees the deadline configured for it
+	mv.AssertExpectations(t)
+	deadline, ok := mv.Calls[0].Arguments[0].(context.Context).Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, start.Add(time.Minute), deadline, 5*time.Second)
+
+	assert.Len(t, ds.Calls, 1)
+	deadline, ok = ds.Calls[0].Arguments[0].(context.Context).Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, start.Add(time.Hour), deadline, 5*time.Second)
+}
+
+func TestProcessWithCancelledContext(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "abc123",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// nothing is written once the context has been cancelled
+	err := processor.Process(ctx, device, payload)
+	assert.NotNil(t, err)
+	assert.Len(t, ds.Calls, 0)
+
+	processErr, ok := err.(*pipeline.ProcessError)
+	assert.True(t, ok)
+	assert.Len(t, processErr.Streams, 1)
+	assert.Equal(t, pipeline.TransformStage, processErr.Streams[0].Stage)
+	assert.Equal(t, context.Canceled, errors.Cause(processErr.Streams[0].Err))
+}
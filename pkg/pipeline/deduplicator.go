@@ -0,0 +1,218 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+// DefaultDedupHorizon is how long a reading is remembered in order to discard
+// copies of it if no other horizon is configured.
+const DefaultDedupHorizon = time.Hour
+
+var (
+	// DuplicateReadingsCounter is a prometheus counter recording the number of
+	// readings discarded as copies of readings already processed
+	DuplicateReadingsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "duplicate_readings",
+			Help:      "Count of duplicate readings discarded",
+		},
+	)
+
+	// DeduplicationKeysGauge is a prometheus gauge recording the number of
+	// readings remembered in memory by the deduplicator
+	DeduplicationKeysGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "deduplication_keys",
+			Help:      "Count of readings remembered in memory to discard duplicates",
+		},
+	)
+)
+
+// Deduplicator is an interface for a type that remembers the readings received
+// from each device. Seen records the reading with the given key, returning true
+// if it was already recorded within the horizon, in which case the reading is
+// a copy, e.g. redelivered by the broker or republished by the device. Forget
+// removes the record of a reading, so that a copy of a reading we failed to
+// process is not discarded when it is delivered again.
+type Deduplicator interface {
+	Seen(ctx context.Context, deviceToken, key string, horizon time.Duration) (bool, error)
+	Forget(ctx context.Context, deviceToken, key string) error
+}
+
+// NewDeduplicator returns a new Deduplicator instance. This is a simple
+// in-memory implementation, which also implements the Sweeper interface in
+// order to forget readings once they have passed the horizon.
+func NewDeduplicator(verbose bool, cl clock.Clock, logger kitlog.Logger) Deduplicator {
+	d := &deduplicator{
+		expiries: make(map[string]time.Time),
+		verbose:  verbose,
+		logger:   logger,
+		clock:    cl,
+	}
+
+	d.periodicRunner = newPeriodicRunner(sweepInterval, d.Sweep)
+
+	return d
+}
+
+// deduplicator is our type that implements the Deduplicator interface using
+// a map from the device token and reading key to the time at which we forget
+// the reading.
+type deduplicator struct {
+	sync.Mutex
+	*periodicRunner
+	expiries map[string]time.Time
+	verbose  bool
+	logger   kitlog.Logger
+	clock    clock.Clock
+}
+
+// Seen is our implementation of the Deduplicator interface method.
+func (d *deduplicator) Seen(ctx context.Context, deviceToken, key string, horizon time.Duration) (bool, error) {
+	key = fmt.Sprintf("%s:%s", deviceToken, key)
+
+	now := d.clock.Now()
+
+	d.Lock()
+	defer d.Unlock()
+
+	expiry, ok := d.expiries[key]
+	if ok && now.Before(expiry) {
+		return true, nil
+	}
+
+	if !ok {
+		DeduplicationKeysGauge.Inc()
+	}
+
+	d.expiries[key] = now.Add(horizon)
+
+	return false, nil
+}
+
+// Forget is our implementation of the Deduplicator interface method.
+func (d *deduplicator) Forget(ctx context.Context, deviceToken, key string) error {
+	key = fmt.Sprintf("%s:%s", deviceToken, key)
+
+	d.Lock()
+	defer d.Unlock()
+
+	if _, ok := d.expiries[key]; ok {
+		delete(d.expiries, key)
+		DeduplicationKeysGauge.Dec()
+	}
+
+	return nil
+}
+
+// Sweep is our implementation of the Sweeper interface method. It forgets any
+// readings which have passed their horizon.
+func (d *deduplicator) Sweep() {
+	now := d.clock.Now()
+
+	d.Lock()
+	defer d.Unlock()
+
+	for key, expiry := range d.expiries {
+		if now.Before(expiry) {
+			continue
+		}
+
+		delete(d.expiries, key)
+		DeduplicationKeysGauge.Dec()
+
+		if d.verbose {
+			d.logger.Log("key", key, "msg", "forgot seen reading")
+		}
+	}
+}
+
+// ReadingKey returns the key identifying a reading received from a device,
+// which is the time it was recorded along with a hash of its contents, so
+// that distinct readings recorded at the same time are not mistaken for copies.
+func ReadingKey(reading *smartcitizen.Device) (string, error) {
+	b, err := json.Marshal(reading)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal reading")
+	}
+
+	hash := sha256.Sum256(b)
+
+	return fmt.Sprintf("%s:%s", reading.RecordedAt.UTC().Format(time.RFC3339Nano), hex.EncodeToString(hash[:])), nil
+}
+
+// deduplicate returns the readings which haven't been seen within the horizon,
+// discarding any copies before they reach the stateful operations of any
+// stream, along with the keys under which the returned readings were recorded
+// as seen. If we have no deduplicator all the readings are returned. If an
+// error is returned any readings already recorded are forgotten again.
+func (p *Processor) deduplicate(ctx context.Context, deviceToken string, readings []*smartcitizen.Device) ([]*smartcitizen.Device, []string, error) {
+	if p.deduplicator == nil || p.dedupHorizon <= 0 {
+		return readings, nil, nil
+	}
+
+	unseen := []*smartcitizen.Device{}
+	keys := []string{}
+
+	for _, reading := range readings {
+		key, err := ReadingKey(reading)
+		if err != nil {
+			p.forget(deviceToken, keys)
+			return nil, nil, err
+		}
+
+		seen, err := p.deduplicator.Seen(ctx, deviceToken, key, p.dedupHorizon)
+		if err != nil {
+			p.forget(deviceToken, keys)
+			return nil, nil, errors.Wrap(err, "failed to check for duplicate reading")
+		}
+
+		if seen {
+			DuplicateReadingsCounter.Inc()
+
+			if p.verbose {
+				p.logger.Log("device_token", deviceToken, "recorded_at", reading.RecordedAt, "msg", "discarding duplicate reading")
+			}
+
+			continue
+		}
+
+		unseen = append(unseen, reading)
+		keys = append(keys, key)
+	}
+
+	return unseen, keys, nil
+}
+
+// forget removes the record of the readings with the given keys, which we
+// failed to process, so that they are processed rather than discarded if they
+// are delivered again. Readings are recorded as seen before they are processed
+// so that concurrent copies are discarded, and forgotten only on failure. We
+// don't use the processing context, as its cancellation may be the reason we
+// failed, and failures to forget are only logged as the reading's own error is
+// returned.
+func (p *Processor) forget(deviceToken string, keys []string) {
+	for _, key := range keys {
+		err := p.deduplicator.Forget(context.Background(), deviceToken, key)
+		if err != nil {
+			p.logger.Log("err", err, "device_token", deviceToken, "key", key, "msg", "failed to forget reading")
+		}
+	}
+}
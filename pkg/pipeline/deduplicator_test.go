@@ -0,0 +1,237 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestDeduplicator(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	keys := gaugeValue(t, pipeline.DeduplicationKeysGauge)
+
+	cl := clock.NewMock(time.Now())
+	dd := pipeline.NewDeduplicator(false, cl, logger)
+
+	sweeper, ok := dd.(pipeline.Sweeper)
+	assert.True(t, ok)
+
+	ctx := context.Background()
+
+	seen, err := dd.Seen(ctx, "abc123", "key", time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	// a copy of the reading is seen within the horizon
+	cl.Add(59 * time.Minute)
+
+	seen, err = dd.Seen(ctx, "abc123", "key", time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, seen)
+
+	// the same key from a different device is a different reading
+	seen, err = dd.Seen(ctx, "def456", "key", 10*time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	assert.Equal(t, keys+2, gaugeValue(t, pipeline.DeduplicationKeysGauge))
+
+	// readings are forgotten once past the horizon
+	cl.Add(time.Minute)
+	sweeper.Sweep()
+
+	assert.Equal(t, keys+1, gaugeValue(t, pipeline.DeduplicationKeysGauge))
+
+	seen, err = dd.Seen(ctx, "abc123", "key", time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	cl.Add(time.Hour)
+	sweeper.Sweep()
+
+	assert.Equal(t, keys, gaugeValue(t, pipeline.DeduplicationKeysGauge))
+
+	// a forgotten reading is no longer seen
+	seen, err = dd.Seen(ctx, "abc123", "key", time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, seen)
+
+	err = dd.Forget(ctx, "abc123", "key")
+	assert.Nil(t, err)
+
+	assert.Equal(t, keys, gaugeValue(t, pipeline.DeduplicationKeysGauge))
+
+	seen, err = dd.Seen(ctx, "abc123", "key", time.Hour)
+	assert.Nil(t, err)
+	assert.False(t, seen)
+}
+
+func TestProcessWithDeduplication(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	// each distinct reading is averaged exactly once
+	mv := mocks.MovingAverager{}
+	mv.On("MovingAverage", mock.Anything, 12.58, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(12.58, 1, nil).Once()
+	mv.On("MovingAverage", mock.Anything, 13.0, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(12.79, 2, nil).Once()
+	mv.On("MovingAverage", mock.Anything, 14.0, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(13.19, 3, nil).Once()
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Deduplicator:   pipeline.NewDeduplicator(false, clock.NewMock(time.Now()), logger),
+		DedupHorizon:   time.Hour,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.MovingAverage,
+						Interval: 900,
+					},
+				},
+			},
+		},
+	}
+
+	testcases := []struct {
+		label   string
+		payload string
+		written int
+	}{
+		{
+			label:   "first delivery",
+			payload: `{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`,
+			written: 1,
+		},
+		{
+			label:   "redelivery",
+			payload: `{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`,
+			written: 1,
+		},
+		{
+			label:   "different reading recorded at the same time",
+			payload: `{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":13.0}]}]}`,
+			written: 2,
+		},
+		{
+			label:   "republished with a new reading",
+			payload: `{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]},{"recorded_at":"2018-12-11T14:47:44Z","sensors":[{"id":12, "value":14.0}]}]}`,
+			written: 3,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			err := processor.Process(context.Background(), device, []byte(tc.payload))
+			assert.Nil(t, err)
+			assert.Len(t, ds.Calls, tc.written)
+		})
+	}
+
+	mv.AssertExpectations(t)
+}
+
+func TestProcessWithDeduplicationAfterFailure(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	// the first write fails, so the redelivered reading must be processed again
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, errors.New("unavailable")).Once()
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil).Once()
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:    datastore.Datastore(&ds),
+		Deduplicator: pipeline.NewDeduplicator(false, clock.NewMock(time.Now()), logger),
+		DedupHorizon: time.Hour,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "abc123",
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Share,
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.NotNil(t, err)
+	assert.Len(t, ds.Calls, 1)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	// once processed, further copies are discarded
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+}
+
+func TestProcessWithDeduplicationError(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+
+	dd := mocks.Deduplicator{}
+	dd.On("Seen", mock.Anything, "foo", mock.Anything, 10*time.Minute).Return(false, errors.New("unavailable"))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:    datastore.Datastore(&ds),
+		Deduplicator: &dd,
+		DedupHorizon: 10 * time.Minute,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "abc123",
+				CommunityID: "smartcitizen",
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.NotNil(t, err)
+	assert.Len(t, ds.Calls, 0)
+
+	processErr, ok := err.(*pipeline.ProcessError)
+	assert.True(t, ok)
+	assert.Len(t, processErr.Streams, 1)
+	assert.Equal(t, pipeline.DeduplicateStage, processErr.Streams[0].Stage)
+	assert.Equal(t, "unavailable", errors.Cause(processErr.Streams[0].Err).Error())
+}
@@ -0,0 +1,357 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+// Emitter is an interface for a type that limits how often a stream writes to
+// the datastore. Readings arriving within the emission interval are buffered,
+// and once the interval has elapsed a single summary of everything buffered is
+// returned for writing.
+type Emitter interface {
+	// Emit records the processed reading for the stream. If the stream is due
+	// to emit it returns the summary to write (of any buffered readings and
+	// this one) and true, otherwise the reading is buffered and false is
+	// returned.
+	Emit(device *postgres.Device, stream *postgres.Stream, reading *smartcitizen.Device) (*smartcitizen.Device, bool, error)
+
+	// Flush returns the summaries of the readings buffered by streams whose
+	// emission interval has elapsed without another reading arriving to emit
+	// them, or of every stream with readings buffered if all is true, and
+	// forgets streams which have nothing buffered and have not emitted for
+	// longer than their interval.
+	Flush(all bool) []*Emission
+}
+
+// Emission is a summary of buffered readings which is due to be written for a
+// stream, along with the device and stream to which it is written.
+type Emission struct {
+	Device  *postgres.Device
+	Stream  *postgres.Stream
+	Summary *smartcitizen.Device
+}
+
+// DeviceStore is an interface for a type from which the current configuration
+// of a device and its streams can be loaded. It returns an error whose cause is
+// sql.ErrNoRows if the device doesn't exist.
+type DeviceStore interface {
+	GetDevice(deviceToken string) (*postgres.Device, error)
+}
+
+// NewEmitter returns an instance of our Emitter interface. This is a simple
+// in-memory implementation.
+func NewEmitter(verbose bool, cl clock.Clock, logger kitlog.Logger) Emitter {
+	return &emitter{
+		streams: make(map[string]*emission),
+		verbose: verbose,
+		logger:  logger,
+		clock:   cl,
+	}
+}
+
+// emission is the state we keep for each stream, recording when it last wrote
+// to the datastore and the readings buffered since, along with the device and
+// stream they were buffered for so that they can be flushed.
+type emission struct {
+	device      *postgres.Device
+	stream      *postgres.Stream
+	lastEmitted time.Time
+	buffered    []*smartcitizen.Device
+}
+
+// due returns true if the stream's emission interval has elapsed.
+func (e *emission) due(now time.Time) bool {
+	return e.lastEmitted.IsZero() || now.Sub(e.lastEmitted) >= time.Second*time.Duration(e.stream.EmissionInterval)
+}
+
+// emitter is our type that implements the Emitter interface using a simple in
+// memory store. The store is a map keyed by stream id.
+type emitter struct {
+	sync.Mutex
+	streams map[string]*emission
+	verbose bool
+	logger  kitlog.Logger
+	clock   clock.Clock
+}
+
+// Emit is our implementation of the Emitter interface method.
+func (e *emitter) Emit(device *postgres.Device, stream *postgres.Stream, reading *smartcitizen.Device) (*smartcitizen.Device, bool, error) {
+	now := e.clock.Now()
+
+	e.Lock()
+	defer e.Unlock()
+
+	state, ok := e.streams[stream.StreamID]
+	if !ok {
+		state = &emission{}
+		e.streams[stream.StreamID] = state
+	}
+
+	// we keep the latest configuration in case the stream has been recreated
+	state.device = device
+	state.stream = stream
+	state.buffered = append(state.buffered, reading)
+
+	if !state.due(now) {
+		if e.verbose {
+			e.logger.Log("stream_id", stream.StreamID, "buffered", len(state.buffered), "msg", "buffering reading")
+		}
+
+		return nil, false, nil
+	}
+
+	summary := SummariseDevices(state.buffered)
+
+	state.buffered = nil
+	state.lastEmitted = now
+
+	return summary, true, nil
+}
+
+// Flush is our implementation of the Emitter interface method.
+func (e *emitter) Flush(all bool) []*Emission {
+	now := e.clock.Now()
+
+	e.Lock()
+	defer e.Unlock()
+
+	emissions := []*Emission{}
+
+	for streamID, state := range e.streams {
+		due := state.due(now)
+
+		// a stream with nothing buffered would emit its next reading
+		// immediately anyway once due, so we no longer need to keep its state
+		if len(state.buffered) == 0 {
+			if due {
+				delete(e.streams, streamID)
+
+				if e.verbose {
+					e.logger.Log("stream_id", streamID, "msg", "evicted idle emission state")
+				}
+			}
+
+			continue
+		}
+
+		if !due && !all {
+			continue
+		}
+
+		emissions = append(emissions, &Emission{
+			Device:  state.device,
+			Stream:  state.stream,
+			Summary: SummariseDevices(state.buffered),
+		})
+
+		state.buffered = nil
+		state.lastEmitted = now
+	}
+
+	return emissions
+}
+
+// NewFlusher returns a Sweeper which periodically has the processor write the
+// summaries of readings its emitter has buffered for streams which have stopped
+// receiving readings, which would otherwise never be written.
+func NewFlusher(processor *Processor, logger kitlog.Logger) Sweeper {
+	f := &flusher{
+		processor: processor,
+		logger:    logger,
+	}
+
+	f.periodicRunner = newPeriodicRunner(sweepInterval, f.Sweep)
+
+	return f
+}
+
+// flusher is our type that flushes the processor's emitter in the background.
+type flusher struct {
+	*periodicRunner
+	processor *Processor
+	logger    kitlog.Logger
+}
+
+// Sweep is our implementation of the Sweeper interface method. It writes any
+// summaries which are due, logging any streams which fail to write.
+func (f *flusher) Sweep() {
+	err := f.processor.Flush(context.Background())
+	if err != nil {
+		f.logger.Log("err", err, "msg", "failed to flush buffered readings")
+	}
+}
+
+// SummariseDevices combines a slice of processed devices for a stream into a
+// single device, so that a summary has the same shape however many readings it
+// includes. Device level fields are taken from the latest reading, while each
+// sensor is summarised by applying its action to the buffered values: the
+// minimum of minimums, the maximum of maximums, the median of medians, the
+// same percentile of percentiles, the highest air quality index, the latest
+// threshold state, the sum of bin counts, and the mean of any other values.
+// The number of readings summarised is recorded against each sensor.
+func SummariseDevices(devices []*smartcitizen.Device) *smartcitizen.Device {
+	if len(devices) == 0 {
+		return nil
+	}
+
+	summary := *devices[len(devices)-1]
+
+	keys := []string{}
+	summaries := map[string]*sensorSummary{}
+
+	for _, device := range devices {
+		for _, sensor := range device.Sensors {
+			key := sensorKey(sensor)
+
+			s, ok := summaries[key]
+			if !ok {
+				s = &sensorSummary{}
+				summaries[key] = s
+				keys = append(keys, key)
+			}
+
+			s.add(sensor)
+		}
+	}
+
+	summary.Sensors = []*smartcitizen.Sensor{}
+
+	for _, key := range keys {
+		summary.Sensors = append(summary.Sensors, summaries[key].sensor())
+	}
+
+	return &summary
+}
+
+// sensorKey returns a key identifying a processed sensor within a stream. A
+// stream may apply several operations to the same sensor, so we include the
+// action and its parameters.
+func sensorKey(sensor *smartcitizen.Sensor) string {
+	var interval int64
+	if sensor.Interval != nil {
+		interval = sensor.Interval.Int64
+	}
+
+	var percentile float64
+	if sensor.Percentile != nil {
+		percentile = sensor.Percentile.Float64
+	}
+
+	var halfLife int64
+	if sensor.HalfLife != nil {
+		halfLife = sensor.HalfLife.Int64
+	}
+
+	var samples int64
+	if sensor.Samples != nil {
+		samples = sensor.Samples.Int64
+	}
+
+	return fmt.Sprintf("%v:%s:%v:%v:%v:%v", sensor.ID, sensor.Action, interval, percentile, halfLife, samples)
+}
+
+// sensorSummary accumulates the readings of a single processed sensor.
+type sensorSummary struct {
+	latest   *smartcitizen.Sensor
+	highest  *smartcitizen.Sensor
+	readings int
+	values   []float64
+	counts   []int
+}
+
+// add includes the given sensor reading in the summary.
+func (s *sensorSummary) add(sensor *smartcitizen.Sensor) {
+	s.latest = sensor
+	s.readings++
+
+	if sensor.Value != nil && sensor.Value.Valid {
+		s.values = append(s.values, sensor.Value.Float64)
+
+		if s.highest == nil || sensor.Value.Float64 > s.highest.Value.Float64 {
+			s.highest = sensor
+		}
+	}
+
+	if sensor.Values != nil {
+		if s.counts == nil {
+			s.counts = make([]int, len(sensor.Values))
+		}
+
+		for i := range sensor.Values {
+			if i < len(s.counts) {
+				s.counts[i] = s.counts[i] + sensor.Values[i]
+			}
+		}
+	}
+}
+
+// sensor returns the summarised sensor. We copy the latest reading so that
+// buffered readings are never modified.
+func (s *sensorSummary) sensor() *smartcitizen.Sensor {
+	sensor := *s.latest
+	sensor.Readings = s.readings
+
+	// the index and category are taken together from the reading with the
+	// worst air quality so that they agree
+	if sensor.Action == postgres.AQI && s.highest != nil {
+		sensor = *s.highest
+		sensor.Readings = s.readings
+
+		return &sensor
+	}
+
+	if len(s.values) > 0 {
+		var (
+			value      null.Float
+			percentile float64
+		)
+
+		if sensor.Percentile != nil {
+			percentile = sensor.Percentile.Float64
+		}
+
+		switch sensor.Action {
+		case postgres.Min, postgres.Max, postgres.Median, postgres.Percentile:
+			value = null.FloatFrom(AggregateValues(s.values, sensor.Action, percentile))
+		default:
+			value = null.FloatFrom(MeanValue(s.values))
+		}
+
+		sensor.Value = &value
+	}
+
+	// the category of a bin is that into which most readings fell
+	if s.counts != nil {
+		sensor.Values = s.counts
+
+		if len(sensor.Labels) == len(s.counts) {
+			sensor.Category = sensor.Labels[modalBin(s.counts)]
+		}
+	}
+
+	return &sensor
+}
+
+// modalBin returns the index of the bin holding the most readings, preferring
+// the higher bin when there is a tie.
+func modalBin(counts []int) int {
+	modal := 0
+
+	for i, count := range counts {
+		if count >= counts[modal] {
+			modal = i
+		}
+	}
+
+	return modal
+}
@@ -0,0 +1,273 @@
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+func makeDevice(recordedAt time.Time, value float64) *smartcitizen.Device {
+	v := null.FloatFrom(value)
+
+	return &smartcitizen.Device{
+		Token:      "abc123",
+		RecordedAt: recordedAt,
+		Sensors: []*smartcitizen.Sensor{
+			&smartcitizen.Sensor{
+				ID:     12,
+				Action: postgres.Share,
+				Value:  &v,
+			},
+		},
+	}
+}
+
+func TestEmitter(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	now := time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	em := pipeline.NewEmitter(false, cl, logger)
+	assert.NotNil(t, em)
+
+	device := &postgres.Device{DeviceToken: "abc123"}
+	stream1 := &postgres.Stream{StreamID: "stream-1", EmissionInterval: 900}
+	stream2 := &postgres.Stream{StreamID: "stream-2", EmissionInterval: 900}
+
+	// the first reading is emitted immediately, in the same shape as a summary
+	summary, ok, err := em.Emit(device, stream1, makeDevice(cl.Now(), 10))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, summary.Sensors[0].Value.Float64)
+	assert.Equal(t, 1, summary.Sensors[0].Readings)
+
+	// readings within the interval are buffered
+	for _, value := range []float64{20, 30} {
+		cl.Add(5 * time.Minute)
+
+		summary, ok, err = em.Emit(device, stream1, makeDevice(cl.Now(), value))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+		assert.Nil(t, summary)
+	}
+
+	// other streams are unaffected
+	summary, ok, err = em.Emit(device, stream2, makeDevice(cl.Now(), 99))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 99.0, summary.Sensors[0].Value.Float64)
+
+	// once the interval has passed we get a summary of everything buffered
+	cl.Add(5 * time.Minute)
+
+	summary, ok, err = em.Emit(device, stream1, makeDevice(cl.Now(), 40))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, cl.Now(), summary.RecordedAt)
+	assert.Len(t, summary.Sensors, 1)
+	assert.Equal(t, 30.0, summary.Sensors[0].Value.Float64)
+	assert.Equal(t, 3, summary.Sensors[0].Readings)
+
+	// and the buffer starts again
+	cl.Add(time.Minute)
+
+	_, ok, err = em.Emit(device, stream1, makeDevice(cl.Now(), 50))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestEmitterFlush(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	now := time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC)
+	cl := clock.NewMock(now)
+
+	em := pipeline.NewEmitter(false, cl, logger)
+
+	device := &postgres.Device{DeviceToken: "abc123"}
+	stream1 := &postgres.Stream{StreamID: "stream-1", EmissionInterval: 900}
+	stream2 := &postgres.Stream{StreamID: "stream-2", EmissionInterval: 3600}
+
+	for _, stream := range []*postgres.Stream{stream1, stream2} {
+		_, ok, err := em.Emit(device, stream, makeDevice(cl.Now(), 10))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+	}
+
+	cl.Add(time.Minute)
+
+	for _, stream := range []*postgres.Stream{stream1, stream2} {
+		_, ok, err := em.Emit(device, stream, makeDevice(cl.Now(), 20))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// nothing is due until an interval has elapsed
+	assert.Empty(t, em.Flush(false))
+
+	// unless we flush everything buffered, e.g. when stopping
+	emissions := em.Flush(true)
+	assert.Len(t, emissions, 2)
+
+	for _, stream := range []*postgres.Stream{stream1, stream2} {
+		_, ok, err := em.Emit(device, stream, makeDevice(cl.Now(), 20))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// the stream with the shorter interval is flushed once it has elapsed,
+	// even though no further reading arrived to emit it
+	cl.Add(15 * time.Minute)
+
+	emissions = em.Flush(false)
+	assert.Len(t, emissions, 1)
+	assert.Equal(t, device, emissions[0].Device)
+	assert.Equal(t, stream1, emissions[0].Stream)
+	assert.Equal(t, 20.0, emissions[0].Summary.Sensors[0].Value.Float64)
+	assert.Equal(t, 1, emissions[0].Summary.Sensors[0].Readings)
+
+	// having been flushed the stream is rate limited as if it had emitted
+	_, ok, err := em.Emit(device, stream1, makeDevice(cl.Now(), 30))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	cl.Add(time.Hour)
+
+	emissions = em.Flush(false)
+	assert.Len(t, emissions, 2)
+
+	// once idle with nothing buffered a stream is forgotten, so its next
+	// reading is emitted immediately
+	cl.Add(time.Hour)
+
+	assert.Empty(t, em.Flush(false))
+
+	_, ok, err = em.Emit(device, stream1, makeDevice(cl.Now(), 40))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestSummariseDevices(t *testing.T) {
+	recordedAt := time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC)
+
+	makeSensors := func(min, max, mean float64, bins []int, state string) []*smartcitizen.Sensor {
+		minValue := null.FloatFrom(min)
+		maxValue := null.FloatFrom(max)
+		meanValue := null.FloatFrom(mean)
+		interval := null.IntFrom(900)
+
+		return []*smartcitizen.Sensor{
+			&smartcitizen.Sensor{ID: 12, Action: postgres.Min, Interval: &interval, Value: &minValue},
+			&smartcitizen.Sensor{ID: 12, Action: postgres.Max, Interval: &interval, Value: &maxValue},
+			&smartcitizen.Sensor{ID: 14, Action: postgres.MovingAverage, Interval: &interval, Value: &meanValue},
+			&smartcitizen.Sensor{ID: 29, Action: postgres.Bin, Bins: []float64{10, 20}, Values: bins},
+			&smartcitizen.Sensor{ID: 13, Action: postgres.Threshold, State: state},
+		}
+	}
+
+	devices := []*smartcitizen.Device{
+		&smartcitizen.Device{RecordedAt: recordedAt, Sensors: makeSensors(4, 8, 10, []int{1, 0, 0}, pipeline.StateNormal)},
+		&smartcitizen.Device{RecordedAt: recordedAt.Add(time.Minute), Sensors: makeSensors(2, 6, 20, []int{0, 1, 0}, pipeline.StateHigh)},
+		&smartcitizen.Device{RecordedAt: recordedAt.Add(2 * time.Minute), Sensors: makeSensors(3, 9, 60, []int{0, 1, 0}, pipeline.StateHigh)},
+	}
+
+	summary := pipeline.SummariseDevices(devices)
+	assert.Equal(t, recordedAt.Add(2*time.Minute), summary.RecordedAt)
+	assert.Len(t, summary.Sensors, 5)
+
+	assert.Equal(t, 2.0, summary.Sensors[0].Value.Float64)
+	assert.Equal(t, 9.0, summary.Sensors[1].Value.Float64)
+	assert.Equal(t, 30.0, summary.Sensors[2].Value.Float64)
+	assert.Equal(t, []int{1, 2, 0}, summary.Sensors[3].Values)
+	assert.Nil(t, summary.Sensors[3].Value)
+	assert.Equal(t, pipeline.StateHigh, summary.Sensors[4].State)
+
+	for _, sensor := range summary.Sensors {
+		assert.Equal(t, 3, sensor.Readings)
+	}
+
+	// the buffered devices are not modified
+	assert.Equal(t, 3.0, devices[2].Sensors[0].Value.Float64)
+	assert.Equal(t, []int{0, 1, 0}, devices[2].Sensors[3].Values)
+	assert.Equal(t, 0, devices[2].Sensors[0].Readings)
+}
+
+func TestSummariseDevicesByAction(t *testing.T) {
+	recordedAt := time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC)
+
+	makeSensors := func(value float64, category string) []*smartcitizen.Sensor {
+		median := null.FloatFrom(value)
+		percentile := null.FloatFrom(value)
+		index := null.FloatFrom(value)
+		ninety := null.FloatFrom(90)
+
+		return []*smartcitizen.Sensor{
+			&smartcitizen.Sensor{ID: 12, Action: postgres.Median, Value: &median},
+			&smartcitizen.Sensor{ID: 12, Action: postgres.Percentile, Percentile: &ninety, Value: &percentile},
+			&smartcitizen.Sensor{ID: pipeline.USEPAAQISensorID, Action: postgres.AQI, Value: &index, Category: category},
+		}
+	}
+
+	devices := []*smartcitizen.Device{}
+
+	for i, value := range []float64{1, 2, 100, 3, 4} {
+		category := "good"
+		if value > 50 {
+			category = "unhealthy"
+		}
+
+		devices = append(devices, &smartcitizen.Device{
+			RecordedAt: recordedAt.Add(time.Duration(i) * time.Minute),
+			Sensors:    makeSensors(value, category),
+		})
+	}
+
+	summary := pipeline.SummariseDevices(devices)
+	assert.Len(t, summary.Sensors, 3)
+
+	// medians and percentiles are not skewed by an outlier as a mean would be
+	assert.Equal(t, 3.0, summary.Sensors[0].Value.Float64)
+	assert.InDelta(t, 61.6, summary.Sensors[1].Value.Float64, 1e-9)
+	assert.Equal(t, 90.0, summary.Sensors[1].Percentile.Float64)
+
+	// the air quality index reports the worst reading along with its category
+	assert.Equal(t, 100.0, summary.Sensors[2].Value.Float64)
+	assert.Equal(t, "unhealthy", summary.Sensors[2].Category)
+	assert.Equal(t, 5, summary.Sensors[2].Readings)
+}
+
+func TestSummariseBinLabels(t *testing.T) {
+	makeDevice := func(counts []int, category string) *smartcitizen.Device {
+		return &smartcitizen.Device{
+			Sensors: []*smartcitizen.Sensor{
+				&smartcitizen.Sensor{
+					ID:       29,
+					Action:   postgres.Bin,
+					Bins:     []float64{10, 20},
+					Labels:   []string{"low", "medium", "high"},
+					Values:   counts,
+					Category: category,
+				},
+			},
+		}
+	}
+
+	summary := pipeline.SummariseDevices([]*smartcitizen.Device{
+		makeDevice([]int{0, 1, 0}, "medium"),
+		makeDevice([]int{0, 1, 0}, "medium"),
+		makeDevice([]int{0, 0, 1}, "high"),
+	})
+
+	// the category is that of the bin most readings fell into
+	assert.Equal(t, []int{0, 2, 1}, summary.Sensors[0].Values)
+	assert.Equal(t, "medium", summary.Sensors[0].Category)
+}
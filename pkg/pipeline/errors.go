@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// Stage is a type used to identify the step of the pipeline at which a stream
+// failed.
+type Stage string
+
+const (
+	// ParseStage is the stage at which the payload received from the device is
+	// parsed, so failures here affect every stream of the device
+	ParseStage = Stage("parse")
+
+	// DeduplicateStage is the stage at which readings already processed are
+	// discarded, so failures here also affect every stream of the device
+	DeduplicateStage = Stage("deduplicate")
+
+	// TransformStage is the stage at which the stream's operations and any
+	// transformation script are applied to the readings
+	TransformStage = Stage("transform")
+
+	// EncryptStage is the stage at which zenroom encrypts an event for the
+	// stream's community
+	EncryptStage = Stage("encrypt")
+
+	// WriteStage is the stage at which the encrypted event is written to the
+	// datastore
+	WriteStage = Stage("write")
+)
+
+var (
+	// StreamErrorCounter is a prometheus counter vec recording a count of the
+	// streams that failed to process a payload, labelled by the stream, its
+	// community and the stage at which it failed
+	StreamErrorCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "stream_errors",
+			Help:      "Count of streams failing to process a payload",
+		},
+		[]string{"stream_id", "community_id", "stage"},
+	)
+)
+
+// StreamError is the error recorded when a single stream fails to process a
+// payload. It identifies the stream and the stage at which it failed, and
+// wraps the underlying error.
+type StreamError struct {
+	StreamID    string
+	CommunityID string
+	Stage       Stage
+	Err         error
+}
+
+// newStreamError returns a new StreamError for the given stream.
+func newStreamError(stream *postgres.Stream, stage Stage, err error) *StreamError {
+	return &StreamError{
+		StreamID:    stream.StreamID,
+		CommunityID: stream.CommunityID,
+		Stage:       stage,
+		Err:         err,
+	}
+}
+
+// Error is our implementation of the error interface.
+func (s *StreamError) Error() string {
+	return fmt.Sprintf("stream %s failed at %s stage: %v", s.StreamID, s.Stage, s.Err)
+}
+
+// Cause returns the underlying error, so that errors.Cause is able to unwrap
+// it.
+func (s *StreamError) Cause() error {
+	return s.Err
+}
+
+// ProcessError is the error returned by the processor when one or more of a
+// device's streams failed to process a payload. Streams are processed
+// independently, so any stream not listed was written successfully.
+type ProcessError struct {
+	Streams []*StreamError
+}
+
+// Error is our implementation of the error interface.
+func (p *ProcessError) Error() string {
+	messages := make([]string, len(p.Streams))
+	for i, streamErr := range p.Streams {
+		messages[i] = streamErr.Error()
+	}
+
+	return fmt.Sprintf("%d stream(s) failed: %s", len(p.Streams), strings.Join(messages, "; "))
+}
+
+// newProcessError returns a ProcessError for the given failures, counting each
+// of them, or nil if there were no failures.
+func newProcessError(failures []*StreamError) error {
+	if len(failures) == 0 {
+		return nil
+	}
+
+	for _, failure := range failures {
+		StreamErrorCounter.WithLabelValues(failure.StreamID, failure.CommunityID, string(failure.Stage)).Inc()
+	}
+
+	return &ProcessError{Streams: failures}
+}
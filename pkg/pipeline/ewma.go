@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+// ExponentialAverager is an interface for a type that can return an
+// exponentially weighted moving average for the given stream/device/sensor/half
+// life. Readings are weighted by the time elapsed between their recorded times,
+// so irregular reporting intervals don't skew the average.
+type ExponentialAverager interface {
+	ExponentialAverage(value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, halfLife uint32) (float64, error)
+}
+
+// ewmaState is the state we hold for each stream/device/sensor/half life, which
+// is just the current average and the recorded time of the last reading
+// included in it.
+type ewmaState struct {
+	average    float64
+	recordedAt time.Time
+}
+
+// NewExponentialAverager returns an instance of our ExponentialAverager
+// interface. This is a simple in-memory implementation.
+func NewExponentialAverager(verbose bool, logger kitlog.Logger) ExponentialAverager {
+	return &exponentialAverager{
+		states:  make(map[string]*ewmaState),
+		verbose: verbose,
+		logger:  logger,
+	}
+}
+
+// exponentialAverager is our type that implements the ExponentialAverager
+// interface using a simple in memory store. The store is a map with a key based
+// on the stream id, device token, sensor id and half life, with values holding
+// a constant amount of state regardless of the half life.
+type exponentialAverager struct {
+	sync.Mutex
+	states  map[string]*ewmaState
+	verbose bool
+	logger  kitlog.Logger
+}
+
+// ExponentialAverage is our implementation of the ExponentialAverager interface
+// method. The first reading for a stream/device/sensor is returned as is.
+// Readings recorded at or before the last reading can't be placed in the
+// series, so leave the average unchanged.
+func (e *exponentialAverager) ExponentialAverage(value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, halfLife uint32) (float64, error) {
+	// build our key for the stream/device/sensor/half life
+	key := fmt.Sprintf("%s:%s:%v:%v", streamID, deviceToken, sensorID, halfLife)
+
+	e.Lock()
+	defer e.Unlock()
+
+	state, ok := e.states[key]
+	if !ok {
+		e.states[key] = &ewmaState{
+			average:    value,
+			recordedAt: recordedAt,
+		}
+
+		return value, nil
+	}
+
+	if !recordedAt.After(state.recordedAt) {
+		if e.verbose {
+			e.logger.Log("device_token", deviceToken, "sensor_id", sensorID, "recordedAt", recordedAt, "msg", "ignoring out of order reading")
+		}
+
+		return state.average, nil
+	}
+
+	weight := EWMAWeight(recordedAt.Sub(state.recordedAt), halfLife)
+
+	state.average = state.average + weight*(value-state.average)
+	state.recordedAt = recordedAt
+
+	return state.average, nil
+}
+
+// EWMAWeight returns the weight given to a new reading arriving the given
+// duration after the previous one, for an average with the given half life in
+// seconds. The previous average decays by half over each half life, so a
+// reading arriving one half life after the last has a weight of 0.5.
+func EWMAWeight(gap time.Duration, halfLife uint32) float64 {
+	if halfLife == 0 {
+		return 1
+	}
+
+	return 1 - math.Exp2(-gap.Seconds()/float64(halfLife))
+}
@@ -0,0 +1,71 @@
+package pipeline_test
+
+import (
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestExponentialAverager(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ew := pipeline.NewExponentialAverager(false, logger)
+	assert.NotNil(t, ew)
+
+	now := time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC)
+
+	// first value is returned as is
+	avg, err := ew.ExponentialAverage(10, now, "stream1", "abc123", 55, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.0, avg)
+
+	// after one half life the new reading has half the weight
+	avg, err = ew.ExponentialAverage(20, now.Add(10*time.Minute), "stream1", "abc123", 55, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 15.0, avg)
+
+	// another series is unaffected, including the same sensor in another stream
+	avg, err = ew.ExponentialAverage(2, now, "stream1", "abc123", 12, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.0, avg)
+
+	avg, err = ew.ExponentialAverage(40, now.Add(10*time.Minute), "stream2", "abc123", 55, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 40.0, avg)
+
+	// after two half lives the new reading has three quarters of the weight
+	avg, err = ew.ExponentialAverage(35, now.Add(30*time.Minute), "stream1", "abc123", 55, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, avg)
+
+	// readings which are out of order leave the average unchanged
+	avg, err = ew.ExponentialAverage(100, now.Add(30*time.Minute), "stream1", "abc123", 55, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, avg)
+
+	avg, err = ew.ExponentialAverage(100, now, "stream1", "abc123", 55, 600)
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, avg)
+}
+
+func TestEWMAWeight(t *testing.T) {
+	testcases := []struct {
+		gap      time.Duration
+		halfLife uint32
+		expected float64
+	}{
+		{gap: 0, halfLife: 60, expected: 0},
+		{gap: time.Minute, halfLife: 60, expected: 0.5},
+		{gap: 2 * time.Minute, halfLife: 60, expected: 0.75},
+		{gap: 3 * time.Minute, halfLife: 60, expected: 0.875},
+		{gap: time.Minute, halfLife: 0, expected: 1},
+	}
+
+	for _, tc := range testcases {
+		assert.InDelta(t, tc.expected, pipeline.EWMAWeight(tc.gap, tc.halfLife), 1e-9)
+	}
+}
@@ -0,0 +1,176 @@
+package pipeline
+
+import (
+	"math"
+	"strings"
+
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+const (
+	// metresPerDegree is the approximate length in metres of one degree of
+	// latitude (or of longitude at the equator)
+	metresPerDegree = 111320.0
+
+	// geohashAlphabet is the base32 alphabet used when encoding geohashes
+	geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	// MaxGeohashPrecision is the maximum number of characters we allow in a
+	// geohash, which gives a cell of a few centimetres.
+	MaxGeohashPrecision = 12
+)
+
+// applyLocationPolicy updates the location of the given device according to
+// the location policy configured for the stream. It must be called on a copy
+// of the parsed device as multiple streams share the same parsed data.
+func applyLocationPolicy(device *smartcitizen.Device, stream *postgres.Stream) {
+	if device.Longitude == nil || device.Latitude == nil {
+		return
+	}
+
+	switch stream.LocationPolicy {
+	case postgres.Grid:
+		longitude, latitude := SnapToGrid(device.Longitude.Float64, device.Latitude.Float64, stream.LocationGridSize)
+		setLocation(device, longitude, latitude)
+	case postgres.Geohash:
+		hash := EncodeGeohash(device.Longitude.Float64, device.Latitude.Float64, int(stream.LocationGeohashPrecision))
+		longitude, latitude := DecodeGeohash(hash)
+		setLocation(device, longitude, latitude)
+		device.Geohash = hash
+	case postgres.Omit:
+		device.Longitude = nil
+		device.Latitude = nil
+	}
+}
+
+// setLocation assigns fresh location values to the device, so we never write
+// through pointers that may be shared with the original parsed device.
+func setLocation(device *smartcitizen.Device, longitude, latitude float64) {
+	lon := null.FloatFrom(longitude)
+	lat := null.FloatFrom(latitude)
+
+	device.Longitude = &lon
+	device.Latitude = &lat
+}
+
+// SnapToGrid returns the centre of the grid cell containing the given location,
+// where the grid is made up of cells of approximately size metres along each
+// side. The longitude step is widened with latitude so that cells remain
+// roughly square away from the equator.
+func SnapToGrid(longitude, latitude float64, size uint32) (float64, float64) {
+	if size == 0 {
+		return longitude, latitude
+	}
+
+	latStep := float64(size) / metresPerDegree
+	snappedLat := (math.Floor(latitude/latStep) + 0.5) * latStep
+	snappedLat = math.Max(-90, math.Min(90, snappedLat))
+
+	cos := math.Cos(snappedLat * math.Pi / 180)
+	if cos <= 0 {
+		// at the poles every longitude is the same place
+		return 0, snappedLat
+	}
+
+	lonStep := float64(size) / (metresPerDegree * cos)
+	snappedLon := (math.Floor(longitude/lonStep) + 0.5) * lonStep
+	snappedLon = math.Max(-180, math.Min(180, snappedLon))
+
+	return snappedLon, snappedLat
+}
+
+// EncodeGeohash returns the geohash of the given location truncated to the
+// given number of characters.
+func EncodeGeohash(longitude, latitude float64, precision int) string {
+	if precision < 1 {
+		precision = 1
+	}
+
+	if precision > MaxGeohashPrecision {
+		precision = MaxGeohashPrecision
+	}
+
+	lonRange := [2]float64{-180, 180}
+	latRange := [2]float64{-90, 90}
+
+	var hash strings.Builder
+
+	even := true
+	bit := 0
+	ch := 0
+
+	for hash.Len() < precision {
+		if even {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if longitude >= mid {
+				ch = ch<<1 | 1
+				lonRange[0] = mid
+			} else {
+				ch = ch << 1
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if latitude >= mid {
+				ch = ch<<1 | 1
+				latRange[0] = mid
+			} else {
+				ch = ch << 1
+				latRange[1] = mid
+			}
+		}
+
+		even = !even
+		bit++
+
+		if bit == 5 {
+			hash.WriteByte(geohashAlphabet[ch])
+			bit = 0
+			ch = 0
+		}
+	}
+
+	return hash.String()
+}
+
+// DecodeGeohash returns the longitude and latitude of the centre of the cell
+// identified by the given geohash. Any characters not in the geohash alphabet
+// are ignored.
+func DecodeGeohash(hash string) (float64, float64) {
+	lonRange := [2]float64{-180, 180}
+	latRange := [2]float64{-90, 90}
+
+	even := true
+
+	for _, c := range hash {
+		idx := strings.IndexRune(geohashAlphabet, c)
+		if idx < 0 {
+			continue
+		}
+
+		for mask := 16; mask > 0; mask >>= 1 {
+			if even {
+				mid := (lonRange[0] + lonRange[1]) / 2
+				if idx&mask != 0 {
+					lonRange[0] = mid
+				} else {
+					lonRange[1] = mid
+				}
+			} else {
+				mid := (latRange[0] + latRange[1]) / 2
+				if idx&mask != 0 {
+					latRange[0] = mid
+				} else {
+					latRange[1] = mid
+				}
+			}
+
+			even = !even
+		}
+	}
+
+	return (lonRange[0] + lonRange[1]) / 2, (latRange[0] + latRange[1]) / 2
+}
@@ -0,0 +1,108 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestSnapToGrid(t *testing.T) {
+	testcases := []struct {
+		label        string
+		longitude    float64
+		latitude     float64
+		size         uint32
+		expectedLon  float64
+		expectedLat  float64
+		sameCellLon  float64
+		sameCellLat  float64
+		differentLon float64
+		differentLat float64
+	}{
+		{
+			label:        "1km grid in barcelona",
+			longitude:    2.1734,
+			latitude:     41.3851,
+			size:         1000,
+			sameCellLon:  2.1730,
+			sameCellLat:  41.3840,
+			differentLon: 2.2134,
+			differentLat: 41.4251,
+		},
+		{
+			label:        "100m grid south of the equator",
+			longitude:    -43.1729,
+			latitude:     -22.9068,
+			size:         100,
+			sameCellLon:  -43.17291,
+			sameCellLat:  -22.90681,
+			differentLon: -43.1629,
+			differentLat: -22.8968,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			lon, lat := pipeline.SnapToGrid(tc.longitude, tc.latitude, tc.size)
+
+			// snapped location is within half a cell of the original
+			assert.InDelta(t, tc.latitude, lat, float64(tc.size)/111320)
+			assert.InDelta(t, tc.longitude, lon, float64(tc.size)/111320*2)
+
+			// nearby locations snap to the same point
+			sameLon, sameLat := pipeline.SnapToGrid(tc.sameCellLon, tc.sameCellLat, tc.size)
+			assert.Equal(t, lon, sameLon)
+			assert.Equal(t, lat, sameLat)
+
+			// distant locations do not
+			diffLon, diffLat := pipeline.SnapToGrid(tc.differentLon, tc.differentLat, tc.size)
+			assert.NotEqual(t, lon, diffLon)
+			assert.NotEqual(t, lat, diffLat)
+		})
+	}
+}
+
+func TestGeohash(t *testing.T) {
+	testcases := []struct {
+		label     string
+		longitude float64
+		latitude  float64
+		precision int
+		expected  string
+	}{
+		{
+			label:     "full precision",
+			longitude: -5.6,
+			latitude:  42.6,
+			precision: 12,
+			expected:  "ezs42e44yx96",
+		},
+		{
+			label:     "truncated",
+			longitude: -5.6,
+			latitude:  42.6,
+			precision: 5,
+			expected:  "ezs42",
+		},
+		{
+			label:     "precision clamped",
+			longitude: -5.6,
+			latitude:  42.6,
+			precision: 20,
+			expected:  "ezs42e44yx96",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got := pipeline.EncodeGeohash(tc.longitude, tc.latitude, tc.precision)
+			assert.Equal(t, tc.expected, got)
+
+			// the centre of the cell must encode to the same hash
+			lon, lat := pipeline.DecodeGeohash(got)
+			assert.Equal(t, got, pipeline.EncodeGeohash(lon, lat, tc.precision))
+		})
+	}
+}
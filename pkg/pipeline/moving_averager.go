@@ -1,18 +1,104 @@
 package pipeline
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/system"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// sweepInterval is how often the in-memory moving averager looks for series
+	// that are no longer being updated
+	sweepInterval = time.Minute
+
+	// minSeriesCapacity is the smallest ring buffer we allocate for a series
+	minSeriesCapacity = 4
+
+	// sampleWindowIdleTimeout is how long a series holding the last N samples
+	// is kept after its last update. These windows have no duration to expire
+	// them, but we still want to forget devices that have gone away.
+	sampleWindowIdleTimeout = 7 * 24 * time.Hour
+
+	// DefaultAllowedLateness is how long before the latest value of a moving
+	// average a value may have been recorded and still be included if no other
+	// lateness is configured
+	DefaultAllowedLateness = 5 * time.Minute
+)
+
+var (
+	// LateReadingsCounter is a prometheus counter recording the number of
+	// values discarded by moving averages and windows for arriving too late
+	LateReadingsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "late_readings",
+			Help:      "Count of values arriving too late to be included in moving averages or windows",
+		},
+	)
+
+	// MovingAverageKeysGauge is a prometheus gauge recording the number of
+	// stream/device/sensor/window series held in memory by the moving averager
+	MovingAverageKeysGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "moving_average_keys",
+			Help:      "Count of moving average series held in memory",
+		},
+	)
+
+	// MovingAverageEntriesGauge is a prometheus gauge recording the number of
+	// values held in memory by the moving averager across all series
+	MovingAverageEntriesGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "moving_average_entries",
+			Help:      "Count of moving average values held in memory",
+		},
+	)
 )
 
 // MovingAverager is an interface for a type that can return a moving average
-// for the given device/sensor/interval
+// for the given stream/device/sensor/window. Series are kept per stream, as
+// every stream subscribed to a device is sent each of its readings, which would
+// otherwise be counted once per stream. Windows are in event time, i.e. by
+// when values were recorded rather than received, and the window is the
+// interval seconds up to when the value was recorded if interval is non-zero,
+// otherwise the last samples values recorded up to then. The number of values included
+// in the average is also returned. Values may arrive out of order, but values
+// recorded more than the allowed lateness before the latest value of the
+// series are discarded, in which case the count is zero. Values without a
+// timestamp, or recorded in the future, are treated as recorded on arrival.
+// Implementations which store values remotely should give up once the context
+// is done.
 type MovingAverager interface {
-	MovingAverage(value float64, deviceToken string, sensorID int, interval uint32) (float64, error)
+	MovingAverage(ctx context.Context, value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) (float64, int, error)
+}
+
+// Sweeper is an interface for a type holding state which must be periodically
+// evicted once it is no longer being used. Start begins sweeping in the
+// background, and Stop ends it.
+type Sweeper interface {
+	system.Startable
+	system.Stoppable
+
+	// Sweep immediately evicts any state that is no longer being used
+	Sweep()
+}
+
+// MovingAverageExpirer is an interface for a persistent MovingAverager which
+// can delete the series that are no longer being updated, returning the number
+// of values deleted. It is implemented by postgres.DB.
+type MovingAverageExpirer interface {
+	ExpireMovingAverages(lateness, idleTimeout time.Duration) (int, error)
 }
 
 // entry is a type we use to store incoming values which we then calculate a
@@ -22,95 +108,349 @@ type entry struct {
 	Value     float64
 }
 
+// series holds the entries for a single stream/device/sensor/window in a ring
+// buffer ordered by when they were recorded, along with a running sum of their
+// values so that calculating the average doesn't usually require visiting
+// every entry. The watermark is the latest time at which an entry was
+// recorded, and lastSeen the time at which the series was last updated.
+type series struct {
+	entries   []entry
+	head      int
+	count     int
+	sum       float64
+	interval  uint32
+	samples   uint32
+	lateness  time.Duration
+	watermark int64
+	lastSeen  int64
+}
+
+// insert adds an entry to the ring buffer in the order it was recorded,
+// growing the buffer if it is full. Entries normally arrive in order, in which
+// case this appends the entry to the end of the buffer.
+func (s *series) insert(e entry) {
+	if s.count == len(s.entries) {
+		capacity := 2 * len(s.entries)
+		if capacity < minSeriesCapacity {
+			capacity = minSeriesCapacity
+		}
+
+		s.resize(capacity)
+	}
+
+	i := s.count
+	for i > 0 && s.at(i-1).Timestamp > e.Timestamp {
+		s.entries[(s.head+i)%len(s.entries)] = s.at(i - 1)
+		i--
+	}
+
+	s.entries[(s.head+i)%len(s.entries)] = e
+	s.count++
+	s.sum = s.sum + e.Value
+}
+
+// at returns the entry at the given position from the front of the ring
+// buffer.
+func (s *series) at(i int) entry {
+	return s.entries[(s.head+i)%len(s.entries)]
+}
+
+// expire removes entries older than the cutoff from the front of the ring
+// buffer, returning the number removed.
+func (s *series) expire(cutoff int64) int {
+	removed := 0
+
+	for s.count > 0 && s.entries[s.head].Timestamp < cutoff {
+		s.pop()
+		removed++
+	}
+
+	s.compact()
+
+	return removed
+}
+
+// trim removes entries older than the cutoff from the front of the ring buffer
+// until it holds no more than the given number, returning the number removed.
+func (s *series) trim(samples int, cutoff int64) int {
+	removed := 0
+
+	for s.count > samples && s.entries[s.head].Timestamp < cutoff {
+		s.pop()
+		removed++
+	}
+
+	s.compact()
+
+	return removed
+}
+
+// pop removes the oldest entry from the ring buffer.
+func (s *series) pop() {
+	s.sum = s.sum - s.entries[s.head].Value
+	s.head = (s.head + 1) % len(s.entries)
+	s.count--
+
+	if s.count == 0 {
+		// reset to avoid accumulating floating point error
+		s.sum = 0
+	}
+}
+
+// compact shrinks the buffer if it is mostly empty so a burst of readings
+// doesn't pin memory for the life of the series.
+func (s *series) compact() {
+	if len(s.entries) > minSeriesCapacity && s.count < len(s.entries)/4 {
+		s.resize(len(s.entries) / 2)
+	}
+}
+
+// sampleWindow returns true if the series holds the last N samples rather than
+// the samples within some interval.
+func (s *series) sampleWindow() bool {
+	return s.interval == 0 && s.samples > 0
+}
+
+// idleTimeout returns how long the series is kept without being updated.
+func (s *series) idleTimeout() time.Duration {
+	if s.sampleWindow() {
+		return sampleWindowIdleTimeout
+	}
+
+	return time.Second*time.Duration(s.interval) + s.lateness
+}
+
+// resize copies the entries into a new buffer of the given capacity, which
+// must be large enough to hold them.
+func (s *series) resize(capacity int) {
+	entries := make([]entry, capacity)
+
+	for i := 0; i < s.count; i++ {
+		entries[i] = s.entries[(s.head+i)%len(s.entries)]
+	}
+
+	s.entries = entries
+	s.head = 0
+}
+
+// average returns the mean of the entries in the series.
+func (s *series) average() float64 {
+	return s.sum / float64(s.count)
+}
+
+// averageBetween returns the mean and number of the entries recorded between
+// the given times inclusive.
+func (s *series) averageBetween(from, to int64) (float64, int) {
+	if s.at(0).Timestamp >= from && s.at(s.count-1).Timestamp <= to {
+		return s.average(), s.count
+	}
+
+	sum, count := 0.0, 0
+
+	for i := 0; i < s.count; i++ {
+		e := s.at(i)
+		if e.Timestamp >= from && e.Timestamp <= to {
+			sum = sum + e.Value
+			count++
+		}
+	}
+
+	return sum / float64(count), count
+}
+
+// averageLast returns the mean and number of the last samples entries
+// recorded no later than the given time.
+func (s *series) averageLast(samples int, to int64) (float64, int) {
+	if s.count <= samples && s.at(s.count-1).Timestamp <= to {
+		return s.average(), s.count
+	}
+
+	sum, count := 0.0, 0
+
+	for i := s.count - 1; i >= 0 && count < samples; i-- {
+		e := s.at(i)
+		if e.Timestamp <= to {
+			sum = sum + e.Value
+			count++
+		}
+	}
+
+	return sum / float64(count), count
+}
+
 // NewMovingAverager returns an instance of our MovingAverager interface. This
-// is a simple in-memory implementation.
+// is a simple in-memory implementation, which also implements the Sweeper
+// interface in order to evict series that are no longer being updated.
 func NewMovingAverager(verbose bool, cl clock.Clock, logger kitlog.Logger) MovingAverager {
-	return &movingAverager{
-		entries: make(map[string][]entry),
+	m := &movingAverager{
+		series:  make(map[string]*series),
 		verbose: verbose,
 		logger:  logger,
 		clock:   cl,
 	}
+
+	m.periodicRunner = newPeriodicRunner(sweepInterval, m.Sweep)
+
+	return m
 }
 
-// movingAverage is our type that implements the MovingAverager interface using
-// a simple in memory store. The store is a map with a key based on the device
-// token, sensor id and moving average duration, and values being slices of the
-// `entry` type shown above. When a value is received the algorithm is to read
-// the slice for the appropriate key, iterate through it removing any entries
-// that should no longer be included in the average, and counting and totalling
-// the ones that should. The allows us to calculate the average. We then write a
-// new slice back into the map.
+// movingAverager is our type that implements the MovingAverager interface using
+// a simple in memory store. The store is a map with a key based on the stream
+// id, device token, sensor id and moving average window, and values being a
+// ring buffer of the `entry` type shown above. When a value is received we
+// insert it in the order it was recorded, and then drop any entries from the
+// front of the buffer that can no longer be included in an average, subtracting
+// them from the running sum. A background sweeper deletes keys which have not
+// been updated for longer than their interval and allowed lateness, e.g.
+// because a device was unsubscribed or a stream changed its interval.
 type movingAverager struct {
-	sync.RWMutex
-	entries map[string][]entry
+	sync.Mutex
+	*periodicRunner
+	series  map[string]*series
 	verbose bool
 	logger  kitlog.Logger
 	clock   clock.Clock
 }
 
 // MovingAverage is our implementation of the MovingAverager interface method.
-func (m *movingAverager) MovingAverage(value float64, deviceToken string, sensorID int, interval uint32) (float64, error) {
-	// build our key for the device/sensor/interval
-	key := fmt.Sprintf("%s:%v:%v", deviceToken, sensorID, interval)
+// We retain entries recorded within the allowed lateness of the watermark, and
+// for interval windows also those within the interval before that, so that a
+// late value is averaged over the window up to when it was recorded.
+func (m *movingAverager) MovingAverage(ctx context.Context, value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) (float64, int, error) {
+	// build our key for the stream/device/sensor/window
+	key := fmt.Sprintf("%s:%s:%v:%v:%v", streamID, deviceToken, sensorID, interval, samples)
 
 	now := m.clock.Now()
-	intervalDuration := time.Second * time.Duration(-int(interval))
-	previousTime := now.Add(intervalDuration)
 
-	// look in the map for the slice of entries for this combination
-	m.RLock()
-	entries, ok := m.entries[key]
-	m.RUnlock()
+	if recordedAt.IsZero() || recordedAt.After(now) {
+		recordedAt = now
+	}
+
+	timestamp := recordedAt.Unix()
+
+	m.Lock()
+	defer m.Unlock()
 
+	s, ok := m.series[key]
 	if !ok {
-		// no value exists at the moment, so we need to create a new slice, and insert into the map
-		entries = []entry{
-			entry{
-				Timestamp: now.Unix(),
-				Value:     value,
-			},
+		s = &series{
+			interval: interval,
+			samples:  samples,
+		}
+
+		m.series[key] = s
+		MovingAverageKeysGauge.Inc()
+	}
+
+	s.lateness = lateness
+	s.lastSeen = now.Unix()
+
+	// discard values arriving too late to be included
+	if s.watermark > 0 && timestamp < s.watermark-int64(lateness/time.Second) {
+		if m.verbose {
+			m.logger.Log("key", key, "recorded_at", recordedAt, "msg", "discarded late moving average value")
 		}
 
-		m.Lock()
-		m.entries[key] = entries
-		m.Unlock()
+		return 0, 0, nil
+	}
+
+	s.insert(entry{
+		Timestamp: timestamp,
+		Value:     value,
+	})
+
+	if timestamp > s.watermark {
+		s.watermark = timestamp
+	}
+
+	// exclude any entries older than we may still need, keeping at least the
+	// last N if this is a sample window, then average over the window
+	cutoff := s.watermark - int64(lateness/time.Second)
+
+	var (
+		avg     float64
+		count   int
+		removed int
+	)
 
-		// as this is the first value the average value is just the received value
-		return value, nil
+	if s.sampleWindow() {
+		removed = s.trim(int(samples), cutoff)
+		avg, count = s.averageLast(int(samples), timestamp)
+	} else {
+		removed = s.expire(cutoff - int64(interval))
+		avg, count = s.averageBetween(timestamp-int64(interval), timestamp)
 	}
 
-	newEntries := []entry{}
-	counter := 0
-	accumulator := 0.0
+	MovingAverageEntriesGauge.Add(float64(1 - removed))
+
+	return avg, count, nil
+}
+
+// Sweep is our implementation of the Sweeper interface method. It deletes any
+// series which has not been updated for longer than its interval and allowed
+// lateness, as its entries would normally be excluded from the next average
+// anyway. Sample windows are deleted once they have been idle for
+// sampleWindowIdleTimeout.
+func (m *movingAverager) Sweep() {
+	now := m.clock.Now()
+
+	m.Lock()
+	defer m.Unlock()
 
-	// we got some values, so we need to iterate over our entries (including the
-	// new value), excluding any that are older than we care about
-	for _, e := range entries {
-		// if older than our interval we ignore
-		if e.Timestamp < previousTime.Unix() {
+	for key, s := range m.series {
+		if s.lastSeen >= now.Add(-s.idleTimeout()).Unix() {
 			continue
 		}
 
-		counter = counter + 1
-		accumulator = accumulator + e.Value
+		delete(m.series, key)
+
+		MovingAverageKeysGauge.Dec()
+		MovingAverageEntriesGauge.Sub(float64(s.count))
 
-		newEntries = append(newEntries, e)
+		if m.verbose {
+			m.logger.Log("key", key, "msg", "evicted moving average series")
+		}
 	}
+}
 
-	// include the latest entry
-	counter = counter + 1
-	accumulator = accumulator + value
+// NewMovingAverageSweeper returns a Sweeper which periodically deletes the idle
+// series of a persistent moving averager, using the same timeouts as the
+// in-memory implementation, as persistent series are otherwise only pruned as
+// values arrive.
+func NewMovingAverageSweeper(expirer MovingAverageExpirer, lateness time.Duration, verbose bool, logger kitlog.Logger) Sweeper {
+	m := &movingAverageSweeper{
+		expirer:  expirer,
+		lateness: lateness,
+		verbose:  verbose,
+		logger:   logger,
+	}
 
-	newEntries = append(newEntries, entry{
-		Timestamp: now.Unix(),
-		Value:     value,
-	})
+	m.periodicRunner = newPeriodicRunner(sweepInterval, m.Sweep)
 
-	// save to the map
-	m.Lock()
-	m.entries[key] = newEntries
-	m.Unlock()
+	return m
+}
+
+// movingAverageSweeper is our type that expires persistent moving averages in
+// the background.
+type movingAverageSweeper struct {
+	*periodicRunner
+	expirer  MovingAverageExpirer
+	lateness time.Duration
+	verbose  bool
+	logger   kitlog.Logger
+}
 
-	return accumulator / float64(counter), nil
+// Sweep is our implementation of the Sweeper interface method. It deletes any
+// series which has not been updated for longer than its interval and allowed
+// lateness, or for sample windows sampleWindowIdleTimeout.
+func (m *movingAverageSweeper) Sweep() {
+	expired, err := m.expirer.ExpireMovingAverages(m.lateness, sampleWindowIdleTimeout)
+	if err != nil {
+		m.logger.Log("err", err, "msg", "failed to expire moving averages")
+		return
+	}
+
+	if m.verbose && expired > 0 {
+		m.logger.Log("expired", expired, "msg", "deleted idle moving average entries")
+	}
 }
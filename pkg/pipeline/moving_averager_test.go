@@ -1,14 +1,22 @@
 package pipeline_test
 
 import (
+	"context"
 	"testing"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
 
 	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
 	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
 )
 
 func TestMovingAverager(t *testing.T) {
@@ -18,32 +26,356 @@ func TestMovingAverager(t *testing.T) {
 	mv := pipeline.NewMovingAverager(false, cl, logger)
 	assert.NotNil(t, mv)
 
-	avg, err := mv.MovingAverage(4.5, "abc123", 55, uint32(900))
+	avg, _, err := mv.MovingAverage(context.Background(), 4.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, 4.5, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(5.5, "abc123", 55, uint32(900))
+	avg, _, err = mv.MovingAverage(context.Background(), 5.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, 5.0, avg)
 
 	// spam another series so we can test it doesn't affect
-	avg, err = mv.MovingAverage(2.2, "abc123", 12, uint32(900))
+	avg, _, err = mv.MovingAverage(context.Background(), 2.2, cl.Now(), "stream1", "abc123", 12, uint32(900), 0, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, 2.2, avg)
 
+	// another stream averaging the same device's sensor keeps its own series
+	avg, count, err := mv.MovingAverage(context.Background(), 5.5, cl.Now(), "stream2", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5.5, avg)
+	assert.Equal(t, 1, count)
+
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(6.5, "abc123", 55, uint32(900))
+	avg, _, err = mv.MovingAverage(context.Background(), 6.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, 5.5, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(5.5, "abc123", 55, uint32(900))
+	avg, _, err = mv.MovingAverage(context.Background(), 5.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, 5.5, avg)
 
 	cl.Add(5 * time.Minute)
-	avg, err = mv.MovingAverage(1.2, "abc123", 55, uint32(900))
+	avg, _, err = mv.MovingAverage(context.Background(), 1.2, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
 	assert.Nil(t, err)
 	assert.Equal(t, 4.675, avg)
 }
+
+func TestMovingAveragerRingBuffer(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	mv := pipeline.NewMovingAverager(false, cl, logger)
+
+	// push enough values to grow the buffer several times
+	for i := 1; i <= 20; i++ {
+		avg, _, err := mv.MovingAverage(context.Background(), float64(i), cl.Now(), "stream1", "abc123", 55, uint32(600), 0, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, float64(i+1)/2, avg)
+
+		cl.Add(time.Second)
+	}
+
+	// all earlier values fall out of the window, shrinking the buffer
+	cl.Add(10 * time.Minute)
+	avg, _, err := mv.MovingAverage(context.Background(), 30, cl.Now(), "stream1", "abc123", 55, uint32(600), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, avg)
+
+	cl.Add(time.Minute)
+	avg, _, err = mv.MovingAverage(context.Background(), 40, cl.Now(), "stream1", "abc123", 55, uint32(600), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 35.0, avg)
+}
+
+func TestMovingAveragerSamples(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	mv := pipeline.NewMovingAverager(false, cl, logger)
+
+	testcases := []struct {
+		value         float64
+		gap           time.Duration
+		expectedAvg   float64
+		expectedCount int
+	}{
+		{value: 2, expectedAvg: 2, expectedCount: 1},
+		{value: 4, gap: time.Minute, expectedAvg: 3, expectedCount: 2},
+		{value: 6, gap: time.Minute, expectedAvg: 4, expectedCount: 3},
+		{value: 8, gap: time.Minute, expectedAvg: 6, expectedCount: 3},
+		// values are kept however long the device is offline
+		{value: 10, gap: 2 * time.Hour, expectedAvg: 8, expectedCount: 3},
+	}
+
+	for _, tc := range testcases {
+		cl.Add(tc.gap)
+
+		avg, count, err := mv.MovingAverage(context.Background(), tc.value, cl.Now(), "stream1", "abc123", 55, 0, 3, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expectedAvg, avg)
+		assert.Equal(t, tc.expectedCount, count)
+	}
+
+	// hybrid windows hold every value within the interval
+	for i := 1; i <= 5; i++ {
+		avg, count, err := mv.MovingAverage(context.Background(), float64(i), cl.Now(), "stream1", "abc123", 55, 600, 3, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, float64(i+1)/2, avg)
+		assert.Equal(t, i, count)
+	}
+
+	cl.Add(time.Hour)
+
+	avg, count, err := mv.MovingAverage(context.Background(), 7, cl.Now(), "stream1", "abc123", 55, 600, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 7.0, avg)
+	assert.Equal(t, 1, count)
+
+	// sample windows outlive their interval, but are evicted eventually
+	sweeper := mv.(pipeline.Sweeper)
+	keys := gaugeValue(t, pipeline.MovingAverageKeysGauge)
+
+	cl.Add(time.Hour)
+	sweeper.Sweep()
+	assert.Equal(t, keys-1, gaugeValue(t, pipeline.MovingAverageKeysGauge))
+
+	cl.Add(7 * 24 * time.Hour)
+	sweeper.Sweep()
+	assert.Equal(t, keys-2, gaugeValue(t, pipeline.MovingAverageKeysGauge))
+}
+
+func TestMovingAveragerSweep(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	keys := gaugeValue(t, pipeline.MovingAverageKeysGauge)
+	entries := gaugeValue(t, pipeline.MovingAverageEntriesGauge)
+
+	cl := clock.NewMock(time.Now())
+	mv := pipeline.NewMovingAverager(false, cl, logger)
+
+	sweeper, ok := mv.(pipeline.Sweeper)
+	assert.True(t, ok)
+
+	_, _, err := mv.MovingAverage(context.Background(), 1.0, cl.Now(), "stream1", "abc123", 55, uint32(300), 0, 0)
+	assert.Nil(t, err)
+
+	_, _, err = mv.MovingAverage(context.Background(), 2.0, cl.Now(), "stream1", "abc123", 55, uint32(300), 0, 0)
+	assert.Nil(t, err)
+
+	_, _, err = mv.MovingAverage(context.Background(), 3.0, cl.Now(), "stream1", "abc123", 12, uint32(3600), 0, 0)
+	assert.Nil(t, err)
+
+	assert.Equal(t, keys+2, gaugeValue(t, pipeline.MovingAverageKeysGauge))
+	assert.Equal(t, entries+3, gaugeValue(t, pipeline.MovingAverageEntriesGauge))
+
+	// nothing is evicted while series are within their interval
+	cl.Add(5 * time.Minute)
+	sweeper.Sweep()
+
+	assert.Equal(t, keys+2, gaugeValue(t, pipeline.MovingAverageKeysGauge))
+	assert.Equal(t, entries+3, gaugeValue(t, pipeline.MovingAverageEntriesGauge))
+
+	// the shorter series is evicted once untouched for longer than its interval
+	cl.Add(time.Second)
+	sweeper.Sweep()
+
+	assert.Equal(t, keys+1, gaugeValue(t, pipeline.MovingAverageKeysGauge))
+	assert.Equal(t, entries+1, gaugeValue(t, pipeline.MovingAverageEntriesGauge))
+
+	// an evicted series starts afresh
+	avg, _, err := mv.MovingAverage(context.Background(), 5.0, cl.Now(), "stream1", "abc123", 55, uint32(300), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5.0, avg)
+
+	cl.Add(time.Hour)
+	sweeper.Sweep()
+
+	assert.Equal(t, keys, gaugeValue(t, pipeline.MovingAverageKeysGauge))
+	assert.Equal(t, entries, gaugeValue(t, pipeline.MovingAverageEntriesGauge))
+
+	assert.Nil(t, sweeper.Start())
+	assert.Nil(t, sweeper.Stop())
+}
+
+func TestMovingAverageSweeper(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	mv := &mocks.MovingAverager{}
+	mv.On("ExpireMovingAverages", 5*time.Minute, 7*24*time.Hour).Return(3, nil).Once()
+	mv.On("ExpireMovingAverages", 5*time.Minute, 7*24*time.Hour).Return(0, errors.New("failed")).Once()
+
+	sweeper := pipeline.NewMovingAverageSweeper(mv, 5*time.Minute, true, logger)
+
+	// failures are logged and retried on the next sweep
+	sweeper.Sweep()
+	sweeper.Sweep()
+
+	mv.AssertExpectations(t)
+
+	assert.Nil(t, sweeper.Start())
+	assert.Nil(t, sweeper.Stop())
+}
+
+func TestMovingAveragerEventTime(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	mv := pipeline.NewMovingAverager(false, cl, logger)
+
+	lateness := 5 * time.Minute
+
+	testcases := []struct {
+		label         string
+		value         float64
+		age           time.Duration
+		expectedAvg   float64
+		expectedCount int
+	}{
+		{
+			label:         "first reading delivered after reconnecting",
+			value:         2,
+			age:           30 * time.Minute,
+			expectedAvg:   2,
+			expectedCount: 1,
+		},
+		{
+			label:         "burst of delayed readings",
+			value:         4,
+			age:           20 * time.Minute,
+			expectedAvg:   3,
+			expectedCount: 2,
+		},
+		{
+			label:         "earliest reading leaves the window",
+			value:         6,
+			age:           10 * time.Minute,
+			expectedAvg:   5,
+			expectedCount: 2,
+		},
+		{
+			label:         "out of order reading within allowed lateness",
+			value:         8,
+			age:           13 * time.Minute,
+			expectedAvg:   6,
+			expectedCount: 2,
+		},
+		{
+			label:         "reading later than allowed lateness",
+			value:         10,
+			age:           16 * time.Minute,
+			expectedAvg:   0,
+			expectedCount: 0,
+		},
+		{
+			label:         "reading from the future",
+			value:         16,
+			age:           -time.Hour,
+			expectedAvg:   10,
+			expectedCount: 3,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			avg, count, err := mv.MovingAverage(context.Background(), tc.value, cl.Now().Add(-tc.age), "stream1", "abc123", 55, 900, 0, lateness)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedAvg, avg)
+			assert.Equal(t, tc.expectedCount, count)
+		})
+	}
+
+	// sample windows hold the last N readings by the time they were recorded
+	avg, count, err := mv.MovingAverage(context.Background(), 1, cl.Now().Add(-time.Minute), "stream1", "abc123", 55, 0, 2, lateness)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, avg)
+	assert.Equal(t, 1, count)
+
+	avg, count, err = mv.MovingAverage(context.Background(), 3, cl.Now().Add(-3*time.Minute), "stream1", "abc123", 55, 0, 2, lateness)
+	assert.Nil(t, err)
+	assert.Equal(t, 3.0, avg)
+	assert.Equal(t, 1, count)
+
+	avg, count, err = mv.MovingAverage(context.Background(), 5, cl.Now().Add(-2*time.Minute), "stream1", "abc123", 55, 0, 2, lateness)
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, avg)
+	assert.Equal(t, 2, count)
+}
+
+func TestProcessWithLateReadings(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 15, 0, 0, 0, time.UTC))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:       datastore.Datastore(&ds),
+		MovingAverager:  pipeline.NewMovingAverager(false, cl, logger),
+		AllowedLateness: 5 * time.Minute,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.MovingAverage,
+						Interval: 900,
+					},
+				},
+			},
+		},
+	}
+
+	late := counterValue(t, pipeline.LateReadingsCounter)
+
+	// the device reconnects and delivers the readings it buffered while offline
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:40:00Z","sensors":[{"id":12, "value":12.0}]},{"recorded_at":"2018-12-11T14:50:00Z","sensors":[{"id":12, "value":14.0}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	// followed by one recorded long before them
+	cl.Add(time.Minute)
+
+	payload = []byte(`{"data":[{"recorded_at":"2018-12-11T14:30:00Z","sensors":[{"id":12, "value":10.0}]}]}`)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	// nothing is written for the late reading
+	assert.Len(t, ds.Calls, 2)
+	assert.Equal(t, late+1, counterValue(t, pipeline.LateReadingsCounter))
+}
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	err := gauge.Write(&m)
+	if err != nil {
+		t.Fatalf("failed to read gauge: %v", err)
+	}
+
+	return m.GetGauge().GetValue()
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+
+	var m dto.Metric
+	err := counter.Write(&m)
+	if err != nil {
+		t.Fatalf("failed to read counter: %v", err)
+	}
+
+	return m.GetCounter().GetValue()
+}
@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// NoiseGenerator is a type that is able to perturb values with random noise
+// calibrated to provide differential privacy. It wraps a rand.Source so that a
+// seeded source can be supplied for deterministic testing.
+type NoiseGenerator struct {
+	sync.Mutex
+	rand *rand.Rand
+}
+
+// NewNoiseGenerator returns a new NoiseGenerator that draws random numbers from
+// the given source.
+func NewNoiseGenerator(src rand.Source) *NoiseGenerator {
+	return &NoiseGenerator{
+		rand: rand.New(src),
+	}
+}
+
+// Perturb returns the given value with noise added according to the mechanism,
+// epsilon, sensitivity and delta specified by the operation.
+func (n *NoiseGenerator) Perturb(value float64, operation *postgres.Operation) float64 {
+	switch operation.Mechanism {
+	case postgres.Gaussian:
+		return value + n.Gaussian(GaussianSigma(operation.Epsilon, operation.Sensitivity, operation.Delta))
+	default:
+		return value + n.Laplace(operation.Sensitivity/operation.Epsilon)
+	}
+}
+
+// Laplace returns a random sample drawn from a Laplace distribution centred on
+// zero with the given scale.
+func (n *NoiseGenerator) Laplace(scale float64) float64 {
+	n.Lock()
+	u := n.rand.Float64()
+	for u == 0 {
+		// zero would give us an infinite sample so we draw again
+		u = n.rand.Float64()
+	}
+	n.Unlock()
+
+	u = u - 0.5
+
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+
+	return -scale * math.Log(1-2*u)
+}
+
+// Gaussian returns a random sample drawn from a Gaussian distribution centred
+// on zero with the given standard deviation.
+func (n *NoiseGenerator) Gaussian(sigma float64) float64 {
+	n.Lock()
+	defer n.Unlock()
+
+	return n.rand.NormFloat64() * sigma
+}
+
+// GaussianSigma returns the standard deviation required by the Gaussian
+// mechanism to provide (epsilon, delta) differential privacy for the given
+// sensitivity.
+func GaussianSigma(epsilon, sensitivity, delta float64) float64 {
+	return sensitivity * math.Sqrt(2*math.Log(1.25/delta)) / epsilon
+}
+
+// NewCryptoSource returns a rand.Source that reads from crypto/rand. We use
+// this as the noise source when running for real, as noise generated by a
+// predictable pseudo random generator could be subtracted from released
+// values by anyone able to recover the seed.
+func NewCryptoSource() rand.Source {
+	return &cryptoSource{}
+}
+
+// cryptoSource is our implementation of rand.Source that reads from the
+// operating system's secure random number generator.
+type cryptoSource struct{}
+
+// Int63 is our implementation of the rand.Source interface method.
+func (c *cryptoSource) Int63() int64 {
+	return int64(c.Uint64() & (1<<63 - 1))
+}
+
+// Uint64 is our implementation of the rand.Source64 interface method.
+func (c *cryptoSource) Uint64() uint64 {
+	var b [8]byte
+
+	_, err := cryptorand.Read(b[:])
+	if err != nil {
+		panic(err)
+	}
+
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// Seed is a noop for our crypto source, we only implement it to satisfy the
+// rand.Source interface.
+func (c *cryptoSource) Seed(seed int64) {}
@@ -0,0 +1,84 @@
+package pipeline_test
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestNoiseGeneratorIsDeterministic(t *testing.T) {
+	n1 := pipeline.NewNoiseGenerator(rand.NewSource(42))
+	n2 := pipeline.NewNoiseGenerator(rand.NewSource(42))
+
+	operation := &postgres.Operation{
+		Action:      postgres.Noise,
+		Epsilon:     0.1,
+		Sensitivity: 1,
+		Mechanism:   postgres.Laplace,
+	}
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, n1.Perturb(20.0, operation), n2.Perturb(20.0, operation))
+	}
+}
+
+func TestLaplaceDistribution(t *testing.T) {
+	n := pipeline.NewNoiseGenerator(rand.NewSource(1))
+
+	samples := 100000
+	scale := 2.0
+
+	sum := 0.0
+	absSum := 0.0
+
+	for i := 0; i < samples; i++ {
+		v := n.Laplace(scale)
+		assert.False(t, math.IsInf(v, 0))
+
+		sum = sum + v
+		absSum = absSum + math.Abs(v)
+	}
+
+	// mean of a Laplace distribution is 0, and mean absolute deviation is the
+	// scale
+	assert.InDelta(t, 0, sum/float64(samples), 0.05)
+	assert.InDelta(t, scale, absSum/float64(samples), 0.05)
+}
+
+func TestGaussianDistribution(t *testing.T) {
+	n := pipeline.NewNoiseGenerator(rand.NewSource(1))
+
+	samples := 100000
+	sigma := 3.0
+
+	sum := 0.0
+	sqSum := 0.0
+
+	for i := 0; i < samples; i++ {
+		v := n.Gaussian(sigma)
+		sum = sum + v
+		sqSum = sqSum + v*v
+	}
+
+	assert.InDelta(t, 0, sum/float64(samples), 0.05)
+	assert.InDelta(t, sigma, math.Sqrt(sqSum/float64(samples)), 0.05)
+}
+
+func TestGaussianSigma(t *testing.T) {
+	sigma := pipeline.GaussianSigma(0.5, 1, 1e-5)
+	assert.InDelta(t, 9.6896, sigma, 0.0001)
+}
+
+func TestCryptoSource(t *testing.T) {
+	r := rand.New(pipeline.NewCryptoSource())
+
+	for i := 0; i < 100; i++ {
+		v := r.Float64()
+		assert.True(t, v >= 0 && v < 1)
+	}
+}
@@ -0,0 +1,708 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+// Operation is an interface for a type implementing one of the actions a
+// stream may apply to a sensor. Operations are looked up by action in a
+// Registry, so new operations can be added by registering them rather than by
+// modifying the processor.
+type Operation interface {
+	// Validate returns an error describing why the configuration of a requested
+	// operation is invalid, or nil if it may be persisted, in which case any
+	// unset configuration with a default is first filled in.
+	Validate(operation *postgres.Operation) error
+
+	// Apply applies the operation to a reading, updating any state held for
+	// the device. It returns nil if the operation has nothing to output for
+	// the reading, e.g. because a window doesn't yet hold enough samples. The
+	// context should be passed to any stores the operation reads or updates.
+	Apply(ctx context.Context, reading *Reading) (*Result, error)
+
+	// Render returns the processed sensor to be written for the result of
+	// applying the operation to the reading.
+	Render(reading *Reading, result *Result) *smartcitizen.Sensor
+}
+
+// Reading is the input to an operation, being the stream and operation being
+// applied along with the device and the sensor it applies to. Operations which
+// derive a value from several sensors of the device, such as an air quality
+// index, are configured without a sensor and so have a nil Sensor. RecordedAt
+// is when the reading was recorded, before the stream's time resolution was
+// applied to the device.
+type Reading struct {
+	Stream     *postgres.Stream
+	Operation  *postgres.Operation
+	Device     *smartcitizen.Device
+	Sensor     *smartcitizen.Sensor
+	RecordedAt time.Time
+
+	// windows caches the values read from the windower while processing the
+	// device, so that several windowed operations on the same sensor only
+	// record its value once
+	windows map[string][]float64
+}
+
+// Result is the output of applying an operation to a reading. Value is the
+// value computed by the operation, and Count the number of readings it was
+// computed from. Label is any textual output of the operation, such as the
+// state of a threshold or the unit a value was converted into.
+type Result struct {
+	Value float64
+	Count int
+	Label string
+}
+
+// Registry is a type that maps action names to the operations implementing
+// them. Operations should all be registered before the registry is used, as
+// it is not safe to register operations concurrently with looking them up.
+type Registry struct {
+	operations map[postgres.Action]Operation
+}
+
+// NewRegistry returns a new empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		operations: make(map[postgres.Action]Operation),
+	}
+}
+
+// DefaultRegistry returns a registry containing every built-in operation, which
+// use the stateful components supplied in the config, with moving averages and
+// windows allowing readings to arrive up to the config's AllowedLateness out of
+// order. Components not used by any of the operations a stream applies may be
+// omitted, and if only validating operations an empty config may be used. The
+// logger records sensors dropped because their values can't be converted.
+func DefaultRegistry(config *Config, logger kitlog.Logger) *Registry {
+	registry := NewRegistry()
+
+	sensors := &smartcitizen.Smartcitizen{}
+
+	windowed := &windowOperation{
+		windower: config.Windower,
+		lateness: config.AllowedLateness,
+	}
+
+	registry.Register(postgres.Share, &shareOperation{})
+	registry.Register(postgres.Bin, &binOperation{})
+	registry.Register(postgres.MovingAverage, &movingAverageOperation{
+		movingAvg: config.MovingAverager,
+		lateness:  config.AllowedLateness,
+	})
+	registry.Register(postgres.EWMA, &exponentialAverageOperation{
+		ewma: config.ExponentialAverager,
+	})
+	registry.Register(postgres.Min, windowed)
+	registry.Register(postgres.Max, windowed)
+	registry.Register(postgres.Median, windowed)
+	registry.Register(postgres.Percentile, windowed)
+	registry.Register(postgres.Noise, &noiseOperation{
+		noise:         config.NoiseGenerator,
+		privacyBudget: config.PrivacyBudget,
+	})
+	registry.Register(postgres.Threshold, &thresholdOperation{
+		thresholder: config.Thresholder,
+	})
+	registry.Register(postgres.Convert, &convertOperation{
+		sensors: sensors,
+		logger:  logger,
+	})
+	registry.Register(postgres.AQI, &aqiOperation{
+		sensors:  sensors,
+		windower: config.Windower,
+		lateness: config.AllowedLateness,
+	})
+
+	return registry
+}
+
+// Register adds an operation to the registry for the given action, replacing
+// any operation previously registered for it.
+func (r *Registry) Register(action postgres.Action, operation Operation) {
+	r.operations[action] = operation
+}
+
+// Lookup returns the operation registered for the given action, and false if
+// no operation is registered for it.
+func (r *Registry) Lookup(action postgres.Action) (Operation, bool) {
+	operation, ok := r.operations[action]
+	return operation, ok
+}
+
+// applyOperation applies a registered operation to a reading, returning the
+// processed sensor or nil if the operation had nothing to output.
+func (p *Processor) applyOperation(ctx context.Context, operation Operation, reading *Reading) (*smartcitizen.Sensor, error) {
+	start := time.Now()
+
+	result, err := operation.Apply(ctx, reading)
+	if err != nil {
+		return nil, err
+	}
+
+	if result == nil {
+		return nil, nil
+	}
+
+	processedSensor := operation.Render(reading, result)
+
+	duration := time.Since(start)
+
+	ProcessHistogram.WithLabelValues(string(reading.Operation.Action)).Observe(duration.Seconds() * 1e3)
+
+	return processedSensor, nil
+}
+
+// renderSensor returns a processed sensor describing the sensor of the reading
+// with the action of the operation applied to it, to which operations then add
+// their output.
+func renderSensor(reading *Reading) *smartcitizen.Sensor {
+	return &smartcitizen.Sensor{
+		ID:          reading.Sensor.ID,
+		Name:        reading.Sensor.Name,
+		Description: reading.Sensor.Description,
+		Unit:        reading.Sensor.Unit,
+		Action:      reading.Operation.Action,
+	}
+}
+
+// window returns the values within the window of the given interval or number
+// of samples for the sensor up to when the reading was recorded, or no values
+// if the reading arrived too late to be included. Values are cached on the
+// reading, so that multiple operations on the same sensor only record the
+// value once.
+func (r *Reading) window(windower Windower, lateness time.Duration, sensor *smartcitizen.Sensor, interval, samples uint32) ([]float64, error) {
+	windowKey := fmt.Sprintf("%v:%v:%v", sensor.ID, interval, samples)
+
+	values, ok := r.windows[windowKey]
+	if ok {
+		return values, nil
+	}
+
+	values, err := windower.Window(
+		sensor.Value.Float64,
+		r.RecordedAt,
+		r.Stream.StreamID,
+		r.Device.Token,
+		sensor.ID,
+		interval,
+		samples,
+		lateness,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read window")
+	}
+
+	if r.windows != nil {
+		r.windows[windowKey] = values
+	}
+
+	return values, nil
+}
+
+// validValue returns true if the sensor reported a value, and it is a finite
+// number, so that operations don't treat a missing reading as zero.
+func validValue(sensor *smartcitizen.Sensor) bool {
+	if sensor.Value == nil || !sensor.Value.Valid {
+		return false
+	}
+
+	return !math.IsNaN(sensor.Value.Float64) && !math.IsInf(sensor.Value.Float64, 0)
+}
+
+// validateSensor returns an error if the operation doesn't identify the sensor
+// it applies to.
+func validateSensor(operation *postgres.Operation) error {
+	if operation.SensorID == 0 && operation.Measurement == "" {
+		return errors.New("require a non-zero sensor id or a measurement")
+	}
+
+	return nil
+}
+
+// shareOperation is our implementation of the SHARE action, which shares the
+// value of a sensor without processing.
+type shareOperation struct{}
+
+// Validate is our implementation of the Operation interface method.
+func (s *shareOperation) Validate(operation *postgres.Operation) error {
+	return validateSensor(operation)
+}
+
+// Apply is our implementation of the Operation interface method.
+func (s *shareOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	return &Result{Value: reading.Sensor.Value.Float64, Count: 1}, nil
+}
+
+// Render is our implementation of the Operation interface method. We share the
+// sensor's own value rather than the result so that null values are kept.
+func (s *shareOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	processedSensor := renderSensor(reading)
+	processedSensor.Value = reading.Sensor.Value
+
+	return processedSensor
+}
+
+// binOperation is our implementation of the BIN action, which shares which of
+// the configured bins the value of a sensor falls into.
+type binOperation struct{}
+
+// Validate is our implementation of the Operation interface method.
+func (b *binOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if len(operation.Bins) == 0 {
+		return errors.New("binning requires a non-empty list of bins")
+	}
+
+	for i := 1; i < len(operation.Bins); i++ {
+		if operation.Bins[i] <= operation.Bins[i-1] {
+			return errors.New("binning requires bins in ascending order")
+		}
+	}
+
+	if len(operation.Labels) > 0 && len(operation.Labels) != len(operation.Bins)+1 {
+		return errors.New("binning requires one more label than the number of bins")
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method.
+func (b *binOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	return &Result{Value: reading.Sensor.Value.Float64, Count: 1}, nil
+}
+
+// Render is our implementation of the Operation interface method.
+func (b *binOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	operation := reading.Operation
+
+	processedSensor := renderSensor(reading)
+	processedSensor.Bins = operation.Bins
+	processedSensor.Values = BinValue(result.Value, operation.Bins)
+
+	if len(operation.Labels) > 0 {
+		processedSensor.Labels = operation.Labels
+		processedSensor.Category = BinLabel(result.Value, operation.Bins, operation.Labels)
+	}
+
+	return processedSensor
+}
+
+// movingAverageOperation is our implementation of the MOVING_AVG action, which
+// shares the average value of a sensor over a window of the time at which
+// readings were recorded, allowing them to arrive up to lateness out of order.
+type movingAverageOperation struct {
+	movingAvg MovingAverager
+	lateness  time.Duration
+}
+
+// Validate is our implementation of the Operation interface method.
+func (m *movingAverageOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if operation.Interval == 0 && operation.Samples == 0 {
+		return errors.New("moving average requires a non-zero interval or number of samples")
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method.
+func (m *movingAverageOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	avgVal, count, err := m.movingAvg.MovingAverage(
+		ctx,
+		reading.Sensor.Value.Float64,
+		reading.RecordedAt,
+		reading.Stream.StreamID,
+		reading.Device.Token,
+		reading.Sensor.ID,
+		reading.Operation.Interval,
+		reading.Operation.Samples,
+		m.lateness,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to calculate moving average")
+	}
+
+	// the reading arrived too late to be included, so has no output
+	if count == 0 {
+		LateReadingsCounter.Inc()
+		return nil, nil
+	}
+
+	if !windowReady(reading.Operation, count) {
+		return nil, nil
+	}
+
+	return &Result{Value: avgVal, Count: count}, nil
+}
+
+// Render is our implementation of the Operation interface method.
+func (m *movingAverageOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	value := null.FloatFrom(result.Value)
+
+	processedSensor := renderSensor(reading)
+	processedSensor.Value = &value
+
+	setWindow(processedSensor, reading.Operation)
+
+	return processedSensor
+}
+
+// exponentialAverageOperation is our implementation of the EWMA action, which
+// shares an exponentially weighted moving average of a sensor with the
+// configured half life.
+type exponentialAverageOperation struct {
+	ewma ExponentialAverager
+}
+
+// Validate is our implementation of the Operation interface method.
+func (e *exponentialAverageOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if operation.HalfLife == 0 {
+		return errors.New("exponentially weighted moving average requires a non-zero half life")
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method. Readings are
+// weighted by the gap between their recorded times, so we use the original
+// time rather than one the stream coarsened.
+func (e *exponentialAverageOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	avgVal, err := e.ewma.ExponentialAverage(
+		reading.Sensor.Value.Float64,
+		reading.RecordedAt,
+		reading.Stream.StreamID,
+		reading.Device.Token,
+		reading.Sensor.ID,
+		reading.Operation.HalfLife,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to calculate exponentially weighted moving average")
+	}
+
+	return &Result{Value: avgVal, Count: 1}, nil
+}
+
+// Render is our implementation of the Operation interface method.
+func (e *exponentialAverageOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	halfLife := null.IntFrom(int64(reading.Operation.HalfLife))
+	value := null.FloatFrom(result.Value)
+
+	processedSensor := renderSensor(reading)
+	processedSensor.HalfLife = &halfLife
+	processedSensor.Value = &value
+
+	return processedSensor
+}
+
+// windowOperation is our implementation of the MIN, MAX, MEDIAN and PERCENTILE
+// actions, which share an aggregate of the values of a sensor over a window of
+// the time at which readings were recorded, allowing them to arrive up to
+// lateness out of order.
+type windowOperation struct {
+	windower Windower
+	lateness time.Duration
+}
+
+// Validate is our implementation of the Operation interface method.
+func (w *windowOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if operation.Interval == 0 && operation.Samples == 0 {
+		return errors.New("windowed operations require a non-zero interval or number of samples")
+	}
+
+	if operation.Action == postgres.Percentile && (operation.Percentile <= 0 || operation.Percentile > 100) {
+		return errors.New("percentile must be greater than 0 and less than or equal to 100")
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method.
+func (w *windowOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	operation := reading.Operation
+
+	values, err := reading.window(w.windower, w.lateness, reading.Sensor, operation.Interval, operation.Samples)
+	if err != nil {
+		return nil, err
+	}
+
+	// the reading arrived too late to be included, so has no output
+	if len(values) == 0 {
+		LateReadingsCounter.Inc()
+		return nil, nil
+	}
+
+	if !windowReady(operation, len(values)) {
+		return nil, nil
+	}
+
+	return &Result{
+		Value: AggregateValues(values, operation.Action, operation.Percentile),
+		Count: len(values),
+	}, nil
+}
+
+// Render is our implementation of the Operation interface method.
+func (w *windowOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	value := null.FloatFrom(result.Value)
+
+	processedSensor := renderSensor(reading)
+	processedSensor.Value = &value
+
+	setWindow(processedSensor, reading.Operation)
+
+	if reading.Operation.Action == postgres.Percentile {
+		percentile := null.FloatFrom(reading.Operation.Percentile)
+		processedSensor.Percentile = &percentile
+	}
+
+	return processedSensor
+}
+
+// noiseOperation is our implementation of the NOISE action, which shares the
+// value of a sensor perturbed with random noise, debiting each value released
+// from the stream's privacy budget if it has one.
+type noiseOperation struct {
+	noise         *NoiseGenerator
+	privacyBudget PrivacyBudget
+}
+
+// Validate is our implementation of the Operation interface method. Noise is
+// drawn from a Laplace distribution unless another mechanism is configured.
+func (n *noiseOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if operation.Epsilon <= 0 {
+		return errors.New("noise requires a positive epsilon")
+	}
+
+	if operation.Sensitivity <= 0 {
+		return errors.New("noise requires a positive sensitivity")
+	}
+
+	if operation.Mechanism == postgres.Gaussian && (operation.Delta <= 0 || operation.Delta >= 1) {
+		return errors.New("gaussian noise requires a delta between 0 and 1")
+	}
+
+	if operation.Mechanism == "" {
+		operation.Mechanism = postgres.Laplace
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method. Once the
+// budget is exhausted we stop releasing the sensor until enough of it has been
+// spent longer ago than the budget's period. Null or invalid values are
+// skipped without spending any budget, as there is nothing to release.
+func (n *noiseOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	stream := reading.Stream
+	operation := reading.Operation
+
+	if !validValue(reading.Sensor) {
+		return nil, nil
+	}
+
+	if stream.PrivacyBudget > 0 {
+		ok, err := n.privacyBudget.Spend(
+			ctx,
+			stream.StreamID,
+			operation.Epsilon,
+			stream.PrivacyBudget,
+			stream.PrivacyBudgetPeriod,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to spend privacy budget")
+		}
+
+		if !ok {
+			return nil, nil
+		}
+	}
+
+	return &Result{Value: n.noise.Perturb(reading.Sensor.Value.Float64, operation), Count: 1}, nil
+}
+
+// Render is our implementation of the Operation interface method.
+func (n *noiseOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	epsilon := null.FloatFrom(reading.Operation.Epsilon)
+	sensitivity := null.FloatFrom(reading.Operation.Sensitivity)
+	value := null.FloatFrom(result.Value)
+
+	processedSensor := renderSensor(reading)
+	processedSensor.Epsilon = &epsilon
+	processedSensor.Sensitivity = &sensitivity
+	processedSensor.Value = &value
+
+	return processedSensor
+}
+
+// thresholdOperation is our implementation of the THRESHOLD action, which
+// shares the state of a sensor relative to upper and lower limits only when
+// that state changes.
+type thresholdOperation struct {
+	thresholder Thresholder
+}
+
+// Validate is our implementation of the Operation interface method.
+func (t *thresholdOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if operation.Upper <= operation.Lower {
+		return errors.New("threshold requires an upper limit greater than the lower limit")
+	}
+
+	if operation.Hysteresis < 0 || operation.Hysteresis >= operation.Upper-operation.Lower {
+		return errors.New("threshold hysteresis must be non-negative and less than the distance between the limits")
+	}
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method. The result is
+// labelled with the new state.
+func (t *thresholdOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	operation := reading.Operation
+
+	state, changed, err := t.thresholder.Threshold(
+		reading.Sensor.Value.Float64,
+		reading.Stream.StreamID,
+		reading.Device.Token,
+		reading.Sensor.ID,
+		operation.Lower,
+		operation.Upper,
+		operation.Hysteresis,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to calculate threshold state")
+	}
+
+	// we only emit output when the state changes
+	if !changed {
+		return nil, nil
+	}
+
+	return &Result{Value: reading.Sensor.Value.Float64, Count: 1, Label: state}, nil
+}
+
+// Render is our implementation of the Operation interface method. We share the
+// state rather than the value of the sensor.
+func (t *thresholdOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	upper := null.FloatFrom(reading.Operation.Upper)
+	lower := null.FloatFrom(reading.Operation.Lower)
+
+	processedSensor := renderSensor(reading)
+	processedSensor.Upper = &upper
+	processedSensor.Lower = &lower
+	processedSensor.State = result.Label
+
+	return processedSensor
+}
+
+// convertOperation is our implementation of the CONVERT action, which shares
+// the value of a sensor converted into another unit.
+type convertOperation struct {
+	sensors *smartcitizen.Smartcitizen
+	logger  kitlog.Logger
+}
+
+// Validate is our implementation of the Operation interface method. A
+// conversion must be possible from every sensor to which a measurement may be
+// resolved, not just the most preferred, and the unit is replaced by the
+// canonical name of the target unit.
+func (c *convertOperation) Validate(operation *postgres.Operation) error {
+	err := validateSensor(operation)
+	if err != nil {
+		return err
+	}
+
+	if operation.Unit == "" {
+		return errors.New("conversion requires a target unit")
+	}
+
+	ids := []int{int(operation.SensorID)}
+
+	if operation.Measurement != "" {
+		ids, err = c.sensors.MeasurementSensors(operation.Measurement, operation.Unit)
+		if err != nil {
+			return err
+		}
+	}
+
+	var unit string
+
+	for i, id := range ids {
+		conversion, err := c.sensors.Conversion(id, operation.Unit)
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			unit = conversion.To
+		}
+	}
+
+	operation.Unit = unit
+
+	return nil
+}
+
+// Apply is our implementation of the Operation interface method. We drop a
+// sensor we can't convert rather than failing the whole stream, e.g. if the
+// sensor metadata has changed.
+func (c *convertOperation) Apply(ctx context.Context, reading *Reading) (*Result, error) {
+	conversion, err := c.sensors.Conversion(reading.Sensor.ID, reading.Operation.Unit)
+	if err != nil {
+		c.logger.Log("err", err, "sensor_id", reading.Sensor.ID, "unit", reading.Operation.Unit, "msg", "dropping sensor which can't be converted")
+		return nil, nil
+	}
+
+	return &Result{Value: conversion.Convert(reading.Sensor.Value.Float64), Count: 1, Label: conversion.To}, nil
+}
+
+// Render is our implementation of the Operation interface method. The result
+// is labelled with the unit converted to.
+func (c *convertOperation) Render(reading *Reading, result *Result) *smartcitizen.Sensor {
+	unit := null.StringFrom(result.Label)
+	value := null.FloatFrom(result.Value)
+
+	processedSensor := renderSensor(reading)
+	processedSensor.Unit = &unit
+	processedSensor.Value = &value
+
+	return processedSensor
+}
@@ -0,0 +1,262 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+func TestDefaultRegistryValidate(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	registry := pipeline.DefaultRegistry(&pipeline.Config{}, logger)
+
+	testcases := []struct {
+		label       string
+		operation   *postgres.Operation
+		expectedErr string
+	}{
+		{
+			label:     "share",
+			operation: &postgres.Operation{SensorID: 12, Action: postgres.Share},
+		},
+		{
+			label:     "bin",
+			operation: &postgres.Operation{SensorID: 12, Action: postgres.Bin, Bins: []float64{40, 80}, Labels: []string{"low", "medium", "high"}},
+		},
+		{
+			label:       "bin without bins",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Bin},
+			expectedErr: "binning requires a non-empty list of bins",
+		},
+		{
+			label:       "bin with unordered bins",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Bin, Bins: []float64{80, 40}},
+			expectedErr: "binning requires bins in ascending order",
+		},
+		{
+			label:       "bin with too few labels",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Bin, Bins: []float64{40, 80}, Labels: []string{"low", "high"}},
+			expectedErr: "binning requires one more label than the number of bins",
+		},
+		{
+			label:     "moving average",
+			operation: &postgres.Operation{SensorID: 12, Action: postgres.MovingAverage, Samples: 5},
+		},
+		{
+			label:       "moving average without window",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.MovingAverage},
+			expectedErr: "moving average requires a non-zero interval or number of samples",
+		},
+		{
+			label:       "share without sensor",
+			operation:   &postgres.Operation{Action: postgres.Share},
+			expectedErr: "require a non-zero sensor id or a measurement",
+		},
+		{
+			label:     "share measurement",
+			operation: &postgres.Operation{Measurement: "temperature", Action: postgres.Share},
+		},
+		{
+			label:     "exponentially weighted moving average",
+			operation: &postgres.Operation{SensorID: 12, Action: postgres.EWMA, HalfLife: 600},
+		},
+		{
+			label:       "exponentially weighted moving average without half life",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.EWMA},
+			expectedErr: "exponentially weighted moving average requires a non-zero half life",
+		},
+		{
+			label:     "median",
+			operation: &postgres.Operation{SensorID: 12, Action: postgres.Median, Interval: 900},
+		},
+		{
+			label:       "maximum without window",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Max},
+			expectedErr: "windowed operations require a non-zero interval or number of samples",
+		},
+		{
+			label:       "percentile out of range",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Percentile, Samples: 10, Percentile: 101},
+			expectedErr: "percentile must be greater than 0 and less than or equal to 100",
+		},
+		{
+			label:       "noise without sensitivity",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Noise, Epsilon: 0.5},
+			expectedErr: "noise requires a positive sensitivity",
+		},
+		{
+			label:       "gaussian noise without delta",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Noise, Epsilon: 0.5, Sensitivity: 1, Mechanism: postgres.Gaussian},
+			expectedErr: "gaussian noise requires a delta between 0 and 1",
+		},
+		{
+			label:       "threshold with inverted limits",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Threshold, Lower: 30, Upper: 20},
+			expectedErr: "threshold requires an upper limit greater than the lower limit",
+		},
+		{
+			label:       "convert to unknown unit",
+			operation:   &postgres.Operation{SensorID: 12, Action: postgres.Convert, Unit: "ppb"},
+			expectedErr: "unable to convert sensor 12 from °C to ppb",
+		},
+		{
+			label:     "air quality index",
+			operation: &postgres.Operation{Action: postgres.AQI},
+		},
+		{
+			label:     "daily eu air quality index",
+			operation: &postgres.Operation{Action: postgres.AQI, Index: postgres.EUCAQI, Interval: 86400},
+		},
+		{
+			label:       "unknown air quality index",
+			operation:   &postgres.Operation{Action: postgres.AQI, Index: postgres.Index("UK_DAQI")},
+			expectedErr: "unknown air quality index UK_DAQI",
+		},
+		{
+			label:       "hourly us air quality index",
+			operation:   &postgres.Operation{Action: postgres.AQI, Index: postgres.USEPA, Interval: 3600},
+			expectedErr: "US EPA AQI requires an interval of 86400 seconds",
+		},
+		{
+			label:       "eu air quality index with unsupported interval",
+			operation:   &postgres.Operation{Action: postgres.AQI, Index: postgres.EUCAQI, Interval: 7200},
+			expectedErr: "EU CAQI requires an interval of 3600 or 86400 seconds",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			operation, ok := registry.Lookup(tc.operation.Action)
+			assert.True(t, ok)
+
+			err := operation.Validate(tc.operation)
+			if tc.expectedErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+
+	_, ok := registry.Lookup(postgres.Action("UNKNOWN"))
+	assert.False(t, ok)
+}
+
+func TestDefaultRegistryDefaults(t *testing.T) {
+	registry := pipeline.DefaultRegistry(&pipeline.Config{}, kitlog.NewNopLogger())
+
+	testcases := []struct {
+		label    string
+		input    *postgres.Operation
+		expected *postgres.Operation
+	}{
+		{
+			label:    "noise defaults to laplace",
+			input:    &postgres.Operation{SensorID: 12, Action: postgres.Noise, Epsilon: 0.5, Sensitivity: 1},
+			expected: &postgres.Operation{SensorID: 12, Action: postgres.Noise, Epsilon: 0.5, Sensitivity: 1, Mechanism: postgres.Laplace},
+		},
+		{
+			label:    "air quality index defaults to us epa",
+			input:    &postgres.Operation{Action: postgres.AQI},
+			expected: &postgres.Operation{Action: postgres.AQI, Index: postgres.USEPA},
+		},
+		{
+			label:    "conversion unit is canonicalised",
+			input:    &postgres.Operation{SensorID: 12, Action: postgres.Convert, Unit: "degF"},
+			expected: &postgres.Operation{SensorID: 12, Action: postgres.Convert, Unit: "°F"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			operation, ok := registry.Lookup(tc.input.Action)
+			assert.True(t, ok)
+
+			err := operation.Validate(tc.input)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, tc.input)
+		})
+	}
+}
+
+func TestProcessWithRegisteredOperation(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	value := null.FloatFrom(25.16)
+
+	// the result is rendered only when the operation has output
+	op := mocks.Operation{}
+	op.On("Apply", mock.Anything, mock.Anything).Return(&pipeline.Result{Value: 25.16, Count: 1}, nil).Once()
+	op.On("Apply", mock.Anything, mock.Anything).Return((*pipeline.Result)(nil), nil).Once()
+	op.On("Render", mock.Anything, &pipeline.Result{Value: 25.16, Count: 1}).Return(&smartcitizen.Sensor{
+		ID:     12,
+		Action: postgres.Action("DOUBLE"),
+		Value:  &value,
+	})
+
+	registry := pipeline.DefaultRegistry(&pipeline.Config{}, logger)
+	registry.Register(postgres.Action("DOUBLE"), &op)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Registry:  registry,
+		Verbose:   true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Action("DOUBLE"),
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+	for i := 0; i < 2; i++ {
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	op.AssertExpectations(t)
+	assert.Len(t, ds.Calls, 1)
+
+	reading := op.Calls[0].Arguments[1].(*pipeline.Reading)
+	assert.Equal(t, 12, reading.Sensor.ID)
+	assert.Equal(t, 12.58, reading.Sensor.Value.Float64)
+	assert.Equal(t, "foo", reading.Device.Token)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+	assert.Equal(t, postgres.Action("DOUBLE"), decryptedDevice.Sensors[0].Action)
+	assert.Equal(t, 25.16, decryptedDevice.Sensors[0].Value.Float64)
+}
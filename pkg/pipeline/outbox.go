@@ -0,0 +1,317 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/lestrrat-go/backoff"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+const (
+	// OutboxLease is how long an event in the outbox is leased to whoever is
+	// writing it before it may be claimed again. This must be longer than a
+	// write to the datastore can take.
+	OutboxLease = time.Minute
+
+	// DefaultOutboxMaxAge is the age after which events are discarded from the
+	// outbox if no other maximum is configured
+	DefaultOutboxMaxAge = 24 * time.Hour
+
+	// DefaultDispatchInterval is how often the dispatcher checks the outbox for
+	// events to retry if no other interval is configured
+	DefaultDispatchInterval = 10 * time.Second
+
+	// DefaultDispatchBatchSize is the number of events the dispatcher claims
+	// from the outbox at a time if no other size is configured
+	DefaultDispatchBatchSize = 100
+
+	// maxDispatchBackoff is the longest the dispatcher waits between attempts
+	// while the datastore is failing
+	maxDispatchBackoff = 5 * time.Minute
+)
+
+var (
+	// OutboxDepthGauge is a prometheus gauge recording the number of events in
+	// the outbox waiting to be written to the datastore
+	OutboxDepthGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_depth",
+			Help:      "Count of events in the outbox waiting to be written",
+		},
+	)
+
+	// OutboxAgeGauge is a prometheus gauge recording the age in seconds of the
+	// oldest event in the outbox
+	OutboxAgeGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_age_seconds",
+			Help:      "Age of the oldest event in the outbox in seconds",
+		},
+	)
+
+	// OutboxExpiredCounter is a prometheus counter recording the number of
+	// events discarded from the outbox as they exceeded the maximum age
+	OutboxExpiredCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "outbox_expired",
+			Help:      "Count of events discarded from the outbox unwritten",
+		},
+	)
+)
+
+// Outbox is an interface for the durable store in which encrypted events are
+// held until they have been written to the datastore, so that events aren't
+// lost if the datastore is unavailable. It is implemented by postgres.DB.
+type Outbox interface {
+	// EnqueueEvent persists an event, which isn't available to be claimed until
+	// the lease has passed.
+	EnqueueEvent(event *postgres.OutboxEvent, lease time.Duration) (*postgres.OutboxEvent, error)
+
+	// ClaimEvents returns up to limit events available to be written, leasing
+	// them to the caller.
+	ClaimEvents(limit int, lease time.Duration) ([]*postgres.OutboxEvent, error)
+
+	// AcknowledgeEvent deletes an event once it has been written.
+	AcknowledgeEvent(id int64) error
+
+	// ExpireEvents deletes events older than the maximum age, returning the
+	// number deleted.
+	ExpireEvents(maxAge time.Duration) (int, error)
+
+	// OutboxStats returns the number of events in the outbox, and the age of
+	// the oldest.
+	OutboxStats() (int, time.Duration, error)
+}
+
+// DispatcherConfig is used to pass in configuration when creating the
+// dispatcher. Zero values are replaced by defaults, and if no policy is given
+// the dispatcher backs off exponentially from the interval. A zero write
+// timeout sets no deadline for writes to the datastore.
+type DispatcherConfig struct {
+	Outbox       Outbox
+	Datastore    datastore.Datastore
+	MaxAge       time.Duration
+	Interval     time.Duration
+	BatchSize    int
+	Policy       backoff.Policy
+	WriteTimeout time.Duration
+	Verbose      bool
+}
+
+// Dispatcher is a type that retries writing events held in the outbox to the
+// datastore. Events remain in the outbox until they are acknowledged by the
+// datastore, or until they exceed the maximum age.
+type Dispatcher struct {
+	sync.Mutex
+	outbox       Outbox
+	datastore    datastore.Datastore
+	maxAge       time.Duration
+	interval     time.Duration
+	batchSize    int
+	policy       backoff.Policy
+	writeTimeout time.Duration
+	verbose      bool
+	logger       kitlog.Logger
+	cancel       context.CancelFunc
+	done         chan struct{}
+}
+
+// NewDispatcher returns a new dispatcher configured from the given config,
+// which is ready to be started.
+func NewDispatcher(config *DispatcherConfig, logger kitlog.Logger) *Dispatcher {
+	logger = kitlog.With(logger, "module", "dispatcher")
+
+	maxAge := config.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultOutboxMaxAge
+	}
+
+	interval := config.Interval
+	if interval == 0 {
+		interval = DefaultDispatchInterval
+	}
+
+	batchSize := config.BatchSize
+	if batchSize == 0 {
+		batchSize = DefaultDispatchBatchSize
+	}
+
+	policy := config.Policy
+	if policy == nil {
+		policy = backoff.NewExponential(
+			backoff.WithInterval(interval),
+			backoff.WithMaxInterval(maxDispatchBackoff),
+			backoff.WithMaxRetries(0),
+		)
+	}
+
+	return &Dispatcher{
+		outbox:       config.Outbox,
+		datastore:    config.Datastore,
+		maxAge:       maxAge,
+		interval:     interval,
+		batchSize:    batchSize,
+		policy:       policy,
+		writeTimeout: config.WriteTimeout,
+		verbose:      config.Verbose,
+		logger:       logger,
+	}
+}
+
+// Start starts a goroutine which dispatches events from the outbox until Stop
+// is called. Each interval we write any events available in the outbox, and if
+// a write fails we retry with exponential backoff until the datastore
+// acknowledges them.
+func (d *Dispatcher) Start() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.cancel != nil {
+		return nil
+	}
+
+	d.logger.Log("msg", "starting dispatcher")
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.cancel = cancel
+	d.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			backoff.Retry(ctx, d.policy, backoff.ExecuteFunc(func(ctx context.Context) error {
+				_, err := d.Dispatch(ctx)
+				if err != nil {
+					d.logger.Log("err", err, "msg", "failed to dispatch events, backing off")
+				}
+
+				return err
+			}))
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}(d.done)
+
+	return nil
+}
+
+// Stop stops the dispatcher, abandoning any write in progress and waiting for
+// the dispatcher to finish. Abandoned events are retried once their lease
+// expires.
+func (d *Dispatcher) Stop() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.cancel != nil {
+		d.logger.Log("msg", "stopping dispatcher")
+
+		d.cancel()
+		<-d.done
+
+		d.cancel = nil
+	}
+
+	return nil
+}
+
+// Dispatch makes a single pass over the outbox, discarding any events older
+// than the maximum age and then writing those available to the datastore in
+// the order they were enqueued. It returns the number of events written. We
+// stop at the first write that fails, as the datastore is probably
+// unavailable, and any events claimed but not written are retried once their
+// lease expires, as are any remaining when the context is done.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	defer d.recordStats()
+
+	expired, err := d.outbox.ExpireEvents(d.maxAge)
+	if err != nil {
+		return 0, err
+	}
+
+	if expired > 0 {
+		OutboxExpiredCounter.Add(float64(expired))
+		d.logger.Log("expired", expired, "msg", "discarded events exceeding maximum age")
+	}
+
+	events, err := d.outbox.ClaimEvents(d.batchSize, OutboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, event := range events {
+		start := time.Now()
+
+		err = d.write(ctx, event)
+
+		duration := time.Since(start)
+
+		if err != nil {
+			DatastoreErrorCounter.Inc()
+			return i, errors.Wrapf(err, "failed to write event %v after %v attempts", event.ID, event.Attempts)
+		}
+
+		DatastoreWriteHistogram.Observe(duration.Seconds())
+
+		err = d.outbox.AcknowledgeEvent(event.ID)
+		if err != nil {
+			return i, err
+		}
+
+		if d.verbose {
+			d.logger.Log("id", event.ID, "attempts", event.Attempts, "msg", "dispatched event")
+		}
+	}
+
+	return len(events), nil
+}
+
+// write writes a single event to the datastore within the write timeout.
+func (d *Dispatcher) write(ctx context.Context, event *postgres.OutboxEvent) error {
+	if d.writeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.writeTimeout)
+		defer cancel()
+	}
+
+	_, err := d.datastore.WriteData(ctx, &datastore.WriteRequest{
+		CommunityId: event.CommunityID,
+		DeviceToken: event.DeviceToken,
+		Data:        event.Data,
+	})
+
+	return err
+}
+
+// recordStats updates our gauges describing the outbox.
+func (d *Dispatcher) recordStats() {
+	depth, age, err := d.outbox.OutboxStats()
+	if err != nil {
+		d.logger.Log("err", err, "msg", "failed to read outbox stats")
+		return
+	}
+
+	OutboxDepthGauge.Set(float64(depth))
+	OutboxAgeGauge.Set(age.Seconds())
+}
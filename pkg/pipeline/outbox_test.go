@@ -0,0 +1,205 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/lestrrat-go/backoff"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestDispatch(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	events := []*postgres.OutboxEvent{
+		{ID: 1, CommunityID: "alpha", DeviceToken: "abc123", Data: []byte("first"), Attempts: 2},
+		{ID: 2, CommunityID: "beta", DeviceToken: "abc123", Data: []byte("second"), Attempts: 1},
+	}
+
+	outbox := mocks.Outbox{}
+	outbox.On("ExpireEvents", time.Hour).Return(0, nil)
+	outbox.On("ClaimEvents", 10, pipeline.OutboxLease).Return(events, nil)
+	outbox.On("AcknowledgeEvent", int64(1)).Return(nil)
+	outbox.On("AcknowledgeEvent", int64(2)).Return(nil)
+	outbox.On("OutboxStats").Return(0, time.Duration(0), nil)
+
+	dispatcher := pipeline.NewDispatcher(&pipeline.DispatcherConfig{
+		Outbox:    &outbox,
+		Datastore: &ds,
+		MaxAge:    time.Hour,
+		BatchSize: 10,
+	}, logger)
+
+	written, err := dispatcher.Dispatch(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, written)
+
+	outbox.AssertExpectations(t)
+	assert.Len(t, ds.Calls, 2)
+
+	req := ds.Calls[0].Arguments[1].(*datastore.WriteRequest)
+	assert.Equal(t, "alpha", req.CommunityId)
+	assert.Equal(t, "abc123", req.DeviceToken)
+	assert.Equal(t, []byte("first"), req.Data)
+}
+
+func TestDispatchWriteError(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, errors.New("unavailable"))
+
+	events := []*postgres.OutboxEvent{
+		{ID: 1, CommunityID: "alpha", DeviceToken: "abc123", Data: []byte("first"), Attempts: 3},
+		{ID: 2, CommunityID: "beta", DeviceToken: "abc123", Data: []byte("second"), Attempts: 1},
+	}
+
+	outbox := mocks.Outbox{}
+	outbox.On("ExpireEvents", pipeline.DefaultOutboxMaxAge).Return(4, nil)
+	outbox.On("ClaimEvents", pipeline.DefaultDispatchBatchSize, pipeline.OutboxLease).Return(events, nil)
+	outbox.On("OutboxStats").Return(2, time.Minute, nil)
+
+	dispatcher := pipeline.NewDispatcher(&pipeline.DispatcherConfig{
+		Outbox:    &outbox,
+		Datastore: &ds,
+	}, logger)
+
+	// we stop at the first failure and acknowledge nothing
+	written, err := dispatcher.Dispatch(context.Background())
+	assert.NotNil(t, err)
+	assert.Equal(t, "failed to write event 1 after 3 attempts: unavailable", err.Error())
+	assert.Equal(t, 0, written)
+
+	outbox.AssertExpectations(t)
+	assert.Len(t, ds.Calls, 1)
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	// the datastore fails twice before recovering
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, errors.New("unavailable")).Twice()
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	events := []*postgres.OutboxEvent{
+		{ID: 1, CommunityID: "alpha", DeviceToken: "abc123", Data: []byte("first")},
+	}
+
+	acknowledged := make(chan struct{}, 1)
+
+	outbox := mocks.Outbox{}
+	outbox.On("ExpireEvents", mock.Anything).Return(0, nil)
+	outbox.On("ClaimEvents", mock.Anything, mock.Anything).Return(events, nil).Times(3)
+	outbox.On("ClaimEvents", mock.Anything, mock.Anything).Return([]*postgres.OutboxEvent{}, nil)
+	outbox.On("AcknowledgeEvent", int64(1)).Return(nil).Run(func(args mock.Arguments) {
+		acknowledged <- struct{}{}
+	}).Once()
+	outbox.On("OutboxStats").Return(0, time.Duration(0), nil)
+
+	dispatcher := pipeline.NewDispatcher(&pipeline.DispatcherConfig{
+		Outbox:    &outbox,
+		Datastore: &ds,
+		Interval:  time.Millisecond,
+		Policy: backoff.NewExponential(
+			backoff.WithInterval(time.Millisecond),
+			backoff.WithMaxRetries(0),
+		),
+	}, logger)
+
+	err := dispatcher.Start()
+	assert.Nil(t, err)
+
+	select {
+	case <-acknowledged:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not acknowledged")
+	}
+
+	err = dispatcher.Stop()
+	assert.Nil(t, err)
+
+	ds.AssertExpectations(t)
+}
+
+func TestProcessWithOutbox(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	testcases := []struct {
+		label         string
+		writeErr      error
+		acknowledged  bool
+		expectedCalls int
+	}{
+		{
+			label:         "written",
+			acknowledged:  true,
+			expectedCalls: 2,
+		},
+		{
+			label:         "datastore unavailable",
+			writeErr:      errors.New("unavailable"),
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			ds := mocks.Datastore{}
+			ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, tc.writeErr)
+
+			outbox := mocks.Outbox{}
+			outbox.On("EnqueueEvent", mock.Anything, pipeline.OutboxLease).Return(&postgres.OutboxEvent{ID: 7}, nil)
+			outbox.On("AcknowledgeEvent", int64(7)).Return(nil)
+
+			processor := pipeline.NewProcessor(&pipeline.Config{
+				Datastore: datastore.Datastore(&ds),
+				Outbox:    &outbox,
+			}, logger)
+
+			device := &postgres.Device{
+				DeviceToken: "abc123",
+				Streams: []*postgres.Stream{
+					{
+						CommunityID: "smartcitizen",
+						PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+					},
+				},
+			}
+
+			payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+			// a failed write is left in the outbox rather than reported
+			err := processor.Process(context.Background(), device, payload)
+			assert.Nil(t, err)
+
+			// the event is enqueued before it is written
+			assert.Len(t, outbox.Calls, tc.expectedCalls)
+			assert.Equal(t, "EnqueueEvent", outbox.Calls[0].Method)
+
+			event := outbox.Calls[0].Arguments[0].(*postgres.OutboxEvent)
+			req := ds.Calls[0].Arguments[1].(*datastore.WriteRequest)
+			assert.Equal(t, "smartcitizen", event.CommunityID)
+			assert.Equal(t, "abc123", event.DeviceToken)
+			assert.Equal(t, req.Data, event.Data)
+
+			if tc.acknowledged {
+				outbox.AssertCalled(t, "AcknowledgeEvent", int64(7))
+			} else {
+				outbox.AssertNotCalled(t, "AcknowledgeEvent", int64(7))
+			}
+		})
+	}
+}
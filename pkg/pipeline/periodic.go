@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// periodicRunner runs a function on a fixed interval in a background goroutine
+// between calls to Start and Stop. Types which sweep or flush their state in
+// the background embed it, so that they implement the Startable and Stoppable
+// parts of the Sweeper interface.
+type periodicRunner struct {
+	interval time.Duration
+	run      func()
+
+	mu   sync.Mutex
+	quit chan struct{}
+	done chan struct{}
+}
+
+// newPeriodicRunner returns a runner which calls run every interval once
+// started.
+func newPeriodicRunner(interval time.Duration, run func()) *periodicRunner {
+	return &periodicRunner{
+		interval: interval,
+		run:      run,
+	}
+}
+
+// Start starts a goroutine which calls the runner's function on a fixed
+// interval until Stop is called. Starting a runner which is already running
+// does nothing.
+func (p *periodicRunner) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit != nil {
+		return nil
+	}
+
+	p.quit = make(chan struct{})
+	p.done = make(chan struct{})
+
+	go func(quit, done chan struct{}) {
+		defer close(done)
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.run()
+			case <-quit:
+				return
+			}
+		}
+	}(p.quit, p.done)
+
+	return nil
+}
+
+// Stop stops the background goroutine, waiting for any call in progress to
+// return so that the function is never called after Stop returns.
+func (p *periodicRunner) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.quit != nil {
+		close(p.quit)
+		<-p.done
+
+		p.quit = nil
+		p.done = nil
+	}
+
+	return nil
+}
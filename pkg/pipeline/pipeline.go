@@ -1,12 +1,13 @@
 package pipeline
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"time"
 
-	zenroom "github.com/DECODEproject/zenroom-go"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
@@ -81,238 +82,724 @@ var (
 // transformations to the data and then encrypting it using zenroom before
 // writing it to the datastore.
 type Processor struct {
-	datastore datastore.Datastore
-	logger    kitlog.Logger
-	verbose   bool
-	sensors   *smartcitizen.Smartcitizen
-	movingAvg MovingAverager
+	datastore    datastore.Datastore
+	logger       kitlog.Logger
+	verbose      bool
+	sensors      *smartcitizen.Smartcitizen
+	emitter      Emitter
+	devices      DeviceStore
+	transformer  *Transformer
+	operations   *Registry
+	workers      *WorkerPool
+	outbox       Outbox
+	deduplicator Deduplicator
+	dedupHorizon time.Duration
+	deadlines    map[Stage]time.Duration
 }
 
-// NewProcessor is a constructor function that takes as input an instantiated
-// datastore client, and a logger. It returns the instantiated processor which
-// is ready for use. Note we pass in the datastore instance so that we can
-// supply a mock for testing.
-func NewProcessor(ds datastore.Datastore, movingAvg MovingAverager, verbose bool, logger kitlog.Logger) *Processor {
+// Config is a struct used to pass in configuration when creating the processor.
+// The WorkerPool caps the number of events encrypted and written concurrently.
+type Config struct {
+	Datastore           datastore.Datastore
+	MovingAverager      MovingAverager
+	ExponentialAverager ExponentialAverager
+	Windower            Windower
+	NoiseGenerator      *NoiseGenerator
+	PrivacyBudget       PrivacyBudget
+	Thresholder         Thresholder
+	Emitter             Emitter
+	Devices             DeviceStore
+	Transformer         *Transformer
+	Registry            *Registry
+	WorkerPool          *WorkerPool
+	Outbox              Outbox
+	Deduplicator        Deduplicator
+	DedupHorizon        time.Duration
+	AllowedLateness     time.Duration
+	TransformTimeout    time.Duration
+	WriteTimeout        time.Duration
+	Verbose             bool
+}
+
+// NewProcessor is a constructor function that takes as input a config struct
+// containing an instantiated datastore client along with the stateful
+// components used by operations, and a logger. It returns the instantiated
+// processor which is ready for use. Note we pass in the datastore instance so
+// that we can supply a mock for testing. If the config has no registry of
+// operations, the built-in operations are used, if it has no transformer
+// scripts are limited to the DefaultScriptInstructions, and if it has no
+// worker pool one of DefaultWorkers is created. If the config has an outbox,
+// events are persisted in it before being written, and if it has a
+// deduplicator readings seen within the horizon are discarded. If it has a
+// device store, buffered readings are only flushed to streams which still
+// exist. The timeouts set
+// deadlines for the transform and write stages, and a zero timeout sets no
+// deadline.
+func NewProcessor(config *Config, logger kitlog.Logger) *Processor {
 	logger = kitlog.With(logger, "module", "pipeline")
 
+	registry := config.Registry
+	if registry == nil {
+		registry = DefaultRegistry(config, logger)
+	}
+
+	transformer := config.Transformer
+	if transformer == nil {
+		transformer = NewTransformer(DefaultScriptInstructions)
+	}
+
+	workers := config.WorkerPool
+	if workers == nil {
+		workers = NewWorkerPool(DefaultWorkers)
+	}
+
 	return &Processor{
-		datastore: ds,
-		logger:    logger,
-		verbose:   verbose,
-		sensors:   &smartcitizen.Smartcitizen{},
-		movingAvg: movingAvg,
+		datastore:    config.Datastore,
+		logger:       logger,
+		verbose:      config.Verbose,
+		sensors:      &smartcitizen.Smartcitizen{},
+		emitter:      config.Emitter,
+		devices:      config.Devices,
+		transformer:  transformer,
+		operations:   registry,
+		workers:      workers,
+		outbox:       config.Outbox,
+		deduplicator: config.Deduplicator,
+		dedupHorizon: config.DedupHorizon,
+		deadlines: map[Stage]time.Duration{
+			TransformStage: config.TransformTimeout,
+			WriteStage:     config.WriteTimeout,
+		},
 	}
 }
 
 // Process is the function that actually does the work of dispatching the
 // received data to all destination streams after applying whatever processing
-// the stream specifies. Currently we do the simplest thing of just writing the
-// data directly to the datastore.
-func (p *Processor) Process(device *postgres.Device, payload []byte) error {
+// the stream specifies. A payload may contain several readings, which are
+// processed in the order they were recorded so that stateful operations see
+// them in sequence. Each stream then writes either one event per reading, or a
+// single batched event containing all of them. Writes to different streams run
+// concurrently on the worker pool, while each stream's events are written in
+// order, and we return once they have all been written. Streams are
+// independent, so a stream failing doesn't stop the others from being written,
+// and any failures are returned together as a ProcessError. Cancelling the
+// context abandons any stateful operations or writes still in progress.
+func (p *Processor) Process(ctx context.Context, device *postgres.Device, payload []byte) error {
+	_, err := p.process(ctx, device, payload)
+	return err
+}
+
+// Replay processes a payload which was received earlier, e.g. a dead letter,
+// exactly as Process does, but also returns the number of events written, as
+// its readings may produce nothing to write, e.g. if they are now too late to
+// be included in windows. If an error is returned some events may not have
+// been written.
+func (p *Processor) Replay(ctx context.Context, device *postgres.Device, payload []byte) (int, error) {
+	return p.process(ctx, device, payload)
+}
+
+// process is our implementation of Process, returning the number of events
+// written. If it fails, readings recorded as seen by the deduplicator are
+// forgotten again, so that they aren't discarded when redelivered.
+func (p *Processor) process(ctx context.Context, device *postgres.Device, payload []byte) (_ int, err error) {
 	// check payload
 	if payload == nil {
-		return errors.New("empty payload received")
+		return 0, failStreams(device, ParseStage, errors.New("empty payload received"))
 	}
 
-	parsedDevice, err := p.sensors.ParseData(device, payload)
+	readings, err := p.sensors.ParseData(device, payload)
 	if err != nil {
-		return errors.Wrap(err, "failed to parse SmartCitizen data")
+		return 0, failStreams(device, ParseStage, errors.Wrap(err, "failed to parse SmartCitizen data"))
+	}
+
+	// copies of readings are discarded before they reach any stateful
+	// operations, so that they aren't counted twice
+	readings, keys, err := p.deduplicate(ctx, device.DeviceToken, readings)
+	if err != nil {
+		return 0, failStreams(device, DeduplicateStage, err)
+	}
+
+	defer func() {
+		if err != nil {
+			p.forget(device.DeviceToken, keys)
+		}
+	}()
+
+	if len(readings) == 0 {
+		return 0, nil
 	}
 
 	// pull encryption script from go-bindata asset
+	script, err := lua.Asset("encrypt.lua")
+	if err != nil {
+		return 0, failStreams(device, EncryptStage, errors.Wrap(err, "failed to read zenroom script"))
+	}
+
+	failures := []*StreamError{}
+	written := 0
+
+	// streams are processed in turn as their operations share state, but the
+	// resulting events are encrypted and written concurrently
+	tasks := []func() error{}
+
+	for _, stream := range device.Streams {
+		events, err := p.processStream(ctx, device, stream, readings)
+		if err != nil {
+			failures = append(failures, newStreamError(stream, TransformStage, err))
+			continue
+		}
+
+		if len(events) == 0 {
+			continue
+		}
+
+		written += len(events)
+		tasks = append(tasks, p.writeTask(ctx, device, stream, script, events))
+	}
+
+	// write tasks only fail with the stream error describing the failure
+	for _, err := range p.workers.Run(tasks) {
+		failures = append(failures, err.(*StreamError))
+	}
+
+	return written, newProcessError(failures)
+}
+
+// Flush writes the summaries of any readings buffered by streams whose
+// emission interval has elapsed without another reading arriving to emit them,
+// e.g. because their device has gone offline. Summaries are written in the same
+// shape as those emitted when readings arrive, and any failures are returned
+// together as a ProcessError.
+func (p *Processor) Flush(ctx context.Context) error {
+	return p.flush(ctx, false)
+}
+
+// FlushAll writes the summaries of every reading buffered by streams whether or
+// not their emission interval has elapsed, which is done when stopping so that
+// buffered readings aren't lost.
+func (p *Processor) FlushAll(ctx context.Context) error {
+	return p.flush(ctx, true)
+}
+
+// flush writes the summaries flushed from our emitter. Readings are buffered
+// with the device and stream they arrived for, so if we have a device store we
+// reload the device first, dropping the summaries of streams which have since
+// been deleted and writing the others with their latest configuration.
+func (p *Processor) flush(ctx context.Context, all bool) error {
+	if p.emitter == nil {
+		return nil
+	}
+
+	emissions := p.emitter.Flush(all)
+	if len(emissions) == 0 {
+		return nil
+	}
+
 	script, err := lua.Asset("encrypt.lua")
 	if err != nil {
 		return errors.Wrap(err, "failed to read zenroom script")
 	}
 
-	// iterate over the configured streams for the device
+	devices := map[string]*postgres.Device{}
+
+	tasks := []func() error{}
+	failures := []*StreamError{}
+
+	for _, emission := range emissions {
+		device, stream := emission.Device, emission.Stream
+
+		if p.devices != nil {
+			device, stream, err = p.currentStream(devices, emission)
+			if err != nil {
+				failures = append(failures, newStreamError(emission.Stream, WriteStage, err))
+				continue
+			}
+
+			if stream == nil {
+				if p.verbose {
+					p.logger.Log("stream_id", emission.Stream.StreamID, "msg", "dropped buffered readings of deleted stream")
+				}
+
+				continue
+			}
+		}
+
+		var event interface{} = emission.Summary
+
+		if stream.BatchReadings {
+			event = &smartcitizen.Batch{Readings: []*smartcitizen.Device{emission.Summary}}
+		}
+
+		tasks = append(tasks, p.writeTask(ctx, device, stream, script, []interface{}{event}))
+	}
+
+	// write tasks only fail with the stream error describing the failure
+	for _, err := range p.workers.Run(tasks) {
+		failures = append(failures, err.(*StreamError))
+	}
+
+	return newProcessError(failures)
+}
+
+// currentStream returns the emission's device and stream as currently stored,
+// loading each device once into the given map, or a nil stream if the stream
+// or its device has been deleted.
+func (p *Processor) currentStream(devices map[string]*postgres.Device, emission *Emission) (*postgres.Device, *postgres.Stream, error) {
+	token := emission.Device.DeviceToken
+
+	device, ok := devices[token]
+	if !ok {
+		var err error
+
+		device, err = p.devices.GetDevice(token)
+		if err != nil {
+			if errors.Cause(err) != sql.ErrNoRows {
+				return nil, nil, errors.Wrap(err, "failed to load device")
+			}
+
+			device = nil
+		}
+
+		devices[token] = device
+	}
+
+	if device == nil {
+		return nil, nil, nil
+	}
+
 	for _, stream := range device.Streams {
-		if p.verbose {
-			p.logger.Log("public_key", stream.PublicKey, "device_token", device.DeviceToken, "msg", "writing data")
+		if stream.StreamID == emission.Stream.StreamID {
+			return device, stream, nil
 		}
+	}
 
-		keyString := fmt.Sprintf(
-			`{"device_token":"%s","community_id":"%s","community_pubkey":"%s"}`,
-			device.DeviceToken,
-			stream.CommunityID,
-			stream.PublicKey,
-		)
+	return nil, nil, nil
+}
 
-		payloadBytes, err := p.processDevice(parsedDevice, stream)
+// failStreams returns a ProcessError recording that every stream of the device
+// failed at the given stage, for failures which occur before the payload is
+// processed for individual streams.
+func failStreams(device *postgres.Device, stage Stage, err error) error {
+	failures := make([]*StreamError, len(device.Streams))
+	for i, stream := range device.Streams {
+		failures[i] = newStreamError(stream, stage, err)
+	}
+
+	return newProcessError(failures)
+}
+
+// processStream applies the stream's processing to each of the readings,
+// returning the events to be written for the stream. This is either one event
+// per reading, or a single batched event containing all of them which is split
+// when written if it is too large to encrypt. The
+// transform stage deadline applies to processing all of the readings.
+func (p *Processor) processStream(ctx context.Context, device *postgres.Device, stream *postgres.Stream, readings []*smartcitizen.Device) ([]interface{}, error) {
+	if p.verbose {
+		p.logger.Log("public_key", stream.PublicKey, "device_token", device.DeviceToken, "readings", len(readings), "msg", "writing data")
+	}
+
+	ctx, cancel := p.stageContext(ctx, TransformStage)
+	defer cancel()
+
+	processedDevices := []*smartcitizen.Device{}
+
+	for _, reading := range readings {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "abandoned processing readings")
+		}
+
+		processedDevice, err := p.processDevice(ctx, reading, stream)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		// no sensors produced output for this stream so there is nothing to write
+		if processedDevice == nil {
+			if p.verbose {
+				p.logger.Log("public_key", stream.PublicKey, "device_token", device.DeviceToken, "msg", "no output, skipping write")
+			}
+			continue
+		}
+
+		if stream.EmissionInterval > 0 {
+			var emit bool
+
+			processedDevice, emit, err = p.emitter.Emit(device, stream, processedDevice)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to rate limit emission")
+			}
+
+			// the reading has been buffered to be summarised in a later write
+			if !emit {
+				continue
+			}
+		}
+
+		processedDevices = append(processedDevices, processedDevice)
+	}
+
+	if len(processedDevices) == 0 {
+		return nil, nil
+	}
+
+	if stream.BatchReadings {
+		return []interface{}{&smartcitizen.Batch{Readings: processedDevices}}, nil
+	}
+
+	events := make([]interface{}, len(processedDevices))
+	for i, processedDevice := range processedDevices {
+		events[i] = processedDevice
+	}
+
+	return events, nil
+}
+
+// writeTask returns a task for the worker pool which writes the events to the
+// stream in order, stopping at the first failure. A failing task returns a
+// StreamError recording the stage at which the write failed.
+func (p *Processor) writeTask(ctx context.Context, device *postgres.Device, stream *postgres.Stream, script []byte, events []interface{}) func() error {
+	return func() error {
+		for _, event := range events {
+			stage, err := p.writeEvent(ctx, device, stream, script, event)
+			if err != nil {
+				return newStreamError(stream, stage, err)
+			}
 		}
 
-		if p.verbose {
-			p.logger.Log("full_payload", string(payloadBytes))
+		return nil
+	}
+}
+
+// writeEvent writes the event to the stream. Zenroom can only return output of
+// limited size, and transformation scripts only accept input of limited size,
+// so if the event is a batch which is too large to transform or once encrypted
+// we split it in half and write each half in turn, so that every event written
+// holds as many readings as fit. Nothing is written for a batch before it has
+// been split, so no reading is written twice.
+func (p *Processor) writeEvent(ctx context.Context, device *postgres.Device, stream *postgres.Stream, script []byte, event interface{}) (Stage, error) {
+	stage, err := p.write(ctx, device, stream, script, event)
+	if cause := errors.Cause(err); cause != errOutputTruncated && cause != errScriptDataTooLong {
+		return stage, err
+	}
+
+	batch, ok := event.(*smartcitizen.Batch)
+	if !ok || len(batch.Readings) < 2 {
+		return stage, err
+	}
+
+	if p.verbose {
+		p.logger.Log("public_key", stream.PublicKey, "device_token", device.DeviceToken, "readings", len(batch.Readings), "msg", "splitting batch too large to write")
+	}
+
+	half := len(batch.Readings) / 2
+
+	for _, readings := range [][]*smartcitizen.Device{batch.Readings[:half], batch.Readings[half:]} {
+		stage, err = p.writeEvent(ctx, device, stream, script, &smartcitizen.Batch{Readings: readings})
+		if err != nil {
+			return stage, err
 		}
+	}
+
+	return "", nil
+}
+
+// write marshals the given data, transforms it using the stream's script if it
+// has one, encrypts it for the stream using zenroom, and then writes the
+// encrypted event to the datastore. If this fails we return the stage at which
+// it failed along with the error. If we have an outbox the encrypted event is
+// persisted before we attempt the write, and a failed write is left in the
+// outbox to be retried by the dispatcher rather than reported as a failure. The
+// write stage deadline applies to each write to the datastore.
+func (p *Processor) write(ctx context.Context, device *postgres.Device, stream *postgres.Stream, script []byte, data interface{}) (Stage, error) {
+	// don't spend time encrypting an event we no longer have time to write
+	if err := ctx.Err(); err != nil {
+		return WriteStage, errors.Wrap(err, "abandoned write")
+	}
+
+	keyString := fmt.Sprintf(
+		`{"device_token":"%s","community_id":"%s","community_pubkey":"%s"}`,
+		device.DeviceToken,
+		stream.CommunityID,
+		stream.PublicKey,
+	)
+
+	payloadBytes, err := json.Marshal(data)
+	if err != nil {
+		return TransformStage, errors.Wrap(err, "failed to marshal processed device")
+	}
 
+	if stream.TransformScript != "" {
 		start := time.Now()
 
-		encodedPayload, err := zenroom.Exec(
-			script,
-			zenroom.WithKeys([]byte(keyString)),
-			zenroom.WithData(payloadBytes),
-			zenroom.WithVerbosity(1),
-		)
+		payloadBytes, err = p.transformer.Transform(ctx, stream.TransformScript, payloadBytes)
 
 		duration := time.Since(start)
 
 		if err != nil {
 			ZenroomErrorCounter.Inc()
-			return err
+			return TransformStage, errors.Wrap(err, "failed to transform processed device")
 		}
 
 		ZenroomHistogram.Observe(duration.Seconds())
+	}
+
+	if p.verbose {
+		p.logger.Log("full_payload", string(payloadBytes))
+	}
+
+	start := time.Now()
+
+	encodedPayload, err := zenroomProcesses.exec(ctx, &zenroomRequest{
+		Script: nulTerminate(script),
+		Keys:   nulTerminate([]byte(keyString)),
+		Data:   nulTerminate(payloadBytes),
+	})
+
+	duration := time.Since(start)
 
-		start = time.Now()
+	if err != nil {
+		ZenroomErrorCounter.Inc()
+		return EncryptStage, err
+	}
+
+	if !json.Valid(bytes.TrimSpace(encodedPayload)) {
+		ZenroomErrorCounter.Inc()
+		return EncryptStage, errors.New("zenroom did not output a valid encrypted event")
+	}
 
-		_, err = p.datastore.WriteData(context.Background(), &datastore.WriteRequest{
-			CommunityId: stream.CommunityID,
+	ZenroomHistogram.Observe(duration.Seconds())
+
+	var event *postgres.OutboxEvent
+
+	if p.outbox != nil {
+		event, err = p.outbox.EnqueueEvent(&postgres.OutboxEvent{
+			CommunityID: stream.CommunityID,
 			DeviceToken: device.DeviceToken,
 			Data:        []byte(encodedPayload),
-		})
+		}, OutboxLease)
+		if err != nil {
+			return WriteStage, errors.Wrap(err, "failed to enqueue event")
+		}
+	}
 
-		duration = time.Since(start)
+	writeCtx, cancel := p.stageContext(ctx, WriteStage)
+	defer cancel()
 
-		if err != nil {
-			DatastoreErrorCounter.Inc()
-			return err
+	start = time.Now()
+
+	_, err = p.datastore.WriteData(writeCtx, &datastore.WriteRequest{
+		CommunityId: stream.CommunityID,
+		DeviceToken: device.DeviceToken,
+		Data:        []byte(encodedPayload),
+	})
+
+	duration = time.Since(start)
+
+	if err != nil {
+		DatastoreErrorCounter.Inc()
+
+		if event != nil {
+			p.logger.Log("err", err, "id", event.ID, "msg", "failed to write event, leaving in outbox to retry")
+			return "", nil
 		}
 
-		DatastoreWriteHistogram.Observe(duration.Seconds())
+		return WriteStage, err
 	}
 
-	return nil
-}
+	DatastoreWriteHistogram.Observe(duration.Seconds())
 
-func (p *Processor) processDevice(device *smartcitizen.Device, stream *postgres.Stream) ([]byte, error) {
-	// if no operations just return the whole object
-	if len(stream.Operations) == 0 {
-		b, err := json.Marshal(device)
+	if event != nil {
+		err = p.outbox.AcknowledgeEvent(event.ID)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to marshal complete device")
+			// the event has been written, but will be written again once its
+			// lease expires
+			p.logger.Log("err", err, "id", event.ID, "msg", "failed to acknowledge written event")
 		}
-		return b, nil
 	}
 
-	// create empty slice for processed sensors
-	processedSensors := []*smartcitizen.Sensor{}
+	return "", nil
+}
 
-	for _, operation := range stream.Operations {
-		// get the sensor from the parsed slice
-		sensor := device.FindSensor(int(operation.SensorID))
-
-		if sensor != nil {
-			switch operation.Action {
-			case postgres.Share:
-				start := time.Now()
-
-				processedSensor := &smartcitizen.Sensor{
-					ID:          sensor.ID,
-					Name:        sensor.Name,
-					Description: sensor.Description,
-					Unit:        sensor.Unit,
-					Action:      operation.Action,
-					Value:       sensor.Value,
-				}
+// findSensor returns the sensor of the device to which the operation applies,
+// or nil if the device has no such sensor. Operations targeting a measurement
+// are resolved to whichever sensor of the device reports that measurement in
+// the unit the operation was created with, or one convertible to it, and its
+// value is converted so that the stream's values are comparable whichever
+// sensor reported them. Conversions convert the value themselves. A sensor
+// whose value can't be converted is treated as missing.
+func (p *Processor) findSensor(device *smartcitizen.Device, operation *postgres.Operation) (*smartcitizen.Sensor, error) {
+	if operation.Measurement == "" {
+		return device.FindSensor(int(operation.SensorID)), nil
+	}
 
-				duration := time.Since(start)
+	sensor, err := p.sensors.FindMeasurement(device, operation.Measurement, operation.Unit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve measurement")
+	}
 
-				ProcessHistogram.WithLabelValues(string(postgres.Share)).Observe(duration.Seconds() * 1e3)
+	if sensor == nil || operation.Unit == "" || operation.Action == postgres.Convert {
+		return sensor, nil
+	}
 
-				processedSensors = append(processedSensors, processedSensor)
-			case postgres.Bin:
-				start := time.Now()
+	converted, err := p.sensors.ConvertSensor(sensor, operation.Unit)
+	if err != nil {
+		// we drop a sensor we can't convert rather than failing the whole
+		// stream, e.g. if the sensor metadata has changed
+		p.logger.Log("err", err, "sensor_id", sensor.ID, "unit", operation.Unit, "msg", "dropping sensor which can't be converted")
+		return nil, nil
+	}
 
-				processedSensor := &smartcitizen.Sensor{
-					ID:          sensor.ID,
-					Name:        sensor.Name,
-					Description: sensor.Description,
-					Unit:        sensor.Unit,
-					Action:      operation.Action,
-					Bins:        operation.Bins,
-					Values:      BinValue(sensor.Value.Float64, operation.Bins),
-				}
+	return converted, nil
+}
 
-				duration := time.Since(start)
+// processDevice applies the stream's policies and operations to a copy of the
+// parsed device, returning the device to be written for the stream, or nil if
+// no sensors produced any output.
+func (p *Processor) processDevice(ctx context.Context, parsedDevice *smartcitizen.Device, stream *postgres.Stream) (*smartcitizen.Device, error) {
+	// take a copy of the parsed device as it is shared between all streams
+	device := *parsedDevice
 
-				ProcessHistogram.WithLabelValues(string(postgres.Bin)).Observe(duration.Seconds() * 1e3)
+	applyLocationPolicy(&device, stream)
+	applyTimeResolution(&device, stream)
 
-				processedSensors = append(processedSensors, processedSensor)
-			case postgres.MovingAverage:
-				start := time.Now()
+	// if no operations just return the whole object
+	if len(stream.Operations) == 0 {
+		return &device, nil
+	}
 
-				avgVal, err := p.movingAvg.MovingAverage(
-					sensor.Value.Float64,
-					device.Token,
-					sensor.ID,
-					operation.Interval,
-				)
-				if err != nil {
-					return nil, errors.Wrap(err, "failed to calculate moving average")
-				}
+	// create empty slice for processed sensors
+	processedSensors := []*smartcitizen.Sensor{}
 
-				interval := null.IntFrom(int64(operation.Interval))
-				value := null.FloatFrom(avgVal)
-
-				processedSensor := &smartcitizen.Sensor{
-					ID:          sensor.ID,
-					Name:        sensor.Name,
-					Description: sensor.Description,
-					Unit:        sensor.Unit,
-					Action:      operation.Action,
-					Interval:    &interval,
-					Value:       &value,
-				}
+	// windows read for this device keyed by sensor and interval, so that
+	// multiple windowed operations on the same sensor only record the value once
+	windows := map[string][]float64{}
 
-				duration := time.Since(start)
+	for _, operation := range stream.Operations {
+		registered, ok := p.operations.Lookup(operation.Action)
+		if !ok {
+			continue
+		}
 
-				ProcessHistogram.WithLabelValues(string(postgres.MovingAverage)).Observe(duration.Seconds() * 1e3)
+		reading := &Reading{
+			Stream:     stream,
+			Operation:  operation,
+			Device:     &device,
+			RecordedAt: parsedDevice.RecordedAt,
+			windows:    windows,
+		}
 
-				processedSensors = append(processedSensors, processedSensor)
-			default:
+		// operations deriving a value from several sensors, such as an air
+		// quality index, are configured without a sensor of their own
+		if operation.SensorID != 0 || operation.Measurement != "" {
+			sensor, err := p.findSensor(&device, operation)
+			if err != nil {
+				return nil, err
+			}
+
+			if sensor == nil {
 				continue
 			}
+
+			reading.Sensor = sensor
+		}
+
+		processedSensor, err := p.applyOperation(ctx, registered, reading)
+		if err != nil {
+			return nil, err
 		}
+
+		if processedSensor != nil {
+			processedSensors = append(processedSensors, processedSensor)
+		}
+	}
+
+	// returning nil tells the caller to skip writing for this stream
+	if len(processedSensors) == 0 {
+		return nil, nil
 	}
 
 	device.Sensors = processedSensors
 
-	b, err := json.Marshal(device)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal processed device")
+	return &device, nil
+}
+
+// windowReady returns true if a window holding the given number of samples may
+// be released for the operation. Operations with both an interval and a number
+// of samples are suppressed until the interval holds at least that many.
+func windowReady(operation *postgres.Operation, count int) bool {
+	return operation.Interval == 0 || count >= int(operation.Samples)
+}
+
+// setWindow records the window used by the operation on the processed sensor.
+func setWindow(sensor *smartcitizen.Sensor, operation *postgres.Operation) {
+	if operation.Interval > 0 {
+		interval := null.IntFrom(int64(operation.Interval))
+		sensor.Interval = &interval
+	}
+
+	if operation.Samples > 0 {
+		samples := null.IntFrom(int64(operation.Samples))
+		sensor.Samples = &samples
+	}
+}
+
+// applyTimeResolution rounds the recorded at timestamp of the device down to
+// the time resolution configured for the stream, recording the resolution used
+// so that consumers know the precision of the timestamp.
+func applyTimeResolution(device *smartcitizen.Device, stream *postgres.Stream) {
+	if stream.TimeResolution == 0 {
+		return
 	}
 
-	return b, nil
+	resolution := null.IntFrom(int64(stream.TimeResolution))
+
+	device.RecordedAt = device.RecordedAt.Truncate(time.Second * time.Duration(stream.TimeResolution))
+	device.TimeResolution = &resolution
+}
+
+// nulTerminate returns a copy of the given bytes with a trailing NUL byte.
+// zenroom-go hands our slices to C as plain char pointers, so without the
+// terminator zenroom reads past the end of the slice into whatever memory
+// happens to follow it.
+func nulTerminate(b []byte) []byte {
+	return append(b[:len(b):len(b)], 0)
 }
 
 // BinValue is a function that tuns a value and a slice containing bin
 // boundaries into a slice containing the binned value.
 func BinValue(value float64, bins []float64) []int {
 	binnedValues := make([]int, len(bins)+1)
+	binnedValues[binIndex(value, bins)] = 1
 
-	classified := false
+	return binnedValues
+}
 
+// BinLabel returns the label of the bin into which the value falls. There must
+// be one more label than there are bin boundaries.
+func BinLabel(value float64, bins []float64, labels []string) string {
+	index := binIndex(value, bins)
+	if index >= len(labels) {
+		return ""
+	}
+
+	return labels[index]
+}
+
+// binIndex returns the index of the bin into which the value falls, where the
+// first bin holds values below the first boundary and the last holds values
+// greater than or equal to the last boundary.
+func binIndex(value float64, bins []float64) int {
 	for i := range bins {
 		if i == 0 {
 			if value < bins[i] {
-				binnedValues[i] = 1
-				classified = true
-				break
+				return i
 			}
 		} else {
 			if value < bins[i] && value >= bins[i-1] {
-				binnedValues[i] = 1
-				classified = true
-				break
+				return i
 			}
 		}
 	}
 
-	if !classified {
-		binnedValues[len(bins)] = 1
-	}
-
-	return binnedValues
+	return len(bins)
 }
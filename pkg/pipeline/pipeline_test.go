@@ -4,7 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/DECODEproject/zenroom-go"
 	kitlog "github.com/go-kit/kit/log"
@@ -13,6 +17,7 @@ import (
 	"github.com/stretchr/testify/mock"
 	datastore "github.com/thingful/twirp-datastore-go"
 
+	"github.com/DECODEproject/iotencoder/pkg/clock"
 	"github.com/DECODEproject/iotencoder/pkg/lua"
 	"github.com/DECODEproject/iotencoder/pkg/mocks"
 	"github.com/DECODEproject/iotencoder/pkg/pipeline"
@@ -22,17 +27,53 @@ import (
 
 func decryptData(t *testing.T, call mock.Call, secKey string) (*smartcitizen.Device, error) {
 	t.Helper()
+
+	var decryptedDevice smartcitizen.Device
+	err := decryptInto(t, call, secKey, &decryptedDevice)
+
+	return &decryptedDevice, err
+}
+
+func decryptBatch(t *testing.T, call mock.Call, secKey string) (*smartcitizen.Batch, error) {
+	t.Helper()
+
+	var decryptedBatch smartcitizen.Batch
+	err := decryptInto(t, call, secKey, &decryptedBatch)
+
+	return &decryptedBatch, err
+}
+
+// findCall returns the call writing to the given community, as streams are
+// written concurrently so their calls may be in any order.
+func findCall(t *testing.T, calls []mock.Call, communityID string) mock.Call {
+	t.Helper()
+
+	for _, call := range calls {
+		req := call.Arguments[1].(*datastore.WriteRequest)
+		if req.CommunityId == communityID {
+			return call
+		}
+	}
+
+	t.Fatalf("no call writing to community %s", communityID)
+
+	return mock.Call{}
+}
+
+func decryptInto(t *testing.T, call mock.Call, secKey string, v interface{}) error {
+	t.Helper()
 	req := call.Arguments[1].(*datastore.WriteRequest)
 
-	decryptKeys := []byte(fmt.Sprintf(`{"community_seckey":"%s"}`, secKey))
+	// zenroom reads its inputs as C strings so we must NUL terminate them
+	decryptKeys := []byte(fmt.Sprintf(`{"community_seckey":"%s"}`+"\x00", secKey))
 
 	decryptScript, err := lua.Asset("decrypt.lua")
 	assert.Nil(t, err)
 
 	output, err := zenroom.Exec(
-		decryptScript,
+		append(decryptScript[:len(decryptScript):len(decryptScript)], 0),
 		zenroom.WithKeys(decryptKeys),
-		zenroom.WithData(req.Data),
+		zenroom.WithData(append(req.Data[:len(req.Data):len(req.Data)], 0)),
 		zenroom.WithVerbosity(1),
 	)
 	assert.Nil(t, err)
@@ -42,11 +83,10 @@ func decryptData(t *testing.T, call mock.Call, secKey string) (*smartcitizen.Dev
 	err = json.Unmarshal(output, &unmarshalled)
 	assert.Nil(t, err)
 
-	var decryptedDevice smartcitizen.Device
-	err = json.Unmarshal([]byte(unmarshalled["data"].(string)), &decryptedDevice)
+	err = json.Unmarshal([]byte(unmarshalled["data"].(string)), v)
 	assert.Nil(t, err)
 
-	return &decryptedDevice, nil
+	return nil
 }
 
 func TestProcess(t *testing.T) {
@@ -56,7 +96,7 @@ func TestProcess(t *testing.T) {
 	// set up a mock response
 	ds.On(
 		"WriteData",
-		context.Background(),
+		mock.Anything,
 		mock.Anything,
 	).Return(
 		&datastore.WriteResponse{},
@@ -66,18 +106,31 @@ func TestProcess(t *testing.T) {
 	mv := mocks.MovingAverager{}
 	mv.On(
 		"MovingAverage",
+		mock.Anything,
 		12.58,
+		time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC),
+		mock.Anything,
 		"foo",
 		12,
 		uint32(900),
+		uint32(0),
+		time.Duration(0),
 	).Return(
 		12.58,
+		1,
 		nil,
 	)
 
+	wd := mocks.Windower{}
+
 	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
 
-	processor := pipeline.NewProcessor(datastore.Datastore(&ds), &mv, true, logger)
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
 
 	device := &postgres.Device{
 		DeviceToken: "foo",
@@ -109,7 +162,7 @@ func TestProcess(t *testing.T) {
 		},
 	}
 
-	err := processor.Process(device, payload)
+	err := processor.Process(context.Background(), device, payload)
 	assert.Nil(t, err)
 
 	ds.AssertExpectations(t)
@@ -124,14 +177,13 @@ func TestProcess(t *testing.T) {
 	assert.Len(t, decryptedDevice.Sensors, 4)
 }
 
-func TestProcessWithNoOperations(t *testing.T) {
+func TestProcessWithWindowedOperations(t *testing.T) {
 	logger := kitlog.NewNopLogger()
 	ds := mocks.Datastore{}
 
-	// set up a mock response
 	ds.On(
 		"WriteData",
-		context.Background(),
+		mock.Anything,
 		mock.Anything,
 	).Return(
 		&datastore.WriteResponse{},
@@ -140,9 +192,47 @@ func TestProcessWithNoOperations(t *testing.T) {
 
 	mv := mocks.MovingAverager{}
 
+	// note we only expect a single call for sensor 12 even though it has three
+	// windowed operations on the same interval
+	wd := mocks.Windower{}
+	wd.On(
+		"Window",
+		12.58,
+		time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC),
+		mock.Anything,
+		"foo",
+		12,
+		uint32(900),
+		uint32(0),
+		time.Duration(0),
+	).Return(
+		[]float64{10.0, 14.0, 12.58, 11.0},
+		nil,
+	).Once()
+
+	wd.On(
+		"Window",
+		79.35,
+		time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC),
+		mock.Anything,
+		"foo",
+		29,
+		uint32(3600),
+		uint32(0),
+		time.Duration(0),
+	).Return(
+		[]float64{50.0, 79.35, 60.0, 70.0, 80.0},
+		nil,
+	).Once()
+
 	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
 
-	processor := pipeline.NewProcessor(datastore.Datastore(&ds), &mv, true, logger)
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
 
 	device := &postgres.Device{
 		DeviceToken: "foo",
@@ -150,56 +240,1971 @@ func TestProcessWithNoOperations(t *testing.T) {
 			{
 				CommunityID: "smartcitizen",
 				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
-				Operations:  postgres.Operations{},
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Min,
+						Interval: 900,
+					},
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Max,
+						Interval: 900,
+					},
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Median,
+						Interval: 900,
+					},
+					&postgres.Operation{
+						SensorID:   29,
+						Action:     postgres.Percentile,
+						Interval:   3600,
+						Percentile: 90,
+					},
+				},
 			},
 		},
 	}
 
-	err := processor.Process(device, payload)
+	err := processor.Process(context.Background(), device, payload)
 	assert.Nil(t, err)
 
 	ds.AssertExpectations(t)
-	mv.AssertExpectations(t)
+	wd.AssertExpectations(t)
 
 	assert.Len(t, ds.Calls, 1)
 
 	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
 	assert.Nil(t, err)
 
-	assert.Len(t, decryptedDevice.Sensors, 9)
+	assert.Len(t, decryptedDevice.Sensors, 4)
+
+	assert.Equal(t, postgres.Min, decryptedDevice.Sensors[0].Action)
+	assert.Equal(t, 10.0, decryptedDevice.Sensors[0].Value.Float64)
+	assert.Equal(t, int64(900), decryptedDevice.Sensors[0].Interval.Int64)
+
+	assert.Equal(t, postgres.Max, decryptedDevice.Sensors[1].Action)
+	assert.Equal(t, 14.0, decryptedDevice.Sensors[1].Value.Float64)
+
+	assert.Equal(t, postgres.Median, decryptedDevice.Sensors[2].Action)
+	assert.InDelta(t, 11.79, decryptedDevice.Sensors[2].Value.Float64, 0.0001)
+
+	assert.Equal(t, postgres.Percentile, decryptedDevice.Sensors[3].Action)
+	assert.InDelta(t, 79.74, decryptedDevice.Sensors[3].Value.Float64, 0.0001)
+	assert.Equal(t, 90.0, decryptedDevice.Sensors[3].Percentile.Float64)
 }
 
-func TestProcessWithDatastoreError(t *testing.T) {
+func TestProcessWithSampleWindows(t *testing.T) {
 	logger := kitlog.NewNopLogger()
 	ds := mocks.Datastore{}
 
 	ds.On(
 		"WriteData",
-		context.Background(),
+		mock.Anything,
 		mock.Anything,
 	).Return(
 		&datastore.WriteResponse{},
-		errors.New("error"),
+		nil,
 	)
 
+	// the hybrid window holds too few samples the first time, so is suppressed
 	mv := mocks.MovingAverager{}
+	mv.On("MovingAverage", mock.Anything, 12.58, mock.Anything, mock.Anything, "foo", 12, uint32(3600), uint32(3), time.Duration(0)).Return(12.0, 2, nil).Once()
+	mv.On("MovingAverage", mock.Anything, 12.58, mock.Anything, mock.Anything, "foo", 12, uint32(3600), uint32(3), time.Duration(0)).Return(12.5, 3, nil).Once()
 
-	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+	wd := mocks.Windower{}
+	wd.On("Window", 79.35, mock.Anything, mock.Anything, "foo", 29, uint32(0), uint32(4), time.Duration(0)).Return([]float64{79.35}, nil)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
 
-	processor := pipeline.NewProcessor(&ds, &mv, true, logger)
 	device := &postgres.Device{
 		DeviceToken: "foo",
 		Streams: []*postgres.Stream{
 			{
 				CommunityID: "smartcitizen",
 				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.MovingAverage,
+						Interval: 3600,
+						Samples:  3,
+					},
+					&postgres.Operation{
+						SensorID: 29,
+						Action:   postgres.Max,
+						Samples:  4,
+					},
+				},
 			},
 		},
 	}
 
-	err := processor.Process(device, payload)
-	assert.NotNil(t, err)
-	assert.Equal(t, "error", err.Error())
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58},{"id":29, "value":79.35}]}]}`)
 
-	ds.AssertExpectations(t)
+	for i := 0; i < 2; i++ {
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	mv.AssertExpectations(t)
+	assert.Len(t, ds.Calls, 2)
+
+	// sample windows without an interval are released straight away
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+
+	assert.Equal(t, postgres.Max, decryptedDevice.Sensors[0].Action)
+	assert.Equal(t, int64(4), decryptedDevice.Sensors[0].Samples.Int64)
+	assert.Nil(t, decryptedDevice.Sensors[0].Interval)
+
+	decryptedDevice, err = decryptData(t, ds.Calls[1], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 2)
+
+	assert.Equal(t, postgres.MovingAverage, decryptedDevice.Sensors[0].Action)
+	assert.Equal(t, 12.5, decryptedDevice.Sensors[0].Value.Float64)
+	assert.Equal(t, int64(3600), decryptedDevice.Sensors[0].Interval.Int64)
+	assert.Equal(t, int64(3), decryptedDevice.Sensors[0].Samples.Int64)
+}
+
+func TestProcessReplay(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	// the window discards the value the first time, as if it was too late
+	wd := mocks.Windower{}
+	wd.On("Window", 79.35, mock.Anything, mock.Anything, "foo", 29, uint32(0), uint32(4), time.Duration(0)).Return([]float64{}, nil).Once()
+	wd.On("Window", 79.35, mock.Anything, mock.Anything, "foo", 29, uint32(0), uint32(4), time.Duration(0)).Return([]float64{79.35}, nil).Once()
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mocks.MovingAverager{},
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 29,
+						Action:   postgres.Max,
+						Samples:  4,
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":29, "value":79.35}]}]}`)
+
+	written, err := processor.Replay(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, written)
+	assert.Len(t, ds.Calls, 0)
+
+	written, err = processor.Replay(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, written)
+	assert.Len(t, ds.Calls, 1)
+
+	wd.AssertExpectations(t)
+}
+
+func TestProcessWithMultipleReadings(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	// readings must reach the averager in the order they were recorded
+	mv := mocks.MovingAverager{}
+	mv.On("MovingAverage", mock.Anything, 12.3, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(12.3, 1, nil).Once()
+	mv.On("MovingAverage", mock.Anything, 12.4, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(12.35, 2, nil).Once()
+	mv.On("MovingAverage", mock.Anything, 12.5, mock.Anything, mock.Anything, "foo", 12, uint32(900), uint32(0), time.Duration(0)).Return(12.4, 3, nil).Once()
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.MovingAverage,
+						Interval: 900,
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-01T10:02:00Z","sensors":[{"id":12,"value":12.5}]},{"recorded_at":"2018-12-01T10:00:00Z","sensors":[{"id":12,"value":12.3}]},{"recorded_at":"2018-12-01T10:01:00Z","sensors":[{"id":12,"value":12.4}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	mv.AssertExpectations(t)
+	assert.Len(t, ds.Calls, 3)
+
+	expected := []struct {
+		recordedAt time.Time
+		value      float64
+	}{
+		{recordedAt: time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC), value: 12.3},
+		{recordedAt: time.Date(2018, 12, 1, 10, 1, 0, 0, time.UTC), value: 12.35},
+		{recordedAt: time.Date(2018, 12, 1, 10, 2, 0, 0, time.UTC), value: 12.4},
+	}
+
+	for i, e := range expected {
+		decryptedDevice, err := decryptData(t, ds.Calls[i], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Equal(t, e.recordedAt, decryptedDevice.RecordedAt)
+		assert.Len(t, decryptedDevice.Sensors, 1)
+		assert.Equal(t, e.value, decryptedDevice.Sensors[0].Value.Float64)
+	}
+}
+
+func TestProcessWithConcurrentStreams(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	var running, maxRunning int32
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	).Run(func(args mock.Arguments) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+
+		// vary how long writes take so that streams finish out of order
+		time.Sleep(time.Duration(rand.Intn(10)+5) * time.Millisecond)
+
+		atomic.AddInt32(&running, -1)
+	})
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:  datastore.Datastore(&ds),
+		WorkerPool: pipeline.NewWorkerPool(2),
+		Verbose:    true,
+	}, logger)
+
+	publicKey := `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+	communities := []string{"alpha", "beta", "gamma", "delta"}
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+	}
+
+	for _, community := range communities {
+		device.Streams = append(device.Streams, &postgres.Stream{
+			CommunityID: community,
+			PublicKey:   publicKey,
+		})
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-01T10:02:00Z","sensors":[{"id":12,"value":12.5}]},{"recorded_at":"2018-12-01T10:00:00Z","sensors":[{"id":12,"value":12.3}]},{"recorded_at":"2018-12-01T10:01:00Z","sensors":[{"id":12,"value":12.4}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 12)
+	assert.True(t, atomic.LoadInt32(&maxRunning) > 1)
+	assert.True(t, atomic.LoadInt32(&maxRunning) <= 2)
+
+	expected := []time.Time{
+		time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2018, 12, 1, 10, 1, 0, 0, time.UTC),
+		time.Date(2018, 12, 1, 10, 2, 0, 0, time.UTC),
+	}
+
+	// each stream must have received its readings in the order recorded
+	for _, community := range communities {
+		recordedAt := []time.Time{}
+
+		for _, call := range ds.Calls {
+			req := call.Arguments[1].(*datastore.WriteRequest)
+			if req.CommunityId != community {
+				continue
+			}
+
+			decryptedDevice, err := decryptData(t, call, "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+			assert.Nil(t, err)
+			recordedAt = append(recordedAt, decryptedDevice.RecordedAt)
+		}
+
+		assert.Equal(t, expected, recordedAt, community)
+	}
+}
+
+func TestProcessWithBatchReadings(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Verbose:   true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:   "smartcitizen",
+				PublicKey:     `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				BatchReadings: true,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Share,
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-01T10:01:00Z","sensors":[{"id":12,"value":12.4}]},{"recorded_at":"2018-12-01T10:00:00Z","sensors":[{"id":12,"value":12.3}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedBatch, err := decryptBatch(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedBatch.Readings, 2)
+
+	assert.Equal(t, time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC), decryptedBatch.Readings[0].RecordedAt)
+	assert.Equal(t, 12.3, decryptedBatch.Readings[0].Sensors[0].Value.Float64)
+	assert.Equal(t, time.Date(2018, 12, 1, 10, 1, 0, 0, time.UTC), decryptedBatch.Readings[1].RecordedAt)
+	assert.Equal(t, 12.4, decryptedBatch.Readings[1].Sensors[0].Value.Float64)
+}
+
+func TestProcessWithLargeBatch(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:   "smartcitizen",
+				PublicKey:     `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				BatchReadings: true,
+			},
+		},
+	}
+
+	// a device delivering a day's readings after being offline produces a
+	// batch far too large to encrypt as a single event
+	readings := []string{}
+	start := time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 48; i++ {
+		readings = append(readings, fmt.Sprintf(`{"recorded_at":"%s","sensors":[{"id":12,"value":%v},{"id":14,"value":%v}]}`, start.Add(time.Duration(i)*30*time.Minute).Format(time.RFC3339), i, i))
+	}
+
+	payload := []byte(`{"data":[` + strings.Join(readings, ",") + `]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.True(t, len(ds.Calls) > 1)
+
+	// every reading is written once, in order
+	recordedAt := []time.Time{}
+
+	for _, call := range ds.Calls {
+		req := call.Arguments[1].(*datastore.WriteRequest)
+		assert.True(t, len(req.Data) < 4095)
+
+		decryptedBatch, err := decryptBatch(t, call, "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+
+		for _, reading := range decryptedBatch.Readings {
+			recordedAt = append(recordedAt, reading.RecordedAt)
+		}
+	}
+
+	assert.Len(t, recordedAt, 48)
+
+	for i, r := range recordedAt {
+		assert.Equal(t, start.Add(time.Duration(i)*30*time.Minute), r)
+	}
+}
+
+func TestProcessWithLargeTransformedBatch(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:     "smartcitizen",
+				PublicKey:       `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				BatchReadings:   true,
+				TransformScript: `local batch = JSON.decode(DATA) print(JSON.encode({ count = #batch.readings }))`,
+			},
+		},
+	}
+
+	// the transformed output is small, but the batch is too large to be given
+	// to the script in one go
+	readings := []string{}
+	start := time.Date(2018, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 500; i++ {
+		readings = append(readings, fmt.Sprintf(`{"recorded_at":"%s","sensors":[{"id":12,"value":%v},{"id":14,"value":%v}]}`, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339), i, i))
+	}
+
+	payload := []byte(`{"data":[` + strings.Join(readings, ",") + `]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.True(t, len(ds.Calls) > 1)
+
+	// every reading is transformed once
+	count := 0
+
+	for _, call := range ds.Calls {
+		var transformed struct {
+			Count int `json:"count"`
+		}
+
+		err := decryptInto(t, call, "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=", &transformed)
+		assert.Nil(t, err)
+
+		count += transformed.Count
+	}
+
+	assert.Equal(t, 500, count)
+}
+
+func TestProcessWithMeasurement(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Verbose:   true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						Measurement: "air temperature",
+						Action:      postgres.Share,
+					},
+				},
+			},
+		},
+	}
+
+	// temperature is reported by a different sensor on an SCK 1.5 and SCK 2.1
+	payloads := [][]byte{
+		[]byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12,"value":12.58},{"id":13,"value":45.2}]}]}`),
+		[]byte(`{"data":[{"recorded_at":"2018-12-11T14:47:44Z","sensors":[{"id":55,"value":13.12},{"id":56,"value":44.8}]}]}`),
+	}
+
+	for _, payload := range payloads {
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	assert.Len(t, ds.Calls, 2)
+
+	expected := []struct {
+		sensorID int
+		value    float64
+	}{
+		{sensorID: 12, value: 12.58},
+		{sensorID: 55, value: 13.12},
+	}
+
+	for i, e := range expected {
+		decryptedDevice, err := decryptData(t, ds.Calls[i], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Len(t, decryptedDevice.Sensors, 1)
+		assert.Equal(t, e.sensorID, decryptedDevice.Sensors[0].ID)
+		assert.Equal(t, e.value, decryptedDevice.Sensors[0].Value.Float64)
+	}
+}
+
+func TestProcessWithMeasurementUnit(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						Measurement: "no2",
+						Unit:        "ppb",
+						Action:      postgres.Share,
+					},
+				},
+			},
+		},
+	}
+
+	// the raw voltage is preferred by id, but only the mass concentration can
+	// be shared in ppb
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":61,"value":230},{"id":32,"value":46.0055}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+	assert.Equal(t, 32, decryptedDevice.Sensors[0].ID)
+	assert.Equal(t, "ppb", decryptedDevice.Sensors[0].Unit.String)
+	assert.InDelta(t, 24.45, decryptedDevice.Sensors[0].Value.Float64, 0.0001)
+}
+
+func TestProcessWithTransformScript(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Verbose:   true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				TransformScript: `
+local device = JSON.decode(DATA)
+print(JSON.encode({ temperature = device.sensors[1].value }))`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Share,
+					},
+				},
+			},
+		},
+	}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":12.58}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	var transformed map[string]float64
+	err = decryptInto(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=", &transformed)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]float64{"temperature": 12.58}, transformed)
+}
+
+func TestProcessWithNoise(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	cl := clock.NewMock(time.Now())
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		NoiseGenerator: pipeline.NewNoiseGenerator(rand.NewSource(1)),
+		PrivacyBudget:  pipeline.NewPrivacyBudget(false, cl, logger),
+		Verbose:        true,
+	}, logger)
+
+	// a generator with the same seed so we can compute the expected noise
+	expectedNoise := pipeline.NewNoiseGenerator(rand.NewSource(1))
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:            "stream-1",
+				CommunityID:         "smartcitizen",
+				PublicKey:           `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				PrivacyBudget:       1.0,
+				PrivacyBudgetPeriod: 3600,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 13,
+						Action:   postgres.Share,
+					},
+					&postgres.Operation{
+						SensorID:    12,
+						Action:      postgres.Noise,
+						Epsilon:     0.5,
+						Sensitivity: 2,
+						Mechanism:   postgres.Laplace,
+					},
+				},
+			},
+		},
+	}
+
+	// first two readings fit within the budget
+	for i := 0; i < 2; i++ {
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+
+		decryptedDevice, err := decryptData(t, ds.Calls[i], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Len(t, decryptedDevice.Sensors, 2)
+
+		noised := decryptedDevice.Sensors[1]
+		assert.Equal(t, postgres.Noise, noised.Action)
+		assert.Equal(t, 0.5, noised.Epsilon.Float64)
+		assert.Equal(t, 2.0, noised.Sensitivity.Float64)
+		assert.InDelta(t, 12.58+expectedNoise.Laplace(4), noised.Value.Float64, 1e-9)
+
+		cl.Add(time.Minute)
+	}
+
+	// the budget is now exhausted so the noised sensor is withheld
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[2], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+	assert.Equal(t, 13, decryptedDevice.Sensors[0].ID)
+
+	// once the period has passed the sensor is released again
+	cl.Add(time.Hour)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	decryptedDevice, err = decryptData(t, ds.Calls[3], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 2)
+
+	// null values are skipped without spending the budget
+	nullPayload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:47:44Z","sensors":[{"id":13, "value":51.00},{"id":12, "value":null}]}]}`)
+
+	for i := 4; i < 6; i++ {
+		err = processor.Process(context.Background(), device, nullPayload)
+		assert.Nil(t, err)
+
+		decryptedDevice, err = decryptData(t, ds.Calls[i], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Len(t, decryptedDevice.Sensors, 1)
+		assert.Equal(t, 13, decryptedDevice.Sensors[0].ID)
+	}
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	decryptedDevice, err = decryptData(t, ds.Calls[6], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 2)
+}
+
+func TestProcessWithThreshold(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Thresholder:    pipeline.NewThresholder(false, logger),
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID:   13,
+						Action:     postgres.Threshold,
+						Lower:      20,
+						Upper:      40,
+						Hysteresis: 2,
+					},
+				},
+			},
+		},
+	}
+
+	values := []float64{30, 35, 45, 39, 37, 25}
+	expectedStates := []string{pipeline.StateNormal, pipeline.StateHigh, pipeline.StateNormal}
+
+	for _, value := range values {
+		payload := []byte(fmt.Sprintf(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":%v}]}]}`, value))
+
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	// we only write when the state changes
+	assert.Len(t, ds.Calls, len(expectedStates))
+
+	for i, expectedState := range expectedStates {
+		decryptedDevice, err := decryptData(t, ds.Calls[i], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Len(t, decryptedDevice.Sensors, 1)
+
+		sensor := decryptedDevice.Sensors[0]
+		assert.Equal(t, postgres.Threshold, sensor.Action)
+		assert.Equal(t, expectedState, sensor.State)
+		assert.Equal(t, 40.0, sensor.Upper.Float64)
+		assert.Equal(t, 20.0, sensor.Lower.Float64)
+		assert.Nil(t, sensor.Value)
+	}
+}
+
+func TestProcessWithThresholdOnTwoStreams(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:   datastore.Datastore(&ds),
+		Thresholder: pipeline.NewThresholder(false, logger),
+	}, logger)
+
+	operations := postgres.Operations{
+		&postgres.Operation{
+			SensorID:   13,
+			Action:     postgres.Threshold,
+			Lower:      20,
+			Upper:      40,
+			Hysteresis: 2,
+		},
+	}
+
+	// both streams apply the same limits to the same sensor
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "stream1",
+				CommunityID: "community1",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  operations,
+			},
+			{
+				StreamID:    "stream2",
+				CommunityID: "community2",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  operations,
+			},
+		},
+	}
+
+	for _, value := range []float64{30, 45} {
+		payload := []byte(fmt.Sprintf(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":%v}]}]}`, value))
+
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+	}
+
+	// each stream reports each change of state
+	assert.Len(t, ds.Calls, 4)
+
+	states := map[string][]string{}
+
+	for _, call := range ds.Calls {
+		req := call.Arguments[1].(*datastore.WriteRequest)
+
+		decryptedDevice, err := decryptData(t, call, "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Len(t, decryptedDevice.Sensors, 1)
+
+		states[req.CommunityId] = append(states[req.CommunityId], decryptedDevice.Sensors[0].State)
+	}
+
+	assert.Equal(t, []string{pipeline.StateNormal, pipeline.StateHigh}, states["community1"])
+	assert.Equal(t, []string{pipeline.StateNormal, pipeline.StateHigh}, states["community2"])
+}
+
+func TestProcessWithWindowsOnTwoStreams(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 15, 0, 0, 0, time.UTC))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: pipeline.NewMovingAverager(false, cl, logger),
+		Windower:       pipeline.NewWindower(false, cl, logger),
+	}, logger)
+
+	// windows are suppressed until they hold two samples
+	operations := postgres.Operations{
+		&postgres.Operation{
+			SensorID: 12,
+			Action:   postgres.MovingAverage,
+			Interval: 3600,
+			Samples:  2,
+		},
+		&postgres.Operation{
+			SensorID: 29,
+			Action:   postgres.Max,
+			Interval: 3600,
+			Samples:  2,
+		},
+	}
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "stream1",
+				CommunityID: "community1",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  operations,
+			},
+			{
+				StreamID:    "stream2",
+				CommunityID: "community2",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  operations,
+			},
+		},
+	}
+
+	// the first reading is counted once by each stream, so neither releases it
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":10.0},{"id":29, "value":70.0}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 0)
+
+	payload = []byte(`{"data":[{"recorded_at":"2018-12-11T14:47:44Z","sensors":[{"id":12, "value":20.0},{"id":29, "value":80.0}]}]}`)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	for _, call := range ds.Calls {
+		decryptedDevice, err := decryptData(t, call, "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+		assert.Nil(t, err)
+		assert.Len(t, decryptedDevice.Sensors, 2)
+
+		assert.Equal(t, 15.0, decryptedDevice.Sensors[0].Value.Float64)
+		assert.Equal(t, 80.0, decryptedDevice.Sensors[1].Value.Float64)
+	}
+}
+
+func TestProcessWithEWMA(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+	ew := mocks.ExponentialAverager{}
+
+	// the average is weighted by the original recorded time, even though the
+	// stream coarsens the time it writes
+	ew.On(
+		"ExponentialAverage",
+		23.5,
+		time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC),
+		mock.Anything,
+		"foo",
+		12,
+		uint32(3600),
+	).Return(21.25, nil)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:           datastore.Datastore(&ds),
+		MovingAverager:      &mv,
+		ExponentialAverager: &ew,
+		Windower:            &wd,
+		Verbose:             true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:    "smartcitizen",
+				PublicKey:      `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				TimeResolution: 3600,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.EWMA,
+						HalfLife: 3600,
+					},
+				},
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":23.5}]}]}`))
+	assert.Nil(t, err)
+
+	ew.AssertExpectations(t)
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+
+	sensor := decryptedDevice.Sensors[0]
+	assert.Equal(t, postgres.EWMA, sensor.Action)
+	assert.Equal(t, int64(3600), sensor.HalfLife.Int64)
+	assert.Equal(t, 21.25, sensor.Value.Float64)
+	assert.Nil(t, sensor.Interval)
+}
+
+func TestProcessWithEmissionInterval(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Emitter:        pipeline.NewEmitter(false, cl, logger),
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:         "stream-1",
+				CommunityID:      "smartcitizen",
+				PublicKey:        `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				EmissionInterval: 900,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 13,
+						Action:   postgres.Share,
+					},
+				},
+			},
+		},
+	}
+
+	// a reading every minute for 16 minutes
+	for i := 0; i <= 15; i++ {
+		payload := []byte(fmt.Sprintf(`{"data":[{"recorded_at":"%s","sensors":[{"id":13, "value":%v}]}]}`, cl.Now().Format(time.RFC3339), i))
+
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+
+		cl.Add(time.Minute)
+	}
+
+	// we wrote the first reading, then a summary of the following fifteen
+	assert.Len(t, ds.Calls, 2)
+
+	// a single reading has the same shape as a summary of several
+	first, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, first.Sensors[0].Value.Float64)
+	assert.Equal(t, 1, first.Sensors[0].Readings)
+
+	summary, err := decryptData(t, ds.Calls[1], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 12, 11, 14, 15, 0, 0, time.UTC), summary.RecordedAt)
+	assert.Len(t, summary.Sensors, 1)
+	assert.Equal(t, 8.0, summary.Sensors[0].Value.Float64)
+	assert.Equal(t, 15, summary.Sensors[0].Readings)
+
+	// the device then goes offline after one more reading, which is buffered
+	payload := []byte(fmt.Sprintf(`{"data":[{"recorded_at":"%s","sensors":[{"id":13, "value":16}]}]}`, cl.Now().Format(time.RFC3339)))
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	// nothing is flushed until the interval has elapsed
+	err = processor.Flush(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	cl.Add(15 * time.Minute)
+
+	err = processor.Flush(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 3)
+
+	flushed, err := decryptData(t, ds.Calls[2], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 12, 11, 14, 16, 0, 0, time.UTC), flushed.RecordedAt)
+	assert.Equal(t, 16.0, flushed.Sensors[0].Value.Float64)
+	assert.Equal(t, 1, flushed.Sensors[0].Readings)
+
+	// and it is only flushed once
+	cl.Add(15 * time.Minute)
+
+	err = processor.Flush(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 3)
+}
+
+func TestProcessFlushWithDeletedStream(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC))
+
+	newStream := func(streamID, communityID string) *postgres.Stream {
+		return &postgres.Stream{
+			StreamID:         streamID,
+			CommunityID:      communityID,
+			PublicKey:        `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			EmissionInterval: 900,
+			Operations: postgres.Operations{
+				&postgres.Operation{
+					SensorID: 13,
+					Action:   postgres.Share,
+				},
+			},
+		}
+	}
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			newStream("stream-1", "community1"),
+			newStream("stream-2", "community2"),
+		},
+	}
+
+	// the first stream has since been deleted
+	devices := mocks.DeviceStore{}
+	devices.On("GetDevice", "foo").Return(&postgres.Device{
+		DeviceToken: "foo",
+		Streams:     []*postgres.Stream{device.Streams[1]},
+	}, nil)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Emitter:   pipeline.NewEmitter(false, cl, logger),
+		Devices:   &devices,
+	}, logger)
+
+	// the first reading is written straight away and the second is buffered
+	for i := 0; i < 2; i++ {
+		payload := []byte(fmt.Sprintf(`{"data":[{"recorded_at":"%s","sensors":[{"id":13, "value":%v}]}]}`, cl.Now().Format(time.RFC3339), i))
+
+		err := processor.Process(context.Background(), device, payload)
+		assert.Nil(t, err)
+
+		cl.Add(time.Minute)
+	}
+
+	assert.Len(t, ds.Calls, 2)
+
+	// readings buffered for the deleted stream are dropped, while when stopping
+	// we flush the other stream before its interval has elapsed
+	err := processor.FlushAll(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 3)
+
+	req := ds.Calls[2].Arguments[1].(*datastore.WriteRequest)
+	assert.Equal(t, "community2", req.CommunityId)
+
+	flushed, err := decryptData(t, ds.Calls[2], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, flushed.Sensors[0].Value.Float64)
+
+	// nothing remains to be flushed
+	cl.Add(time.Hour)
+
+	err = processor.Flush(context.Background())
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 3)
+
+	devices.AssertNumberOfCalls(t, "GetDevice", 1)
+}
+
+func TestProcessWithConvert(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":20.00},{"id":32, "value":46.0055}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Convert,
+						Unit:     "°F",
+					},
+					&postgres.Operation{
+						SensorID: 32,
+						Action:   postgres.Convert,
+						Unit:     "ppb",
+					},
+				},
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 2)
+
+	temperature := decryptedDevice.Sensors[0]
+	assert.Equal(t, postgres.Convert, temperature.Action)
+	assert.Equal(t, "°F", temperature.Unit.String)
+	assert.InDelta(t, 68, temperature.Value.Float64, 1e-9)
+
+	no2 := decryptedDevice.Sensors[1]
+	assert.Equal(t, "ppb", no2.Unit.String)
+	assert.InDelta(t, 24.45, no2.Value.Float64, 1e-9)
+}
+
+func TestProcessWithUnconvertibleSensor(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":12, "value":20.00},{"id":61, "value":230}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Convert,
+						Unit:     "°F",
+					},
+					&postgres.Operation{
+						SensorID: 61,
+						Action:   postgres.Convert,
+						Unit:     "ppb",
+					},
+					&postgres.Operation{
+						Measurement: "no2",
+						Action:      postgres.Convert,
+						Unit:        "ppb",
+					},
+				},
+			},
+		},
+	}
+
+	// the voltage can't be converted to ppb, so is dropped without failing the
+	// rest of the stream
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+	assert.Equal(t, 12, decryptedDevice.Sensors[0].ID)
+	assert.Equal(t, "°F", decryptedDevice.Sensors[0].Unit.String)
+}
+
+func TestProcessWithAQI(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       pipeline.NewWindower(false, cl, logger),
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						Action: postgres.AQI,
+						Index:  postgres.USEPA,
+					},
+					&postgres.Operation{
+						Action: postgres.AQI,
+						Index:  postgres.EUCAQI,
+					},
+				},
+			},
+		},
+	}
+
+	// two readings averaging 12 µg/m³ PM2.5 and 60 µg/m³ PM10
+	for _, payload := range []string{
+		`{"data":[{"recorded_at":"2018-12-11T14:00:00Z","sensors":[{"id":87, "value":10.00},{"id":88, "value":50.00}]}]}`,
+		`{"data":[{"recorded_at":"2018-12-11T14:30:00Z","sensors":[{"id":87, "value":14.00},{"id":88, "value":70.00}]}]}`,
+	} {
+		err := processor.Process(context.Background(), device, []byte(payload))
+		assert.Nil(t, err)
+
+		cl.Add(30 * time.Minute)
+	}
+
+	assert.Len(t, ds.Calls, 2)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[1], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 2)
+
+	aqi := decryptedDevice.Sensors[0]
+	assert.Equal(t, pipeline.USEPAAQISensorID, aqi.ID)
+	assert.Equal(t, postgres.AQI, aqi.Action)
+	assert.Equal(t, int64(86400), aqi.Interval.Int64)
+	assert.Equal(t, 56.0, aqi.Value.Float64)
+	assert.Equal(t, "Moderate", aqi.Category)
+
+	caqi := decryptedDevice.Sensors[1]
+	assert.Equal(t, pipeline.EUCAQISensorID, caqi.ID)
+	assert.Equal(t, int64(3600), caqi.Interval.Int64)
+	assert.InDelta(t, 56.25, caqi.Value.Float64, 1e-9)
+	assert.Equal(t, "Medium", caqi.Category)
+}
+
+func TestProcessWithAQIOnOlderKit(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Windower:  pipeline.NewWindower(false, cl, logger),
+		Verbose:   true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						Action: postgres.AQI,
+						Index:  postgres.USEPA,
+					},
+				},
+			},
+		},
+	}
+
+	// the PPD42NS reports PM2.5 and PM10 as concentrations, and PM2.5 as a
+	// particle count which must not be mistaken for a concentration
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:00:00Z","sensors":[{"id":33, "value":500.00},{"id":34, "value":12.00},{"id":36, "value":60.00}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+	assert.Len(t, decryptedDevice.Sensors, 1)
+
+	aqi := decryptedDevice.Sensors[0]
+	assert.Equal(t, pipeline.USEPAAQISensorID, aqi.ID)
+	assert.Equal(t, 56.0, aqi.Value.Float64)
+	assert.Equal(t, "Moderate", aqi.Category)
+}
+
+func TestProcessWithLocationPolicy(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
+
+	publicKey := `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Longitude:   2.1734,
+		Latitude:    41.3851,
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:              "geohash",
+				PublicKey:                publicKey,
+				LocationPolicy:           postgres.Geohash,
+				LocationGeohashPrecision: 5,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 13,
+						Action:   postgres.Share,
+					},
+				},
+			},
+			{
+				CommunityID:      "grid",
+				PublicKey:        publicKey,
+				LocationPolicy:   postgres.Grid,
+				LocationGridSize: 1000,
+			},
+			{
+				CommunityID:    "omit",
+				PublicKey:      publicKey,
+				LocationPolicy: postgres.Omit,
+			},
+			{
+				CommunityID:    "exact",
+				PublicKey:      publicKey,
+				LocationPolicy: postgres.Exact,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 4)
+
+	secKey := "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA="
+
+	geohashed, err := decryptData(t, findCall(t, ds.Calls, "geohash"), secKey)
+	assert.Nil(t, err)
+	assert.Equal(t, "sp3e3", geohashed.Geohash)
+	assert.NotNil(t, geohashed.Longitude)
+	assert.NotNil(t, geohashed.Latitude)
+	lon, lat := pipeline.DecodeGeohash("sp3e3")
+	assert.Equal(t, lon, geohashed.Longitude.Float64)
+	assert.Equal(t, lat, geohashed.Latitude.Float64)
+	assert.Len(t, geohashed.Sensors, 1)
+
+	gridded, err := decryptData(t, findCall(t, ds.Calls, "grid"), secKey)
+	assert.Nil(t, err)
+	lon, lat = pipeline.SnapToGrid(2.1734, 41.3851, 1000)
+	assert.Equal(t, lon, gridded.Longitude.Float64)
+	assert.Equal(t, lat, gridded.Latitude.Float64)
+	assert.Len(t, gridded.Sensors, 3)
+
+	omitted, err := decryptData(t, findCall(t, ds.Calls, "omit"), secKey)
+	assert.Nil(t, err)
+	assert.Nil(t, omitted.Longitude)
+	assert.Nil(t, omitted.Latitude)
+	assert.Equal(t, "", omitted.Geohash)
+
+	exact, err := decryptData(t, findCall(t, ds.Calls, "exact"), secKey)
+	assert.Nil(t, err)
+	assert.Equal(t, 2.1734, exact.Longitude.Float64)
+	assert.Equal(t, 41.3851, exact.Latitude.Float64)
+	assert.Len(t, exact.Sensors, 3)
+}
+
+func TestProcessWithTimeResolution(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
+
+	publicKey := `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID:    "quarter-hour",
+				PublicKey:      publicKey,
+				TimeResolution: 900,
+			},
+			{
+				CommunityID:    "hourly",
+				PublicKey:      publicKey,
+				TimeResolution: 3600,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 13,
+						Action:   postgres.Share,
+					},
+				},
+			},
+			{
+				CommunityID: "exact",
+				PublicKey:   publicKey,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	assert.Len(t, ds.Calls, 3)
+
+	secKey := "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA="
+
+	quarterHour, err := decryptData(t, findCall(t, ds.Calls, "quarter-hour"), secKey)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 12, 11, 14, 45, 0, 0, time.UTC), quarterHour.RecordedAt)
+	assert.Equal(t, int64(900), quarterHour.TimeResolution.Int64)
+
+	hourly, err := decryptData(t, findCall(t, ds.Calls, "hourly"), secKey)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 12, 11, 14, 0, 0, 0, time.UTC), hourly.RecordedAt)
+	assert.Equal(t, int64(3600), hourly.TimeResolution.Int64)
+
+	exact, err := decryptData(t, findCall(t, ds.Calls, "exact"), secKey)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2018, 12, 11, 14, 46, 44, 0, time.UTC), exact.RecordedAt)
+	assert.Nil(t, exact.TimeResolution)
+}
+
+func TestProcessWithNoOperations(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	// set up a mock response
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations:  postgres.Operations{},
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	ds.AssertExpectations(t)
+	mv.AssertExpectations(t)
+
+	assert.Len(t, ds.Calls, 1)
+
+	decryptedDevice, err := decryptData(t, ds.Calls[0], "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err)
+
+	assert.Len(t, decryptedDevice.Sensors, 9)
+}
+
+func TestProcessWithDatastoreError(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		errors.New("error"),
+	)
+
+	mv := mocks.MovingAverager{}
+	wd := mocks.Windower{}
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58},{"id":29, "value":79.35},{"id":53, "value":51.00},{"id":58, "value":101.56},{"id":89, "value":4.00},{"id":87, "value":7.00},{"id":88, "value":7.00}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:      datastore.Datastore(&ds),
+		MovingAverager: &mv,
+		Windower:       &wd,
+		Verbose:        true,
+	}, logger)
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.NotNil(t, err)
+
+	processErr, ok := err.(*pipeline.ProcessError)
+	assert.True(t, ok)
+	assert.Len(t, processErr.Streams, 1)
+	assert.Equal(t, "smartcitizen", processErr.Streams[0].CommunityID)
+	assert.Equal(t, pipeline.WriteStage, processErr.Streams[0].Stage)
+	assert.Equal(t, "error", errors.Cause(processErr.Streams[0]).Error())
+
+	ds.AssertExpectations(t)
+}
+
+func TestProcessWithFailingStreams(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.MatchedBy(func(req *datastore.WriteRequest) bool {
+			return req.CommunityId == "unavailable"
+		}),
+	).Return(
+		&datastore.WriteResponse{},
+		errors.New("unavailable"),
+	)
+
+	ds.On(
+		"WriteData",
+		mock.Anything,
+		mock.Anything,
+	).Return(
+		&datastore.WriteResponse{},
+		nil,
+	)
+
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:46:44Z","sensors":[{"id":13, "value":51.00},{"id":14, "value":426.42},{"id":12, "value":12.58}]}]}`)
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Verbose:   true,
+	}, logger)
+
+	publicKey := `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "abc123",
+				CommunityID: "unavailable",
+				PublicKey:   publicKey,
+			},
+			{
+				StreamID:    "def456",
+				CommunityID: "unknown-measurement",
+				PublicKey:   publicKey,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						Measurement: "radiation",
+						Action:      postgres.Share,
+					},
+				},
+			},
+			{
+				StreamID:        "ghi789",
+				CommunityID:     "failing-script",
+				PublicKey:       publicKey,
+				TransformScript: `print("hello")`,
+			},
+			{
+				StreamID:    "jkl012",
+				CommunityID: "working",
+				PublicKey:   publicKey,
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.NotNil(t, err)
+
+	// the working stream is written despite the failures of the others
+	assert.Len(t, ds.Calls, 2)
+	decryptedDevice, err2 := decryptData(t, findCall(t, ds.Calls, "working"), "D19GsDTGjLBX23J281SNpXWUdu+oL6hdAJ0Zh6IrRHA=")
+	assert.Nil(t, err2)
+	assert.Len(t, decryptedDevice.Sensors, 3)
+
+	processErr, ok := err.(*pipeline.ProcessError)
+	assert.True(t, ok)
+
+	failed := map[string]pipeline.Stage{}
+	for _, streamErr := range processErr.Streams {
+		failed[streamErr.StreamID] = streamErr.Stage
+	}
+
+	assert.Equal(t, map[string]pipeline.Stage{
+		"abc123": pipeline.WriteStage,
+		"def456": pipeline.TransformStage,
+		"ghi789": pipeline.TransformStage,
+	}, failed)
+
+	assert.Contains(t, err.Error(), "3 stream(s) failed")
+}
+
+func TestProcessWithInvalidPayload(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+	ds := mocks.Datastore{}
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore: datastore.Datastore(&ds),
+		Verbose:   true,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				StreamID:    "abc123",
+				CommunityID: "first",
+			},
+			{
+				StreamID:    "def456",
+				CommunityID: "second",
+			},
+		},
+	}
+
+	err := processor.Process(context.Background(), device, []byte(`not json`))
+	assert.NotNil(t, err)
+
+	processErr, ok := err.(*pipeline.ProcessError)
+	assert.True(t, ok)
+	assert.Len(t, processErr.Streams, 2)
+
+	for _, streamErr := range processErr.Streams {
+		assert.Equal(t, pipeline.ParseStage, streamErr.Stage)
+	}
+
+	assert.Len(t, ds.Calls, 0)
+}
+
+func TestBinLabel(t *testing.T) {
+	bins := []float64{12, 35.5}
+	labels := []string{"good", "moderate", "unhealthy"}
+
+	testcases := []struct {
+		value          float64
+		expectedValues []int
+		expectedLabel  string
+	}{
+		{value: 4, expectedValues: []int{1, 0, 0}, expectedLabel: "good"},
+		{value: 12, expectedValues: []int{0, 1, 0}, expectedLabel: "moderate"},
+		{value: 35.4, expectedValues: []int{0, 1, 0}, expectedLabel: "moderate"},
+		{value: 35.5, expectedValues: []int{0, 0, 1}, expectedLabel: "unhealthy"},
+		{value: 250, expectedValues: []int{0, 0, 1}, expectedLabel: "unhealthy"},
+	}
+
+	for _, tc := range testcases {
+		assert.Equal(t, tc.expectedValues, pipeline.BinValue(tc.value, bins), "value %v", tc.value)
+		assert.Equal(t, tc.expectedLabel, pipeline.BinLabel(tc.value, bins, labels), "value %v", tc.value)
+	}
+
+	// missing labels give an empty label
+	assert.Equal(t, "", pipeline.BinLabel(250, bins, labels[:2]))
 }
@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+)
+
+// PrivacyBudget is an interface for a type that keeps track of how much privacy
+// budget (expressed as epsilon) each stream has spent over some period.
+type PrivacyBudget interface {
+	// Spend attempts to spend the given epsilon from the stream's budget. If the
+	// total spent within the trailing period plus epsilon would exceed the
+	// budget, nothing is spent and false is returned. Implementations which
+	// store spends remotely should give up once the context is done.
+	Spend(ctx context.Context, streamID string, epsilon, budget float64, period uint32) (bool, error)
+}
+
+// NewPrivacyBudget returns an instance of our PrivacyBudget interface. This is a
+// simple in-memory implementation, whose spends are forgotten on restart and
+// aren't shared with other instances, so it is only suitable for tests. The
+// server uses the persistent implementation in the postgres package.
+func NewPrivacyBudget(verbose bool, cl clock.Clock, logger kitlog.Logger) PrivacyBudget {
+	return &privacyBudget{
+		entries: make(map[string][]entry),
+		verbose: verbose,
+		logger:  logger,
+		clock:   cl,
+	}
+}
+
+// privacyBudget is our type that implements the PrivacyBudget interface using a
+// simple in memory store. The store is a map keyed by stream id, with values
+// being slices of `entry` recording when and how much epsilon was spent. When
+// asked to spend we discard any entries older than the period, and total the
+// remainder to see if the budget allows the new spend.
+type privacyBudget struct {
+	sync.Mutex
+	entries map[string][]entry
+	verbose bool
+	logger  kitlog.Logger
+	clock   clock.Clock
+}
+
+// Spend is our implementation of the PrivacyBudget interface method.
+func (p *privacyBudget) Spend(ctx context.Context, streamID string, epsilon, budget float64, period uint32) (bool, error) {
+	now := p.clock.Now()
+	periodDuration := time.Second * time.Duration(-int(period))
+	previousTime := now.Add(periodDuration)
+
+	// we hold the lock for the whole operation as checking and spending the
+	// budget must be atomic
+	p.Lock()
+	defer p.Unlock()
+
+	newEntries := []entry{}
+	spent := 0.0
+
+	for _, e := range p.entries[streamID] {
+		// if older than our period we ignore
+		if e.Timestamp < previousTime.Unix() {
+			continue
+		}
+
+		spent = spent + e.Value
+		newEntries = append(newEntries, e)
+	}
+
+	if spent+epsilon > budget {
+		p.entries[streamID] = newEntries
+
+		if p.verbose {
+			p.logger.Log("stream_id", streamID, "spent", spent, "budget", budget, "msg", "privacy budget exhausted")
+		}
+
+		return false, nil
+	}
+
+	p.entries[streamID] = append(newEntries, entry{
+		Timestamp: now.Unix(),
+		Value:     epsilon,
+	})
+
+	return true, nil
+}
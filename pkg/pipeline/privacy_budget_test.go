@@ -0,0 +1,54 @@
+package pipeline_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestPrivacyBudget(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	pb := pipeline.NewPrivacyBudget(false, cl, logger)
+	assert.NotNil(t, pb)
+
+	ctx := context.Background()
+
+	ok, err := pb.Spend(ctx, "stream-1", 0.4, 1.0, uint32(3600))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	cl.Add(20 * time.Minute)
+	ok, err = pb.Spend(ctx, "stream-1", 0.4, 1.0, uint32(3600))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// another stream has its own budget
+	ok, err = pb.Spend(ctx, "stream-2", 1.0, 1.0, uint32(3600))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// this would take us over budget so is refused, and nothing is spent
+	cl.Add(20 * time.Minute)
+	ok, err = pb.Spend(ctx, "stream-1", 0.4, 1.0, uint32(3600))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a smaller spend still fits
+	ok, err = pb.Spend(ctx, "stream-1", 0.2, 1.0, uint32(3600))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// after the first spend falls out of the period we have budget again
+	cl.Add(21 * time.Minute)
+	ok, err = pb.Spend(ctx, "stream-1", 0.4, 1.0, uint32(3600))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
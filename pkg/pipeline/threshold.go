@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"fmt"
+	"sync"
+
+	kitlog "github.com/go-kit/kit/log"
+)
+
+const (
+	// StateLow is the state of a sensor whose value is below the lower limit
+	StateLow = "LOW"
+
+	// StateNormal is the state of a sensor whose value is between the limits
+	StateNormal = "NORMAL"
+
+	// StateHigh is the state of a sensor whose value is above the upper limit
+	StateHigh = "HIGH"
+)
+
+// Thresholder is an interface for a type that can classify a value against
+// upper and lower limits for the given stream/device/sensor, remembering the
+// previous state so that it can report whether the state has changed. State is
+// kept per stream, as each stream must report a change to its own recipients.
+type Thresholder interface {
+	Threshold(value float64, streamID, deviceToken string, sensorID int, lower, upper, hysteresis float64) (string, bool, error)
+}
+
+// NewThresholder returns an instance of our Thresholder interface. This is a
+// simple in-memory implementation.
+func NewThresholder(verbose bool, logger kitlog.Logger) Thresholder {
+	return &thresholder{
+		states:  make(map[string]string),
+		verbose: verbose,
+		logger:  logger,
+	}
+}
+
+// thresholder is our type that implements the Thresholder interface using a
+// simple in memory store. The store is a map with a key based on the stream
+// id, device token, sensor id and the limits configured, with values being the
+// last state we computed for that combination.
+type thresholder struct {
+	sync.Mutex
+	states  map[string]string
+	verbose bool
+	logger  kitlog.Logger
+}
+
+// Threshold is our implementation of the Thresholder interface method. It
+// returns the new state of the sensor along with a boolean which is true if
+// the state differs from the previous state. The first value received for a
+// stream/device/sensor is always reported as a change.
+func (t *thresholder) Threshold(value float64, streamID, deviceToken string, sensorID int, lower, upper, hysteresis float64) (string, bool, error) {
+	// build our key for the stream/device/sensor/limits
+	key := fmt.Sprintf("%s:%s:%v:%v:%v:%v", streamID, deviceToken, sensorID, lower, upper, hysteresis)
+
+	t.Lock()
+	defer t.Unlock()
+
+	previous, ok := t.states[key]
+	state := NextState(previous, value, lower, upper, hysteresis)
+
+	t.states[key] = state
+
+	changed := !ok || state != previous
+
+	if changed && t.verbose {
+		t.logger.Log("stream_id", streamID, "device_token", deviceToken, "sensor_id", sensorID, "previous", previous, "state", state, "msg", "threshold state changed")
+	}
+
+	return state, changed, nil
+}
+
+// NextState returns the state of a sensor given its previous state and a new
+// value. To move into the `HIGH` or `LOW` states a value must cross the
+// relevant limit, but to leave them it must move back past the limit by at
+// least the hysteresis margin, which stops a value hovering around a limit
+// from flapping between states.
+func NextState(previous string, value, lower, upper, hysteresis float64) string {
+	switch previous {
+	case StateHigh:
+		if value >= upper-hysteresis {
+			return StateHigh
+		}
+	case StateLow:
+		if value <= lower+hysteresis {
+			return StateLow
+		}
+	}
+
+	if value > upper {
+		return StateHigh
+	}
+
+	if value < lower {
+		return StateLow
+	}
+
+	return StateNormal
+}
@@ -0,0 +1,115 @@
+package pipeline_test
+
+import (
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestThresholder(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	th := pipeline.NewThresholder(false, logger)
+	assert.NotNil(t, th)
+
+	testcases := []struct {
+		value           float64
+		expectedState   string
+		expectedChanged bool
+	}{
+		{value: 20, expectedState: pipeline.StateNormal, expectedChanged: true},
+		{value: 30, expectedState: pipeline.StateNormal, expectedChanged: false},
+		{value: 41, expectedState: pipeline.StateHigh, expectedChanged: true},
+		{value: 39, expectedState: pipeline.StateHigh, expectedChanged: false},
+		{value: 41, expectedState: pipeline.StateHigh, expectedChanged: false},
+		{value: 34, expectedState: pipeline.StateNormal, expectedChanged: true},
+		{value: 9, expectedState: pipeline.StateLow, expectedChanged: true},
+		{value: 14, expectedState: pipeline.StateLow, expectedChanged: false},
+		{value: 50, expectedState: pipeline.StateHigh, expectedChanged: true},
+	}
+
+	for _, tc := range testcases {
+		state, changed, err := th.Threshold(tc.value, "stream1", "abc123", 12, 10, 40, 5)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.expectedState, state, "value %v", tc.value)
+		assert.Equal(t, tc.expectedChanged, changed, "value %v", tc.value)
+	}
+
+	// a different sensor keeps its own state
+	state, changed, err := th.Threshold(20, "stream1", "abc123", 14, 10, 40, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, pipeline.StateNormal, state)
+	assert.True(t, changed)
+
+	// as does a different stream of the same device with the same limits
+	state, changed, err = th.Threshold(50, "stream2", "abc123", 12, 10, 40, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, pipeline.StateHigh, state)
+	assert.True(t, changed)
+}
+
+func TestNextState(t *testing.T) {
+	testcases := []struct {
+		label    string
+		previous string
+		value    float64
+		expected string
+	}{
+		{
+			label:    "initial normal",
+			value:    20,
+			expected: pipeline.StateNormal,
+		},
+		{
+			label:    "initial high",
+			value:    45,
+			expected: pipeline.StateHigh,
+		},
+		{
+			label:    "on the upper limit is not high",
+			previous: pipeline.StateNormal,
+			value:    40,
+			expected: pipeline.StateNormal,
+		},
+		{
+			label:    "high within hysteresis",
+			previous: pipeline.StateHigh,
+			value:    35,
+			expected: pipeline.StateHigh,
+		},
+		{
+			label:    "high leaving hysteresis",
+			previous: pipeline.StateHigh,
+			value:    34.9,
+			expected: pipeline.StateNormal,
+		},
+		{
+			label:    "high straight to low",
+			previous: pipeline.StateHigh,
+			value:    5,
+			expected: pipeline.StateLow,
+		},
+		{
+			label:    "low within hysteresis",
+			previous: pipeline.StateLow,
+			value:    15,
+			expected: pipeline.StateLow,
+		},
+		{
+			label:    "low leaving hysteresis",
+			previous: pipeline.StateLow,
+			value:    15.1,
+			expected: pipeline.StateNormal,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got := pipeline.NextState(tc.previous, tc.value, 10, 40, 5)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/guregu/null.v3"
+
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+const (
+	// DefaultScriptInstructions is the number of Lua instructions a
+	// transformation script may execute if no other limit is configured, which
+	// is roughly a quarter of a second of execution including the cost of
+	// enforcing the limits
+	DefaultScriptInstructions = 10000000
+
+	// MaxScriptLength is the maximum length in bytes of a transformation script
+	MaxScriptLength = 4096
+
+	// MaxScriptDataLength is the maximum length in bytes of the data given to a
+	// transformation script, and of any string the script builds using
+	// string.rep
+	MaxScriptDataLength = 65536
+
+	// MaxScriptMemory is the maximum memory in bytes a transformation script
+	// may allocate, beyond that used by zenroom itself
+	MaxScriptMemory = 8 * 1024 * 1024
+
+	// MaxScriptDuration is the maximum time for which a transformation script
+	// may run, after which its process is killed whatever the script is doing
+	MaxScriptDuration = time.Second
+
+	// scriptHookInterval is the number of instructions between checks of a
+	// script's limits. Memory is only checked this often, so a script may
+	// briefly exceed its limit by what these instructions allocate, which is
+	// why we check so frequently despite the cost, as a few concatenations can
+	// each double the size of a string.
+	scriptHookInterval = 16
+
+	// instructionLimitExceeded and memoryLimitExceeded are the errors raised
+	// within zenroom when a script exceeds its limits
+	instructionLimitExceeded = "instruction limit exceeded"
+	memoryLimitExceeded      = "memory limit exceeded"
+
+	// scriptPreamble is prepended to every transformation script to limit the
+	// instructions it may execute and the memory it may allocate. Zenroom can't
+	// be interrupted from outside the VM, so we use a count hook which aborts
+	// the script once a limit is exceeded, and then on every subsequent
+	// instruction so that it can't be caught by pcall. Hooks are per thread, so
+	// the hook is also installed in every coroutine the script creates. The
+	// functions the hook needs are captured in a block the script can't see,
+	// and the debug library is removed, along with package through which it
+	// may otherwise be reached, so that the script can't replace the hook.
+	// Instructions run by the hook itself count towards the limit. We also
+	// remove io and os, which would let scripts read the host's files, and the
+	// functions which load code, which would let scripts load bytecode built
+	// with string.dump.
+	//
+	// Library functions written in C run without triggering the hook, so we
+	// also wrap string.rep to limit the length of the strings it builds, and
+	// remove the pattern matching functions, whose running time and output
+	// aren't bounded by the length of their input, leaving only plain
+	// string.find. As the hook can't interrupt C functions at all, scripts are
+	// also run in a child process which is killed once MaxScriptDuration has
+	// passed. The preamble is a single line so that line numbers in errors
+	// match the script.
+	scriptPreamble = `do local sethook, assert, collectgarbage, create, resume, pack, unpack = debug.sethook, assert, collectgarbage, coroutine.create, coroutine.resume, table.pack, table.unpack; ` +
+		`local count, base = 0, collectgarbage("count"); ` +
+		`local function abort(msg) local function again() sethook(again, "", 1) assert(false, msg) end; again() end; ` +
+		`local function hook() count = count + %[1]d; if count >= %[2]d then abort("%[3]s") end; if collectgarbage("count") - base > %[4]d then collectgarbage("collect"); if collectgarbage("count") - base > %[4]d then abort("%[5]s") end end end; ` +
+		`sethook(hook, "", %[1]d); ` +
+		`coroutine.create = function(f) local co = create(f); sethook(co, hook, "", %[1]d); return co end; ` +
+		`coroutine.wrap = function(f) local co = coroutine.create(f); return function(...) local results = pack(resume(co, ...)); assert(results[1], results[2]); return unpack(results, 2, results.n) end end end; ` +
+		`do local rep, find, tostring, assert = string.rep, string.find, tostring, assert; ` +
+		`local function unavailable() assert(false, "pattern matching is not available to transformation scripts") end; ` +
+		`string.rep = function(s, n, sep) assert(n * (#tostring(s) + #tostring(sep or "")) <= %[6]d, "string.rep must not build strings longer than %[6]d bytes"); return rep(s, n, sep) end; ` +
+		`string.find = function(s, pattern, init, plain) if not plain then unavailable() end; return find(s, pattern, init, plain) end; ` +
+		`string.match, string.gmatch, string.gsub = unavailable, unavailable, unavailable end; ` +
+		`string.dump = nil; ` +
+		`package.loaded.debug, package.loaded.io, package.loaded.os, package.loaded.package = nil, nil, nil, nil; ` +
+		`debug, io, os, package, require, load, loadstring, dofile, loadfile = nil, nil, nil, nil, nil, nil, nil, nil, nil; `
+)
+
+// errScriptDataTooLong is returned when the data to be transformed is longer
+// than a transformation script may be given.
+var errScriptDataTooLong = errors.Errorf("transformation script data must not exceed %v bytes", MaxScriptDataLength)
+
+// Transformer is a type that runs the custom transformation scripts configured
+// for streams. Scripts are Lua executed within zenroom's sandbox, which
+// receive the processed device as JSON in DATA and must print the JSON value
+// to be encrypted for the stream. Scripts are limited in the instructions they
+// execute, the memory they allocate, the time for which they run, and the size
+// of their input and output.
+type Transformer struct {
+	instructions int
+}
+
+// NewTransformer returns a new Transformer whose scripts may execute no more
+// than the given number of Lua instructions, which limits the time for which
+// they run.
+func NewTransformer(instructions int) *Transformer {
+	return &Transformer{
+		instructions: instructions,
+	}
+}
+
+// Transform runs the script with the given data, returning the JSON printed by
+// the script. An error is returned if the data is longer than
+// MaxScriptDataLength, or if the script fails, prints something other than
+// JSON, prints more than zenroom can return, or exceeds the instruction,
+// memory or time limits. The script is also abandoned if the context is done.
+func (t *Transformer) Transform(ctx context.Context, script string, data []byte) ([]byte, error) {
+	if len(data) > MaxScriptDataLength {
+		return nil, errScriptDataTooLong
+	}
+
+	interval := scriptHookInterval
+	if t.instructions < interval {
+		interval = t.instructions
+	}
+
+	limited := fmt.Sprintf(
+		scriptPreamble,
+		interval,
+		t.instructions,
+		instructionLimitExceeded,
+		MaxScriptMemory/1024,
+		memoryLimitExceeded,
+		MaxScriptDataLength,
+	) + script
+
+	scriptCtx, cancel := context.WithTimeout(ctx, MaxScriptDuration)
+	defer cancel()
+
+	output, err := zenroomProcesses.exec(scriptCtx, &zenroomRequest{
+		Script: nulTerminate([]byte(limited)),
+		Data:   nulTerminate(data),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), "abandoned transformation script")
+		}
+
+		if scriptCtx.Err() != nil {
+			return nil, errors.Errorf("transformation script exceeded time limit of %v", MaxScriptDuration)
+		}
+
+		if strings.Contains(err.Error(), instructionLimitExceeded) {
+			return nil, errors.Errorf("transformation script exceeded instruction limit of %v", t.instructions)
+		}
+
+		if strings.Contains(err.Error(), memoryLimitExceeded) {
+			return nil, errors.Errorf("transformation script exceeded memory limit of %v bytes", MaxScriptMemory)
+		}
+
+		return nil, errors.Wrap(err, "failed to execute transformation script")
+	}
+
+	output = bytes.TrimSpace(output)
+	if !json.Valid(output) {
+		return nil, errors.New("transformation script must print a JSON value")
+	}
+
+	return output, nil
+}
+
+// Validate checks that a script is short enough to be stored with a stream,
+// and that it is able to transform a sample device within the script limits.
+func (t *Transformer) Validate(ctx context.Context, script string) error {
+	if len(script) > MaxScriptLength {
+		return errors.Errorf("transformation script must not exceed %v bytes", MaxScriptLength)
+	}
+
+	data, err := json.Marshal(sampleDevice())
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal sample device")
+	}
+
+	_, err = t.Transform(ctx, script, data)
+
+	return err
+}
+
+// sampleDevice returns the device we transform when validating a script, which
+// is shaped like the devices written for streams.
+func sampleDevice() *smartcitizen.Device {
+	longitude := null.FloatFrom(2.15)
+	latitude := null.FloatFrom(41.38)
+	unit := null.StringFrom("ºC")
+	value := null.FloatFrom(21.5)
+
+	return &smartcitizen.Device{
+		Token:      "sample",
+		Label:      "Sample device",
+		Longitude:  &longitude,
+		Latitude:   &latitude,
+		Exposure:   "indoor",
+		RecordedAt: time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC),
+		Sensors: []*smartcitizen.Sensor{
+			{
+				ID:          55,
+				Name:        "SHT31 - Temperature",
+				Description: "Temperature",
+				Unit:        &unit,
+				Action:      postgres.Share,
+				Value:       &value,
+			},
+		},
+	}
+}
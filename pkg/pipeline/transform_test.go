@@ -0,0 +1,323 @@
+package pipeline_test
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestTransform(t *testing.T) {
+	transformer := pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+
+	script := `
+local device = JSON.decode(DATA)
+print(JSON.encode({ token = device.token, count = #device.sensors }))`
+
+	output, err := transformer.Transform(context.Background(), script, []byte(`{"token":"abc123","sensors":[{"id":12},{"id":14}]}`))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"token":"abc123","count":2}`, string(output))
+}
+
+func TestTransformInvalid(t *testing.T) {
+	testcases := []struct {
+		label       string
+		script      string
+		expectedErr string
+	}{
+		{
+			label:       "syntax error",
+			script:      `print(JSON.encode(`,
+			expectedErr: "failed to execute transformation script",
+		},
+		{
+			label:       "not json",
+			script:      `print("hello")`,
+			expectedErr: "transformation script must print a JSON value",
+		},
+		{
+			label:       "no output",
+			script:      `local x = 1`,
+			expectedErr: "transformation script must print a JSON value",
+		},
+		{
+			label:       "output too large",
+			script:      `local t = {} for i = 1, 1000 do t[i] = "abcdefgh" end print(JSON.encode(t))`,
+			expectedErr: "zenroom output must be shorter than 4095 bytes",
+		},
+	}
+
+	transformer := pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := transformer.Transform(context.Background(), tc.script, []byte(`{"token":"abc123"}`))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestTransformInstructionLimit(t *testing.T) {
+	transformer := pipeline.NewTransformer(100000)
+
+	testcases := []struct {
+		label  string
+		script string
+	}{
+		{
+			label:  "infinite loop",
+			script: `while true do end`,
+		},
+		{
+			label:  "caught by pcall",
+			script: `while true do pcall(function() while true do end end) end`,
+		},
+		{
+			label:  "hook removed",
+			script: `if debug then debug.sethook() end while true do end`,
+		},
+		{
+			label:  "within a coroutine",
+			script: `local co = coroutine.wrap(function() while true do end end) pcall(co) print("{}")`,
+		},
+		{
+			label:  "within a created coroutine",
+			script: `local co = coroutine.create(function() while true do end end) coroutine.resume(co) print("{}")`,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := transformer.Transform(context.Background(), tc.script, []byte(`{"token":"abc123"}`))
+			assert.EqualError(t, err, "transformation script exceeded instruction limit of 100000")
+		})
+	}
+
+	// scripts within the limit are unaffected
+	output, err := transformer.Transform(context.Background(), `local n = 0 for i = 1, 1000 do n = n + i end print(n)`, []byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "500500", string(output))
+}
+
+func TestTransformMemoryLimit(t *testing.T) {
+	transformer := pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+
+	testcases := []struct {
+		label       string
+		script      string
+		expectedErr string
+	}{
+		{
+			label:       "string.rep",
+			script:      `local s = string.rep("x", 1e9) print(#s)`,
+			expectedErr: "string.rep must not build strings longer than 65536 bytes",
+		},
+		{
+			label:       "string.rep method",
+			script:      `local s = ("abc"):rep(1e9) print(#s)`,
+			expectedErr: "string.rep must not build strings longer than 65536 bytes",
+		},
+		{
+			label:       "string.rep separator",
+			script:      `local s = string.rep("", 1e9, "x") print(#s)`,
+			expectedErr: "string.rep must not build strings longer than 65536 bytes",
+		},
+		{
+			label:       "pattern matching",
+			script:      `print(string.gsub(DATA, ".", "x"))`,
+			expectedErr: "pattern matching is not available to transformation scripts",
+		},
+		{
+			label:       "pattern find",
+			script:      `print(DATA:find("%w+"))`,
+			expectedErr: "pattern matching is not available to transformation scripts",
+		},
+		{
+			label:       "doubling a string",
+			script:      `local s = "x" for i = 1, 40 do s = s .. s end print(#s)`,
+			expectedErr: "transformation script exceeded memory limit of 8388608 bytes",
+		},
+		{
+			label:       "growing a table",
+			script:      `local t = {} for i = 1, 1e8 do t[i] = i end print(#t)`,
+			expectedErr: "transformation script exceeded memory limit of 8388608 bytes",
+		},
+		{
+			label:       "caught by pcall",
+			script:      `local t = {} pcall(function() for i = 1, 1e8 do t[i] = i end end) print(#t)`,
+			expectedErr: "transformation script exceeded memory limit of 8388608 bytes",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := transformer.Transform(context.Background(), tc.script, []byte(`{"token":"abc123"}`))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+
+	// strings within the limits may still be built, and searched plainly
+	output, err := transformer.Transform(context.Background(), `local s = string.rep("ab", 1000, ",") print(JSON.encode({ length = #s, at = s:find("b,a", 1, true) }))`, []byte(`{}`))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"length":2999,"at":2}`, string(output))
+
+	// as may coroutines
+	output, err = transformer.Transform(context.Background(), `local f = coroutine.wrap(function(a, b) coroutine.yield(a + b) end) print(f(1, 2))`, []byte(`{}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", string(output))
+
+	// and the data given to the script is limited too
+	_, err = transformer.Transform(context.Background(), `print(DATA)`, []byte(`"`+strings.Repeat("x", pipeline.MaxScriptDataLength)+`"`))
+	assert.EqualError(t, err, "transformation script data must not exceed 65536 bytes")
+}
+
+func TestTransformSandbox(t *testing.T) {
+	testcases := []struct {
+		label  string
+		script string
+	}{
+		{
+			label:  "debug via package.loaded",
+			script: `package.loaded.debug.sethook() while true do end`,
+		},
+		{
+			label:  "debug via require",
+			script: `require("debug").sethook() while true do end`,
+		},
+		{
+			label:  "io",
+			script: `for line in io.lines("/etc/hostname") do print(JSON.encode(line)) end`,
+		},
+		{
+			label:  "io via package.loaded",
+			script: `for line in package.loaded.io.lines("/etc/hostname") do print(JSON.encode(line)) end`,
+		},
+		{
+			label:  "os",
+			script: `print(JSON.encode(os.getenv("HOME")))`,
+		},
+		{
+			label:  "load",
+			script: `load("print(1)")()`,
+		},
+		{
+			label:  "loadstring",
+			script: `loadstring("print(1)")()`,
+		},
+		{
+			label:  "dofile",
+			script: `dofile("/etc/hostname")`,
+		},
+		{
+			label:  "loadfile",
+			script: `loadfile("/etc/hostname")()`,
+		},
+		{
+			label:  "string.dump",
+			script: `print(JSON.encode(string.dump(function() end)))`,
+		},
+	}
+
+	transformer := pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := transformer.Transform(context.Background(), tc.script, []byte(`{"token":"abc123"}`))
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), "attempt to")
+		})
+	}
+}
+
+func TestTransformTimeLimit(t *testing.T) {
+	// an instruction limit large enough that only the time limit stops the
+	// script
+	transformer := pipeline.NewTransformer(1 << 40)
+
+	start := time.Now()
+
+	_, err := transformer.Transform(context.Background(), `while true do end`, []byte(`{}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, "transformation script exceeded time limit of 1s", err.Error())
+	assert.True(t, time.Since(start) < 2*pipeline.MaxScriptDuration)
+
+	// the killed process is replaced, so later scripts still run
+	output, err := transformer.Transform(context.Background(), `print(JSON.encode({ ok = true }))`, []byte(`{}`))
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"ok":true}`, string(output))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = transformer.Transform(ctx, `print(1)`, []byte(`{}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, "abandoned transformation script: context canceled", err.Error())
+}
+
+func TestTransformConcurrently(t *testing.T) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("scripts only run concurrently with more than one CPU")
+	}
+
+	transformer := pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+
+	// scripts running until the instruction limit take a similar time, so we
+	// time one script on its own and then two at once
+	script := `while true do end`
+
+	_, err := transformer.Transform(context.Background(), script, []byte(`{}`))
+	assert.NotNil(t, err)
+
+	start := time.Now()
+
+	_, err = transformer.Transform(context.Background(), script, []byte(`{}`))
+	assert.NotNil(t, err)
+
+	single := time.Since(start)
+
+	var wg sync.WaitGroup
+
+	start = time.Now()
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := transformer.Transform(context.Background(), script, []byte(`{}`))
+			assert.NotNil(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.True(t, time.Since(start) < single*3/2)
+}
+
+func TestValidateScript(t *testing.T) {
+	transformer := pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+
+	err := transformer.Validate(context.Background(), `
+local device = JSON.decode(DATA)
+local out = {}
+for i, sensor in ipairs(device.sensors) do
+  out[sensor.description] = sensor.value
+end
+print(JSON.encode(out))`)
+	assert.Nil(t, err)
+
+	err = transformer.Validate(context.Background(), `print("hello")`)
+	assert.NotNil(t, err)
+
+	err = transformer.Validate(context.Background(), "print(JSON.encode(DATA))"+strings.Repeat(" ", pipeline.MaxScriptLength))
+	assert.EqualError(t, err, "transformation script must not exceed 4096 bytes")
+}
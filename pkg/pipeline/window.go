@@ -0,0 +1,251 @@
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+// Windower is an interface for a type that can return all values received
+// within a window for the given stream/device/sensor. Like the MovingAverager,
+// windows are in event time, and the window is the interval seconds up to when
+// the value was recorded if interval is non-zero, otherwise the last samples
+// values recorded up to then. The returned values include the value passed in. Values
+// recorded more than the allowed lateness before the latest value of the
+// series are discarded, in which case no values are returned. Values without a
+// timestamp, or recorded in the future, are treated as recorded on arrival.
+type Windower interface {
+	Window(value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) ([]float64, error)
+}
+
+// NewWindower returns an instance of our Windower interface. This is a simple
+// in-memory implementation, which also implements the Sweeper interface in
+// order to evict series that are no longer being updated.
+func NewWindower(verbose bool, cl clock.Clock, logger kitlog.Logger) Windower {
+	w := &windower{
+		series:  make(map[string]*series),
+		verbose: verbose,
+		logger:  logger,
+		clock:   cl,
+	}
+
+	w.periodicRunner = newPeriodicRunner(sweepInterval, w.Sweep)
+
+	return w
+}
+
+// windower is our type that implements the Windower interface using a simple in
+// memory store. It works in the same way as our movingAverager, keeping a map
+// keyed by stream id, device token, sensor id and window, with values being a
+// ring buffer of entries ordered by when they were recorded. When a value is
+// received we insert it in order, discard any entries that can no longer be
+// included in a window, and return the values of the entries within the window
+// up to when the value was recorded. A background sweeper deletes keys which
+// have not been updated for longer than their interval and allowed lateness.
+type windower struct {
+	sync.Mutex
+	*periodicRunner
+	series  map[string]*series
+	verbose bool
+	logger  kitlog.Logger
+	clock   clock.Clock
+}
+
+// Window is our implementation of the Windower interface method. The lock is
+// held throughout so that concurrent values for the same series can't
+// overwrite each other.
+func (w *windower) Window(value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) ([]float64, error) {
+	// build our key for the stream/device/sensor/window
+	key := fmt.Sprintf("%s:%s:%v:%v:%v", streamID, deviceToken, sensorID, interval, samples)
+
+	now := w.clock.Now()
+
+	if recordedAt.IsZero() || recordedAt.After(now) {
+		recordedAt = now
+	}
+
+	timestamp := recordedAt.Unix()
+
+	w.Lock()
+	defer w.Unlock()
+
+	s, ok := w.series[key]
+	if !ok {
+		s = &series{
+			interval: interval,
+			samples:  samples,
+		}
+
+		w.series[key] = s
+	}
+
+	s.lateness = lateness
+	s.lastSeen = now.Unix()
+
+	// discard values arriving too late to be included
+	if s.watermark > 0 && timestamp < s.watermark-int64(lateness/time.Second) {
+		if w.verbose {
+			w.logger.Log("key", key, "recorded_at", recordedAt, "msg", "discarded late window value")
+		}
+
+		return []float64{}, nil
+	}
+
+	s.insert(entry{
+		Timestamp: timestamp,
+		Value:     value,
+	})
+
+	if timestamp > s.watermark {
+		s.watermark = timestamp
+	}
+
+	// exclude any entries older than we may still need, keeping at least the
+	// last N if this is a sample window, then read the window
+	cutoff := s.watermark - int64(lateness/time.Second)
+
+	if s.sampleWindow() {
+		s.trim(int(samples), cutoff)
+		return s.valuesLast(int(samples), timestamp), nil
+	}
+
+	s.expire(cutoff - int64(interval))
+
+	return s.valuesBetween(timestamp-int64(interval), timestamp), nil
+}
+
+// valuesBetween returns the values of the entries recorded between the given
+// times inclusive, in the order they were recorded.
+func (s *series) valuesBetween(from, to int64) []float64 {
+	values := make([]float64, 0, s.count)
+
+	for i := 0; i < s.count; i++ {
+		e := s.at(i)
+		if e.Timestamp >= from && e.Timestamp <= to {
+			values = append(values, e.Value)
+		}
+	}
+
+	return values
+}
+
+// valuesLast returns the values of the last samples entries recorded no later
+// than the given time, in the order they were recorded.
+func (s *series) valuesLast(samples int, to int64) []float64 {
+	last := s.count - 1
+	for last >= 0 && s.at(last).Timestamp > to {
+		last--
+	}
+
+	first := last - samples + 1
+	if first < 0 {
+		first = 0
+	}
+
+	values := make([]float64, 0, last-first+1)
+
+	for i := first; i <= last; i++ {
+		values = append(values, s.at(i).Value)
+	}
+
+	return values
+}
+
+// Sweep is our implementation of the Sweeper interface method. It deletes any
+// series which has not been updated for longer than its interval and allowed
+// lateness, as its entries would normally be excluded from the next window
+// anyway. Sample windows are deleted once they have been idle for
+// sampleWindowIdleTimeout.
+func (w *windower) Sweep() {
+	now := w.clock.Now()
+
+	w.Lock()
+	defer w.Unlock()
+
+	for key, s := range w.series {
+		if s.lastSeen >= now.Add(-s.idleTimeout()).Unix() {
+			continue
+		}
+
+		delete(w.series, key)
+
+		if w.verbose {
+			w.logger.Log("key", key, "msg", "evicted window series")
+		}
+	}
+}
+
+// AggregateValues is a function that takes a slice of values from a window and
+// returns the aggregate value requested by the given action. The percentile
+// parameter is only used for the Percentile action.
+func AggregateValues(values []float64, action postgres.Action, percentile float64) float64 {
+	switch action {
+	case postgres.Min:
+		return MinValue(values)
+	case postgres.Max:
+		return MaxValue(values)
+	case postgres.Median:
+		return PercentileValue(values, 50)
+	case postgres.Percentile:
+		return PercentileValue(values, percentile)
+	default:
+		return math.NaN()
+	}
+}
+
+// MinValue returns the smallest value in the given slice.
+func MinValue(values []float64) float64 {
+	min := math.Inf(1)
+	for _, v := range values {
+		min = math.Min(min, v)
+	}
+	return min
+}
+
+// MaxValue returns the largest value in the given slice.
+func MaxValue(values []float64) float64 {
+	max := math.Inf(-1)
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	return max
+}
+
+// MeanValue returns the mean of the values in the given slice.
+func MeanValue(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total = total + v
+	}
+	return total / float64(len(values))
+}
+
+// PercentileValue returns the requested percentile (between 0 and 100) of the
+// given values. Where the percentile falls between two values we linearly
+// interpolate between them.
+func PercentileValue(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := (percentile / 100) * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	if lower == upper {
+		return sorted[lower]
+	}
+
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
@@ -0,0 +1,356 @@
+package pipeline_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	datastore "github.com/thingful/twirp-datastore-go"
+
+	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+func TestWindower(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	wd := pipeline.NewWindower(false, cl, logger)
+	assert.NotNil(t, wd)
+
+	values, err := wd.Window(4.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5}, values)
+
+	cl.Add(5 * time.Minute)
+	values, err = wd.Window(5.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5}, values)
+
+	// spam another series so we can test it doesn't affect
+	values, err = wd.Window(2.2, cl.Now(), "stream1", "abc123", 12, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{2.2}, values)
+
+	// another stream windowing the same device's sensor keeps its own series
+	values, err = wd.Window(5.5, cl.Now(), "stream2", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{5.5}, values)
+
+	cl.Add(5 * time.Minute)
+	values, err = wd.Window(6.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5, 6.5}, values)
+
+	cl.Add(5 * time.Minute)
+	values, err = wd.Window(5.5, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5, 6.5, 5.5}, values)
+
+	cl.Add(5 * time.Minute)
+	values, err = wd.Window(1.2, cl.Now(), "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{5.5, 6.5, 5.5, 1.2}, values)
+}
+
+func TestWindowerSamples(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	wd := pipeline.NewWindower(false, cl, logger)
+
+	values, err := wd.Window(4.5, cl.Now(), "stream1", "abc123", 55, 0, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5}, values)
+
+	cl.Add(time.Hour)
+	values, err = wd.Window(5.5, cl.Now(), "stream1", "abc123", 55, 0, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5}, values)
+
+	cl.Add(time.Hour)
+	values, err = wd.Window(6.5, cl.Now(), "stream1", "abc123", 55, 0, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{4.5, 5.5, 6.5}, values)
+
+	cl.Add(time.Hour)
+	values, err = wd.Window(1.2, cl.Now(), "stream1", "abc123", 55, 0, 3, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{5.5, 6.5, 1.2}, values)
+
+	// hybrid windows hold every value within the interval
+	values, err = wd.Window(1.0, cl.Now(), "stream1", "abc123", 55, 900, 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1.0}, values)
+
+	cl.Add(5 * time.Minute)
+	values, err = wd.Window(2.0, cl.Now(), "stream1", "abc123", 55, 900, 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1.0, 2.0}, values)
+
+	cl.Add(5 * time.Minute)
+	values, err = wd.Window(3.0, cl.Now(), "stream1", "abc123", 55, 900, 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1.0, 2.0, 3.0}, values)
+}
+
+func TestWindowerEventTime(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	wd := pipeline.NewWindower(false, cl, logger)
+
+	lateness := 5 * time.Minute
+
+	testcases := []struct {
+		label    string
+		value    float64
+		age      time.Duration
+		expected []float64
+	}{
+		{
+			label:    "first reading delivered after reconnecting",
+			value:    2,
+			age:      30 * time.Minute,
+			expected: []float64{2},
+		},
+		{
+			label:    "burst of delayed readings",
+			value:    4,
+			age:      20 * time.Minute,
+			expected: []float64{2, 4},
+		},
+		{
+			label:    "earliest reading leaves the window",
+			value:    6,
+			age:      10 * time.Minute,
+			expected: []float64{4, 6},
+		},
+		{
+			label:    "out of order reading within allowed lateness",
+			value:    8,
+			age:      13 * time.Minute,
+			expected: []float64{4, 8},
+		},
+		{
+			label:    "reading later than allowed lateness",
+			value:    10,
+			age:      16 * time.Minute,
+			expected: []float64{},
+		},
+		{
+			label:    "reading from the future",
+			value:    16,
+			age:      -time.Hour,
+			expected: []float64{8, 6, 16},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			values, err := wd.Window(tc.value, cl.Now().Add(-tc.age), "stream1", "abc123", 55, 900, 0, lateness)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, values)
+		})
+	}
+
+	// sample windows hold the last N readings by the time they were recorded
+	values, err := wd.Window(1, cl.Now().Add(-time.Minute), "stream1", "abc123", 55, 0, 2, lateness)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{1}, values)
+
+	values, err = wd.Window(3, cl.Now().Add(-3*time.Minute), "stream1", "abc123", 55, 0, 2, lateness)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{3}, values)
+
+	values, err = wd.Window(5, cl.Now().Add(-2*time.Minute), "stream1", "abc123", 55, 0, 2, lateness)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{3, 5}, values)
+}
+
+func TestProcessWithLateWindowedReadings(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	ds := mocks.Datastore{}
+	ds.On("WriteData", mock.Anything, mock.Anything).Return(&datastore.WriteResponse{}, nil)
+
+	cl := clock.NewMock(time.Date(2018, 12, 11, 15, 0, 0, 0, time.UTC))
+
+	processor := pipeline.NewProcessor(&pipeline.Config{
+		Datastore:       datastore.Datastore(&ds),
+		Windower:        pipeline.NewWindower(false, cl, logger),
+		AllowedLateness: 5 * time.Minute,
+	}, logger)
+
+	device := &postgres.Device{
+		DeviceToken: "foo",
+		Streams: []*postgres.Stream{
+			{
+				CommunityID: "smartcitizen",
+				PublicKey:   `BBLewg4VqLR38b38daE7Fj\/uhr543uGrEpyoPFgmFZK6EZ9g2XdK\/i65RrSJ6sJ96aXD3DJHY3Me2GJQO9\/ifjE=`,
+				Operations: postgres.Operations{
+					&postgres.Operation{
+						SensorID: 12,
+						Action:   postgres.Median,
+						Interval: 900,
+					},
+					&postgres.Operation{
+						Action: postgres.AQI,
+					},
+				},
+			},
+		},
+	}
+
+	late := counterValue(t, pipeline.LateReadingsCounter)
+
+	// the device reconnects and delivers the readings it buffered while offline
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-11T14:40:00Z","sensors":[{"id":12, "value":12.0},{"id":87, "value":10.0}]},{"recorded_at":"2018-12-11T14:50:00Z","sensors":[{"id":12, "value":14.0},{"id":87, "value":20.0}]}]}`)
+
+	err := processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, ds.Calls, 2)
+
+	// followed by one recorded long before them
+	cl.Add(time.Minute)
+
+	payload = []byte(`{"data":[{"recorded_at":"2018-12-11T14:30:00Z","sensors":[{"id":12, "value":10.0},{"id":87, "value":30.0}]}]}`)
+
+	err = processor.Process(context.Background(), device, payload)
+	assert.Nil(t, err)
+
+	// nothing is written for the late reading by either operation
+	assert.Len(t, ds.Calls, 2)
+	assert.Equal(t, late+2, counterValue(t, pipeline.LateReadingsCounter))
+}
+
+func TestWindowerConcurrent(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	wd := pipeline.NewWindower(false, cl, logger)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := wd.Window(1.0, cl.Now(), "stream1", "abc123", 55, 0, 100, 0)
+			assert.Nil(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	// no value is lost to a concurrent update of the same series
+	values, err := wd.Window(1.0, cl.Now(), "stream1", "abc123", 55, 0, 100, 0)
+	assert.Nil(t, err)
+	assert.Len(t, values, 51)
+}
+
+func TestWindowerSweep(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	cl := clock.NewMock(time.Now())
+	wd := pipeline.NewWindower(false, cl, logger)
+
+	sweeper, ok := wd.(pipeline.Sweeper)
+	assert.True(t, ok)
+
+	_, err := wd.Window(1.0, cl.Now(), "stream1", "abc123", 55, uint32(300), 0, 0)
+	assert.Nil(t, err)
+
+	_, err = wd.Window(2.0, cl.Now(), "stream1", "abc123", 12, uint32(3600), 0, 0)
+	assert.Nil(t, err)
+
+	// the shorter series is evicted once idle for longer than its interval, so
+	// its next window starts afresh
+	cl.Add(10 * time.Minute)
+	sweeper.Sweep()
+
+	values, err := wd.Window(3.0, cl.Now(), "stream1", "abc123", 55, uint32(300), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{3.0}, values)
+
+	values, err = wd.Window(4.0, cl.Now(), "stream1", "abc123", 12, uint32(3600), 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []float64{2.0, 4.0}, values)
+}
+
+func TestAggregateValues(t *testing.T) {
+	testcases := []struct {
+		label      string
+		values     []float64
+		action     postgres.Action
+		percentile float64
+		expected   float64
+	}{
+		{
+			label:    "min",
+			values:   []float64{3, 1, 2},
+			action:   postgres.Min,
+			expected: 1,
+		},
+		{
+			label:    "max",
+			values:   []float64{3, 1, 2},
+			action:   postgres.Max,
+			expected: 3,
+		},
+		{
+			label:    "median odd length",
+			values:   []float64{3, 1, 2},
+			action:   postgres.Median,
+			expected: 2,
+		},
+		{
+			label:    "median even length",
+			values:   []float64{4, 1, 3, 2},
+			action:   postgres.Median,
+			expected: 2.5,
+		},
+		{
+			label:      "90th percentile",
+			values:     []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+			action:     postgres.Percentile,
+			percentile: 90,
+			expected:   10,
+		},
+		{
+			label:      "interpolated percentile",
+			values:     []float64{10, 20},
+			action:     postgres.Percentile,
+			percentile: 75,
+			expected:   17.5,
+		},
+		{
+			label:      "100th percentile",
+			values:     []float64{5, 2, 9},
+			action:     postgres.Percentile,
+			percentile: 100,
+			expected:   9,
+		},
+		{
+			label:    "single value",
+			values:   []float64{4.2},
+			action:   postgres.Median,
+			expected: 4.2,
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			got := pipeline.AggregateValues(tc.values, tc.action, tc.percentile)
+			assert.Equal(t, tc.expected, got)
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWorkers is the number of workers in the pool used to write to streams
+// if no other size is configured.
+const DefaultWorkers = 16
+
+var (
+	// WorkersBusyGauge is a prometheus gauge recording the number of workers
+	// currently writing to streams
+	WorkersBusyGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "encoder",
+			Name:      "workers_busy",
+			Help:      "Count of workers currently writing to streams",
+		},
+	)
+)
+
+// WorkerPool is a type that runs tasks concurrently while capping the number
+// running at once. A single pool is shared by every call to the processor, so
+// the cap applies across all devices rather than to each payload. Workers
+// encrypt events concurrently in zenroom child processes, of which as many run
+// at once as there are CPUs, so workers beyond that overlap writes to the
+// datastore with encryption.
+type WorkerPool struct {
+	slots chan struct{}
+}
+
+// NewWorkerPool returns a new WorkerPool which runs no more than size tasks at
+// once.
+func NewWorkerPool(size int) *WorkerPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &WorkerPool{
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Run runs the given tasks concurrently, waiting for a free worker for each,
+// and returns once they have all finished. Tasks are independent so a failing
+// task doesn't stop the others, and the errors of any failing tasks are
+// returned in the order the tasks were given. Each task runs on a single
+// worker, so anything a task does in sequence stays in sequence.
+func (w *WorkerPool) Run(tasks []func() error) []error {
+	errs := make([]error, len(tasks))
+
+	var wg sync.WaitGroup
+
+	for i, task := range tasks {
+		wg.Add(1)
+
+		go func(i int, task func() error) {
+			defer wg.Done()
+
+			w.slots <- struct{}{}
+			WorkersBusyGauge.Inc()
+
+			defer func() {
+				WorkersBusyGauge.Dec()
+				<-w.slots
+			}()
+
+			errs[i] = task()
+		}(i, task)
+	}
+
+	wg.Wait()
+
+	failed := []error{}
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+
+	return failed
+}
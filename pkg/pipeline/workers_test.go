@@ -0,0 +1,72 @@
+package pipeline_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+)
+
+func TestWorkerPoolRun(t *testing.T) {
+	pool := pipeline.NewWorkerPool(3)
+
+	var running, maxRunning, completed int32
+
+	tasks := []func() error{}
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+
+			time.Sleep(10 * time.Millisecond)
+
+			atomic.AddInt32(&running, -1)
+			atomic.AddInt32(&completed, 1)
+			return nil
+		})
+	}
+
+	errs := pool.Run(tasks)
+	assert.Len(t, errs, 0)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&completed))
+	assert.True(t, atomic.LoadInt32(&maxRunning) > 1)
+	assert.True(t, atomic.LoadInt32(&maxRunning) <= 3)
+}
+
+func TestWorkerPoolRunError(t *testing.T) {
+	pool := pipeline.NewWorkerPool(2)
+
+	var completed int32
+
+	tasks := []func() error{
+		func() error {
+			atomic.AddInt32(&completed, 1)
+			return nil
+		},
+		func() error {
+			// failures are reported in task order rather than as they finish
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&completed, 1)
+			return errors.New("first")
+		},
+		func() error {
+			atomic.AddInt32(&completed, 1)
+			return errors.New("second")
+		},
+	}
+
+	errs := pool.Run(tasks)
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "first", errs[0].Error())
+	assert.Equal(t, "second", errs[1].Error())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&completed))
+}
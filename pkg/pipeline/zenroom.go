@@ -0,0 +1,299 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+
+	zenroom "github.com/DECODEproject/zenroom-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// zenroomOutputLimit is the size of the buffer in which zenroom returns the
+	// output of a script. Output which doesn't fit is silently truncated to
+	// one byte less than the buffer, so output of that length can't be trusted.
+	zenroomOutputLimit = 4096
+
+	// zenroomProcessEnv is the environment variable which, when set, makes a
+	// process importing this package run the zenroom scripts sent to it by its
+	// parent rather than starting normally.
+	zenroomProcessEnv = "IOTENCODER_ZENROOM_PROCESS"
+)
+
+var (
+	// errOutputTruncated is returned when the output of a zenroom script fills
+	// zenroom's output buffer, so may have been truncated.
+	errOutputTruncated = errors.Errorf("zenroom output must be shorter than %v bytes", zenroomOutputLimit-1)
+
+	// zenroomProcesses is the pool of child processes in which we run zenroom
+	// scripts, running as many at once as we have CPUs.
+	zenroomProcesses = newZenroomPool(runtime.NumCPU())
+)
+
+func init() {
+	if os.Getenv(zenroomProcessEnv) != "" {
+		os.Exit(serveZenroom(os.NewFile(3, "requests"), os.NewFile(4, "responses")))
+	}
+}
+
+// zenroomRequest is a script sent to a child process to be run by zenroom,
+// along with its inputs, each of which must be NUL terminated.
+type zenroomRequest struct {
+	Script []byte
+	Keys   []byte
+	Data   []byte
+}
+
+// zenroomResponse is the output of running a script in a child process, or the
+// error returned by zenroom.
+type zenroomResponse struct {
+	Output []byte
+	Err    string
+}
+
+// serveZenroom runs the scripts read from requests in zenroom one at a time,
+// writing their output to responses, until requests is closed by our parent.
+// It returns the process's exit code.
+func serveZenroom(requests io.Reader, responses io.Writer) int {
+	decoder := gob.NewDecoder(requests)
+	encoder := gob.NewEncoder(responses)
+
+	for {
+		var request zenroomRequest
+
+		err := decoder.Decode(&request)
+		if err != nil {
+			if err == io.EOF {
+				return 0
+			}
+
+			return 1
+		}
+
+		options := []zenroom.Option{zenroom.WithVerbosity(1)}
+
+		if request.Keys != nil {
+			options = append(options, zenroom.WithKeys(request.Keys))
+		}
+
+		if request.Data != nil {
+			options = append(options, zenroom.WithData(request.Data))
+		}
+
+		var response zenroomResponse
+
+		response.Output, err = zenroom.Exec(request.Script, options...)
+		if err != nil {
+			response.Err = err.Error()
+		}
+
+		err = encoder.Encode(&response)
+		if err != nil {
+			return 1
+		}
+	}
+}
+
+// zenroomProcess is a child process running zenroom scripts one at a time.
+type zenroomProcess struct {
+	cmd       *exec.Cmd
+	requests  *os.File
+	responses *os.File
+	encoder   *gob.Encoder
+	decoder   *gob.Decoder
+}
+
+// startZenroomProcess starts a child process running our own executable, which
+// serves requests rather than starting normally as it finds zenroomProcessEnv
+// set. Requests and responses are passed over their own pipes so that anything
+// zenroom prints to stdout can't be mistaken for a response.
+func startZenroomProcess() (*zenroomProcess, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find executable")
+	}
+
+	requestsReader, requestsWriter, err := os.Pipe()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create requests pipe")
+	}
+
+	responsesReader, responsesWriter, err := os.Pipe()
+	if err != nil {
+		requestsReader.Close()
+		requestsWriter.Close()
+		return nil, errors.Wrap(err, "failed to create responses pipe")
+	}
+
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), zenroomProcessEnv+"=1")
+	cmd.ExtraFiles = []*os.File{requestsReader, responsesWriter}
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Start()
+
+	// the child has its own copies of its ends of the pipes
+	requestsReader.Close()
+	responsesWriter.Close()
+
+	if err != nil {
+		requestsWriter.Close()
+		responsesReader.Close()
+		return nil, errors.Wrap(err, "failed to start zenroom process")
+	}
+
+	return &zenroomProcess{
+		cmd:       cmd,
+		requests:  requestsWriter,
+		responses: responsesReader,
+		encoder:   gob.NewEncoder(requestsWriter),
+		decoder:   gob.NewDecoder(responsesReader),
+	}, nil
+}
+
+// exec sends the request to the process and waits for its response. If the
+// context is done first the process is killed, as zenroom can't otherwise be
+// interrupted, and an error returned along with false to show the process can't
+// be reused, which is also the case if we fail to communicate with it.
+func (z *zenroomProcess) exec(ctx context.Context, request *zenroomRequest) ([]byte, bool, error) {
+	var response zenroomResponse
+
+	done := make(chan error, 1)
+
+	go func() {
+		err := z.encoder.Encode(request)
+		if err == nil {
+			err = z.decoder.Decode(&response)
+		}
+
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			z.kill()
+			return nil, false, errors.Wrap(err, "zenroom process failed")
+		}
+	case <-ctx.Done():
+		z.kill()
+		<-done
+		return nil, false, ctx.Err()
+	}
+
+	if response.Err != "" {
+		return nil, true, errors.New(response.Err)
+	}
+
+	return response.Output, true, nil
+}
+
+// kill kills the process and releases its resources.
+func (z *zenroomProcess) kill() {
+	z.cmd.Process.Kill()
+	z.requests.Close()
+	z.responses.Close()
+	z.cmd.Wait()
+}
+
+// zenroomPool is a pool of child processes running zenroom scripts. Zenroom
+// holds global state so a process can only run one script at a time, and a
+// script can't be interrupted from outside the VM, so running scripts in child
+// processes lets us run them concurrently and kill those which run too long.
+// Idle processes are kept to be reused.
+type zenroomPool struct {
+	slots chan struct{}
+
+	sync.Mutex
+	idle []*zenroomProcess
+}
+
+// newZenroomPool returns a new pool which runs no more than size scripts at
+// once.
+func newZenroomPool(size int) *zenroomPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &zenroomPool{
+		slots: make(chan struct{}, size),
+	}
+}
+
+// exec runs the script in a child process, waiting for a free process if the
+// pool is busy. The script is abandoned and its process killed if the context
+// is done before it finishes.
+func (z *zenroomPool) exec(ctx context.Context, request *zenroomRequest) ([]byte, error) {
+	select {
+	case z.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	defer func() {
+		<-z.slots
+	}()
+
+	process, err := z.get()
+	if err != nil {
+		return nil, err
+	}
+
+	output, reusable, err := process.exec(ctx, request)
+	if reusable {
+		z.put(process)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return checkZenroomOutput(output)
+}
+
+// checkZenroomOutput returns the output of a script, or an error if it filled
+// zenroom's output buffer, as it may then have been truncated.
+func checkZenroomOutput(output []byte) ([]byte, error) {
+	// the output buffer is filled with spaces before zenroom is called, and
+	// left untouched if the script prints nothing
+	if len(bytes.TrimSpace(output)) == 0 {
+		return []byte{}, nil
+	}
+
+	if len(output) >= zenroomOutputLimit-1 {
+		return nil, errOutputTruncated
+	}
+
+	return output, nil
+}
+
+// get returns an idle process, or starts a new one if none are idle.
+func (z *zenroomPool) get() (*zenroomProcess, error) {
+	z.Lock()
+
+	if len(z.idle) == 0 {
+		z.Unlock()
+		return startZenroomProcess()
+	}
+
+	process := z.idle[len(z.idle)-1]
+	z.idle = z.idle[:len(z.idle)-1]
+
+	z.Unlock()
+
+	return process, nil
+}
+
+// put returns a process to the pool to be reused.
+func (z *zenroomPool) put(process *zenroomProcess) {
+	z.Lock()
+	defer z.Unlock()
+
+	z.idle = append(z.idle, process)
+}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DeadLetter is a message received from a device which we failed to process,
+// kept so that it can be inspected and replayed once the cause is fixed. If
+// StreamID is set only that stream failed, otherwise the message failed for
+// every stream of the device.
+type DeadLetter struct {
+	ID          int64     `db:"id"`
+	Topic       string    `db:"topic"`
+	DeviceToken string    `db:"device_token"`
+	StreamID    string    `db:"stream_id"`
+	Payload     []byte    `db:"payload"`
+	Stage       string    `db:"stage"`
+	Error       string    `db:"error"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// SaveDeadLetter persists a dead letter, returning it with its id and creation
+// time set.
+func (d *DB) SaveDeadLetter(letter *DeadLetter) (_ *DeadLetter, err error) {
+	sql := `INSERT INTO dead_letters
+		(topic, device_token, stream_id, payload, stage, error)
+	VALUES (:topic, :device_token, :stream_id, :payload, :stage, :error)
+	RETURNING id, created_at`
+
+	mapArgs := map[string]interface{}{
+		"topic":        letter.Topic,
+		"device_token": letter.DeviceToken,
+		"stream_id":    letter.StreamID,
+		"payload":      letter.Payload,
+		"stage":        letter.Stage,
+		"error":        letter.Error,
+	}
+
+	tx, err := BeginTX(d.DB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction when saving dead letter")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Get(letter, sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save dead letter")
+	}
+
+	return letter, nil
+}
+
+// ListDeadLetters returns up to limit dead letters, oldest first.
+func (d *DB) ListDeadLetters(limit int) ([]*DeadLetter, error) {
+	letters := []*DeadLetter{}
+
+	err := d.DB.Select(
+		&letters,
+		`SELECT id, topic, device_token, stream_id, payload, stage, error, created_at
+		FROM dead_letters
+		ORDER BY id
+		LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list dead letters")
+	}
+
+	return letters, nil
+}
+
+// GetDeadLetter returns the dead letter with the given id, or an error if it
+// doesn't exist.
+func (d *DB) GetDeadLetter(id int64) (*DeadLetter, error) {
+	var letter DeadLetter
+
+	err := d.DB.Get(
+		&letter,
+		`SELECT id, topic, device_token, stream_id, payload, stage, error, created_at
+		FROM dead_letters
+		WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.Errorf("no dead letter with id %v", id)
+		}
+		return nil, errors.Wrap(err, "failed to get dead letter")
+	}
+
+	return &letter, nil
+}
+
+// DeleteDeadLetter deletes the dead letter with the given id, e.g. once it has
+// been replayed successfully.
+func (d *DB) DeleteDeadLetter(id int64) error {
+	_, err := d.DB.Exec(`DELETE FROM dead_letters WHERE id = $1`, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete dead letter")
+	}
+
+	return nil
+}
+
+// PurgeDeadLetters deletes all dead letters created before the given time,
+// returning the number deleted.
+func (d *DB) PurgeDeadLetters(before time.Time) (int, error) {
+	result, err := d.DB.Exec(`DELETE FROM dead_letters WHERE created_at < $1`, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to purge dead letters")
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count purged dead letters")
+	}
+
+	return int(count), nil
+}
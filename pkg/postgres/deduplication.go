@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Seen is a persistent implementation of the pipeline's Deduplicator
+// interface. We first delete any of the device's readings seen longer ago than
+// the horizon, and then attempt to record the reading, which conflicts with the
+// existing row if it has been seen within the horizon. Inserting and checking
+// are a single statement so that multiple encoder instances sharing the
+// database agree on which of them saw a reading first.
+func (d *DB) Seen(ctx context.Context, deviceToken, key string, horizon time.Duration) (_ bool, err error) {
+	mapArgs := map[string]interface{}{
+		"device_token": deviceToken,
+		"reading_key":  key,
+		"horizon":      horizon.Seconds(),
+	}
+
+	tx, err := BeginTXContext(ctx, d.DB)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	sql := `DELETE FROM seen_readings
+		WHERE device_token = :device_token
+		AND seen_at < NOW() - :horizon * INTERVAL '1 second'`
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete expired seen readings")
+	}
+
+	sql = `INSERT INTO seen_readings (device_token, reading_key)
+		VALUES (:device_token, :reading_key)
+		ON CONFLICT (device_token, reading_key) DO NOTHING
+		RETURNING reading_key`
+
+	inserted := 0
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			inserted++
+		}
+
+		return rows.Err()
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record seen reading")
+	}
+
+	return inserted == 0, nil
+}
+
+// Forget is a persistent implementation of the pipeline's Deduplicator
+// interface. It deletes the record of the reading, so that a copy of it is no
+// longer treated as seen.
+func (d *DB) Forget(ctx context.Context, deviceToken, key string) error {
+	_, err := d.DB.ExecContext(
+		ctx,
+		`DELETE FROM seen_readings WHERE device_token = $1 AND reading_key = $2`,
+		deviceToken,
+		key,
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to forget seen reading")
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// OutboxEvent is an encrypted event held in the outbox until it has been
+// written to the datastore.
+type OutboxEvent struct {
+	ID          int64     `db:"id"`
+	CommunityID string    `db:"community_id"`
+	DeviceToken string    `db:"device_token"`
+	Data        []byte    `db:"data"`
+	Attempts    int       `db:"attempts"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// EnqueueEvent persists an event in the outbox, returning the event with its
+// id set. The event isn't available to be claimed until the lease has passed,
+// which gives the caller that long to write it before it may be retried.
+func (d *DB) EnqueueEvent(event *OutboxEvent, lease time.Duration) (_ *OutboxEvent, err error) {
+	sql := `INSERT INTO outbox_events
+		(community_id, device_token, data, available_at)
+	VALUES (:community_id, :device_token, :data, NOW() + :lease * INTERVAL '1 second')
+	RETURNING id, created_at`
+
+	mapArgs := map[string]interface{}{
+		"community_id": event.CommunityID,
+		"device_token": event.DeviceToken,
+		"data":         event.Data,
+		"lease":        lease.Seconds(),
+	}
+
+	tx, err := BeginTX(d.DB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction when enqueuing event")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Get(event, sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to enqueue event")
+	}
+
+	return event, nil
+}
+
+// ClaimEvents returns up to limit events from the outbox which are available
+// to be written, oldest first. Claimed events are leased to the caller, so are
+// not available again until the lease has passed, and rows already being
+// claimed are skipped so that multiple encoder instances may share the outbox.
+// An event that is not acknowledged within its lease is retried.
+func (d *DB) ClaimEvents(limit int, lease time.Duration) (_ []*OutboxEvent, err error) {
+	sql := `UPDATE outbox_events
+	SET attempts = attempts + 1,
+		available_at = NOW() + :lease * INTERVAL '1 second'
+	WHERE id IN (
+		SELECT id FROM outbox_events
+		WHERE available_at <= NOW()
+		ORDER BY id
+		LIMIT :limit
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, community_id, device_token, data, attempts, created_at`
+
+	mapArgs := map[string]interface{}{
+		"limit": limit,
+		"lease": lease.Seconds(),
+	}
+
+	tx, err := BeginTX(d.DB)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction when claiming events")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	events := []*OutboxEvent{}
+
+	mapper := func(rows *sqlx.Rows) error {
+		for rows.Next() {
+			var event OutboxEvent
+
+			err = rows.StructScan(&event)
+			if err != nil {
+				return errors.Wrap(err, "failed to scan row into OutboxEvent struct")
+			}
+
+			events = append(events, &event)
+		}
+
+		return nil
+	}
+
+	err = tx.Map(sql, mapArgs, mapper)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to claim events")
+	}
+
+	// the update returns rows in no particular order
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+
+	return events, nil
+}
+
+// AcknowledgeEvent deletes an event from the outbox once it has been written
+// to the datastore.
+func (d *DB) AcknowledgeEvent(id int64) error {
+	_, err := d.DB.Exec(`DELETE FROM outbox_events WHERE id = $1`, id)
+	if err != nil {
+		return errors.Wrap(err, "failed to acknowledge event")
+	}
+
+	return nil
+}
+
+// ExpireEvents deletes any events older than the maximum age from the outbox,
+// returning the number deleted.
+func (d *DB) ExpireEvents(maxAge time.Duration) (int, error) {
+	result, err := d.DB.Exec(
+		`DELETE FROM outbox_events WHERE created_at < NOW() - $1 * INTERVAL '1 second'`,
+		maxAge.Seconds(),
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to expire events")
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count expired events")
+	}
+
+	return int(count), nil
+}
+
+// OutboxStats returns the number of events in the outbox, and the age of the
+// oldest of them, which is zero if the outbox is empty.
+func (d *DB) OutboxStats() (int, time.Duration, error) {
+	var stats struct {
+		Depth int     `db:"depth"`
+		Age   float64 `db:"age"`
+	}
+
+	err := d.DB.Get(
+		&stats,
+		`SELECT COUNT(*) AS depth,
+			COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at)), 0) AS age
+		FROM outbox_events`,
+	)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to read outbox stats")
+	}
+
+	return stats.Depth, time.Duration(stats.Age * float64(time.Second)), nil
+}
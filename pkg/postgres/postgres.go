@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
@@ -31,6 +32,18 @@ var (
 // Action is a type alias for string - we use for constants
 type Action string
 
+// Mechanism is a type alias for string - we use for constants describing how
+// noise is generated for the Noise action
+type Mechanism string
+
+// Index is a type alias for string - we use for constants describing which air
+// quality index is computed for the AQI action
+type Index string
+
+// LocationPolicy is a type alias for string - we use for constants describing
+// how precisely a stream reveals the location of the device
+type LocationPolicy string
+
 const (
 	// Share defines an action of sharing a sensor without processing
 	Share Action = "SHARE"
@@ -41,6 +54,70 @@ const (
 	// MovingAverage defines an action of sharing a moving average for a sensor
 	MovingAverage Action = "MOVING_AVG"
 
+	// Min defines an action of sharing the minimum value seen within a window
+	// for a sensor
+	Min Action = "MIN"
+
+	// Max defines an action of sharing the maximum value seen within a window
+	// for a sensor
+	Max Action = "MAX"
+
+	// Median defines an action of sharing the median value seen within a window
+	// for a sensor
+	Median Action = "MEDIAN"
+
+	// Percentile defines an action of sharing an arbitrary percentile of the
+	// values seen within a window for a sensor
+	Percentile Action = "PERCENTILE"
+
+	// Noise defines an action of sharing a sensor value perturbed with random
+	// noise calibrated to provide differential privacy
+	Noise Action = "NOISE"
+
+	// Threshold defines an action of sharing the state of a sensor relative to
+	// upper and lower limits, only when that state changes
+	Threshold Action = "THRESHOLD"
+
+	// Convert defines an action of sharing a sensor value converted into some
+	// other unit
+	Convert Action = "CONVERT"
+
+	// AQI defines an action of sharing an air quality index derived from the
+	// particulate sensors of a device
+	AQI Action = "AQI"
+
+	// EWMA defines an action of sharing an exponentially weighted moving average
+	// for a sensor
+	EWMA Action = "EWMA"
+
+	// USEPA defines the US EPA Air Quality Index
+	USEPA Index = "US_EPA"
+
+	// EUCAQI defines the European Common Air Quality Index
+	EUCAQI Index = "EU_CAQI"
+
+	// Laplace defines the noise mechanism which adds noise drawn from a Laplace
+	// distribution
+	Laplace Mechanism = "LAPLACE"
+
+	// Gaussian defines the noise mechanism which adds noise drawn from a
+	// Gaussian distribution
+	Gaussian Mechanism = "GAUSSIAN"
+
+	// Exact defines the location policy of sharing the device location exactly
+	Exact LocationPolicy = "EXACT"
+
+	// Grid defines the location policy of snapping the device location to the
+	// centre of a grid cell of some size in metres
+	Grid LocationPolicy = "GRID"
+
+	// Geohash defines the location policy of truncating the device location to
+	// the centre of a geohash cell of some precision
+	Geohash LocationPolicy = "GEOHASH"
+
+	// Omit defines the location policy of removing the device location entirely
+	Omit LocationPolicy = "OMIT"
+
 	// TokenLength is a constant which controls the length in bytes of the security
 	// tokens we generate for streams.
 	TokenLength = 24
@@ -68,11 +145,20 @@ type Device struct {
 // stream. It contains a public key field used when reading data, and for
 // creating a new stream has an associated Device instance.
 type Stream struct {
-	CommunityID string     `db:"community_id"`
-	PublicKey   string     `db:"public_key"`
-	Operations  Operations `db:"operations"`
-
-	StreamID string
+	CommunityID              string         `db:"community_id"`
+	PublicKey                string         `db:"public_key"`
+	Operations               Operations     `db:"operations"`
+	PrivacyBudget            float64        `db:"privacy_budget"`
+	PrivacyBudgetPeriod      uint32         `db:"privacy_budget_period"`
+	LocationPolicy           LocationPolicy `db:"location_policy"`
+	LocationGridSize         uint32         `db:"location_grid_size"`
+	LocationGeohashPrecision uint32         `db:"location_geohash_precision"`
+	TimeResolution           uint32         `db:"time_resolution"`
+	EmissionInterval         uint32         `db:"emission_interval"`
+	BatchReadings            bool           `db:"batch_readings"`
+	TransformScript          string         `db:"transform_script"`
+
+	StreamID string `db:"uuid"`
 	Token    string
 
 	Device *Device
@@ -81,10 +167,24 @@ type Stream struct {
 // Operation is a type used to capture the data around the operations to be
 // applied to a Stream.
 type Operation struct {
-	SensorID uint32    `json:"sensorId"`
-	Action   Action    `json:"action"`
-	Bins     []float64 `json:"bins"`
-	Interval uint32    `json:"interval"`
+	SensorID    uint32    `json:"sensorId"`
+	Action      Action    `json:"action"`
+	Bins        []float64 `json:"bins"`
+	Interval    uint32    `json:"interval"`
+	Percentile  float64   `json:"percentile,omitempty"`
+	Epsilon     float64   `json:"epsilon,omitempty"`
+	Sensitivity float64   `json:"sensitivity,omitempty"`
+	Delta       float64   `json:"delta,omitempty"`
+	Mechanism   Mechanism `json:"mechanism,omitempty"`
+	Upper       float64   `json:"upper,omitempty"`
+	Lower       float64   `json:"lower,omitempty"`
+	Hysteresis  float64   `json:"hysteresis,omitempty"`
+	Unit        string    `json:"unit,omitempty"`
+	Index       Index     `json:"index,omitempty"`
+	HalfLife    uint32    `json:"halfLife,omitempty"`
+	Samples     uint32    `json:"samples,omitempty"`
+	Labels      []string  `json:"labels,omitempty"`
+	Measurement string    `json:"measurement,omitempty"`
 }
 
 // Operations is a type alias for a slice of Operation instance. We add as a
@@ -225,8 +325,12 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 
 	// streams insert sql
 	sql = `INSERT INTO streams
-	(device_id, community_id, public_key, token, operations, uuid)
-	VALUES (:device_id, :community_id, :public_key, pgp_sym_encrypt(:token, :encryption_password), :operations, :uuid)`
+	(device_id, community_id, public_key, token, operations, uuid, privacy_budget, privacy_budget_period,
+		location_policy, location_grid_size, location_geohash_precision, time_resolution,
+		emission_interval, batch_readings, transform_script)
+	VALUES (:device_id, :community_id, :public_key, pgp_sym_encrypt(:token, :encryption_password), :operations, :uuid, :privacy_budget, :privacy_budget_period,
+		:location_policy, :location_grid_size, :location_geohash_precision, :time_resolution,
+		:emission_interval, :batch_readings, :transform_script)`
 
 	token, err := GenerateToken(TokenLength)
 	if err != nil {
@@ -234,13 +338,22 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 	}
 
 	mapArgs = map[string]interface{}{
-		"device_id":           deviceID,
-		"community_id":        stream.CommunityID,
-		"public_key":          stream.PublicKey,
-		"token":               token,
-		"encryption_password": d.encryptionPassword,
-		"operations":          stream.Operations,
-		"uuid":                streamID.String(),
+		"device_id":                  deviceID,
+		"community_id":               stream.CommunityID,
+		"public_key":                 stream.PublicKey,
+		"token":                      token,
+		"encryption_password":        d.encryptionPassword,
+		"operations":                 stream.Operations,
+		"uuid":                       streamID.String(),
+		"privacy_budget":             stream.PrivacyBudget,
+		"privacy_budget_period":      stream.PrivacyBudgetPeriod,
+		"location_policy":            stream.LocationPolicy,
+		"location_grid_size":         stream.LocationGridSize,
+		"location_geohash_precision": stream.LocationGeohashPrecision,
+		"time_resolution":            stream.TimeResolution,
+		"emission_interval":          stream.EmissionInterval,
+		"batch_readings":             stream.BatchReadings,
+		"transform_script":           stream.TransformScript,
 	}
 
 	err = tx.Exec(sql, mapArgs)
@@ -259,10 +372,10 @@ func (d *DB) CreateStream(stream *Stream) (_ *Stream, err error) {
 	return stream, err
 }
 
-// DeleteStream deletes a stream identified by the given id string. If this
-// stream is the last one associated with a device, then the device record is
-// also deleted. We return a Device object purely so we can pass back out the
-// token allowing us to unsubscribe.
+// DeleteStream deletes a stream identified by the given id string along with
+// its moving average entries. If this stream is the last one associated with a
+// device, then the device record is also deleted. We return a Device object
+// purely so we can pass back out the token allowing us to unsubscribe.
 func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
 	sql := `DELETE FROM streams
 	WHERE uuid = :uuid
@@ -294,6 +407,19 @@ func (d *DB) DeleteStream(stream *Stream) (_ *Device, err error) {
 		return nil, errors.Wrap(err, "failed to delete stream")
 	}
 
+	// moving averages are keyed by the stream's id rather than referencing the
+	// stream, so we delete its series explicitly
+	sql = `DELETE FROM moving_average_entries WHERE stream_id = :stream_id`
+
+	mapArgs = map[string]interface{}{
+		"stream_id": stream.StreamID,
+	}
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to delete moving average entries")
+	}
+
 	// now we count streams for that device id, and if no more we should also
 	// delete the device and unsubscribe from its topic
 	sql = `SELECT COUNT(*) FROM streams WHERE device_id = :device_id`
@@ -403,7 +529,11 @@ func (d *DB) GetDevice(deviceToken string) (_ *Device, err error) {
 	}
 
 	// now load streams
-	sql = `SELECT community_id, public_key, operations FROM streams WHERE device_id = :device_id`
+	sql = `SELECT uuid, community_id, public_key, operations, privacy_budget, privacy_budget_period,
+		location_policy, location_grid_size, location_geohash_precision, time_resolution,
+		emission_interval, batch_readings, transform_script
+		FROM streams
+		WHERE device_id = :device_id`
 
 	mapArgs = map[string]interface{}{
 		"device_id": device.ID,
@@ -437,6 +567,205 @@ func (d *DB) GetDevice(deviceToken string) (_ *Device, err error) {
 	return &device, nil
 }
 
+// MovingAverage is a persistent implementation of the pipeline's MovingAverager
+// interface. We record the value for the stream/device/sensor/window in the
+// moving_average_entries table at the time it was recorded, delete any entries
+// which can no longer be included in an average, and return the average and
+// count of those within the window up to when the value was recorded. The
+// window is the last interval seconds if interval is non-zero, otherwise the
+// last samples values. Values recorded more than the allowed lateness before
+// the latest entry of the series are discarded, and values without a timestamp,
+// or recorded in the future according to the database clock, are treated as
+// recorded now. We take a transaction scoped advisory lock on the series, so
+// that multiple encoder instances sharing the database see a consistent window.
+func (d *DB) MovingAverage(ctx context.Context, value float64, recordedAt time.Time, streamID, deviceToken string, sensorID int, interval, samples uint32, lateness time.Duration) (_ float64, _ int, err error) {
+	mapArgs := map[string]interface{}{
+		"series":         fmt.Sprintf("%s:%s:%v:%v:%v", streamID, deviceToken, sensorID, interval, samples),
+		"stream_id":      streamID,
+		"device_token":   deviceToken,
+		"sensor_id":      sensorID,
+		"window_seconds": interval,
+		"window_samples": samples,
+		"value":          value,
+		"recorded_at":    nil,
+	}
+
+	if !recordedAt.IsZero() {
+		mapArgs["recorded_at"] = recordedAt
+	}
+
+	sampleWindow := interval == 0 && samples > 0
+
+	tx, err := BeginTXContext(ctx, d.DB)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	err = tx.Exec(`SELECT pg_advisory_xact_lock(hashtext(:series))`, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to lock moving average series")
+	}
+
+	sql := `SELECT LEAST(COALESCE(CAST(:recorded_at AS TIMESTAMP WITH TIME ZONE), NOW()), NOW()) AS recorded_at,
+		MAX(recorded_at) AS watermark
+		FROM moving_average_entries
+		WHERE stream_id = :stream_id
+		AND device_token = :device_token
+		AND sensor_id = :sensor_id
+		AND window_seconds = :window_seconds
+		AND window_samples = :window_samples`
+
+	var times struct {
+		RecordedAt time.Time   `db:"recorded_at"`
+		Watermark  pq.NullTime `db:"watermark"`
+	}
+
+	err = tx.Get(&times, sql, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to read moving average watermark")
+	}
+
+	watermark := times.RecordedAt
+
+	if times.Watermark.Valid {
+		// discard values arriving too late to be included
+		if times.RecordedAt.Before(times.Watermark.Time.Add(-lateness)) {
+			return 0, 0, nil
+		}
+
+		if times.Watermark.Time.After(watermark) {
+			watermark = times.Watermark.Time
+		}
+	}
+
+	mapArgs["recorded_at"] = times.RecordedAt
+	mapArgs["cutoff"] = watermark.Add(-lateness)
+
+	sql = `INSERT INTO moving_average_entries
+		(stream_id, device_token, sensor_id, window_seconds, window_samples, recorded_at, value)
+		VALUES (:stream_id, :device_token, :sensor_id, :window_seconds, :window_samples, :recorded_at, :value)`
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to insert moving average entry")
+	}
+
+	if sampleWindow {
+		sql = `DELETE FROM moving_average_entries
+			WHERE stream_id = :stream_id
+			AND device_token = :device_token
+			AND sensor_id = :sensor_id
+			AND window_seconds = :window_seconds
+			AND window_samples = :window_samples
+			AND recorded_at < :cutoff
+			AND id NOT IN (
+				SELECT id FROM moving_average_entries
+				WHERE stream_id = :stream_id
+				AND device_token = :device_token
+				AND sensor_id = :sensor_id
+				AND window_seconds = :window_seconds
+				AND window_samples = :window_samples
+				ORDER BY recorded_at DESC, id DESC
+				LIMIT :window_samples
+			)`
+	} else {
+		sql = `DELETE FROM moving_average_entries
+			WHERE stream_id = :stream_id
+			AND device_token = :device_token
+			AND sensor_id = :sensor_id
+			AND window_seconds = :window_seconds
+			AND window_samples = :window_samples
+			AND recorded_at < CAST(:cutoff AS TIMESTAMP WITH TIME ZONE) - :window_seconds * INTERVAL '1 second'`
+	}
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to delete expired moving average entries")
+	}
+
+	if sampleWindow {
+		sql = `SELECT AVG(value) AS average, COUNT(*) AS count
+			FROM (
+				SELECT value FROM moving_average_entries
+				WHERE stream_id = :stream_id
+				AND device_token = :device_token
+				AND sensor_id = :sensor_id
+				AND window_seconds = :window_seconds
+				AND window_samples = :window_samples
+				AND recorded_at <= :recorded_at
+				ORDER BY recorded_at DESC, id DESC
+				LIMIT :window_samples
+			) AS window_entries`
+	} else {
+		sql = `SELECT AVG(value) AS average, COUNT(*) AS count
+			FROM moving_average_entries
+			WHERE stream_id = :stream_id
+			AND device_token = :device_token
+			AND sensor_id = :sensor_id
+			AND window_seconds = :window_seconds
+			AND window_samples = :window_samples
+			AND recorded_at >= CAST(:recorded_at AS TIMESTAMP WITH TIME ZONE) - :window_seconds * INTERVAL '1 second'
+			AND recorded_at <= :recorded_at`
+	}
+
+	var result struct {
+		Average float64 `db:"average"`
+		Count   int     `db:"count"`
+	}
+
+	err = tx.Get(&result, sql, mapArgs)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed to calculate moving average")
+	}
+
+	return result.Average, result.Count, nil
+}
+
+// ExpireMovingAverages deletes every moving average series which has not
+// received a value for longer than it can still be used, returning the number
+// of entries deleted. Series are otherwise only pruned as values arrive, so
+// without this the entries of devices which have stopped sending readings, or
+// of windows no stream uses any more, would be kept forever. Interval windows
+// are idle once their latest value is older than the interval and allowed
+// lateness, and sample windows, which have no duration, once it is older than
+// the idle timeout.
+func (d *DB) ExpireMovingAverages(lateness, idleTimeout time.Duration) (int, error) {
+	sql := `DELETE FROM moving_average_entries AS e
+		USING (
+			SELECT stream_id, device_token, sensor_id, window_seconds, window_samples,
+				MAX(recorded_at) AS watermark
+			FROM moving_average_entries
+			GROUP BY stream_id, device_token, sensor_id, window_seconds, window_samples
+		) AS s
+		WHERE e.stream_id = s.stream_id
+		AND e.device_token = s.device_token
+		AND e.sensor_id = s.sensor_id
+		AND e.window_seconds = s.window_seconds
+		AND e.window_samples = s.window_samples
+		AND s.watermark < NOW() - CASE
+			WHEN s.window_seconds = 0 AND s.window_samples > 0 THEN CAST($2 AS DOUBLE PRECISION)
+			ELSE s.window_seconds + CAST($1 AS DOUBLE PRECISION)
+		END * INTERVAL '1 second'`
+
+	result, err := d.DB.Exec(sql, lateness.Seconds(), idleTimeout.Seconds())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to expire moving average entries")
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to count expired moving average entries")
+	}
+
+	return int(count), nil
+}
+
 // MigrateUp is a convenience function to run all up migrations in the context
 // of an instantiated DB instance.
 func (d *DB) MigrateUp() error {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/google/uuid"
@@ -286,6 +287,374 @@ func (s *PostgresSuite) TestCertificates() {
 	assert.Equal(s.T(), autocert.ErrCacheMiss, err)
 }
 
+func (s *PostgresSuite) TestMovingAverage() {
+	// first value is returned as is
+	avg, _, err := s.db.MovingAverage(context.Background(), 4.5, time.Time{}, "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 4.5, avg)
+
+	avg, _, err = s.db.MovingAverage(context.Background(), 5.5, time.Time{}, "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 5.0, avg)
+
+	// different stream, sensor, device or interval are averaged separately
+	avg, _, err = s.db.MovingAverage(context.Background(), 9.0, time.Time{}, "stream2", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 9.0, avg)
+
+	avg, _, err = s.db.MovingAverage(context.Background(), 2.2, time.Time{}, "stream1", "abc123", 12, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2.2, avg)
+
+	avg, _, err = s.db.MovingAverage(context.Background(), 1.0, time.Time{}, "stream1", "def456", 55, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1.0, avg)
+
+	avg, _, err = s.db.MovingAverage(context.Background(), 3.0, time.Time{}, "stream1", "abc123", 55, uint32(3600), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3.0, avg)
+
+	avg, _, err = s.db.MovingAverage(context.Background(), 6.5, time.Time{}, "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 5.5, avg)
+
+	// entries persist across connections
+	s.db.Stop()
+	s.db.Start()
+
+	avg, _, err = s.db.MovingAverage(context.Background(), 7.5, time.Time{}, "stream1", "abc123", 55, uint32(900), 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 6.0, avg)
+}
+
+func (s *PostgresSuite) TestMovingAverageSamples() {
+	// sample windows keep the last N values
+	values := []float64{2, 4, 6, 8}
+	expected := []float64{2, 3, 4, 6}
+	counts := []int{1, 2, 3, 3}
+
+	for i, value := range values {
+		avg, count, err := s.db.MovingAverage(context.Background(), value, time.Time{}, "stream1", "abc123", 55, 0, 3, 0)
+		assert.Nil(s.T(), err)
+		assert.Equal(s.T(), expected[i], avg)
+		assert.Equal(s.T(), counts[i], count)
+	}
+
+	// hybrid windows average every value within the interval
+	expected = []float64{2, 3, 4, 5}
+
+	for i, value := range values {
+		avg, count, err := s.db.MovingAverage(context.Background(), value, time.Time{}, "stream1", "abc123", 55, 900, 3, 0)
+		assert.Nil(s.T(), err)
+		assert.Equal(s.T(), expected[i], avg)
+		assert.Equal(s.T(), i+1, count)
+	}
+}
+
+func (s *PostgresSuite) TestMovingAverageEventTime() {
+	ctx := context.Background()
+	now := time.Now()
+
+	// a burst of readings delivered late is averaged by when they were recorded
+	avg, count, err := s.db.MovingAverage(ctx, 2.0, now.Add(-30*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2.0, avg)
+	assert.Equal(s.T(), 1, count)
+
+	avg, count, err = s.db.MovingAverage(ctx, 4.0, now.Add(-20*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3.0, avg)
+	assert.Equal(s.T(), 2, count)
+
+	avg, count, err = s.db.MovingAverage(ctx, 6.0, now.Add(-10*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 5.0, avg)
+	assert.Equal(s.T(), 2, count)
+
+	// out of order readings within the allowed lateness are averaged over their
+	// own window
+	avg, count, err = s.db.MovingAverage(ctx, 8.0, now.Add(-13*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 6.0, avg)
+	assert.Equal(s.T(), 2, count)
+
+	// readings later than that are discarded
+	_, count, err = s.db.MovingAverage(ctx, 10.0, now.Add(-16*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+}
+
+func (s *PostgresSuite) TestDeleteStreamDeletesMovingAverages() {
+	ctx := context.Background()
+
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID: "policy-id",
+		PublicKey:   "public",
+		Device: &postgres.Device{
+			DeviceToken: "abc123",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	for _, streamID := range []string{stream.StreamID, "other-stream"} {
+		_, _, err = s.db.MovingAverage(ctx, 2.0, time.Time{}, streamID, "abc123", 55, 900, 0, 0)
+		assert.Nil(s.T(), err)
+	}
+
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	// the deleted stream's series starts afresh
+	avg, count, err := s.db.MovingAverage(ctx, 4.0, time.Time{}, stream.StreamID, "abc123", 55, 900, 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 4.0, avg)
+	assert.Equal(s.T(), 1, count)
+
+	// while other streams are untouched
+	avg, count, err = s.db.MovingAverage(ctx, 4.0, time.Time{}, "other-stream", "abc123", 55, 900, 0, 0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3.0, avg)
+	assert.Equal(s.T(), 2, count)
+}
+
+func (s *PostgresSuite) TestExpireMovingAverages() {
+	ctx := context.Background()
+	now := time.Now()
+
+	// an interval window whose latest value is older than its interval and
+	// lateness, and one which is still in use
+	_, _, err := s.db.MovingAverage(ctx, 2.0, now.Add(-30*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+
+	_, _, err = s.db.MovingAverage(ctx, 4.0, now.Add(-25*time.Minute), "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+
+	_, _, err = s.db.MovingAverage(ctx, 6.0, now.Add(-10*time.Minute), "stream1", "abc123", 12, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+
+	// and a sample window, which is kept until the idle timeout
+	_, _, err = s.db.MovingAverage(ctx, 8.0, now.Add(-2*time.Hour), "stream1", "abc123", 55, 0, 3, 5*time.Minute)
+	assert.Nil(s.T(), err)
+
+	expired, err := s.db.ExpireMovingAverages(5*time.Minute, 24*time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, expired)
+
+	// nothing more is expired until the idle timeout has passed
+	expired, err = s.db.ExpireMovingAverages(5*time.Minute, 24*time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, expired)
+
+	expired, err = s.db.ExpireMovingAverages(5*time.Minute, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, expired)
+
+	// the expired series starts afresh
+	avg, count, err := s.db.MovingAverage(ctx, 10.0, time.Time{}, "stream1", "abc123", 55, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 10.0, avg)
+	assert.Equal(s.T(), 1, count)
+
+	avg, count, err = s.db.MovingAverage(ctx, 10.0, time.Time{}, "stream1", "abc123", 12, 900, 0, 5*time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 8.0, avg)
+	assert.Equal(s.T(), 2, count)
+}
+
+func (s *PostgresSuite) TestSpend() {
+	ctx := context.Background()
+
+	stream, err := s.db.CreateStream(&postgres.Stream{
+		CommunityID:         "policy-id",
+		PublicKey:           "public",
+		PrivacyBudget:       1.0,
+		PrivacyBudgetPeriod: 3600,
+		Device: &postgres.Device{
+			DeviceToken: "123",
+		},
+	})
+	assert.Nil(s.T(), err)
+
+	ok, err := s.db.Spend(ctx, stream.StreamID, 0.4, 1.0, 3600)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), ok)
+
+	ok, err = s.db.Spend(ctx, stream.StreamID, 0.4, 1.0, 3600)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), ok)
+
+	// this would take us over budget so is refused, and nothing is spent
+	ok, err = s.db.Spend(ctx, stream.StreamID, 0.4, 1.0, 3600)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), ok)
+
+	ok, err = s.db.Spend(ctx, stream.StreamID, 0.2, 1.0, 3600)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), ok)
+
+	// spends persist across connections
+	s.db.Stop()
+	s.db.Start()
+
+	ok, err = s.db.Spend(ctx, stream.StreamID, 0.1, 1.0, 3600)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), ok)
+
+	// spends are deleted along with the stream
+	_, err = s.db.DeleteStream(stream)
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.Spend(ctx, stream.StreamID, 0.1, 1.0, 3600)
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestOutbox() {
+	// events enqueued with a lease aren't claimed until it has passed
+	first, err := s.db.EnqueueEvent(&postgres.OutboxEvent{
+		CommunityID: "community",
+		DeviceToken: "abc123",
+		Data:        []byte("first"),
+	}, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), int64(0), first.ID)
+
+	second, err := s.db.EnqueueEvent(&postgres.OutboxEvent{
+		CommunityID: "community",
+		DeviceToken: "abc123",
+		Data:        []byte("second"),
+	}, 0)
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.EnqueueEvent(&postgres.OutboxEvent{
+		CommunityID: "community",
+		DeviceToken: "abc123",
+		Data:        []byte("third"),
+	}, 0)
+	assert.Nil(s.T(), err)
+
+	events, err := s.db.ClaimEvents(10, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), events, 2)
+	assert.Equal(s.T(), second.ID, events[0].ID)
+	assert.Equal(s.T(), []byte("second"), events[0].Data)
+	assert.Equal(s.T(), 1, events[0].Attempts)
+	assert.Equal(s.T(), []byte("third"), events[1].Data)
+
+	// claimed events are leased so aren't claimed again
+	events, err = s.db.ClaimEvents(10, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), events, 0)
+
+	err = s.db.AcknowledgeEvent(second.ID)
+	assert.Nil(s.T(), err)
+
+	depth, age, err := s.db.OutboxStats()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, depth)
+	assert.True(s.T(), age >= 0)
+
+	// expiry deletes events older than the maximum age
+	expired, err := s.db.ExpireEvents(time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, expired)
+
+	expired, err = s.db.ExpireEvents(0)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, expired)
+
+	depth, age, err = s.db.OutboxStats()
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, depth)
+	assert.Equal(s.T(), time.Duration(0), age)
+}
+
+func (s *PostgresSuite) TestDeadLetters() {
+	first, err := s.db.SaveDeadLetter(&postgres.DeadLetter{
+		Topic:       "device/sck/abc123/readings",
+		DeviceToken: "abc123",
+		Payload:     []byte("not json"),
+		Stage:       "parse",
+		Error:       "failed to parse SmartCitizen data",
+	})
+	assert.Nil(s.T(), err)
+	assert.NotEqual(s.T(), int64(0), first.ID)
+	assert.False(s.T(), first.CreatedAt.IsZero())
+
+	second, err := s.db.SaveDeadLetter(&postgres.DeadLetter{
+		Topic:       "device/sck/abc123/readings",
+		DeviceToken: "abc123",
+		StreamID:    "def456",
+		Payload:     []byte(`{"data":[]}`),
+		Stage:       "encrypt",
+		Error:       "zenroom failed",
+	})
+	assert.Nil(s.T(), err)
+
+	letters, err := s.db.ListDeadLetters(10)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), letters, 2)
+	assert.Equal(s.T(), first.ID, letters[0].ID)
+	assert.Equal(s.T(), "", letters[0].StreamID)
+
+	letter, err := s.db.GetDeadLetter(second.ID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "def456", letter.StreamID)
+	assert.Equal(s.T(), "encrypt", letter.Stage)
+	assert.Equal(s.T(), []byte(`{"data":[]}`), letter.Payload)
+
+	err = s.db.DeleteDeadLetter(second.ID)
+	assert.Nil(s.T(), err)
+
+	_, err = s.db.GetDeadLetter(second.ID)
+	assert.NotNil(s.T(), err)
+
+	// purging only deletes letters created before the given time
+	purged, err := s.db.PurgeDeadLetters(first.CreatedAt)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, purged)
+
+	purged, err = s.db.PurgeDeadLetters(time.Now().Add(time.Minute))
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, purged)
+}
+
+func (s *PostgresSuite) TestSeen() {
+	ctx := context.Background()
+
+	seen, err := s.db.Seen(ctx, "abc123", "2018-12-11T14:46:44Z:1a2b", time.Hour)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), seen)
+
+	// a copy of the reading is seen within the horizon
+	seen, err = s.db.Seen(ctx, "abc123", "2018-12-11T14:46:44Z:1a2b", time.Hour)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), seen)
+
+	// the same key from a different device is a different reading
+	seen, err = s.db.Seen(ctx, "def456", "2018-12-11T14:46:44Z:1a2b", time.Hour)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), seen)
+
+	// once past the horizon the reading is forgotten
+	time.Sleep(10 * time.Millisecond)
+
+	seen, err = s.db.Seen(ctx, "abc123", "2018-12-11T14:46:44Z:1a2b", time.Millisecond)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), seen)
+
+	// a forgotten reading is no longer seen, while other devices' are
+	err = s.db.Forget(ctx, "abc123", "2018-12-11T14:46:44Z:1a2b")
+	assert.Nil(s.T(), err)
+
+	seen, err = s.db.Seen(ctx, "abc123", "2018-12-11T14:46:44Z:1a2b", time.Hour)
+	assert.Nil(s.T(), err)
+	assert.False(s.T(), seen)
+
+	seen, err = s.db.Seen(ctx, "def456", "2018-12-11T14:46:44Z:1a2b", time.Hour)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), seen)
+}
+
 func TestRunPostgresSuite(t *testing.T) {
 	suite.Run(t, new(PostgresSuite))
 }
@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Spend is a persistent implementation of the pipeline's PrivacyBudget
+// interface, recording the epsilon spent by each stream so that its budget
+// survives restarts and is shared between encoder instances. We lock the
+// stream's row so that concurrent spends for the stream are applied one at a
+// time, delete any spends older than the period, and then record the new
+// spend only if the total remains within the budget. The spend is committed
+// before we return, so a noised value is never released without having been
+// debited from the budget.
+func (d *DB) Spend(ctx context.Context, streamID string, epsilon, budget float64, period uint32) (_ bool, err error) {
+	mapArgs := map[string]interface{}{
+		"uuid":    streamID,
+		"epsilon": epsilon,
+		"period":  period,
+	}
+
+	tx, err := BeginTXContext(ctx, d.DB)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	defer func() {
+		if cerr := tx.CommitOrRollback(); err == nil && cerr != nil {
+			err = cerr
+		}
+	}()
+
+	sql := `SELECT id FROM streams WHERE uuid = :uuid FOR UPDATE`
+
+	var id int
+
+	err = tx.Get(&id, sql, mapArgs)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to lock stream")
+	}
+
+	mapArgs["stream_id"] = id
+
+	sql = `DELETE FROM privacy_budget_spends
+		WHERE stream_id = :stream_id
+		AND spent_at < NOW() - :period * INTERVAL '1 second'`
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete expired privacy budget spends")
+	}
+
+	sql = `SELECT COALESCE(SUM(epsilon), 0) FROM privacy_budget_spends
+		WHERE stream_id = :stream_id`
+
+	var spent float64
+
+	err = tx.Get(&spent, sql, mapArgs)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to total privacy budget spends")
+	}
+
+	if spent+epsilon > budget {
+		return false, nil
+	}
+
+	sql = `INSERT INTO privacy_budget_spends (stream_id, epsilon)
+		VALUES (:stream_id, :epsilon)`
+
+	err = tx.Exec(sql, mapArgs)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record privacy budget spend")
+	}
+
+	return true, nil
+}
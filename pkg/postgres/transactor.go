@@ -1,6 +1,7 @@
 package postgres
 
 import (
+	"context"
 	"sync"
 
 	"github.com/jmoiron/sqlx"
@@ -58,6 +59,22 @@ func BeginTX(db *sqlx.DB) (Transactor, error) {
 	}, nil
 }
 
+// BeginTXContext is a constructor function like BeginTX, but the returned
+// transaction is rolled back by the database driver if the given context is
+// done before the transaction is finalised.
+func BeginTXContext(ctx context.Context, db *sqlx.DB) (Transactor, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction for Transactor")
+	}
+
+	return &transactor{
+		tx:           tx,
+		finalised:    false,
+		finalisedErr: nil,
+	}, nil
+}
+
 // CommitOrRollback keeps track of whether the transaction experienced any
 // errors or not. If an error has not occurred then the transaction is rolled
 // back, else it is committed.
@@ -0,0 +1,109 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+)
+
+const (
+	// DeviceStage is the stage recorded for dead letters when we failed to load
+	// the device that sent the message
+	DeviceStage = "device"
+
+	// ProcessStage is the stage recorded for dead letters when the processor
+	// failed without saying which stage of the pipeline failed
+	ProcessStage = "process"
+)
+
+// Replayer is the interface we call to replay dead letters, which reports the
+// number of events written so that we know whether replaying achieved anything.
+type Replayer interface {
+	Replay(ctx context.Context, device *postgres.Device, payload []byte) (int, error)
+}
+
+// saveDeadLetters persists a message we failed to process so that it can be
+// replayed later. If the processor reports which streams failed we save a
+// letter for each of them, so that replaying a letter doesn't write again to
+// the streams which succeeded.
+func (e *encoderImpl) saveDeadLetters(topic, deviceToken string, payload []byte, stage string, err error) {
+	for _, letter := range deadLetters(topic, deviceToken, payload, stage, err) {
+		_, serr := e.db.SaveDeadLetter(letter)
+		if serr != nil {
+			e.logger.Log("err", serr, "msg", "failed to save dead letter", "topic", topic)
+		}
+	}
+}
+
+// deadLetters returns the dead letters describing the failure to process a
+// message.
+func deadLetters(topic, deviceToken string, payload []byte, stage string, err error) []*postgres.DeadLetter {
+	processErr, ok := err.(*pipeline.ProcessError)
+	if !ok {
+		return []*postgres.DeadLetter{
+			{
+				Topic:       topic,
+				DeviceToken: deviceToken,
+				Payload:     payload,
+				Stage:       stage,
+				Error:       err.Error(),
+			},
+		}
+	}
+
+	letters := make([]*postgres.DeadLetter, len(processErr.Streams))
+	for i, streamErr := range processErr.Streams {
+		letters[i] = &postgres.DeadLetter{
+			Topic:       topic,
+			DeviceToken: deviceToken,
+			StreamID:    streamErr.StreamID,
+			Payload:     payload,
+			Stage:       string(streamErr.Stage),
+			Error:       streamErr.Err.Error(),
+		}
+	}
+
+	return letters
+}
+
+// ReplayDeadLetter pushes a dead letter back through the processor, which will
+// normally be done once whatever caused the message to fail has been fixed. A
+// letter recorded for a single stream is only replayed to that stream. The
+// letter is deleted if it is processed successfully and something is written,
+// otherwise it is kept and an error returned, as readings which are now too late
+// to be included in windows are discarded without writing anything.
+func ReplayDeadLetter(ctx context.Context, db *postgres.DB, replayer Replayer, letter *postgres.DeadLetter) error {
+	device, err := db.GetDevice(letter.DeviceToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to get device")
+	}
+
+	if letter.StreamID != "" {
+		streams := []*postgres.Stream{}
+		for _, stream := range device.Streams {
+			if stream.StreamID == letter.StreamID {
+				streams = append(streams, stream)
+			}
+		}
+
+		if len(streams) == 0 {
+			return errors.Errorf("stream %s no longer exists", letter.StreamID)
+		}
+
+		device.Streams = streams
+	}
+
+	written, err := replayer.Replay(ctx, device, letter.Payload)
+	if err != nil {
+		return err
+	}
+
+	if written == 0 {
+		return errors.New("replaying wrote nothing, e.g. as its readings were too late to be included")
+	}
+
+	return db.DeleteDeadLetter(letter.ID)
+}
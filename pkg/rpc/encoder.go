@@ -9,65 +9,109 @@ import (
 	raven "github.com/getsentry/raven-go"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
-	encoder "github.com/thingful/twirp-encoder-go"
 	"github.com/twitchtv/twirp"
 
+	"github.com/DECODEproject/iotencoder/pkg/encoder"
 	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
 )
 
 // Processor is the interface we want to call to process incoming events. We
 // define it in this package where we need it.
 type Processor interface {
-	Process(device *postgres.Device, payload []byte) error
+	Process(ctx context.Context, device *postgres.Device, payload []byte) error
 }
 
 // encoderImpl is our implementation of the generated twirp interface for the
 // stream encoder.
 type encoderImpl struct {
+	ctx            context.Context
 	logger         kitlog.Logger
 	db             *postgres.DB
 	mqtt           mqtt.Client
+	queue          *mqtt.Queue
 	brokerAddr     string
 	brokerUsername string
 	processor      Processor
 	verbose        bool
 	topicPattern   *regexp.Regexp
+	sensors        *smartcitizen.Smartcitizen
+	operations     *pipeline.Registry
+	transformer    *pipeline.Transformer
 }
 
 // Config is a struct used to pass in configuration when creating the encoder
 type Config struct {
+	Context        context.Context
 	DB             *postgres.DB
 	MQTTClient     mqtt.Client
+	Queue          *mqtt.Queue
 	Processor      Processor
 	Verbose        bool
 	BrokerAddr     string
 	BrokerUsername string
+	Registry       *pipeline.Registry
+	Transformer    *pipeline.Transformer
 }
 
 // NewEncoder returns a newly instantiated Encoder instance. It takes as
 // parameters a DB connection string and a logger. The connection string is
-// passed down to the postgres package where it is used to connect.
+// passed down to the postgres package where it is used to connect. The registry
+// is used to validate requested operations, and if not supplied the built-in
+// operations are used. Similarly the transformer is used to validate scripts.
+// Incoming messages are processed within the given context, so cancelling it
+// abandons any processing in progress. If given a queue, received messages are
+// queued to be processed by its workers, otherwise they are processed as they
+// are received.
 func NewEncoder(config *Config, logger kitlog.Logger) encoder.Encoder {
 	logger = kitlog.With(logger, "module", "rpc")
 
+	ctx := config.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	registry := config.Registry
+	if registry == nil {
+		registry = pipeline.DefaultRegistry(&pipeline.Config{}, logger)
+	}
+
+	transformer := config.Transformer
+	if transformer == nil {
+		transformer = pipeline.NewTransformer(pipeline.DefaultScriptInstructions)
+	}
+
 	logger.Log("msg", "creating encoder")
 
 	return &encoderImpl{
+		ctx:            ctx,
 		logger:         logger,
 		db:             config.DB,
 		mqtt:           config.MQTTClient,
+		queue:          config.Queue,
 		processor:      config.Processor,
 		verbose:        config.Verbose,
 		brokerAddr:     config.BrokerAddr,
 		brokerUsername: config.BrokerUsername,
 		topicPattern:   regexp.MustCompile(`device/sck/(\w+)/readings`),
+		sensors:        &smartcitizen.Smartcitizen{},
+		operations:     registry,
+		transformer:    transformer,
 	}
 }
 
-// Start the encoder. Here we create MQTT subscriptions for all records stored
-// in the DB.
+// Start the encoder. Here we start the queue if we have one, and create MQTT
+// subscriptions for all records stored in the DB.
 func (e *encoderImpl) Start() error {
+	if e.queue != nil {
+		err := e.queue.Start(e.handleCallback)
+		if err != nil {
+			return errors.Wrap(err, "failed to start queue")
+		}
+	}
+
 	e.logger.Log("msg", "creating existing subscriptions")
 
 	devices, err := e.db.GetDevices()
@@ -86,9 +130,8 @@ func (e *encoderImpl) Start() error {
 			e.brokerAddr,
 			e.brokerUsername,
 			d.DeviceToken,
-			func(topic string, payload []byte) {
-				e.handleCallback(topic, payload)
-			})
+			e.receive,
+		)
 
 		if err != nil {
 			e.logger.Log("err", err, "msg", "failed to subscribe to topic")
@@ -98,11 +141,15 @@ func (e *encoderImpl) Start() error {
 	return nil
 }
 
-// Stop stops the encoder. Currently this is a NOOP, but keeping the function
-// for now.
+// Stop stops the encoder. If we have a queue we wait for it to drain, so the
+// MQTT client should be stopped first so that no more messages are received.
 func (e *encoderImpl) Stop() error {
 	e.logger.Log("msg", "stopping encoder")
 
+	if e.queue != nil {
+		return e.queue.Stop()
+	}
+
 	return nil
 }
 
@@ -115,7 +162,16 @@ func (e *encoderImpl) CreateStream(ctx context.Context, req *encoder.CreateStrea
 		return nil, err
 	}
 
-	stream, err := createStream(req, e.brokerAddr)
+	// scripts are run against a sample device so that we reject scripts which
+	// fail or are too slow before they are stored
+	if req.TransformScript != "" {
+		err = e.transformer.Validate(ctx, req.TransformScript)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("transform_script", err.Error())
+		}
+	}
+
+	stream, err := createStream(req, e.sensors, e.operations)
 	if err != nil {
 		return nil, err
 	}
@@ -130,9 +186,8 @@ func (e *encoderImpl) CreateStream(ctx context.Context, req *encoder.CreateStrea
 		e.brokerAddr,
 		e.brokerUsername,
 		req.DeviceToken,
-		func(topic string, payload []byte) {
-			e.handleCallback(topic, payload)
-		})
+		e.receive,
+	)
 
 	if err != nil {
 		raven.CaptureError(err, map[string]string{"operation": "createStream"})
@@ -177,10 +232,23 @@ func (e *encoderImpl) DeleteStream(ctx context.Context, req *encoder.DeleteStrea
 	return &encoder.DeleteStreamResponse{}, nil
 }
 
+// receive is the callback we subscribe with, which either queues the message
+// or processes it straight away if we have no queue.
+func (e *encoderImpl) receive(topic string, payload []byte) {
+	if e.queue != nil {
+		e.queue.Enqueue(topic, payload)
+		return
+	}
+
+	e.handleCallback(topic, payload)
+}
+
 // handleCallback is our internal function that receives incoming data from the
 // MQTT client. It loads the correct device from Postgres and then dispatches
 // processing to the pipeline module which is responsible for manipulating the
-// data and then writing to the datastore.
+// data and then writing to the datastore. Messages we fail to process are saved
+// as dead letters so that they can be replayed, including any abandoned when
+// the encoder's context is cancelled.
 func (e *encoderImpl) handleCallback(topic string, payload []byte) {
 	token, err := e.extractToken(topic)
 	if err != nil {
@@ -192,6 +260,7 @@ func (e *encoderImpl) handleCallback(topic string, payload []byte) {
 	if err != nil {
 		raven.CaptureError(err, map[string]string{"operation": "handleCallback"})
 		e.logger.Log("err", err, "msg", "failed to get device", "token", token)
+		e.saveDeadLetters(topic, token, payload, DeviceStage, err)
 		return
 	}
 
@@ -199,10 +268,11 @@ func (e *encoderImpl) handleCallback(topic string, payload []byte) {
 		e.logger.Log("topic", topic, "payload", string(payload), "msg", "received data")
 	}
 
-	err = e.processor.Process(device, payload)
+	err = e.processor.Process(e.ctx, device, payload)
 	if err != nil {
 		raven.CaptureError(err, map[string]string{"operation": "handleCallback"})
 		e.logger.Log("err", err, "msg", "failed to process payload")
+		e.saveDeadLetters(topic, token, payload, ProcessStage, err)
 	}
 }
 
@@ -246,17 +316,54 @@ func validateCreateRequest(req *encoder.CreateStreamRequest) error {
 		return twirp.InvalidArgumentError("latitude", "must be between -90 and 90")
 	}
 
+	if req.PrivacyBudget < 0 {
+		return twirp.InvalidArgumentError("privacy_budget", "must be greater than or equal to 0")
+	}
+
+	if req.PrivacyBudget > 0 {
+		if req.PrivacyBudgetPeriod == 0 {
+			return twirp.RequiredArgumentError("privacy_budget_period")
+		}
+
+		for _, op := range req.Operations {
+			if op.Action == encoder.CreateStreamRequest_Operation_NOISE && op.Epsilon > req.PrivacyBudget {
+				return twirp.InvalidArgumentError("operations", "noise epsilon must not exceed the privacy budget")
+			}
+		}
+	}
+
+	switch req.LocationPolicy {
+	case encoder.CreateStreamRequest_GRID:
+		if req.LocationGridSize == 0 {
+			return twirp.RequiredArgumentError("location_grid_size")
+		}
+	case encoder.CreateStreamRequest_GEOHASH:
+		if req.LocationGeohashPrecision == 0 {
+			return twirp.RequiredArgumentError("location_geohash_precision")
+		}
+
+		if req.LocationGeohashPrecision > 12 {
+			return twirp.InvalidArgumentError("location_geohash_precision", "must be between 1 and 12")
+		}
+	}
+
+	// we require the resolution to divide evenly into a day (86400 seconds) so
+	// that rounded timestamps line up with the same boundaries every day
+	if req.TimeResolution > 0 && 86400%req.TimeResolution != 0 {
+		return twirp.InvalidArgumentError("time_resolution", "must divide evenly into a day")
+	}
+
 	return nil
 }
 
 // createStream is a simple helper method that converts the incoming
 // CreateStreamRequest object into a *postgres.Stream instance ready to be
 // persisted to the DB.
-func createStream(req *encoder.CreateStreamRequest, brokerAddr string) (*postgres.Stream, error) {
+func createStream(req *encoder.CreateStreamRequest, sensors *smartcitizen.Smartcitizen, registry *pipeline.Registry) (*postgres.Stream, error) {
 	operations := []*postgres.Operation{}
 
 	for _, o := range req.Operations {
-		operation, err := createOperation(o)
+		operation, err := createOperation(o, sensors, registry)
 		if err != nil {
 			return nil, err
 		}
@@ -268,6 +375,19 @@ func createStream(req *encoder.CreateStreamRequest, brokerAddr string) (*postgre
 		CommunityID: req.CommunityId,
 		PublicKey:   req.RecipientPublicKey,
 		Operations:  operations,
+
+		PrivacyBudget:       req.PrivacyBudget,
+		PrivacyBudgetPeriod: req.PrivacyBudgetPeriod,
+
+		LocationPolicy:           postgres.LocationPolicy(req.LocationPolicy.String()),
+		LocationGridSize:         req.LocationGridSize,
+		LocationGeohashPrecision: req.LocationGeohashPrecision,
+
+		TimeResolution:   req.TimeResolution,
+		EmissionInterval: req.EmissionInterval,
+		BatchReadings:    req.BatchReadings,
+		TransformScript:  req.TransformScript,
+
 		Device: &postgres.Device{
 			DeviceToken: req.DeviceToken,
 			Label:       req.DeviceLabel,
@@ -278,38 +398,88 @@ func createStream(req *encoder.CreateStreamRequest, brokerAddr string) (*postgre
 	}, nil
 }
 
-func createOperation(op *encoder.CreateStreamRequest_Operation) (*postgres.Operation, error) {
-	if op.SensorId == 0 {
-		return nil, twirp.InvalidArgumentError("operations", "require a non-zero sensor id")
+// createOperation converts a requested operation into a *postgres.Operation,
+// which is validated by the operation registered for its action.
+func createOperation(op *encoder.CreateStreamRequest_Operation, sensors *smartcitizen.Smartcitizen, registry *pipeline.Registry) (*postgres.Operation, error) {
+	if op.SensorId != 0 && op.Measurement != "" {
+		return nil, twirp.InvalidArgumentError("operations", "require either a sensor id or a measurement, not both")
+	}
+
+	registered, ok := registry.Lookup(postgres.Action(op.Action.String()))
+	if !ok {
+		return nil, twirp.InvalidArgumentError("operations", fmt.Sprintf("unsupported action %s", op.Action))
 	}
 
-	switch op.Action {
-	case encoder.CreateStreamRequest_Operation_SHARE:
-		return &postgres.Operation{
-			SensorID: op.SensorId,
-			Action:   postgres.Action(op.Action.String()),
-		}, nil
-	case encoder.CreateStreamRequest_Operation_BIN:
-		if len(op.Bins) == 0 {
-			return nil, twirp.InvalidArgumentError("operations", "binning requires a non-empty list of bins")
+	operation := newOperation(op)
+
+	// sensor ids vary between kit versions so a measurement is resolved to a
+	// sensor as each reading is processed, but it must be one we know about.
+	// Sensors report some measurements in different units, so we pin the unit
+	// of the measurement to the one requested or that of the most preferred
+	// sensor, and only resolve it to sensors whose values can be shared in
+	// that unit. Conversions instead have a target unit, which they validate.
+	if op.Measurement != "" && op.Action != encoder.CreateStreamRequest_Operation_CONVERT {
+		unit := op.Unit
+
+		if unit == "" {
+			var err error
+
+			unit, err = sensors.MeasurementUnit(op.Measurement)
+			if err != nil {
+				return nil, twirp.InvalidArgumentError("operations", err.Error())
+			}
 		}
-		return &postgres.Operation{
-			SensorID: op.SensorId,
-			Action:   postgres.Action(op.Action.String()),
-			Bins:     op.Bins,
-		}, nil
-	case encoder.CreateStreamRequest_Operation_MOVING_AVG:
-		if op.Interval == 0 {
-			return nil, twirp.InvalidArgumentError("operations", "moving average requires a non-zero interval")
+
+		_, err := sensors.MeasurementSensors(op.Measurement, unit)
+		if err != nil {
+			return nil, twirp.InvalidArgumentError("operations", err.Error())
 		}
-		return &postgres.Operation{
-			SensorID: op.SensorId,
-			Action:   postgres.Action(op.Action.String()),
-			Interval: op.Interval,
-		}, nil
-	default:
-		return nil, twirp.InvalidArgumentError("operations", "foo")
+
+		operation.Unit = unit
+	}
+
+	err := registered.Validate(operation)
+	if err != nil {
+		return nil, twirp.InvalidArgumentError("operations", err.Error())
 	}
+
+	return operation, nil
+}
+
+// newOperation converts a requested operation into a *postgres.Operation for
+// its registered operation to validate. We copy every configurable attribute
+// as we don't know which the operation uses, except that enumerated attributes
+// are only copied if not the default, which the operations using them fill
+// in, so that they aren't recorded for operations which don't.
+func newOperation(op *encoder.CreateStreamRequest_Operation) *postgres.Operation {
+	operation := &postgres.Operation{
+		SensorID:    op.SensorId,
+		Measurement: op.Measurement,
+		Action:      postgres.Action(op.Action.String()),
+		Bins:        op.Bins,
+		Labels:      op.Labels,
+		Interval:    op.Interval,
+		Samples:     op.Samples,
+		Percentile:  op.Percentile,
+		Epsilon:     op.Epsilon,
+		Sensitivity: op.Sensitivity,
+		Delta:       op.Delta,
+		Upper:       op.Upper,
+		Lower:       op.Lower,
+		Hysteresis:  op.Hysteresis,
+		Unit:        op.Unit,
+		HalfLife:    op.HalfLife,
+	}
+
+	if op.Mechanism != encoder.CreateStreamRequest_Operation_LAPLACE {
+		operation.Mechanism = postgres.Mechanism(op.Mechanism.String())
+	}
+
+	if op.Index != encoder.CreateStreamRequest_Operation_US_EPA {
+		operation.Index = postgres.Index(op.Index.String())
+	}
+
+	return operation
 }
 
 // validateDeleteRequest validates incoming deletion requests (we just check for
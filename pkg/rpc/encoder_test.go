@@ -10,9 +10,10 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
-	encoder "github.com/thingful/twirp-encoder-go"
 
+	"github.com/DECODEproject/iotencoder/pkg/encoder"
 	"github.com/DECODEproject/iotencoder/pkg/mocks"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
 	"github.com/DECODEproject/iotencoder/pkg/rpc"
 	"github.com/DECODEproject/iotencoder/pkg/system"
@@ -376,7 +377,94 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 					},
 				},
 			},
-			expectedErr: "twirp error invalid_argument: operations require a non-zero sensor id",
+			expectedErr: "twirp error invalid_argument: operations require a non-zero sensor id or a measurement",
+		},
+		{
+			label: "operation with sensor id and measurement",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId:    12,
+						Measurement: "air temperature",
+						Action:      encoder.CreateStreamRequest_Operation_SHARE,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations require either a sensor id or a measurement, not both",
+		},
+		{
+			label: "operation with unknown measurement",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						Measurement: "happiness",
+						Action:      encoder.CreateStreamRequest_Operation_SHARE,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations unknown measurement happiness",
+		},
+		{
+			label: "operation with measurement in unknown unit",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						Measurement: "no2",
+						Unit:        "°C",
+						Action:      encoder.CreateStreamRequest_Operation_SHARE,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations no sensor reports measurement no2 in or convertible to °C",
+		},
+		{
+			label: "convert measurement from unconvertible sensors",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						Measurement: "no2",
+						Unit:        "mV",
+						Action:      encoder.CreateStreamRequest_Operation_CONVERT,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations unsupported source unit mV for sensor 61",
 		},
 		{
 			label: "bin with no bins",
@@ -418,7 +506,354 @@ func (e *EncoderTestSuite) TestCreateStreamInvalid() {
 					},
 				},
 			},
-			expectedErr: "twirp error invalid_argument: operations moving average requires a non-zero interval",
+			expectedErr: "twirp error invalid_argument: operations moving average requires a non-zero interval or number of samples",
+		},
+		{
+			label: "max no interval",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 13,
+						Action:   encoder.CreateStreamRequest_Operation_MAX,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations windowed operations require a non-zero interval or number of samples",
+		},
+		{
+			label: "percentile out of range",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId:   13,
+						Action:     encoder.CreateStreamRequest_Operation_PERCENTILE,
+						Interval:   900,
+						Percentile: 120,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations percentile must be greater than 0 and less than or equal to 100",
+		},
+		{
+			label: "noise with no epsilon",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId:    13,
+						Action:      encoder.CreateStreamRequest_Operation_NOISE,
+						Sensitivity: 1,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations noise requires a positive epsilon",
+		},
+		{
+			label: "privacy budget with no period",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure:      encoder.CreateStreamRequest_INDOOR,
+				PrivacyBudget: 1,
+			},
+			expectedErr: "twirp error invalid_argument: privacy_budget_period is required",
+		},
+		{
+			label: "grid location policy with no grid size",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure:       encoder.CreateStreamRequest_INDOOR,
+				LocationPolicy: encoder.CreateStreamRequest_GRID,
+			},
+			expectedErr: "twirp error invalid_argument: location_grid_size is required",
+		},
+		{
+			label: "geohash location policy with no precision",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure:       encoder.CreateStreamRequest_INDOOR,
+				LocationPolicy: encoder.CreateStreamRequest_GEOHASH,
+			},
+			expectedErr: "twirp error invalid_argument: location_geohash_precision is required",
+		},
+		{
+			label: "geohash location policy with invalid precision",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure:                 encoder.CreateStreamRequest_INDOOR,
+				LocationPolicy:           encoder.CreateStreamRequest_GEOHASH,
+				LocationGeohashPrecision: 13,
+			},
+			expectedErr: "twirp error invalid_argument: location_geohash_precision must be between 1 and 12",
+		},
+		{
+			label: "time resolution not dividing a day",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure:       encoder.CreateStreamRequest_INDOOR,
+				TimeResolution: 7000,
+			},
+			expectedErr: "twirp error invalid_argument: time_resolution must divide evenly into a day",
+		},
+		{
+			label: "threshold with inverted limits",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 13,
+						Action:   encoder.CreateStreamRequest_Operation_THRESHOLD,
+						Upper:    10,
+						Lower:    20,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations threshold requires an upper limit greater than the lower limit",
+		},
+		{
+			label: "threshold with excessive hysteresis",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId:   13,
+						Action:     encoder.CreateStreamRequest_Operation_THRESHOLD,
+						Upper:      40,
+						Lower:      20,
+						Hysteresis: 20,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations threshold hysteresis must be non-negative and less than the distance between the limits",
+		},
+		{
+			label: "conversion with no unit",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 12,
+						Action:   encoder.CreateStreamRequest_Operation_CONVERT,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations conversion requires a target unit",
+		},
+		{
+			label: "conversion between incompatible units",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 12,
+						Action:   encoder.CreateStreamRequest_Operation_CONVERT,
+						Unit:     "ppb",
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations unable to convert sensor 12 from °C to ppb",
+		},
+		{
+			label: "ewma with no half life",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 12,
+						Action:   encoder.CreateStreamRequest_Operation_EWMA,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations exponentially weighted moving average requires a non-zero half life",
+		},
+		{
+			label: "bins out of order",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 12,
+						Action:   encoder.CreateStreamRequest_Operation_BIN,
+						Bins:     []float64{10, 5},
+						Labels:   []string{"low", "medium", "high"},
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations binning requires bins in ascending order",
+		},
+		{
+			label: "wrong number of bin labels",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						SensorId: 12,
+						Action:   encoder.CreateStreamRequest_Operation_BIN,
+						Bins:     []float64{5, 10},
+						Labels:   []string{"low", "high"},
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations binning requires one more label than the number of bins",
+		},
+		{
+			label: "unknown air quality index",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						Action: encoder.CreateStreamRequest_Operation_AQI,
+						Index:  encoder.CreateStreamRequest_Operation_Index(5),
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations unknown air quality index 5",
+		},
+		{
+			label: "air quality index interval",
+			request: &encoder.CreateStreamRequest{
+				DeviceToken:        "abc123",
+				DeviceLabel:        "my sensor",
+				RecipientPublicKey: "pub_key",
+				CommunityId:        "policy-id",
+				Location: &encoder.CreateStreamRequest_Location{
+					Longitude: -0.024,
+					Latitude:  54.24,
+				},
+				Exposure: encoder.CreateStreamRequest_INDOOR,
+				Operations: []*encoder.CreateStreamRequest_Operation{
+					&encoder.CreateStreamRequest_Operation{
+						Action:   encoder.CreateStreamRequest_Operation_AQI,
+						Index:    encoder.CreateStreamRequest_Operation_EU_CAQI,
+						Interval: 1800,
+					},
+				},
+			},
+			expectedErr: "twirp error invalid_argument: operations EU CAQI requires an interval of 3600 or 86400 seconds",
 		},
 	}
 
@@ -511,6 +946,108 @@ func (e *EncoderTestSuite) TestSubscribeErrorContinues() {
 	assert.Nil(e.T(), err)
 }
 
+func (e *EncoderTestSuite) TestFailedMessagesAreDeadLettered() {
+	logger := kitlog.NewNopLogger()
+	mqttClient := mocks.NewMQTTClient(nil)
+
+	first, err := e.db.CreateStream(&postgres.Stream{
+		PublicKey:   "abc123",
+		CommunityID: "policy-id",
+		Device: &postgres.Device{
+			DeviceToken: "foo",
+			Longitude:   23,
+			Latitude:    45,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(e.T(), err)
+
+	_, err = e.db.CreateStream(&postgres.Stream{
+		PublicKey:   "abc123",
+		CommunityID: "policy-id-2",
+		Device: &postgres.Device{
+			DeviceToken: "foo",
+			Longitude:   23,
+			Latitude:    45,
+			Exposure:    "indoor",
+		},
+	})
+	assert.Nil(e.T(), err)
+
+	// only the first stream fails
+	processor := mocks.NewFailingProcessor(&pipeline.ProcessError{
+		Streams: []*pipeline.StreamError{
+			{
+				StreamID:    first.StreamID,
+				CommunityID: first.CommunityID,
+				Stage:       pipeline.EncryptStage,
+				Err:         errors.New("zenroom failed"),
+			},
+		},
+	})
+
+	enc := rpc.NewEncoder(&rpc.Config{
+		DB:             e.db,
+		MQTTClient:     mqttClient,
+		Processor:      processor,
+		Verbose:        true,
+		BrokerAddr:     "tcp://broker:1883",
+		BrokerUsername: "decode",
+	}, logger)
+
+	err = enc.(system.Startable).Start()
+	assert.Nil(e.T(), err)
+
+	mqttClient.Callbacks["foo"]("device/sck/foo/readings", []byte(`{"data":[]}`))
+
+	letters, err := e.db.ListDeadLetters(10)
+	assert.Nil(e.T(), err)
+	assert.Len(e.T(), letters, 1)
+	assert.Equal(e.T(), "device/sck/foo/readings", letters[0].Topic)
+	assert.Equal(e.T(), "foo", letters[0].DeviceToken)
+	assert.Equal(e.T(), first.StreamID, letters[0].StreamID)
+	assert.Equal(e.T(), "encrypt", letters[0].Stage)
+	assert.Equal(e.T(), "zenroom failed", letters[0].Error)
+	assert.Equal(e.T(), []byte(`{"data":[]}`), letters[0].Payload)
+
+	// replaying a letter which writes nothing keeps it
+	replayer := mocks.NewProcessor()
+
+	err = rpc.ReplayDeadLetter(context.Background(), e.db, replayer, letters[0])
+	assert.NotNil(e.T(), err)
+
+	letters, err = e.db.ListDeadLetters(10)
+	assert.Nil(e.T(), err)
+	assert.Len(e.T(), letters, 1)
+
+	// replaying the letter only processes the stream which failed, and deletes
+	// it once processed
+	replayer = mocks.NewProcessor()
+	replayer.Written = 1
+
+	err = rpc.ReplayDeadLetter(context.Background(), e.db, replayer, letters[0])
+	assert.Nil(e.T(), err)
+	assert.Len(e.T(), replayer.Devices, 1)
+	assert.Len(e.T(), replayer.Devices[0].Streams, 1)
+	assert.Equal(e.T(), first.StreamID, replayer.Devices[0].Streams[0].StreamID)
+
+	letters, err = e.db.ListDeadLetters(10)
+	assert.Nil(e.T(), err)
+	assert.Len(e.T(), letters, 0)
+
+	// messages from unknown devices are dead lettered for every stream
+	mqttClient.Callbacks["foo"]("device/sck/bar/readings", []byte(`{"data":[]}`))
+
+	letters, err = e.db.ListDeadLetters(10)
+	assert.Nil(e.T(), err)
+	assert.Len(e.T(), letters, 1)
+	assert.Equal(e.T(), rpc.DeviceStage, letters[0].Stage)
+	assert.Equal(e.T(), "", letters[0].StreamID)
+
+	err = rpc.ReplayDeadLetter(context.Background(), e.db, replayer, letters[0])
+	assert.NotNil(e.T(), err)
+}
+
 func TestRunEncoderTestSuite(t *testing.T) {
 	suite.Run(t, new(EncoderTestSuite))
 }
@@ -17,12 +17,12 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	registry "github.com/thingful/retryable-registry-prometheus"
 	datastore "github.com/thingful/twirp-datastore-go"
-	encoder "github.com/thingful/twirp-encoder-go"
 	goji "goji.io"
 	"goji.io/pat"
 	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/DECODEproject/iotencoder/pkg/clock"
+	"github.com/DECODEproject/iotencoder/pkg/encoder"
 	"github.com/DECODEproject/iotencoder/pkg/mqtt"
 	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
@@ -42,14 +42,40 @@ var (
 	)
 )
 
+const (
+	// MemoryStore is the value of MovingAvgStore or DedupStore which keeps
+	// state in memory, meaning it is lost when the process restarts
+	MemoryStore = "memory"
+
+	// PostgresStore is the value of MovingAvgStore or DedupStore which persists
+	// state in postgres
+	PostgresStore = "postgres"
+
+	// drainTimeout is how long we wait when stopping for queued messages to be
+	// processed before abandoning them
+	drainTimeout = 10 * time.Second
+)
+
 func init() {
 	registry.MustRegister(buildInfo)
 	registry.MustRegister(mqtt.MessageCounter)
+	registry.MustRegister(mqtt.QueueDepthGauge)
+	registry.MustRegister(mqtt.QueueDroppedCounter)
 	registry.MustRegister(pipeline.DatastoreErrorCounter)
 	registry.MustRegister(pipeline.ZenroomErrorCounter)
 	registry.MustRegister(pipeline.DatastoreWriteHistogram)
 	registry.MustRegister(pipeline.ProcessHistogram)
 	registry.MustRegister(pipeline.ZenroomHistogram)
+	registry.MustRegister(pipeline.MovingAverageKeysGauge)
+	registry.MustRegister(pipeline.MovingAverageEntriesGauge)
+	registry.MustRegister(pipeline.WorkersBusyGauge)
+	registry.MustRegister(pipeline.DuplicateReadingsCounter)
+	registry.MustRegister(pipeline.LateReadingsCounter)
+	registry.MustRegister(pipeline.DeduplicationKeysGauge)
+	registry.MustRegister(pipeline.StreamErrorCounter)
+	registry.MustRegister(pipeline.OutboxDepthGauge)
+	registry.MustRegister(pipeline.OutboxAgeGauge)
+	registry.MustRegister(pipeline.OutboxExpiredCounter)
 	registry.MustRegister(postgres.StreamGauge)
 }
 
@@ -64,17 +90,48 @@ type Config struct {
 	BrokerAddr         string
 	BrokerUsername     string
 	Domains            []string
+	MovingAvgStore     string
+	DedupStore         string
+	DedupHorizon       time.Duration
+	AllowedLateness    time.Duration
+	ScriptInstructions int
+	Workers            int
+	OutboxMaxAge       time.Duration
+	QueueSize          int
+	QueueWorkers       int
+	QueueOverflow      mqtt.OverflowPolicy
+	TransformTimeout   time.Duration
+	WriteTimeout       time.Duration
+}
+
+// memoryActions are the actions whose state is held in the memory of the
+// server, unless for moving averages the server is configured to persist them
+// in postgres.
+var memoryActions = map[postgres.Action]bool{
+	postgres.MovingAverage: true,
+	postgres.EWMA:          true,
+	postgres.Min:           true,
+	postgres.Max:           true,
+	postgres.Median:        true,
+	postgres.Percentile:    true,
+	postgres.Threshold:     true,
+	postgres.AQI:           true,
 }
 
 // Server is our top level type, contains all other components, is responsible
 // for starting and stopping them in the correct order.
 type Server struct {
-	srv     *http.Server
-	encoder encoder.Encoder
-	db      *postgres.DB
-	mqtt    mqtt.Client
-	logger  kitlog.Logger
-	domains []string
+	srv        *http.Server
+	cancel     context.CancelFunc
+	ctx        context.Context
+	encoder    encoder.Encoder
+	processor  *pipeline.Processor
+	db         *postgres.DB
+	mqtt       mqtt.Client
+	sweepers   []pipeline.Sweeper
+	dispatcher *pipeline.Dispatcher
+	logger     kitlog.Logger
+	domains    []string
 }
 
 // PulseHandler is the simplest possible handler function - used to expose an
@@ -93,33 +150,58 @@ func PulseHandler(db *postgres.DB) http.Handler {
 
 // NewServer returns a new simple HTTP server. Is also responsible for
 // constructing all components, and injecting them into the right place. This
-// perhaps belongs elsewhere, but leaving here for now.
+// perhaps belongs elsewhere, but leaving here for now. Incoming messages are
+// processed within a root context which is cancelled when the server stops.
 func NewServer(config *Config, logger kitlog.Logger) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	db := postgres.NewDB(&postgres.Config{
 		ConnStr:            config.ConnStr,
 		EncryptionPassword: config.EncryptionPassword,
 	}, logger)
 
-	ds := datastore.NewDatastoreProtobufClient(
-		config.DatastoreAddr,
-		&http.Client{
-			Timeout: time.Second * 10,
-		},
-	)
+	ds := NewDatastore(config.DatastoreAddr)
+
+	pipelineConfig, sweepers := NewPipelineConfig(config, db, ds, logger)
 
-	mv := pipeline.NewMovingAverager(config.Verbose, clock.New(), logger)
+	processor := pipeline.NewProcessor(pipelineConfig, logger)
 
-	processor := pipeline.NewProcessor(ds, mv, config.Verbose, logger)
+	// readings buffered by rate limited streams are written once due even if
+	// no further readings arrive to emit them
+	sweepers = append(sweepers, pipeline.NewFlusher(processor, logger))
+
+	// events are held in the outbox in postgres until written, and the
+	// dispatcher retries any the processor failed to write
+	dispatcher := pipeline.NewDispatcher(&pipeline.DispatcherConfig{
+		Outbox:       db,
+		Datastore:    ds,
+		MaxAge:       config.OutboxMaxAge,
+		WriteTimeout: config.WriteTimeout,
+		Verbose:      config.Verbose,
+	}, logger)
 
 	mqttClient := mqtt.NewClient(logger, config.Verbose)
 
+	// received messages are queued so that processing them doesn't hold up the
+	// mqtt client
+	queue := mqtt.NewQueue(&mqtt.QueueConfig{
+		Size:    config.QueueSize,
+		Workers: config.QueueWorkers,
+		Policy:  config.QueueOverflow,
+		Verbose: config.Verbose,
+	}, logger)
+
 	enc := rpc.NewEncoder(&rpc.Config{
+		Context:        ctx,
 		DB:             db,
 		MQTTClient:     mqttClient,
+		Queue:          queue,
 		Processor:      processor,
 		Verbose:        config.Verbose,
 		BrokerAddr:     config.BrokerAddr,
 		BrokerUsername: config.BrokerUsername,
+		Registry:       pipelineConfig.Registry,
+		Transformer:    pipelineConfig.Transformer,
 	}, logger)
 
 	hooks := twrpprom.NewServerHooks(registry.DefaultRegisterer)
@@ -157,13 +239,132 @@ func NewServer(config *Config, logger kitlog.Logger) *Server {
 
 	// return the instantiated server
 	return &Server{
-		srv:     srv,
-		encoder: enc,
-		db:      db,
-		mqtt:    mqttClient,
-		logger:  kitlog.With(logger, "module", "server"),
-		domains: config.Domains,
+		srv:        srv,
+		cancel:     cancel,
+		ctx:        ctx,
+		encoder:    enc,
+		processor:  processor,
+		db:         db,
+		mqtt:       mqttClient,
+		sweepers:   sweepers,
+		dispatcher: dispatcher,
+		logger:     kitlog.With(logger, "module", "server"),
+		domains:    config.Domains,
+	}
+}
+
+// NewDatastore returns a client for the datastore listening at the given
+// address.
+func NewDatastore(addr string) datastore.Datastore {
+	return datastore.NewDatastoreProtobufClient(
+		addr,
+		&http.Client{
+			Timeout: time.Second * 10,
+		},
+	)
+}
+
+// NewPipelineConfig constructs the components used by the processor, returning
+// the config from which the processor is created along with the sweepers
+// evicting state which is no longer being used. This is used by the server,
+// and by tasks which need to process payloads outside of it such as replaying
+// dead letters, which must first check streams with CheckReplayable as only
+// state persisted in postgres is shared with the server. Readings are only
+// deduplicated if the config has a positive horizon.
+func NewPipelineConfig(config *Config, db *postgres.DB, ds datastore.Datastore, logger kitlog.Logger) (*pipeline.Config, []pipeline.Sweeper) {
+	cl := clock.New()
+
+	// moving averages are held in memory unless configured to be persisted in
+	// postgres, which survives restarts and is shared between instances
+	var (
+		mv       pipeline.MovingAverager
+		sweepers []pipeline.Sweeper
+	)
+
+	if config.MovingAvgStore == PostgresStore {
+		mv = db
+		sweepers = append(sweepers, pipeline.NewMovingAverageSweeper(db, config.AllowedLateness, config.Verbose, logger))
+	} else {
+		mv = pipeline.NewMovingAverager(config.Verbose, cl, logger)
+		sweepers = append(sweepers, mv.(pipeline.Sweeper))
 	}
+
+	// similarly the readings we have seen are remembered in memory unless
+	// persisted in postgres, which lets instances discard each other's copies
+	var dd pipeline.Deduplicator
+
+	if config.DedupHorizon > 0 {
+		if config.DedupStore == PostgresStore {
+			dd = db
+		} else {
+			dd = pipeline.NewDeduplicator(config.Verbose, cl, logger)
+			sweepers = append(sweepers, dd.(pipeline.Sweeper))
+		}
+	}
+
+	ew := pipeline.NewExponentialAverager(config.Verbose, logger)
+
+	wd := pipeline.NewWindower(config.Verbose, cl, logger)
+	sweepers = append(sweepers, wd.(pipeline.Sweeper))
+
+	th := pipeline.NewThresholder(config.Verbose, logger)
+
+	em := pipeline.NewEmitter(config.Verbose, cl, logger)
+
+	// privacy budgets are always persisted in the database, as forgetting what
+	// was spent on restart would let streams release more than their budget
+	pipelineConfig := &pipeline.Config{
+		Datastore:           ds,
+		MovingAverager:      mv,
+		ExponentialAverager: ew,
+		Windower:            wd,
+		NoiseGenerator:      pipeline.NewNoiseGenerator(pipeline.NewCryptoSource()),
+		PrivacyBudget:       db,
+		Thresholder:         th,
+		Emitter:             em,
+		Devices:             db,
+		Transformer:         pipeline.NewTransformer(config.ScriptInstructions),
+		WorkerPool:          pipeline.NewWorkerPool(config.Workers),
+		Outbox:              db,
+		Deduplicator:        dd,
+		DedupHorizon:        config.DedupHorizon,
+		AllowedLateness:     config.AllowedLateness,
+		TransformTimeout:    config.TransformTimeout,
+		WriteTimeout:        config.WriteTimeout,
+		Verbose:             config.Verbose,
+	}
+
+	// the registry of operations is shared so that the operations validated by
+	// the encoder are the same as those applied by the processor, and any
+	// additional operations should be registered here
+	pipelineConfig.Registry = pipeline.DefaultRegistry(pipelineConfig, logger)
+
+	return pipelineConfig, sweepers
+}
+
+// CheckReplayable returns an error if the stream can't be processed outside of
+// the server with the given config, e.g. when replaying dead letters, because
+// its operations rely on state the server holds in its own memory. A separate
+// process would apply these operations to fresh state, so would write windows,
+// averages and threshold crossings computed over the replayed readings alone.
+// Moving averages persisted in postgres and privacy budgets are shared with
+// the server, so streams only using these may be replayed.
+func CheckReplayable(config *Config, stream *postgres.Stream) error {
+	if stream.EmissionInterval > 0 {
+		return errors.Errorf("stream %s can't be replayed as its emissions are held in the server's memory", stream.StreamID)
+	}
+
+	for _, operation := range stream.Operations {
+		if operation.Action == postgres.MovingAverage && config.MovingAvgStore == PostgresStore {
+			continue
+		}
+
+		if memoryActions[operation.Action] {
+			return errors.Errorf("stream %s can't be replayed as the state of its %s operation is held in the server's memory", stream.StreamID, operation.Action)
+		}
+	}
+
+	return nil
 }
 
 // Start starts the server running. This is responsible for starting components
@@ -185,6 +386,20 @@ func (s *Server) Start() error {
 		return errors.Wrap(err, "failed to migrate the database")
 	}
 
+	// start evicting unused in-memory state if configured
+	for _, sweeper := range s.sweepers {
+		err = sweeper.Start()
+		if err != nil {
+			return errors.Wrap(err, "failed to start sweeper")
+		}
+	}
+
+	// start retrying any events left in the outbox
+	err = s.dispatcher.Start()
+	if err != nil {
+		return errors.Wrap(err, "failed to start outbox dispatcher")
+	}
+
 	// start the encoder RPC component - this creates all mqtt subscriptions
 	err = s.encoder.(system.Startable).Start()
 	if err != nil {
@@ -229,15 +444,43 @@ func (s *Server) Start() error {
 // Stop the server and all child components
 func (s *Server) Stop() error {
 	s.logger.Log("msg", "stopping")
-	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancelFn()
 
-	err := s.encoder.(system.Stoppable).Stop()
+	// stop receiving messages before draining those already queued
+	err := s.mqtt.(system.Stoppable).Stop()
 	if err != nil {
 		return err
 	}
 
-	err = s.mqtt.(system.Stoppable).Stop()
+	// queued messages are given a while to be processed, after which any still
+	// queued or being processed are abandoned and saved as dead letters
+	drain := time.AfterFunc(drainTimeout, s.cancel)
+
+	err = s.encoder.(system.Stoppable).Stop()
+
+	// readings buffered by rate limited streams are only held in memory, so
+	// are written within the same deadline before we abandon processing
+	if err == nil {
+		ferr := s.processor.FlushAll(s.ctx)
+		if ferr != nil {
+			s.logger.Log("err", ferr, "msg", "failed to flush buffered readings")
+		}
+	}
+
+	drain.Stop()
+	s.cancel()
+
+	if err != nil {
+		return err
+	}
+
+	for _, sweeper := range s.sweepers {
+		err = sweeper.Stop()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.dispatcher.Stop()
 	if err != nil {
 		return err
 	}
@@ -247,6 +490,9 @@ func (s *Server) Stop() error {
 		return err
 	}
 
+	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFn()
+
 	return s.srv.Shutdown(ctx)
 }
 
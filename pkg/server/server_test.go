@@ -41,3 +41,77 @@ func TestPulseHandler(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
+
+func TestCheckReplayable(t *testing.T) {
+	testcases := []struct {
+		label          string
+		movingAvgStore string
+		stream         *postgres.Stream
+		expectedErr    string
+	}{
+		{
+			label:          "stateless operations",
+			movingAvgStore: server.MemoryStore,
+			stream: &postgres.Stream{
+				StreamID: "abc123",
+				Operations: postgres.Operations{
+					{SensorID: 12, Action: postgres.Share},
+					{SensorID: 13, Action: postgres.Bin, Bins: []float64{10, 20}},
+				},
+			},
+		},
+		{
+			label:          "persisted moving average",
+			movingAvgStore: server.PostgresStore,
+			stream: &postgres.Stream{
+				StreamID: "abc123",
+				Operations: postgres.Operations{
+					{SensorID: 12, Action: postgres.MovingAverage, Interval: 900},
+				},
+			},
+		},
+		{
+			label:          "moving average in memory",
+			movingAvgStore: server.MemoryStore,
+			stream: &postgres.Stream{
+				StreamID: "abc123",
+				Operations: postgres.Operations{
+					{SensorID: 12, Action: postgres.MovingAverage, Interval: 900},
+				},
+			},
+			expectedErr: "stream abc123 can't be replayed as the state of its MOVING_AVG operation is held in the server's memory",
+		},
+		{
+			label:          "threshold",
+			movingAvgStore: server.PostgresStore,
+			stream: &postgres.Stream{
+				StreamID: "abc123",
+				Operations: postgres.Operations{
+					{SensorID: 12, Action: postgres.Share},
+					{SensorID: 13, Action: postgres.Threshold},
+				},
+			},
+			expectedErr: "stream abc123 can't be replayed as the state of its THRESHOLD operation is held in the server's memory",
+		},
+		{
+			label:          "emission interval",
+			movingAvgStore: server.PostgresStore,
+			stream: &postgres.Stream{
+				StreamID:         "abc123",
+				EmissionInterval: 900,
+			},
+			expectedErr: "stream abc123 can't be replayed as its emissions are held in the server's memory",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			err := server.CheckReplayable(&server.Config{MovingAvgStore: tc.movingAvgStore}, tc.stream)
+			if tc.expectedErr == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tc.expectedErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package smartcitizen
 
 import (
 	"encoding/json"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 	null "gopkg.in/guregu/null.v3"
@@ -10,12 +12,22 @@ import (
 // SensorMetadata is a type we use to parse the raw sensor metadata json published by
 // SmartCitizen.
 type SensorMetadata struct {
-	ID          int         `json:"id"`
-	UUID        string      `json:"uuid"`
-	ParentID    null.Int    `json:"parent_id"`
-	Name        string      `json:"name"`
-	Description string      `json:"description"`
-	Unit        null.String `json:"unit"`
+	ID          int          `json:"id"`
+	UUID        string       `json:"uuid"`
+	ParentID    null.Int     `json:"parent_id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Unit        null.String  `json:"unit"`
+	Measurement *Measurement `json:"measurement"`
+}
+
+// Measurement is a type we use to parse the description of the physical
+// quantity a sensor measures, which SmartCitizen publishes as part of the
+// sensor metadata.
+type Measurement struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
 }
 
 // ReadMetadata is a function that returns a map of SensorMetadata instances read
@@ -42,3 +54,42 @@ func ReadMetadata() (map[int]SensorMetadata, error) {
 
 	return sensors, nil
 }
+
+// NormaliseMeasurement returns the key under which we index a measurement name,
+// ignoring case and whitespace so that e.g. `PM2.5` and `PM 2.5` are the same.
+func NormaliseMeasurement(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
+
+// IndexMeasurements returns a map from normalised measurement names to the ids
+// of the sensors reporting them, in order of preference. Sensors which are the
+// parent of other sensors describe a board or component rather than a reading,
+// so are excluded. Where several sensors report the same measurement we prefer
+// the highest id, as SmartCitizen gives newer and derived sensors (e.g. the
+// averaged PM readings and calibrated gas concentrations) higher ids.
+func IndexMeasurements(sensors map[int]SensorMetadata) map[string][]int {
+	parents := map[int]bool{}
+
+	for _, sensor := range sensors {
+		if sensor.ParentID.Valid {
+			parents[int(sensor.ParentID.Int64)] = true
+		}
+	}
+
+	measurements := map[string][]int{}
+
+	for _, sensor := range sensors {
+		if sensor.Measurement == nil || parents[sensor.ID] {
+			continue
+		}
+
+		key := NormaliseMeasurement(sensor.Measurement.Name)
+		measurements[key] = append(measurements[key], sensor.ID)
+	}
+
+	for _, ids := range measurements {
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	}
+
+	return measurements
+}
@@ -1,12 +1,16 @@
 package smartcitizen
 
-import "time"
+import (
+	"time"
+
+	null "gopkg.in/guregu/null.v3"
+)
 
 // RawSensor is a type used when parsing the actual payload published by
-// Smartcitizen
+// Smartcitizen. Sensors which failed to take a reading report a null value.
 type RawSensor struct {
-	ID    int     `json:"id"`
-	Value float64 `json:"value"`
+	ID    int        `json:"id"`
+	Value null.Float `json:"value"`
 }
 
 // SensorData is a type used when parsing the actual payload published by
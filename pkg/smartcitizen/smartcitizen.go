@@ -2,12 +2,14 @@ package smartcitizen
 
 import (
 	"encoding/json"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	encoder "github.com/thingful/twirp-encoder-go"
 	"gopkg.in/guregu/null.v3"
 
+	"github.com/DECODEproject/iotencoder/pkg/encoder"
 	"github.com/DECODEproject/iotencoder/pkg/postgres"
 )
 
@@ -20,21 +22,42 @@ type Sensor struct {
 	Unit        *null.String    `json:"unit,omitempty"`
 	Action      postgres.Action `json:"type"`
 	Interval    *null.Int       `json:"interval,omitempty"`
+	HalfLife    *null.Int       `json:"halfLife,omitempty"`
+	Samples     *null.Int       `json:"samples,omitempty"`
+	Percentile  *null.Float     `json:"percentile,omitempty"`
+	Epsilon     *null.Float     `json:"epsilon,omitempty"`
+	Sensitivity *null.Float     `json:"sensitivity,omitempty"`
+	Upper       *null.Float     `json:"upper,omitempty"`
+	Lower       *null.Float     `json:"lower,omitempty"`
+	State       string          `json:"state,omitempty"`
+	Category    string          `json:"category,omitempty"`
 	Value       *null.Float     `json:"value,omitempty"`
 	Bins        []float64       `json:"bins,omitempty"`
+	Labels      []string        `json:"labels,omitempty"`
 	Values      []int           `json:"values,omitempty"`
+	Readings    int             `json:"readings,omitempty"`
 }
 
 // Device is a type used when we marshal the enriched data to write to the
-// datastore.
+// datastore. The location fields are pointers so that a stream's location
+// policy is able to omit them entirely. TimeResolution is the precision in
+// seconds of RecordedAt if it has been coarsened by the stream.
 type Device struct {
-	Token      string    `json:"token"`
-	Label      string    `json:"label"`
-	Longitude  float64   `json:"longitude"`
-	Latitude   float64   `json:"latitude"`
-	Exposure   string    `json:"exposure"`
-	RecordedAt time.Time `json:"recordedAt"`
-	Sensors    []*Sensor `json:"sensors"`
+	Token          string      `json:"token"`
+	Label          string      `json:"label"`
+	Longitude      *null.Float `json:"longitude,omitempty"`
+	Latitude       *null.Float `json:"latitude,omitempty"`
+	Geohash        string      `json:"geohash,omitempty"`
+	Exposure       string      `json:"exposure"`
+	RecordedAt     time.Time   `json:"recordedAt"`
+	TimeResolution *null.Int   `json:"timeResolution,omitempty"`
+	Sensors        []*Sensor   `json:"sensors"`
+}
+
+// Batch is a type used when a stream writes all of the readings from a single
+// payload to the datastore as one event.
+type Batch struct {
+	Readings []*Device `json:"readings"`
 }
 
 // FindSensor is a helper function that either returns a sensor pointer from our
@@ -51,24 +74,167 @@ func (d *Device) FindSensor(id int) *Sensor {
 // Smartcitizen is our type that holds the map of sensor metadata, and is able
 // to use this state to enrich an incoming payload.
 type Smartcitizen struct {
+	once           sync.Once
 	sensorMetadata map[int]SensorMetadata
+	measurements   map[string][]int
+	metadataErr    error
+}
+
+// metadata returns our map of sensor metadata, reading it on first use.
+func (s *Smartcitizen) metadata() (map[int]SensorMetadata, error) {
+	s.once.Do(func() {
+		s.sensorMetadata, s.metadataErr = ReadMetadata()
+		if s.metadataErr == nil {
+			s.measurements = IndexMeasurements(s.sensorMetadata)
+		}
+	})
+
+	if s.metadataErr != nil {
+		return nil, errors.Wrap(s.metadataErr, "failed to read sensor metadata")
+	}
+
+	return s.sensorMetadata, nil
+}
+
+// Conversion returns a Conversion able to convert values of the sensor
+// identified by the given id into the target unit, or an error if the sensor
+// is unknown or no such conversion is possible.
+func (s *Smartcitizen) Conversion(sensorID int, target string) (*Conversion, error) {
+	sensorMetadata, err := s.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, ok := sensorMetadata[sensorID]
+	if !ok {
+		return nil, errors.Errorf("unknown sensor %v", sensorID)
+	}
+
+	return FindConversion(metadata, target)
+}
+
+// MeasurementSensors returns the ids of the sensors reporting the given
+// measurement in order of preference, or an error if no known sensor reports
+// it. Sensors report the same measurement in different units, e.g. NO2 as a
+// raw voltage or a calibrated concentration, so if a unit is given only the
+// sensors whose unit matches or converts to it are returned.
+func (s *Smartcitizen) MeasurementSensors(measurement, unit string) ([]int, error) {
+	sensorMetadata, err := s.metadata()
+	if err != nil {
+		return nil, err
+	}
+
+	ids, ok := s.measurements[NormaliseMeasurement(measurement)]
+	if !ok {
+		return nil, errors.Errorf("unknown measurement %s", measurement)
+	}
+
+	if unit == "" {
+		return ids, nil
+	}
+
+	matching := []int{}
+
+	for _, id := range ids {
+		if convertible(sensorMetadata[id], unit) {
+			matching = append(matching, id)
+		}
+	}
+
+	if len(matching) == 0 {
+		return nil, errors.Errorf("no sensor reports measurement %s in or convertible to %s", measurement, unit)
+	}
+
+	return matching, nil
+}
+
+// MeasurementUnit returns the unit of the most preferred sensor reporting the
+// given measurement, or an error if no known sensor reports it.
+func (s *Smartcitizen) MeasurementUnit(measurement string) (string, error) {
+	ids, err := s.MeasurementSensors(measurement, "")
+	if err != nil {
+		return "", err
+	}
+
+	return s.sensorMetadata[ids[0]].Unit.String, nil
+}
+
+// FindMeasurement returns the sensor of the device which reports the given
+// measurement, or nil if none of the device's sensors report it. If several
+// do, the most preferred as described by IndexMeasurements is returned. If a
+// unit is given only sensors whose unit matches or converts to it are
+// considered, and the sensor is returned as reported so the caller must
+// convert its value if required.
+func (s *Smartcitizen) FindMeasurement(device *Device, measurement, unit string) (*Sensor, error) {
+	ids, err := s.MeasurementSensors(measurement, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		sensor := device.FindSensor(id)
+		if sensor != nil {
+			return sensor, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// ConvertSensor returns a copy of the sensor with its value converted into the
+// given unit, or the sensor itself if it is already reported in that unit. An
+// error is returned if no such conversion is possible.
+func (s *Smartcitizen) ConvertSensor(sensor *Sensor, unit string) (*Sensor, error) {
+	if sensor.Unit != nil && SameUnit(sensor.Unit.String, unit) {
+		return sensor, nil
+	}
+
+	conversion, err := s.Conversion(sensor.ID, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	converted := *sensor
+
+	to := null.StringFrom(conversion.To)
+	converted.Unit = &to
+
+	// a null value stays null rather than becoming the conversion of zero
+	if sensor.Value != nil && sensor.Value.Valid {
+		value := null.FloatFrom(conversion.Convert(sensor.Value.Float64))
+		converted.Value = &value
+	}
+
+	return &converted, nil
+}
+
+// convertible returns true if the sensor described by the given metadata
+// reports values in the given unit, or in a unit which converts to it.
+func convertible(metadata SensorMetadata, unit string) bool {
+	if SameUnit(metadata.Unit.String, unit) {
+		return true
+	}
+
+	_, err := FindConversion(metadata, unit)
+
+	return err == nil
 }
 
 // ParseData is our main public function, that takes in the device
 // representation from our database and the bytes of the payload. It then parses
 // this payload into an internal representation, which we then enrich using the
-// metadata, before returning an object containing the additional richer data.
-func (s *Smartcitizen) ParseData(device *postgres.Device, payload []byte) (*Device, error) {
-	if s.sensorMetadata == nil {
-		sensorMetadata, err := ReadMetadata()
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to read sensor metadata")
-		}
-		s.sensorMetadata = sensorMetadata
+// metadata, before returning objects containing the additional richer data. A
+// payload may contain several readings, e.g. when a device publishes readings
+// it buffered while offline, so we return one Device per reading sorted by the
+// time at which they were recorded.
+func (s *Smartcitizen) ParseData(device *postgres.Device, payload []byte) ([]*Device, error) {
+	sensorMetadata, err := s.metadata()
+	if err != nil {
+		return nil, err
 	}
 
 	var p Payload
-	err := json.Unmarshal(payload, &p)
+	err = json.Unmarshal(payload, &p)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to unmarshal raw payload")
 	}
@@ -77,37 +243,48 @@ func (s *Smartcitizen) ParseData(device *postgres.Device, payload []byte) (*Devi
 		return nil, errors.New("missing data from payload")
 	}
 
-	data := p.Data[0]
+	devices := []*Device{}
 
-	d := &Device{
-		Token:      device.DeviceToken,
-		Label:      device.Label,
-		Longitude:  device.Longitude,
-		Latitude:   device.Latitude,
-		Exposure:   device.Exposure,
-		RecordedAt: data.RecordedAt,
-		Sensors:    []*Sensor{},
-	}
+	for _, data := range p.Data {
+		longitude := null.FloatFrom(device.Longitude)
+		latitude := null.FloatFrom(device.Latitude)
 
-	for _, rawSensor := range data.Sensors {
-		metadata, ok := s.sensorMetadata[rawSensor.ID]
-		if !ok {
-			continue
+		d := &Device{
+			Token:      device.DeviceToken,
+			Label:      device.Label,
+			Longitude:  &longitude,
+			Latitude:   &latitude,
+			Exposure:   device.Exposure,
+			RecordedAt: data.RecordedAt,
+			Sensors:    []*Sensor{},
 		}
 
-		value := null.FloatFrom(rawSensor.Value)
+		for _, rawSensor := range data.Sensors {
+			metadata, ok := sensorMetadata[rawSensor.ID]
+			if !ok {
+				continue
+			}
+
+			value := rawSensor.Value
 
-		sensor := &Sensor{
-			ID:          rawSensor.ID,
-			Name:        metadata.Name,
-			Description: metadata.Description,
-			Value:       &value,
-			Action:      postgres.Action(encoder.CreateStreamRequest_Operation_SHARE.String()),
-			Unit:        &metadata.Unit,
+			sensor := &Sensor{
+				ID:          rawSensor.ID,
+				Name:        metadata.Name,
+				Description: metadata.Description,
+				Value:       &value,
+				Action:      postgres.Action(encoder.CreateStreamRequest_Operation_SHARE.String()),
+				Unit:        &metadata.Unit,
+			}
+
+			d.Sensors = append(d.Sensors, sensor)
 		}
 
-		d.Sensors = append(d.Sensors, sensor)
+		devices = append(devices, d)
 	}
 
-	return d, nil
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].RecordedAt.Before(devices[j].RecordedAt)
+	})
+
+	return devices, nil
 }
@@ -32,11 +32,14 @@ func TestParseData(t *testing.T) {
 	unit2 := null.StringFrom("Lux")
 	value2 := null.FloatFrom(23.2)
 
+	longitude := null.FloatFrom(12)
+	latitude := null.FloatFrom(12)
+
 	expected := &smartcitizen.Device{
 		Token:      "abc123",
 		Label:      "my sensor",
-		Longitude:  12,
-		Latitude:   12,
+		Longitude:  &longitude,
+		Latitude:   &latitude,
 		Exposure:   "INDOOR",
 		RecordedAt: time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC),
 		Sensors: []*smartcitizen.Sensor{
@@ -61,7 +64,61 @@ func TestParseData(t *testing.T) {
 
 	got, err := s.ParseData(device, payload)
 	assert.Nil(t, err)
-	assert.Equal(t, expected, got)
+	assert.Equal(t, []*smartcitizen.Device{expected}, got)
+}
+
+func TestParseDataMultipleReadings(t *testing.T) {
+	device := &postgres.Device{
+		DeviceToken: "abc123",
+		Label:       "my sensor",
+		Longitude:   12,
+		Latitude:    12,
+		Exposure:    "INDOOR",
+	}
+
+	// readings buffered while offline may not be in order
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-01T10:02:00Z","sensors":[{"id":12,"value":12.5}]},{"recorded_at":"2018-12-01T10:00:00Z","sensors":[{"id":12,"value":12.3}]},{"recorded_at":"2018-12-01T10:01:00Z","sensors":[{"id":12,"value":12.4}]}]}`)
+
+	s := smartcitizen.Smartcitizen{}
+
+	got, err := s.ParseData(device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, got, 3)
+
+	expected := []struct {
+		recordedAt time.Time
+		value      float64
+	}{
+		{recordedAt: time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC), value: 12.3},
+		{recordedAt: time.Date(2018, 12, 1, 10, 1, 0, 0, time.UTC), value: 12.4},
+		{recordedAt: time.Date(2018, 12, 1, 10, 2, 0, 0, time.UTC), value: 12.5},
+	}
+
+	for i, e := range expected {
+		assert.Equal(t, e.recordedAt, got[i].RecordedAt)
+		assert.Len(t, got[i].Sensors, 1)
+		assert.Equal(t, e.value, got[i].Sensors[0].Value.Float64)
+	}
+}
+
+func TestParseDataNullValue(t *testing.T) {
+	device := &postgres.Device{
+		DeviceToken: "abc123",
+	}
+
+	// sensors which failed to take a reading report null rather than zero
+	payload := []byte(`{"data":[{"recorded_at":"2018-12-01T10:00:00Z","sensors":[{"id":12,"value":null},{"id":14,"value":0}]}]}`)
+
+	s := smartcitizen.Smartcitizen{}
+
+	got, err := s.ParseData(device, payload)
+	assert.Nil(t, err)
+	assert.Len(t, got, 1)
+	assert.Len(t, got[0].Sensors, 2)
+
+	assert.False(t, got[0].Sensors[0].Value.Valid)
+	assert.True(t, got[0].Sensors[1].Value.Valid)
+	assert.Equal(t, 0.0, got[0].Sensors[1].Value.Float64)
 }
 
 func TestMarshalling(t *testing.T) {
@@ -85,6 +142,104 @@ func TestFindSensor(t *testing.T) {
 	assert.NotNil(t, sensor)
 }
 
+func TestMeasurementSensors(t *testing.T) {
+	s := smartcitizen.Smartcitizen{}
+
+	// the parent ultrasonic ranger sensor is excluded
+	ids, err := s.MeasurementSensors("air temperature", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{55, 12, 4}, ids)
+
+	ids, err = s.MeasurementSensors("PM2.5", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{87, 75, 71, 34, 33}, ids)
+
+	_, err = s.MeasurementSensors("happiness", "")
+	assert.NotNil(t, err)
+}
+
+func TestFindMeasurement(t *testing.T) {
+	s := smartcitizen.Smartcitizen{}
+
+	// temperature is sensor 12 on an SCK 1.5
+	sensor, err := s.FindMeasurement(buildDevice(t), "Air Temperature", "")
+	assert.Nil(t, err)
+	assert.NotNil(t, sensor)
+	assert.Equal(t, 12, sensor.ID)
+
+	sensor, err = s.FindMeasurement(buildDevice(t), "no2", "")
+	assert.Nil(t, err)
+	assert.Nil(t, sensor)
+}
+
+func TestMeasurementUnits(t *testing.T) {
+	s := smartcitizen.Smartcitizen{}
+
+	// no2 is reported as raw voltages and resistances as well as calibrated
+	// concentrations, so without a unit we resolve to any of them
+	ids, err := s.MeasurementSensors("no2", "")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{83, 81, 61, 32, 30, 27, 22, 15, 8}, ids)
+
+	unit, err := s.MeasurementUnit("no2")
+	assert.Nil(t, err)
+	assert.Equal(t, "ppb", unit)
+
+	// with a unit only the concentrations are included, converting µg/m³ and
+	// ppm to ppb
+	ids, err = s.MeasurementSensors("no2", "ppb")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{83, 81, 32, 22}, ids)
+
+	ids, err = s.MeasurementSensors("no2", "mV")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{61, 30, 27}, ids)
+
+	_, err = s.MeasurementSensors("no2", "°C")
+	assert.NotNil(t, err)
+
+	// a kit reporting no2 as a voltage and a mass concentration resolves to the
+	// concentration when the operation wants ppb
+	mv := null.StringFrom("mV")
+	voltage := null.FloatFrom(230)
+	ugm3 := null.StringFrom("ug/m3")
+	concentration := null.FloatFrom(46.0055)
+
+	device := &smartcitizen.Device{
+		Token: "abc123",
+		Sensors: []*smartcitizen.Sensor{
+			{ID: 61, Unit: &mv, Value: &voltage},
+			{ID: 32, Unit: &ugm3, Value: &concentration},
+		},
+	}
+
+	sensor, err := s.FindMeasurement(device, "no2", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 61, sensor.ID)
+
+	sensor, err = s.FindMeasurement(device, "no2", "ppb")
+	assert.Nil(t, err)
+	assert.Equal(t, 32, sensor.ID)
+
+	converted, err := s.ConvertSensor(sensor, "ppb")
+	assert.Nil(t, err)
+	assert.Equal(t, "ppb", converted.Unit.String)
+	assert.InDelta(t, 24.45, converted.Value.Float64, 0.0001)
+
+	// the sensor itself is unchanged
+	assert.Equal(t, "ug/m3", sensor.Unit.String)
+	assert.Equal(t, 46.0055, sensor.Value.Float64)
+
+	// sensors already in the unit are returned as they are
+	same, err := s.ConvertSensor(sensor, "µg/m³")
+	assert.Nil(t, err)
+	assert.Equal(t, sensor, same)
+
+	// and a voltage has no unit to which it converts
+	_, err = s.ConvertSensor(device.Sensors[0], "ppb")
+	assert.NotNil(t, err)
+}
+
 func buildDevice(t *testing.T) *smartcitizen.Device {
 	unit1 := null.StringFrom("ºC")
 	value1 := null.FloatFrom(12.3)
@@ -95,11 +250,14 @@ func buildDevice(t *testing.T) *smartcitizen.Device {
 
 	unit3 := null.StringFrom("dBA")
 
+	longitude := null.FloatFrom(12)
+	latitude := null.FloatFrom(12)
+
 	return &smartcitizen.Device{
 		Token:      "abc123",
 		Label:      "my sensor",
-		Longitude:  12,
-		Latitude:   12,
+		Longitude:  &longitude,
+		Latitude:   &latitude,
 		Exposure:   "INDOOR",
 		RecordedAt: time.Date(2018, 12, 1, 10, 0, 0, 0, time.UTC),
 		Sensors: []*smartcitizen.Sensor{
@@ -0,0 +1,210 @@
+package smartcitizen
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unit describes a unit we know how to convert. Each unit belongs to a
+// dimension, and converts to and from a base unit for that dimension.
+type unit struct {
+	name      string
+	dimension string
+	toBase    func(float64) float64
+	fromBase  func(float64) float64
+}
+
+const (
+	temperature       = "temperature"
+	pressure          = "pressure"
+	length            = "length"
+	mixingRatio       = "mixing ratio"
+	massConcentration = "mass concentration"
+
+	// molarVolume is the volume in litres of one mole of an ideal gas at 25ºC
+	// and one atmosphere, used to convert between mixing ratios and mass
+	// concentrations of gases.
+	molarVolume = 24.45
+)
+
+// scale returns a unit which converts to its base unit by multiplying by the
+// given factor.
+func scale(name, dimension string, factor float64) *unit {
+	return &unit{
+		name:      name,
+		dimension: dimension,
+		toBase:    func(v float64) float64 { return v * factor },
+		fromBase:  func(v float64) float64 { return v / factor },
+	}
+}
+
+// units is our table of known units. Base units are degrees Celsius, pascals,
+// metres, parts per billion and micrograms per cubic metre.
+var units = []*unit{
+	scale("°C", temperature, 1),
+	&unit{
+		name:      "°F",
+		dimension: temperature,
+		toBase:    func(v float64) float64 { return (v - 32) * 5 / 9 },
+		fromBase:  func(v float64) float64 { return v*9/5 + 32 },
+	},
+	&unit{
+		name:      "K",
+		dimension: temperature,
+		toBase:    func(v float64) float64 { return v - 273.15 },
+		fromBase:  func(v float64) float64 { return v + 273.15 },
+	},
+	scale("Pa", pressure, 1),
+	scale("hPa", pressure, 100),
+	scale("kPa", pressure, 1000),
+	scale("inHg", pressure, 3386.389),
+	scale("m", length, 1),
+	scale("cm", length, 0.01),
+	scale("mm", length, 0.001),
+	scale("in", length, 0.0254),
+	scale("ppb", mixingRatio, 1),
+	scale("ppm", mixingRatio, 1000),
+	scale("µg/m³", massConcentration, 1),
+	scale("mg/m³", massConcentration, 1000),
+}
+
+// unitAliases maps the various spellings of units found in SmartCitizen's
+// metadata (and likely to be requested by users) to the names in our table.
+var unitAliases = map[string]string{
+	"ºc":    "°C",
+	"°c":    "°C",
+	"c":     "°C",
+	"degc":  "°C",
+	"ºf":    "°F",
+	"°f":    "°F",
+	"f":     "°F",
+	"degf":  "°F",
+	"k":     "K",
+	"pa":    "Pa",
+	"hpa":   "hPa",
+	"mbar":  "hPa",
+	"kpa":   "kPa",
+	"k pa":  "kPa",
+	"inhg":  "inHg",
+	"m":     "m",
+	"cm":    "cm",
+	"mm":    "mm",
+	"in":    "in",
+	"ppb":   "ppb",
+	"ppm":   "ppm",
+	"ug/m3": "µg/m³",
+	"µg/m3": "µg/m³",
+	"ug/m³": "µg/m³",
+	"µg/m³": "µg/m³",
+	"mg/m3": "mg/m³",
+	"mg/m³": "mg/m³",
+}
+
+// molecularWeights contains the molecular weight in grams per mole of the gases
+// for which we can convert between mixing ratios and mass concentrations, keyed
+// by SmartCitizen's measurement name.
+var molecularWeights = map[string]float64{
+	"no2": 46.0055,
+	"co":  28.010,
+	"o3":  47.997,
+	"so2": 64.066,
+	"nh3": 17.031,
+}
+
+// findUnit returns the unit identified by the given name or alias, or nil if
+// we do not know about the unit.
+func findUnit(name string) *unit {
+	canonical, ok := unitAliases[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return nil
+	}
+
+	for _, u := range units {
+		if u.name == canonical {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// SameUnit returns true if the given names identify the same unit. Units we
+// know are compared by their canonical name so that aliases match, and other
+// units such as `mV` by their name ignoring case and surrounding whitespace.
+func SameUnit(a, b string) bool {
+	ua, ub := findUnit(a), findUnit(b)
+	if ua != nil || ub != nil {
+		return ua == ub
+	}
+
+	return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+}
+
+// Conversion is a type that converts values from the unit of a sensor to some
+// target unit.
+type Conversion struct {
+	From    string
+	To      string
+	convert func(float64) float64
+}
+
+// Convert returns the given value converted to the target unit.
+func (c *Conversion) Convert(value float64) float64 {
+	return c.convert(value)
+}
+
+// FindConversion returns a Conversion from the unit of the sensor described by
+// the given metadata to the target unit, or an error if no such conversion is
+// possible. Conversions between mixing ratios (ppm, ppb) and mass
+// concentrations (µg/m³) are only possible for gases whose molecular weight we
+// know.
+func FindConversion(metadata SensorMetadata, target string) (*Conversion, error) {
+	if !metadata.Unit.Valid || metadata.Unit.String == "" {
+		return nil, fmt.Errorf("sensor %v has no unit", metadata.ID)
+	}
+
+	from := findUnit(metadata.Unit.String)
+	if from == nil {
+		return nil, fmt.Errorf("unsupported source unit %s for sensor %v", metadata.Unit.String, metadata.ID)
+	}
+
+	to := findUnit(target)
+	if to == nil {
+		return nil, fmt.Errorf("unsupported target unit %s", target)
+	}
+
+	conversion := &Conversion{
+		From: from.name,
+		To:   to.name,
+	}
+
+	if from.dimension == to.dimension {
+		conversion.convert = func(v float64) float64 {
+			return to.fromBase(from.toBase(v))
+		}
+
+		return conversion, nil
+	}
+
+	var measurement string
+	if metadata.Measurement != nil {
+		measurement = metadata.Measurement.Name
+	}
+
+	weight, ok := molecularWeights[strings.ToLower(measurement)]
+
+	switch {
+	case from.dimension == mixingRatio && to.dimension == massConcentration && ok:
+		conversion.convert = func(v float64) float64 {
+			return to.fromBase(from.toBase(v) * weight / molarVolume)
+		}
+	case from.dimension == massConcentration && to.dimension == mixingRatio && ok:
+		conversion.convert = func(v float64) float64 {
+			return to.fromBase(from.toBase(v) * molarVolume / weight)
+		}
+	default:
+		return nil, fmt.Errorf("unable to convert sensor %v from %s to %s", metadata.ID, from.name, to.name)
+	}
+
+	return conversion, nil
+}
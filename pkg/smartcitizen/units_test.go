@@ -0,0 +1,142 @@
+package smartcitizen_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotencoder/pkg/smartcitizen"
+)
+
+func TestConversion(t *testing.T) {
+	testcases := []struct {
+		label        string
+		sensorID     int
+		target       string
+		value        float64
+		expectedUnit string
+		expected     float64
+	}{
+		{
+			label:        "celsius to fahrenheit",
+			sensorID:     12,
+			target:       "°F",
+			value:        100,
+			expectedUnit: "°F",
+			expected:     212,
+		},
+		{
+			label:        "celsius to kelvin with alias",
+			sensorID:     55,
+			target:       "k",
+			value:        0,
+			expectedUnit: "K",
+			expected:     273.15,
+		},
+		{
+			label:        "kilopascals to hectopascals",
+			sensorID:     58,
+			target:       "mbar",
+			value:        101.3,
+			expectedUnit: "hPa",
+			expected:     1013,
+		},
+		{
+			label:        "no2 mass concentration to ppb",
+			sensorID:     32,
+			target:       "ppb",
+			value:        46.0055,
+			expectedUnit: "ppb",
+			expected:     24.45,
+		},
+		{
+			label:        "co ppm to mg/m3",
+			sensorID:     84,
+			target:       "mg/m3",
+			value:        24.45,
+			expectedUnit: "mg/m³",
+			expected:     28.01,
+		},
+	}
+
+	s := smartcitizen.Smartcitizen{}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			conversion, err := s.Conversion(tc.sensorID, tc.target)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedUnit, conversion.To)
+			assert.InDelta(t, tc.expected, conversion.Convert(tc.value), 1e-9)
+		})
+	}
+}
+
+func TestConversionInvalid(t *testing.T) {
+	testcases := []struct {
+		label       string
+		sensorID    int
+		target      string
+		expectedErr string
+	}{
+		{
+			label:       "unknown sensor",
+			sensorID:    100000,
+			target:      "°F",
+			expectedErr: "unknown sensor 100000",
+		},
+		{
+			label:       "sensor without unit",
+			sensorID:    3,
+			target:      "°F",
+			expectedErr: "sensor 3 has no unit",
+		},
+		{
+			label:       "unknown target unit",
+			sensorID:    12,
+			target:      "furlongs",
+			expectedErr: "unsupported target unit furlongs",
+		},
+		{
+			label:       "incompatible units",
+			sensorID:    12,
+			target:      "ppb",
+			expectedErr: "unable to convert sensor 12 from °C to ppb",
+		},
+		{
+			label:       "particulates have no molecular weight",
+			sensorID:    87,
+			target:      "ppb",
+			expectedErr: "unable to convert sensor 87 from µg/m³ to ppb",
+		},
+	}
+
+	s := smartcitizen.Smartcitizen{}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			_, err := s.Conversion(tc.sensorID, tc.target)
+			assert.NotNil(t, err)
+			assert.Equal(t, tc.expectedErr, err.Error())
+		})
+	}
+}
+
+func TestSameUnit(t *testing.T) {
+	testcases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"ºC", "°C", true},
+		{"ug/m3", "µg/m³", true},
+		{"ppb", "ppm", false},
+		{"mV", "mv", true},
+		{"mV", "kOhm", false},
+		{"mV", "ppb", false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.a+" "+tc.b, func(t *testing.T) {
+			assert.Equal(t, tc.expected, smartcitizen.SameUnit(tc.a, tc.b))
+		})
+	}
+}
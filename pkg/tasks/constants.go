@@ -4,4 +4,20 @@ const (
 	// DatabaseURL is the environment variable which must hold the database URL to
 	// which we want to connect.
 	DatabaseURLKey = "IOTENCODER_DATABASE_URL"
+
+	// EncryptionPasswordKey is the environment variable which must hold the
+	// password used to encrypt secret tokens we write to Postgres.
+	EncryptionPasswordKey = "IOTENCODER_ENCRYPTION_PASSWORD"
+
+	// DatastoreKey is the environment variable which must hold the address at
+	// which the datastore is listening.
+	DatastoreKey = "IOTENCODER_DATASTORE"
+
+	// MovingAvgStoreKey is the environment variable which may hold where moving
+	// averages are stored, either memory or postgres.
+	MovingAvgStoreKey = "IOTENCODER_MOVING_AVG_STORE"
+
+	// AllowedLatenessKey is the environment variable which may hold how late a
+	// reading may be recorded and still be included in moving averages.
+	AllowedLatenessKey = "IOTENCODER_ALLOWED_LATENESS"
 )
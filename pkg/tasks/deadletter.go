@@ -0,0 +1,386 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
+	"github.com/DECODEproject/iotencoder/pkg/postgres"
+	"github.com/DECODEproject/iotencoder/pkg/rpc"
+	"github.com/DECODEproject/iotencoder/pkg/server"
+	"github.com/DECODEproject/iotencoder/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(deadletterCmd)
+	deadletterCmd.AddCommand(deadletterListCmd)
+	deadletterCmd.AddCommand(deadletterShowCmd)
+	deadletterCmd.AddCommand(deadletterReplayCmd)
+	deadletterCmd.AddCommand(deadletterPurgeCmd)
+
+	deadletterListCmd.Flags().IntP("limit", "l", 100, "Maximum number of dead letters to list")
+	deadletterReplayCmd.Flags().Bool("all", false, "Boolean flag that if true replays all dead letters")
+	deadletterReplayCmd.Flags().IntP("limit", "l", 1000, "Maximum number of dead letters to replay with --all")
+	deadletterPurgeCmd.Flags().Bool("all", false, "Boolean flag that if true purges all dead letters")
+	deadletterPurgeCmd.Flags().Duration("older-than", 0, "Purge only dead letters older than this duration (e.g. 168h)")
+}
+
+var deadletterCmd = &cobra.Command{
+	Use:   "deadletter",
+	Short: "Inspect and replay messages that failed to process",
+	Long: `This task provides subcommands for working with dead letters, which are
+messages received from devices that we failed to process, e.g. because the
+payload couldn't be parsed, the device couldn't be loaded, or encryption
+failed. If a message failed for only some of a device's streams, a dead letter
+is recorded for each stream that failed.
+
+Once the cause of a failure has been fixed, dead letters can be replayed
+through the processor, and are deleted once processed successfully.
+
+These commands read the database URL and encryption password from the same
+environment variables as the server ($IOTENCODER_DATABASE_URL and
+$IOTENCODER_ENCRYPTION_PASSWORD), and replaying also requires the datastore
+address ($IOTENCODER_DATASTORE).`,
+}
+
+var deadletterListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List dead letters",
+	Long: `This command lists dead letters, oldest first, showing when and at which
+stage each message failed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			return err
+		}
+
+		db, err := openDeadLetterDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		letters, err := db.ListDeadLetters(limit)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCREATED AT\tDEVICE\tSTREAM\tSTAGE\tERROR")
+
+		for _, letter := range letters {
+			fmt.Fprintf(
+				w,
+				"%v\t%s\t%s\t%s\t%s\t%s\n",
+				letter.ID,
+				letter.CreatedAt.Format(time.RFC3339),
+				letter.DeviceToken,
+				letter.StreamID,
+				letter.Stage,
+				letter.Error,
+			)
+		}
+
+		return w.Flush()
+	},
+}
+
+var deadletterShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show a dead letter",
+	Long: fmt.Sprintf(`This command shows the full details of a dead letter including its payload.
+The id of the dead letter should be passed via a positional argument.
+
+For example:
+
+    $ %s deadletter show 42`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ids, err := parseDeadLetterIDs(args)
+		if err != nil {
+			return err
+		}
+
+		db, err := openDeadLetterDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		letter, err := db.GetDeadLetter(ids[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("ID:         %v\n", letter.ID)
+		fmt.Printf("Created At: %s\n", letter.CreatedAt.Format(time.RFC3339))
+		fmt.Printf("Topic:      %s\n", letter.Topic)
+		fmt.Printf("Device:     %s\n", letter.DeviceToken)
+		fmt.Printf("Stream:     %s\n", letter.StreamID)
+		fmt.Printf("Stage:      %s\n", letter.Stage)
+		fmt.Printf("Error:      %s\n", letter.Error)
+		fmt.Printf("Payload:\n%s\n", letter.Payload)
+
+		return nil
+	},
+}
+
+var deadletterReplayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay dead letters through the processor",
+	Long: fmt.Sprintf(`This command pushes dead letters back through the processor, writing them
+to the datastore. Either the ids of the dead letters should be passed as
+positional arguments, or the --all flag given to replay every dead letter.
+Dead letters are deleted once replayed successfully, while those that fail
+again are kept.
+
+Replaying happens outside of the server, so only state the server persists in
+postgres is shared with it. Dead letters for streams with operations whose
+state is held in the server's memory (exponential averages, windowed
+operations, thresholds, air quality indexes, emission intervals, and moving
+averages unless $IOTENCODER_MOVING_AVG_STORE is postgres) are skipped and
+kept. $IOTENCODER_ALLOWED_LATENESS should match the server's configuration.
+
+For example:
+
+    $ %s deadletter replay 42 43`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+
+		limit, err := cmd.Flags().GetInt("limit")
+		if err != nil {
+			return err
+		}
+
+		if all == (len(args) > 0) {
+			return errors.New("Must provide either dead letter ids or the --all flag")
+		}
+
+		ids, err := parseDeadLetterIDs(args)
+		if err != nil {
+			return err
+		}
+
+		datastoreAddr, err := GetFromEnv(DatastoreKey)
+		if err != nil {
+			return err
+		}
+
+		db, err := openDeadLetterDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		var letters []*postgres.DeadLetter
+
+		if all {
+			letters, err = db.ListDeadLetters(limit)
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, id := range ids {
+				letter, err := db.GetDeadLetter(id)
+				if err != nil {
+					return err
+				}
+
+				letters = append(letters, letter)
+			}
+		}
+
+		movingAvgStore := os.Getenv(MovingAvgStoreKey)
+		if movingAvgStore == "" {
+			movingAvgStore = server.MemoryStore
+		}
+
+		allowedLateness := pipeline.DefaultAllowedLateness
+		if lateness := os.Getenv(AllowedLatenessKey); lateness != "" {
+			allowedLateness, err = time.ParseDuration(lateness)
+			if err != nil {
+				return errors.Wrapf(err, "Invalid $%s", AllowedLatenessKey)
+			}
+		}
+
+		logger := logger.NewLogger()
+
+		// replayed readings were already seen when they were first received, so
+		// we don't deduplicate them
+		serverConfig := &server.Config{
+			MovingAvgStore:     movingAvgStore,
+			AllowedLateness:    allowedLateness,
+			ScriptInstructions: pipeline.DefaultScriptInstructions,
+			Workers:            pipeline.DefaultWorkers,
+			TransformTimeout:   pipeline.DefaultTransformTimeout,
+			WriteTimeout:       pipeline.DefaultWriteTimeout,
+		}
+
+		pipelineConfig, _ := server.NewPipelineConfig(serverConfig, db, server.NewDatastore(datastoreAddr), logger)
+
+		processor := pipeline.NewProcessor(pipelineConfig, logger)
+
+		failed := 0
+
+		for _, letter := range letters {
+			err = checkReplayable(db, serverConfig, letter)
+			if err != nil {
+				failed++
+				fmt.Printf("%v: skipped: %v\n", letter.ID, err)
+				continue
+			}
+
+			err = rpc.ReplayDeadLetter(context.Background(), db, processor, letter)
+			if err != nil {
+				failed++
+				fmt.Printf("%v: failed: %v\n", letter.ID, err)
+				continue
+			}
+
+			fmt.Printf("%v: replayed\n", letter.ID)
+		}
+
+		if failed > 0 {
+			return errors.Errorf("Failed to replay %v of %v dead letters", failed, len(letters))
+		}
+
+		return nil
+	},
+}
+
+var deadletterPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Delete dead letters",
+	Long: fmt.Sprintf(`This command deletes dead letters without replaying them. Either the ids of
+the dead letters should be passed as positional arguments, or the --all flag
+given to delete every dead letter, optionally only those older than the
+duration given by --older-than.
+
+For example:
+
+    $ %s deadletter purge --all --older-than 168h`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, err := cmd.Flags().GetBool("all")
+		if err != nil {
+			return err
+		}
+
+		olderThan, err := cmd.Flags().GetDuration("older-than")
+		if err != nil {
+			return err
+		}
+
+		if all == (len(args) > 0) {
+			return errors.New("Must provide either dead letter ids or the --all flag")
+		}
+
+		ids, err := parseDeadLetterIDs(args)
+		if err != nil {
+			return err
+		}
+
+		db, err := openDeadLetterDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		if all {
+			purged, err := db.PurgeDeadLetters(time.Now().Add(-olderThan))
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Purged %v dead letters\n", purged)
+
+			return nil
+		}
+
+		for _, id := range ids {
+			err = db.DeleteDeadLetter(id)
+			if err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Purged %v dead letters\n", len(ids))
+
+		return nil
+	},
+}
+
+// openDeadLetterDB returns a started connection to the database configured in
+// the environment.
+func openDeadLetterDB() (*postgres.DB, error) {
+	connStr, err := GetFromEnv(DatabaseURLKey)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionPassword, err := GetFromEnv(EncryptionPasswordKey)
+	if err != nil {
+		return nil, err
+	}
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:            connStr,
+		EncryptionPassword: encryptionPassword,
+	}, logger.NewLogger())
+
+	err = db.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// checkReplayable returns an error if any of the streams the dead letter would
+// be replayed to rely on state held in the server's memory, which our
+// processor can't share.
+func checkReplayable(db *postgres.DB, config *server.Config, letter *postgres.DeadLetter) error {
+	device, err := db.GetDevice(letter.DeviceToken)
+	if err != nil {
+		return errors.Wrap(err, "failed to get device")
+	}
+
+	for _, stream := range device.Streams {
+		if letter.StreamID != "" && stream.StreamID != letter.StreamID {
+			continue
+		}
+
+		err = server.CheckReplayable(config, stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseDeadLetterIDs parses the positional arguments given as dead letter ids.
+func parseDeadLetterIDs(args []string) ([]int64, error) {
+	ids := make([]int64, len(args))
+
+	for i, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, errors.Errorf("Invalid dead letter id: %s", arg)
+		}
+
+		ids[i] = id
+	}
+
+	return ids, nil
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/DECODEproject/iotencoder/pkg/logger"
+	"github.com/DECODEproject/iotencoder/pkg/mqtt"
+	"github.com/DECODEproject/iotencoder/pkg/pipeline"
 	"github.com/DECODEproject/iotencoder/pkg/server"
 	"github.com/DECODEproject/iotencoder/pkg/version"
 )
@@ -25,6 +27,18 @@ func init() {
 	serverCmd.Flags().StringP("broker-addr", "b", "tcps://mqtt.smartcitizen.me:8883", "Address at which the MQTT broker is listening")
 	serverCmd.Flags().StringP("broker-username", "u", "", "Username for accessing the MQTT broker")
 	serverCmd.Flags().StringSlice("domains", []string{}, "Comma separated list of domains to enable TLS for these domains")
+	serverCmd.Flags().String("moving-avg-store", server.MemoryStore, "Where moving average windows are stored, either memory or postgres")
+	serverCmd.Flags().Duration("allowed-lateness", pipeline.DefaultAllowedLateness, "How long before the latest reading a reading may have been recorded and still be included in moving averages and windows")
+	serverCmd.Flags().String("dedup-store", server.MemoryStore, "Where readings are remembered to discard duplicates, either memory or postgres")
+	serverCmd.Flags().Duration("dedup-horizon", pipeline.DefaultDedupHorizon, "How long readings are remembered to discard duplicates, or 0 to disable deduplication")
+	serverCmd.Flags().Int("script-instructions", pipeline.DefaultScriptInstructions, "Maximum number of Lua instructions a stream's transformation script may execute")
+	serverCmd.Flags().Int("workers", pipeline.DefaultWorkers, "Maximum number of streams encrypted and written to concurrently")
+	serverCmd.Flags().Duration("outbox-max-age", pipeline.DefaultOutboxMaxAge, "Maximum age of events retried from the outbox before they are discarded")
+	serverCmd.Flags().Int("queue-size", mqtt.DefaultQueueSize, "Maximum number of received messages queued for processing")
+	serverCmd.Flags().Int("queue-workers", mqtt.DefaultQueueWorkers, "Number of workers processing queued messages")
+	serverCmd.Flags().String("queue-overflow", string(mqtt.DefaultOverflowPolicy), "What to do with messages received when the queue is full, either drop-oldest, drop-newest or block")
+	serverCmd.Flags().Duration("transform-timeout", pipeline.DefaultTransformTimeout, "Deadline for applying a stream's operations to a message, or 0 for no deadline")
+	serverCmd.Flags().Duration("write-timeout", pipeline.DefaultWriteTimeout, "Deadline for each write to the datastore, or 0 for no deadline")
 
 	viper.BindPFlag("addr", serverCmd.Flags().Lookup("addr"))
 	viper.BindPFlag("datastore", serverCmd.Flags().Lookup("datastore"))
@@ -34,6 +48,18 @@ func init() {
 	viper.BindPFlag("broker-addr", serverCmd.Flags().Lookup("broker-addr"))
 	viper.BindPFlag("broker-username", serverCmd.Flags().Lookup("broker-username"))
 	viper.BindPFlag("domains", serverCmd.Flags().Lookup("domains"))
+	viper.BindPFlag("moving-avg-store", serverCmd.Flags().Lookup("moving-avg-store"))
+	viper.BindPFlag("allowed-lateness", serverCmd.Flags().Lookup("allowed-lateness"))
+	viper.BindPFlag("dedup-store", serverCmd.Flags().Lookup("dedup-store"))
+	viper.BindPFlag("dedup-horizon", serverCmd.Flags().Lookup("dedup-horizon"))
+	viper.BindPFlag("script-instructions", serverCmd.Flags().Lookup("script-instructions"))
+	viper.BindPFlag("workers", serverCmd.Flags().Lookup("workers"))
+	viper.BindPFlag("outbox-max-age", serverCmd.Flags().Lookup("outbox-max-age"))
+	viper.BindPFlag("queue-size", serverCmd.Flags().Lookup("queue-size"))
+	viper.BindPFlag("queue-workers", serverCmd.Flags().Lookup("queue-workers"))
+	viper.BindPFlag("queue-overflow", serverCmd.Flags().Lookup("queue-overflow"))
+	viper.BindPFlag("transform-timeout", serverCmd.Flags().Lookup("transform-timeout"))
+	viper.BindPFlag("write-timeout", serverCmd.Flags().Lookup("write-timeout"))
 
 	raven.SetRelease(version.Version)
 	raven.SetTagsContext(map[string]string{"component": "encoder"})
@@ -85,6 +111,66 @@ able to be supplied via an environment variable: $IOTENCODER_EXAMPLE_FLAG`,
 			return errors.New("Must provide MQTT broker username to authenticate access to the broker")
 		}
 
+		movingAvgStore := viper.GetString("moving-avg-store")
+		if movingAvgStore != server.MemoryStore && movingAvgStore != server.PostgresStore {
+			return errors.New("Moving average store must be either memory or postgres")
+		}
+
+		allowedLateness := viper.GetDuration("allowed-lateness")
+		if allowedLateness < 0 {
+			return errors.New("Allowed lateness must not be negative")
+		}
+
+		dedupStore := viper.GetString("dedup-store")
+		if dedupStore != server.MemoryStore && dedupStore != server.PostgresStore {
+			return errors.New("Deduplication store must be either memory or postgres")
+		}
+
+		dedupHorizon := viper.GetDuration("dedup-horizon")
+		if dedupHorizon < 0 {
+			return errors.New("Deduplication horizon must not be negative")
+		}
+
+		scriptInstructions := viper.GetInt("script-instructions")
+		if scriptInstructions <= 0 {
+			return errors.New("Script instruction limit must be positive")
+		}
+
+		workers := viper.GetInt("workers")
+		if workers <= 0 {
+			return errors.New("Number of workers must be positive")
+		}
+
+		outboxMaxAge := viper.GetDuration("outbox-max-age")
+		if outboxMaxAge <= 0 {
+			return errors.New("Outbox maximum age must be positive")
+		}
+
+		queueSize := viper.GetInt("queue-size")
+		if queueSize <= 0 {
+			return errors.New("Queue size must be positive")
+		}
+
+		queueWorkers := viper.GetInt("queue-workers")
+		if queueWorkers <= 0 {
+			return errors.New("Number of queue workers must be positive")
+		}
+
+		queueOverflow, err := mqtt.ParseOverflowPolicy(viper.GetString("queue-overflow"))
+		if err != nil {
+			return errors.New("Queue overflow policy must be either drop-oldest, drop-newest or block")
+		}
+
+		transformTimeout := viper.GetDuration("transform-timeout")
+		if transformTimeout < 0 {
+			return errors.New("Transform timeout must not be negative")
+		}
+
+		writeTimeout := viper.GetDuration("write-timeout")
+		if writeTimeout < 0 {
+			return errors.New("Write timeout must not be negative")
+		}
+
 		logger := logger.NewLogger()
 
 		config := &server.Config{
@@ -96,6 +182,18 @@ able to be supplied via an environment variable: $IOTENCODER_EXAMPLE_FLAG`,
 			BrokerAddr:         brokerAddr,
 			BrokerUsername:     brokerUsername,
 			Domains:            viper.GetStringSlice("domains"),
+			MovingAvgStore:     movingAvgStore,
+			DedupStore:         dedupStore,
+			DedupHorizon:       dedupHorizon,
+			AllowedLateness:    allowedLateness,
+			ScriptInstructions: scriptInstructions,
+			Workers:            workers,
+			OutboxMaxAge:       outboxMaxAge,
+			QueueSize:          queueSize,
+			QueueWorkers:       queueWorkers,
+			QueueOverflow:      queueOverflow,
+			TransformTimeout:   transformTimeout,
+			WriteTimeout:       writeTimeout,
 		}
 
 		executer := backoff.ExecuteFunc(func(_ context.Context) error {